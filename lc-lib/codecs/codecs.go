@@ -30,6 +30,12 @@ type Codec interface {
 	APIEncodable() api.Encodable
 }
 
+// Flusher is implemented by codecs that buffer events, allowing any buffered
+// events to be shipped immediately
+type Flusher interface {
+	Flush() error
+}
+
 // CallbackFunc is a callback function that a codec will call for each of its
 // "output" events. It could be called at any time by any routine (not
 // necessarily the routine providing the "input" events.)
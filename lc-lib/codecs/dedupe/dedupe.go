@@ -0,0 +1,290 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+const (
+	defaultDedupeWindow time.Duration = 10 * time.Second
+
+	// dedupeNormalisedPlaceholder replaces each match of a normalise pattern
+	dedupeNormalisedPlaceholder = "\x00"
+)
+
+// CodecDedupeFactory holds the configuration for a dedupe codec
+type CodecDedupeFactory struct {
+	NormalisePatterns []string      `config:"normalise patterns"`
+	Window            time.Duration `config:"window"`
+
+	normalisePatterns []*regexp.Regexp
+}
+
+// CodecDedupe is an instance of a dedupe codec that is used by the Harvester
+// to collapse repeated lines
+type CodecDedupe struct {
+	config       *CodecDedupeFactory
+	lastOffset   int64
+	callbackFunc codecs.CallbackFunc
+
+	mutex     sync.Mutex
+	timerStop chan struct{}
+	timerWait sync.WaitGroup
+
+	haveLast     bool
+	lastKey      string
+	windowEnd    time.Time
+	repeatCount  int64
+	repeatStart  int64
+	repeatEnd    int64
+	repeatData   map[string]interface{}
+	dedupedLines uint64
+	lastErr      error
+
+	meterDeduped uint64
+	meterPending int64
+}
+
+// NewDedupeCodecFactory creates a new DedupeCodecFactory for a codec
+// definition in the configuration file. This factory can be used to create
+// instances of a dedupe codec for use by harvesters
+func NewDedupeCodecFactory(p *config.Parser, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecDedupeFactory{
+		Window: defaultDedupeWindow,
+	}
+	if err = p.Populate(result, unused, configPath, true); err != nil {
+		return nil, err
+	}
+
+	if result.Window <= 0 {
+		return nil, fmt.Errorf("Window for dedupe codec at %s must be greater than 0", configPath)
+	}
+
+	result.normalisePatterns = make([]*regexp.Regexp, len(result.NormalisePatterns))
+	for k, pattern := range result.NormalisePatterns {
+		if result.normalisePatterns[k], err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("Failed to compile normalise pattern for dedupe codec at %s, '%s': %s", configPath, pattern, err)
+		}
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecDedupeFactory) NewCodec(callbackFunc codecs.CallbackFunc, offset int64) codecs.Codec {
+	c := &CodecDedupe{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+		timerStop:    make(chan struct{}),
+	}
+
+	// Start the routine that will send the summary of repeated lines when the
+	// window ends
+	c.timerWait.Add(1)
+	go c.deadlineRoutine()
+
+	return c
+}
+
+// normalise returns the text with all matches of the normalise patterns
+// replaced, so that lines that differ only in those matches are considered
+// identical
+func (f *CodecDedupeFactory) normalise(text string) string {
+	for _, pattern := range f.normalisePatterns {
+		text = pattern.ReplaceAllLiteralString(text, dedupeNormalisedPlaceholder)
+	}
+	return text
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+// Repeated lines that are yet to be summarised are not shipped, and will be
+// read again when the file is resumed
+func (c *CodecDedupe) Teardown() int64 {
+	close(c.timerStop)
+	c.timerWait.Wait()
+
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecDedupe) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastOffset = 0
+	c.haveLast = false
+	c.repeatCount = 0
+	c.repeatData = nil
+}
+
+// ProcessEvent is called by a Harvester when a new event occurs on a file
+// The first occurrence of a line is shipped to the callback, and repeats of it
+// within the window are collapsed into a single summary event
+func (c *CodecDedupe) ProcessEvent(startOffset int64, endOffset int64, data map[string]interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lastErr != nil {
+		return c.lastErr
+	}
+
+	// TODO: Option to set the field
+	text, ok := data["message"].(string)
+
+	var key string
+	if ok {
+		key = c.config.normalise(text)
+		if c.haveLast && key == c.lastKey && time.Now().Before(c.windowEnd) {
+			if c.repeatCount == 0 {
+				c.repeatStart = startOffset
+			}
+			c.repeatCount++
+			c.repeatEnd = endOffset
+			c.repeatData = data
+			c.dedupedLines++
+			return nil
+		}
+	}
+
+	// A different line, so summarise the repeats of the previous one first
+	if c.lastErr = c.flush(); c.lastErr != nil {
+		return c.lastErr
+	}
+
+	if c.lastErr = c.callbackFunc(startOffset, endOffset, data); c.lastErr != nil {
+		return c.lastErr
+	}
+
+	c.lastOffset = endOffset
+	c.haveLast = ok
+	c.lastKey = key
+	c.windowEnd = time.Now().Add(c.config.Window)
+	return nil
+}
+
+// Flush ships the summary event for any repeated lines immediately
+func (c *CodecDedupe) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lastErr != nil {
+		return c.lastErr
+	}
+
+	c.lastErr = c.flush()
+	c.haveLast = false
+	return c.lastErr
+}
+
+// flush ships the summary event for the repeated lines, if there were any
+// The summary is the last of the repeated lines with the number of repeats and
+// the offsets they covered added, and it is shipped with those offsets so that
+// the registrar resumes after the repeats once it is acknowledged
+func (c *CodecDedupe) flush() error {
+	if c.repeatCount == 0 {
+		return nil
+	}
+
+	data := c.repeatData
+	data["repeat_count"] = c.repeatCount
+	data["repeat_first_offset"] = c.repeatStart
+	data["repeat_last_offset"] = c.repeatEnd
+
+	err := c.callbackFunc(c.repeatStart, c.repeatEnd, data)
+	c.repeatCount = 0
+	c.repeatData = nil
+	if err != nil {
+		return err
+	}
+
+	c.lastOffset = c.repeatEnd
+	return nil
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecDedupe) Meter() {
+	c.mutex.Lock()
+	c.meterDeduped = c.dedupedLines
+	c.meterPending = c.repeatCount
+	c.mutex.Unlock()
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecDedupe) APIEncodable() api.Encodable {
+	apiKV := &api.KeyValue{}
+	apiKV.SetEntry("deduplicated_lines", api.Number(c.meterDeduped))
+	apiKV.SetEntry("pending_repeats", api.Number(c.meterPending))
+	return apiKV
+}
+
+func (c *CodecDedupe) deadlineRoutine() {
+	timer := time.NewTimer(c.config.Window)
+
+DeadlineLoop:
+	for {
+		select {
+		case <-c.timerStop:
+			if !timer.Stop() {
+				<-timer.C
+			}
+
+			// Shutdown signal so end the routine
+			break DeadlineLoop
+		case now := <-timer.C:
+			c.mutex.Lock()
+
+			if c.lastErr == nil {
+				// Has the window ended?
+				if c.haveLast && now.Before(c.windowEnd) {
+					// Window moved, update the timer
+					timer.Reset(c.windowEnd.Sub(now))
+					c.mutex.Unlock()
+					continue
+				}
+
+				c.lastErr = c.flush()
+			}
+
+			if c.lastErr != nil {
+				c.mutex.Unlock()
+				break DeadlineLoop
+			}
+			timer.Reset(c.config.Window)
+			c.mutex.Unlock()
+		}
+	}
+
+	c.timerWait.Done()
+}
+
+// Register the codec
+func init() {
+	codecs.Register("dedupe", NewDedupeCodecFactory)
+}
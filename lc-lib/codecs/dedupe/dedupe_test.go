@@ -0,0 +1,202 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+type checkDedupeEvent struct {
+	start, end int64
+	data       map[string]interface{}
+}
+
+type checkDedupe struct {
+	mutex  sync.Mutex
+	events []checkDedupeEvent
+}
+
+func (c *checkDedupe) EventCallback(startOffset int64, endOffset int64, data map[string]interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, checkDedupeEvent{startOffset, endOffset, data})
+	return nil
+}
+
+func (c *checkDedupe) Check(t *testing.T, idx int, start int64, end int64, message string, count int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if idx >= len(c.events) {
+		t.Fatalf("Event %d was not received, only %d events", idx, len(c.events))
+	}
+
+	evnt := c.events[idx]
+	if evnt.start != start || evnt.end != end {
+		t.Errorf("Unexpected offsets for event %d, got: %d-%d, expected: %d-%d", idx, evnt.start, evnt.end, start, end)
+	}
+	if evnt.data["message"] != message {
+		t.Errorf("Unexpected message for event %d, got: %v, expected: %s", idx, evnt.data["message"], message)
+	}
+	if count == 0 {
+		if _, ok := evnt.data["repeat_count"]; ok {
+			t.Errorf("Unexpected repeat count for event %d: %v", idx, evnt.data["repeat_count"])
+		}
+		return
+	}
+	if evnt.data["repeat_count"] != count {
+		t.Errorf("Unexpected repeat count for event %d, got: %v, expected: %d", idx, evnt.data["repeat_count"], count)
+	}
+	if evnt.data["repeat_first_offset"] != start || evnt.data["repeat_last_offset"] != end {
+		t.Errorf("Unexpected repeat offsets for event %d, got: %v-%v, expected: %d-%d", idx, evnt.data["repeat_first_offset"], evnt.data["repeat_last_offset"], start, end)
+	}
+}
+
+func (c *checkDedupe) CheckCount(t *testing.T, count int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.events) != count {
+		t.Errorf("Unexpected event count, got: %d, expected: %d", len(c.events), count)
+	}
+}
+
+func createDedupeCodec(unused map[string]interface{}, callback codecs.CallbackFunc, t *testing.T) codecs.Codec {
+	factory, err := NewDedupeCodecFactory(config.NewParser(config.NewConfig()), "", unused, "dedupe")
+	if err != nil {
+		t.Fatalf("Failed to create dedupe codec: %s", err)
+	}
+
+	return codecs.NewCodec(factory, callback, 0)
+}
+
+func codecEvent(codec codecs.Codec, startOffset int64, endOffset int64, data string) error {
+	return codec.ProcessEvent(startOffset, endOffset, map[string]interface{}{"message": data})
+}
+
+func TestDedupeRepeated(t *testing.T) {
+	check := &checkDedupe{}
+	codec := createDedupeCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codecEvent(codec, 0, 10, "Starting up")
+	codecEvent(codec, 10, 20, "Crashed")
+	codecEvent(codec, 20, 30, "Crashed")
+	codecEvent(codec, 30, 40, "Crashed")
+	codecEvent(codec, 40, 50, "Crashed")
+	codecEvent(codec, 50, 60, "Restarted")
+
+	check.CheckCount(t, 4)
+	check.Check(t, 0, 0, 10, "Starting up", 0)
+	check.Check(t, 1, 10, 20, "Crashed", 0)
+	check.Check(t, 2, 20, 50, "Crashed", 3)
+	check.Check(t, 3, 50, 60, "Restarted", 0)
+
+	if offset := codec.Teardown(); offset != 60 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeNormalise(t *testing.T) {
+	check := &checkDedupe{}
+	codec := createDedupeCodec(map[string]interface{}{
+		"normalise patterns": []string{"[0-9]+"},
+	}, check.EventCallback, t)
+
+	codecEvent(codec, 0, 10, "Connection 1 failed")
+	codecEvent(codec, 10, 20, "Connection 2 failed")
+	codecEvent(codec, 20, 30, "Connection 33 failed")
+	codecEvent(codec, 30, 40, "Connection closed")
+
+	check.CheckCount(t, 3)
+	check.Check(t, 0, 0, 10, "Connection 1 failed", 0)
+	check.Check(t, 1, 10, 30, "Connection 33 failed", 2)
+	check.Check(t, 2, 30, 40, "Connection closed", 0)
+
+	if offset := codec.Teardown(); offset != 40 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	check := &checkDedupe{}
+	codec := createDedupeCodec(map[string]interface{}{
+		"window": "1s",
+	}, check.EventCallback, t)
+
+	codecEvent(codec, 0, 10, "Crashed")
+	codecEvent(codec, 10, 20, "Crashed")
+	codecEvent(codec, 20, 30, "Crashed")
+
+	check.CheckCount(t, 1)
+
+	// The summary is sent when the window ends even without another line
+	time.Sleep(2 * time.Second)
+
+	check.CheckCount(t, 2)
+	check.Check(t, 1, 10, 30, "Crashed", 2)
+
+	// The window has ended so the line is shipped again
+	codecEvent(codec, 30, 40, "Crashed")
+
+	check.CheckCount(t, 3)
+	check.Check(t, 2, 30, 40, "Crashed", 0)
+
+	if offset := codec.Teardown(); offset != 40 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupePending(t *testing.T) {
+	check := &checkDedupe{}
+	codec := createDedupeCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codecEvent(codec, 0, 10, "Crashed")
+	codecEvent(codec, 10, 20, "Crashed")
+
+	// Repeats not yet summarised must be read again on resume
+	if offset := codec.Teardown(); offset != 10 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeNoMessage(t *testing.T) {
+	check := &checkDedupe{}
+	codec := createDedupeCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codec.ProcessEvent(0, 10, map[string]interface{}{"other": "value"})
+	codec.ProcessEvent(10, 20, map[string]interface{}{"other": "value"})
+
+	check.CheckCount(t, 2)
+
+	if offset := codec.Teardown(); offset != 20 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeInvalidWindow(t *testing.T) {
+	_, err := NewDedupeCodecFactory(config.NewParser(config.NewConfig()), "", map[string]interface{}{
+		"window": "0s",
+	}, "dedupe")
+	if err == nil {
+		t.Error("Zero window was accepted")
+	}
+}
@@ -31,18 +31,34 @@ import (
 const (
 	codecMultilineWhatPrevious = 0x00000001
 	codecMultilineWhatNext     = 0x00000002
+	codecMultilineWhatBetween  = 0x00000003
 )
 
 // CodecMultilineFactory holds the configuration for a multiline codec
 type CodecMultilineFactory struct {
 	Patterns          []string      `config:"patterns"`
+	StartPatterns     []string      `config:"start patterns"`
+	EndPatterns       []string      `config:"end patterns"`
+	Preset            string        `config:"preset"`
 	Match             string        `config:"match"`
 	What              string        `config:"what"`
 	PreviousTimeout   time.Duration `config:"previous timeout"`
 	MaxMultilineBytes int64         `config:"max multiline bytes"`
+	MaxLines          int64         `config:"max lines"`
 
-	patterns codecs.PatternCollection
-	what     int
+	patterns      codecs.PatternCollection
+	startPatterns codecs.PatternCollection
+	endPatterns   codecs.PatternCollection
+	presets       []*presetPatterns
+	what          int
+}
+
+// presetPatterns holds the compiled patterns of a preset, which are nil where
+// the preset has none
+type presetPatterns struct {
+	header *codecs.PatternCollection
+	start  *codecs.PatternCollection
+	trace  *codecs.PatternCollection
 }
 
 // CodecMultiline is an instance of a multiline codec that is used by the
@@ -56,6 +72,8 @@ type CodecMultiline struct {
 	startOffset   int64
 	buffer        []string
 	bufferLines   int64
+	inBlock       bool
+	activePreset  *presetPatterns
 	bufferLen     int64
 	timerLock     sync.Mutex
 	timerStop     chan struct{}
@@ -78,18 +96,68 @@ func NewMultilineCodecFactory(p *config.Parser, configPath string, unused map[st
 		return nil, err
 	}
 
-	if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
-		return nil, fmt.Errorf("Invalid patterns for multiline codec at %s: %s", configPath, err)
+	if result.Preset != "" {
+		preset, ok := multilinePresets[result.Preset]
+		if !ok {
+			return nil, fmt.Errorf("Unknown \"preset\" value, '%s', for multiline codec at %s", result.Preset, configPath)
+		}
+		if len(result.Patterns) != 0 || result.Match != "" {
+			return nil, fmt.Errorf("Patterns cannot be specified alongside a preset for multiline codec at %s", configPath)
+		}
+		if result.What != "" && result.What != "previous" {
+			return nil, fmt.Errorf("Presets only support a \"what\" value of 'previous' for multiline codec at %s", configPath)
+		}
+		for _, entry := range preset {
+			patterns := &presetPatterns{}
+			if patterns.header, err = newPresetCollection(entry.header); err != nil {
+				return nil, fmt.Errorf("Invalid preset patterns for multiline codec at %s: %s", configPath, err)
+			}
+			if patterns.start, err = newPresetCollection(entry.start); err != nil {
+				return nil, fmt.Errorf("Invalid preset patterns for multiline codec at %s: %s", configPath, err)
+			}
+			if patterns.trace, err = newPresetCollection(entry.trace); err != nil {
+				return nil, fmt.Errorf("Invalid preset patterns for multiline codec at %s: %s", configPath, err)
+			}
+			result.presets = append(result.presets, patterns)
+		}
 	}
 
 	if result.What == "" || result.What == "previous" {
 		result.what = codecMultilineWhatPrevious
 	} else if result.What == "next" {
 		result.what = codecMultilineWhatNext
+	} else if result.What == "between" {
+		result.what = codecMultilineWhatBetween
 	} else {
 		return nil, fmt.Errorf("Unknown \"what\" value, '%s', for multiline codec at %s", result.What, configPath)
 	}
 
+	if result.what == codecMultilineWhatBetween {
+		if len(result.Patterns) != 0 {
+			return nil, fmt.Errorf("Patterns cannot be used with a \"what\" value of 'between' for multiline codec at %s, use start patterns and end patterns instead", configPath)
+		}
+		if err = result.startPatterns.Set(result.StartPatterns, result.Match); err != nil {
+			return nil, fmt.Errorf("Invalid start patterns for multiline codec at %s: %s", configPath, err)
+		}
+		if err = result.endPatterns.Set(result.EndPatterns, result.Match); err != nil {
+			return nil, fmt.Errorf("Invalid end patterns for multiline codec at %s: %s", configPath, err)
+		}
+	} else {
+		if len(result.StartPatterns) != 0 || len(result.EndPatterns) != 0 {
+			return nil, fmt.Errorf("Start patterns and end patterns can only be used with a \"what\" value of 'between' for multiline codec at %s", configPath)
+		}
+		// Presets have their own patterns
+		if result.presets == nil {
+			if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
+				return nil, fmt.Errorf("Invalid patterns for multiline codec at %s: %s", configPath, err)
+			}
+		}
+	}
+
+	if result.MaxLines < 0 {
+		return nil, fmt.Errorf("Max lines for multiline codec at %s cannot be negative", configPath)
+	}
+
 	spoolMaxBytes := p.Config().GeneralPart("spooler").(*spooler.General).SpoolMaxBytes
 
 	if result.MaxMultilineBytes == 0 {
@@ -105,6 +173,19 @@ func NewMultilineCodecFactory(p *config.Parser, configPath string, unused map[st
 	return result, nil
 }
 
+// newPresetCollection returns a collection that matches any of the given
+// preset patterns, or nil if there are none
+func newPresetCollection(patterns []string) (*codecs.PatternCollection, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	collection := &codecs.PatternCollection{}
+	if err := collection.Set(patterns, "any"); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
 // NewCodec returns a new codec instance that will send events to the callback
 // function provided upon completion of processing
 func (f *CodecMultilineFactory) NewCodec(callbackFunc codecs.CallbackFunc, offset int64) codecs.Codec {
@@ -116,7 +197,7 @@ func (f *CodecMultilineFactory) NewCodec(callbackFunc codecs.CallbackFunc, offse
 	}
 
 	// Start the "previous timeout" routine that will auto flush at deadline
-	if f.PreviousTimeout != 0 {
+	if f.usesTimeout() {
 		c.timerStop = make(chan struct{})
 		c.timerWait.Add(1)
 
@@ -129,7 +210,7 @@ func (f *CodecMultilineFactory) NewCodec(callbackFunc codecs.CallbackFunc, offse
 
 // Teardown ends the codec and returns the last offset shipped to the callback
 func (c *CodecMultiline) Teardown() int64 {
-	if c.config.PreviousTimeout != 0 {
+	if c.config.usesTimeout() {
 		close(c.timerStop)
 		c.timerWait.Wait()
 	}
@@ -144,20 +225,25 @@ func (c *CodecMultiline) Reset() {
 	c.buffer = nil
 	c.bufferLen = 0
 	c.bufferLines = 0
+	c.inBlock = false
+	c.activePreset = nil
+}
+
+// usesTimeout returns true if the "previous timeout" applies
+func (f *CodecMultilineFactory) usesTimeout() bool {
+	return f.PreviousTimeout != 0 && f.what != codecMultilineWhatNext
 }
 
 // ProcessEvent is called by a Harvester when a new line event occurs on a file.
 // Multiline processing takes place and when a complete multiline event is found
 // as described by the configuration it is shipped to the callback
 func (c *CodecMultiline) ProcessEvent(startOffset int64, endOffset int64, data map[string]interface{}) error {
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Prevent a flush happening while we're modifying the stored data
-			c.timerLock.Lock()
-			defer func() {
-				c.timerLock.Unlock()
-			}()
-		}
+	if c.config.usesTimeout() {
+		// Prevent a flush happening while we're modifying the stored data
+		c.timerLock.Lock()
+		defer func() {
+			c.timerLock.Unlock()
+		}()
 	}
 
 	if c.lastErr != nil {
@@ -180,7 +266,14 @@ func (c *CodecMultiline) ProcessEvent(startOffset int64, endOffset int64, data m
 	// odd incomplete data. It would be a signal from the user, "I will worry about the buffering
 	// issues my programs may have - you just make sure to write each event either completely or
 	// partially, always with the FIRST line correct (which could be the important one)."
-	matched := c.config.patterns.Match(text)
+	var matched bool
+	if c.config.what == codecMultilineWhatBetween {
+		matched = c.matchBetween(text)
+	} else if c.config.presets != nil {
+		matched = c.matchPreset(text)
+	} else {
+		matched = c.config.patterns.Match(text)
+	}
 
 	if c.config.what == codecMultilineWhatPrevious && !matched {
 		c.lastErr = c.flush()
@@ -228,18 +321,88 @@ func (c *CodecMultiline) ProcessEvent(startOffset int64, endOffset int64, data m
 	c.bufferLines++
 	c.bufferLen += textLen
 
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Reset the timer and unlock
-			c.timerDeadline = time.Now().Add(c.config.PreviousTimeout)
-		}
-	} else if c.config.what == codecMultilineWhatNext && !matched {
+	if c.config.usesTimeout() {
+		// Reset the timer and unlock
+		c.timerDeadline = time.Now().Add(c.config.PreviousTimeout)
+	}
+
+	if (c.config.what == codecMultilineWhatNext || c.config.what == codecMultilineWhatBetween) && !matched {
+		c.lastErr = c.flush()
+	} else if c.config.MaxLines != 0 && c.bufferLines >= c.config.MaxLines {
+		// Too many lines so flush and continue the remaining lines in a new
+		// event, as we do when exceeding max multiline bytes
 		c.lastErr = c.flush()
 	}
 
 	return c.lastErr
 }
 
+// matchBetween tracks whether the line is within a block that begins with a
+// line matching the start patterns and ends with a line matching the end
+// patterns, which can be the same line. It returns true if the line belongs
+// with the next line, which is the case until the end of the block
+func (c *CodecMultiline) matchBetween(text string) bool {
+	if !c.inBlock {
+		if !c.config.startPatterns.Match(text) {
+			// Lines outside of a block are events on their own
+			return false
+		}
+		c.inBlock = true
+	}
+
+	if c.config.endPatterns.Match(text) {
+		c.inBlock = false
+		return false
+	}
+
+	return true
+}
+
+// matchPreset returns true if the line is part of a stack trace detected by
+// the preset. It tracks the trace that is active so that the patterns for the
+// remaining lines of a trace only apply within it
+func (c *CodecMultiline) matchPreset(text string) bool {
+	for _, preset := range c.config.presets {
+		if preset.header != nil && preset.header.Match(text) {
+			// Begins a new event
+			c.activePreset = preset
+			return false
+		}
+		if preset.start != nil && preset.start.Match(text) {
+			c.activePreset = preset
+			return true
+		}
+	}
+
+	if c.activePreset != nil && c.activePreset.trace != nil && c.activePreset.trace.Match(text) {
+		return true
+	}
+
+	c.activePreset = nil
+	return false
+}
+
+// Flush ships any buffered lines immediately as a single event, such as when
+// the file is truncated and the remaining lines will never arrive
+func (c *CodecMultiline) Flush() error {
+	if c.config.usesTimeout() {
+		// Prevent a flush happening while we're modifying the stored data
+		c.timerLock.Lock()
+		defer func() {
+			c.timerLock.Unlock()
+		}()
+	}
+
+	if c.lastErr != nil {
+		return c.lastErr
+	}
+
+	c.lastErr = c.flush()
+	c.inBlock = false
+	c.activePreset = nil
+	return c.lastErr
+}
+
 // flush is called internally when a multiline event is ready.
 // It combines the lines collected and passes the new event to the callback
 func (c *CodecMultiline) flush() error {
@@ -304,6 +467,10 @@ DeadlineLoop:
 				}
 
 				c.lastErr = c.flush()
+
+				// An incomplete block was flushed, so look for the start of
+				// the next block
+				c.inBlock = false
 			}
 
 			if c.lastErr != nil {
@@ -2,6 +2,7 @@ package codecs
 
 import (
 	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -390,6 +391,44 @@ func TestMultilineReset(t *testing.T) {
 	}
 }
 
+func TestMultilineFlush(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 3, "DEBUG First line\nNEXT line"},
+			{4, 5, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"patterns": []string{"^(ANOTHER|NEXT) "},
+			"what":     "previous",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codecEvent(codec, 0, 1, "DEBUG First line")
+	codecEvent(codec, 2, 3, "NEXT line")
+
+	if err := codec.(codecs.Flusher).Flush(); err != nil {
+		t.Error("Flush returned an error: ", err)
+	}
+	check.CheckCurrentCount(1, "Flush did not ship buffered lines")
+
+	codecEvent(codec, 4, 5, "DEBUG Next line")
+	codecEvent(codec, 6, 7, "DEBUG Last line")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
 func TestMultilineMultiplePattern(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
@@ -634,3 +673,296 @@ func TestMultilineErrorTimeout(t *testing.T) {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+func TestMultilineBetween(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 1, "DEBUG First line"},
+			{2, 7, "<record>\n  <id>1</id>\n</record>"},
+			{8, 9, "<record><id>2</id></record>"},
+			{10, 11, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"start patterns": []string{"^<record>"},
+			"end patterns":   []string{"</record>$"},
+			"what":           "between",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codecEvent(codec, 0, 1, "DEBUG First line")
+	codecEvent(codec, 2, 3, "<record>")
+	codecEvent(codec, 4, 5, "  <id>1</id>")
+	codecEvent(codec, 6, 7, "</record>")
+	codecEvent(codec, 8, 9, "<record><id>2</id></record>")
+	codecEvent(codec, 10, 11, "DEBUG Next line")
+	codecEvent(codec, 12, 13, "<record>")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 11 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineBetweenTimeout(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 3, "{\n  \"id\": 1,"},
+			{4, 5, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"start patterns":   []string{"^\\{"},
+			"end patterns":     []string{"^\\}"},
+			"what":             "between",
+			"previous timeout": "1s",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send an incomplete block
+	codecEvent(codec, 0, 1, "{")
+	codecEvent(codec, 2, 3, "  \"id\": 1,")
+
+	time.Sleep(2 * time.Second)
+
+	check.CheckCurrentCount(1, "Timeout did not trigger")
+
+	// Lines after the timeout are no longer within the block
+	codecEvent(codec, 4, 5, "DEBUG Next line")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineBetweenPatterns(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.GeneralPart("spooler").(*spooler.General).SpoolMaxBytes = 10485760
+
+	_, err := NewMultilineCodecFactory(config.NewParser(cfg), "", map[string]interface{}{
+		"patterns": []string{"^<record>"},
+		"what":     "between",
+	}, "multiline")
+	if err == nil {
+		t.Error("Patterns were accepted for between")
+	}
+
+	_, err = NewMultilineCodecFactory(config.NewParser(cfg), "", map[string]interface{}{
+		"patterns":       []string{"^DEBUG "},
+		"start patterns": []string{"^<record>"},
+	}, "multiline")
+	if err == nil {
+		t.Error("Start patterns were accepted for previous")
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 5, "DEBUG First line\nsecond line\nthird line"},
+			{6, 7, "fourth line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"max lines": int64(3),
+			"patterns":  []string{"!^DEBUG "},
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codecEvent(codec, 0, 1, "DEBUG First line")
+	codecEvent(codec, 2, 3, "second line")
+	codecEvent(codec, 4, 5, "third line")
+	codecEvent(codec, 6, 7, "fourth line")
+	codecEvent(codec, 8, 9, "DEBUG Next line")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func testMultilinePreset(t *testing.T, preset string, lines []string) {
+	end := int64(len(lines)*2 - 1)
+
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, end, strings.Join(lines, "\n")},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"preset": preset,
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send the trace followed by the next event
+	for idx, line := range lines {
+		codecEvent(codec, int64(idx*2), int64(idx*2+1), line)
+	}
+	codecEvent(codec, end+1, end+2, "INFO Next line")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != end {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilinePresetJava(t *testing.T) {
+	testMultilinePreset(t, "java", []string{
+		"ERROR Something failed",
+		"java.lang.IllegalStateException: Failed to process",
+		"\tat com.example.Processor.process(Processor.java:42)",
+		"\tat com.example.Main.main(Main.java:10)",
+		"Caused by: java.lang.NullPointerException",
+		"\tat com.example.Processor.load(Processor.java:80)",
+		"\t... 2 more",
+	})
+}
+
+func TestMultilinePresetPython(t *testing.T) {
+	testMultilinePreset(t, "python", []string{
+		"ERROR Something failed",
+		"Traceback (most recent call last):",
+		"  File \"app.py\", line 10, in <module>",
+		"    main()",
+		"  File \"app.py\", line 6, in main",
+		"    return 1 / 0",
+		"ZeroDivisionError: division by zero",
+	})
+}
+
+func TestMultilinePresetGo(t *testing.T) {
+	testMultilinePreset(t, "go", []string{
+		"panic: runtime error: index out of range [5] with length 3",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/src/app/main.go:8 +0x1d",
+		"net/http.(*conn).serve(0xc0001b6000, {0x7a2c38, 0xc00009c1e0})",
+		"\t/usr/local/go/src/net/http/server.go:2009 +0x615",
+		"created by net/http.(*Server).Serve in goroutine 1",
+		"exit status 2",
+	})
+}
+
+func TestMultilinePresetRuby(t *testing.T) {
+	testMultilinePreset(t, "ruby", []string{
+		"app.rb:3:in `foo': undefined method `bar' for nil:NilClass (NoMethodError)",
+		"\tfrom app.rb:7:in `<main>'",
+		"  app/models/user.rb:10:in 'User#save'",
+	})
+}
+
+func TestMultilinePresetDotnet(t *testing.T) {
+	testMultilinePreset(t, "dotnet", []string{
+		"ERROR Something failed",
+		"System.InvalidOperationException: Operation is not valid",
+		" ---> System.ArgumentException: Value does not fall within the expected range.",
+		"   at MyApp.Loader.Load(String path) in C:\\src\\Loader.cs:line 12",
+		"   --- End of inner exception stack trace ---",
+		"   at MyApp.Program.Main(String[] args) in C:\\src\\Program.cs:line 10",
+	})
+}
+
+func TestMultilinePresetStacktraces(t *testing.T) {
+	testMultilinePreset(t, "stacktraces", []string{
+		"ERROR Something failed",
+		"java.lang.IllegalStateException: Failed to process",
+		"\tat com.example.Processor.process(Processor.java:42)",
+	})
+}
+
+func TestMultilinePresetOutsideTrace(t *testing.T) {
+	// Blank lines and lines that look like Go stack frames are only joined
+	// within a trace
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 1, "INFO Calling"},
+			{2, 3, "foo.bar(x)"},
+			{4, 5, ""},
+			{6, 7, ""},
+			{8, 17, "panic: failed\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/app/main.go:8 +0x1d"},
+			{18, 19, "INFO Next line"},
+			{20, 21, "foo.bar(y)"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"preset": "stacktraces",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	codecEvent(codec, 0, 1, "INFO Calling")
+	codecEvent(codec, 2, 3, "foo.bar(x)")
+	codecEvent(codec, 4, 5, "")
+	codecEvent(codec, 6, 7, "")
+	codecEvent(codec, 8, 9, "panic: failed")
+	codecEvent(codec, 10, 11, "")
+	codecEvent(codec, 12, 13, "goroutine 1 [running]:")
+	codecEvent(codec, 14, 15, "main.main()")
+	codecEvent(codec, 16, 17, "\t/src/app/main.go:8 +0x1d")
+	codecEvent(codec, 18, 19, "INFO Next line")
+	codecEvent(codec, 20, 21, "foo.bar(y)")
+	codecEvent(codec, 22, 23, "INFO Last line")
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 21 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilinePresetInvalid(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.GeneralPart("spooler").(*spooler.General).SpoolMaxBytes = 10485760
+
+	_, err := NewMultilineCodecFactory(config.NewParser(cfg), "", map[string]interface{}{
+		"preset": "cobol",
+	}, "multiline")
+	if err == nil {
+		t.Error("Unknown preset was accepted")
+	}
+
+	_, err = NewMultilineCodecFactory(config.NewParser(cfg), "", map[string]interface{}{
+		"preset":   "java",
+		"patterns": []string{"^DEBUG "},
+	}, "multiline")
+	if err == nil {
+		t.Error("Patterns were accepted alongside a preset")
+	}
+}
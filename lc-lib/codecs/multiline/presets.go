@@ -0,0 +1,114 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+// multilinePreset contains the patterns for a type of stack trace. Lines that
+// match are joined onto the previous line, so that each trace is joined onto
+// the line that was logged before it
+type multilinePreset struct {
+	// header matches the lines that begin a trace in a new event, for traces
+	// that are not logged alongside another line
+	header []string
+	// start matches the lines that begin a trace
+	start []string
+	// trace matches the remaining lines of a trace, and only applies once a
+	// trace has begun, so that patterns such as those for blank or indented
+	// lines do not join lines outside of a trace
+	trace []string
+}
+
+// multilinePresets contains the stack traces detected by each of the built-in
+// presets
+var multilinePresets = map[string][]*multilinePreset{
+	"java":        {javaPreset},
+	"python":      {pythonPreset},
+	"go":          {goPreset},
+	"ruby":        {rubyPreset},
+	"dotnet":      {dotnetPreset},
+	"stacktraces": {javaPreset, pythonPreset, goPreset, rubyPreset, dotnetPreset},
+}
+
+var (
+	// javaPreset matches the exception and its stack frames, including those
+	// of causes and suppressed exceptions
+	javaPreset = &multilinePreset{
+		start: []string{
+			`^([a-zA-Z_$][\w$]*\.)+[a-zA-Z_$][\w$]*(Exception|Error|Throwable)(: .*)?$`,
+		},
+		trace: []string{
+			`^\s+at `,
+			`^\s+\.\.\. \d+ (more|common frames omitted)$`,
+			`^\s*Caused by: `,
+			`^\s+Suppressed: `,
+		},
+	}
+
+	// pythonPreset matches a traceback, including chained tracebacks and the
+	// final exception line
+	pythonPreset = &multilinePreset{
+		start: []string{
+			`^Traceback \(most recent call last\):$`,
+		},
+		trace: []string{
+			`^\s+`,
+			`^$`,
+			`^During handling of the above exception, another exception occurred:$`,
+			`^The above exception was the direct cause of the following exception:$`,
+			`^[a-zA-Z_][\w.]*(Error|Exception|Warning|Exit|Interrupt|StopIteration)(: .*)?$`,
+		},
+	}
+
+	// goPreset matches a panic or fatal error and the goroutine traces that
+	// follow it
+	goPreset = &multilinePreset{
+		header: []string{
+			`^(panic|fatal error): `,
+		},
+		start: []string{
+			`^goroutine \d+ \[.*\]:$`,
+		},
+		trace: []string{
+			`^$`,
+			`^\s+`,
+			`^[\w\-./]+(\.\(\*?\w+\))?\.[\w\-.]+(\[\.\.\.\])?\(.*\)$`,
+			`^created by `,
+			`^\[signal `,
+			`^exit status \d+$`,
+		},
+	}
+
+	// rubyPreset matches the backtrace lines that follow an exception, which
+	// are specific enough to not need a trace to have begun
+	rubyPreset = &multilinePreset{
+		start: []string{
+			"^\\s+(from )?\\S+:\\d+:in [`']",
+		},
+	}
+
+	// dotnetPreset matches the exception, its stack frames, and those of any
+	// inner exceptions
+	dotnetPreset = &multilinePreset{
+		start: []string{
+			`^([a-zA-Z_]\w*\.)+[a-zA-Z_]\w*Exception(: .*)?$`,
+		},
+		trace: []string{
+			`^\s+at `,
+			`^\s*---> `,
+			`^\s*--- End of `,
+		},
+	}
+)
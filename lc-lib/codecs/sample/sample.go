@@ -0,0 +1,222 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+const (
+	codecSampleModeCount = "count"
+	codecSampleModeRate  = "rate"
+	codecSampleModeHash  = "hash"
+)
+
+// CodecSampleFactory holds the configuration for a sample codec
+type CodecSampleFactory struct {
+	Mode       string `config:"mode"`
+	Ratio      int64  `config:"ratio"`
+	Rate       int64  `config:"rate"`
+	KeyPattern string `config:"key pattern"`
+
+	keyPattern *regexp.Regexp
+}
+
+// CodecSample is an instance of a sample codec that is used by the Harvester
+// to ship only a sample of events
+type CodecSample struct {
+	config       *CodecSampleFactory
+	lastOffset   int64
+	callbackFunc codecs.CallbackFunc
+
+	count        int64
+	windowStart  time.Time
+	windowSeen   int64
+	windowKept   int64
+	windowRatio  float64
+	sampledLines uint64
+	droppedLines uint64
+
+	meterSampled uint64
+	meterDropped uint64
+}
+
+// NewSampleCodecFactory creates a new SampleCodecFactory for a codec
+// definition in the configuration file. This factory can be used to create
+// instances of a sample codec for use by harvesters
+func NewSampleCodecFactory(p *config.Parser, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecSampleFactory{}
+	if err = p.Populate(result, unused, configPath, true); err != nil {
+		return nil, err
+	}
+
+	switch result.Mode {
+	case codecSampleModeCount, codecSampleModeHash:
+		if result.Ratio < 1 {
+			return nil, fmt.Errorf("Ratio for sample codec at %s must be at least 1", configPath)
+		}
+	case codecSampleModeRate:
+		if result.Rate < 1 {
+			return nil, fmt.Errorf("Rate for sample codec at %s must be at least 1", configPath)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown \"mode\" value, '%s', for sample codec at %s", result.Mode, configPath)
+	}
+
+	if result.Mode == codecSampleModeHash {
+		if result.KeyPattern == "" {
+			return nil, fmt.Errorf("Key pattern for sample codec at %s is required when mode is '%s'", configPath, codecSampleModeHash)
+		}
+		if result.keyPattern, err = regexp.Compile(result.KeyPattern); err != nil {
+			return nil, fmt.Errorf("Failed to compile key pattern for sample codec at %s, '%s': %s", configPath, result.KeyPattern, err)
+		}
+		if result.keyPattern.NumSubexp() < 1 {
+			return nil, fmt.Errorf("Key pattern for sample codec at %s must contain a capture group", configPath)
+		}
+	} else if result.KeyPattern != "" {
+		return nil, fmt.Errorf("Key pattern for sample codec at %s can only be specified when mode is '%s'", configPath, codecSampleModeHash)
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecSampleFactory) NewCodec(callbackFunc codecs.CallbackFunc, offset int64) codecs.Codec {
+	return &CodecSample{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+		windowRatio:  1,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecSample) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecSample) Reset() {
+}
+
+// ProcessEvent is called by a Harvester when a new event occurs on a file
+// Only the sampled events are shipped to the callback, with the sample rate
+// added so that downstream counts can be weighted accordingly
+func (c *CodecSample) ProcessEvent(startOffset int64, endOffset int64, data map[string]interface{}) error {
+	// TODO: Option to set the field
+	text, ok := data["message"].(string)
+	if !ok {
+		text = ""
+	}
+
+	var keep bool
+	var sampleRate float64
+	switch c.config.Mode {
+	case codecSampleModeCount:
+		keep = c.count%c.config.Ratio == 0
+		c.count++
+		sampleRate = float64(c.config.Ratio)
+	case codecSampleModeRate:
+		keep, sampleRate = c.sampleRate(time.Now())
+	case codecSampleModeHash:
+		keep = c.sampleHash(text)
+		sampleRate = float64(c.config.Ratio)
+	}
+
+	if keep {
+		data["sample_rate"] = sampleRate
+		err := c.callbackFunc(startOffset, endOffset, data)
+		if err != nil {
+			return err
+		}
+		c.sampledLines++
+	} else {
+		c.droppedLines++
+	}
+
+	c.lastOffset = endOffset
+	return nil
+}
+
+// sampleRate keeps up to the configured rate of events in each second. As the
+// number of events in the current second is not yet known, the sample rate
+// is taken from the previous second
+func (c *CodecSample) sampleRate(now time.Time) (bool, float64) {
+	if now.Sub(c.windowStart) >= time.Second {
+		if c.windowKept != 0 && now.Sub(c.windowStart) < 2*time.Second {
+			c.windowRatio = float64(c.windowSeen) / float64(c.windowKept)
+		} else {
+			// Nothing was seen during the previous second
+			c.windowRatio = 1
+		}
+		c.windowStart = now
+		c.windowSeen = 0
+		c.windowKept = 0
+	}
+
+	c.windowSeen++
+	if c.windowKept >= c.config.Rate {
+		return false, 0
+	}
+
+	c.windowKept++
+	return true, c.windowRatio
+}
+
+// sampleHash keeps events whose key hashes into the sample, so that events
+// with the same key are either all kept or all dropped. Events that do not
+// match the key pattern are sampled using the entire message
+func (c *CodecSample) sampleHash(text string) bool {
+	key := text
+	if matches := c.config.keyPattern.FindStringSubmatch(text); matches != nil {
+		key = matches[1]
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32()%uint32(c.config.Ratio) == 0
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecSample) Meter() {
+	c.meterSampled = c.sampledLines
+	c.meterDropped = c.droppedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecSample) APIEncodable() api.Encodable {
+	apiKV := &api.KeyValue{}
+	apiKV.SetEntry("sampled_lines", api.Number(c.meterSampled))
+	apiKV.SetEntry("dropped_lines", api.Number(c.meterDropped))
+	return apiKV
+}
+
+// Register the codec
+func init() {
+	codecs.Register("sample", NewSampleCodecFactory)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+type checkSample struct {
+	events []map[string]interface{}
+}
+
+func (c *checkSample) EventCallback(startOffset int64, endOffset int64, data map[string]interface{}) error {
+	c.events = append(c.events, data)
+	return nil
+}
+
+func createSampleFactory(unused map[string]interface{}) (*CodecSampleFactory, error) {
+	factory, err := NewSampleCodecFactory(config.NewParser(config.NewConfig()), "", unused, "sample")
+	if err != nil {
+		return nil, err
+	}
+	return factory.(*CodecSampleFactory), nil
+}
+
+func createSampleCodec(unused map[string]interface{}, callback codecs.CallbackFunc, t *testing.T) *CodecSample {
+	factory, err := createSampleFactory(unused)
+	if err != nil {
+		t.Fatalf("Failed to create sample codec: %s", err)
+	}
+
+	return factory.NewCodec(callback, 0).(*CodecSample)
+}
+
+func codecEvent(codec codecs.Codec, startOffset int64, endOffset int64, data string) error {
+	return codec.ProcessEvent(startOffset, endOffset, map[string]interface{}{"message": data})
+}
+
+func TestSampleCount(t *testing.T) {
+	check := &checkSample{}
+	codec := createSampleCodec(map[string]interface{}{
+		"mode":  "count",
+		"ratio": int64(3),
+	}, check.EventCallback, t)
+
+	for i := 0; i < 7; i++ {
+		codecEvent(codec, int64(i*10), int64(i*10+10), fmt.Sprintf("line %d", i))
+	}
+
+	if len(check.events) != 3 {
+		t.Fatalf("Unexpected event count, got: %d, expected: 3", len(check.events))
+	}
+	for idx, expected := range []string{"line 0", "line 3", "line 6"} {
+		if check.events[idx]["message"] != expected {
+			t.Errorf("Unexpected message for event %d, got: %v, expected: %s", idx, check.events[idx]["message"], expected)
+		}
+		if check.events[idx]["sample_rate"] != float64(3) {
+			t.Errorf("Unexpected sample rate for event %d: %v", idx, check.events[idx]["sample_rate"])
+		}
+	}
+
+	// Dropped lines still advance the offset
+	if offset := codec.Teardown(); offset != 70 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	codec := createSampleCodec(map[string]interface{}{
+		"mode": "rate",
+		"rate": int64(2),
+	}, (&checkSample{}).EventCallback, t)
+
+	now := time.Now()
+	for idx, expected := range []bool{true, true, false, false} {
+		if keep, rate := codec.sampleRate(now); keep != expected {
+			t.Errorf("Unexpected result for event %d in first second, got: %t, expected: %t", idx, keep, expected)
+		} else if keep && rate != 1 {
+			t.Errorf("Unexpected sample rate for event %d in first second: %f", idx, rate)
+		}
+	}
+
+	// The sample rate in the next second is that of the previous second
+	now = now.Add(time.Second)
+	if keep, rate := codec.sampleRate(now); !keep || rate != 2 {
+		t.Errorf("Unexpected result in second second, got: %t, %f", keep, rate)
+	}
+
+	// After a pause there is nothing to base the sample rate on
+	now = now.Add(5 * time.Second)
+	if keep, rate := codec.sampleRate(now); !keep || rate != 1 {
+		t.Errorf("Unexpected result after pause, got: %t, %f", keep, rate)
+	}
+}
+
+func TestSampleHash(t *testing.T) {
+	check := &checkSample{}
+	codec := createSampleCodec(map[string]interface{}{
+		"mode":        "hash",
+		"ratio":       int64(4),
+		"key pattern": "request=([0-9]+)",
+	}, check.EventCallback, t)
+
+	// Lines with the same key are always kept or dropped together
+	kept := make(map[int]int)
+	for i := 0; i < 400; i++ {
+		request := i % 100
+		count := len(check.events)
+		codecEvent(codec, int64(i), int64(i+1), fmt.Sprintf("step %d request=%d", i, request))
+		kept[request] += len(check.events) - count
+	}
+
+	keptRequests := 0
+	for request, count := range kept {
+		if count != 0 && count != 4 {
+			t.Errorf("Request %d was partially sampled: %d of 4", request, count)
+		}
+		if count != 0 {
+			keptRequests++
+		}
+	}
+	if keptRequests == 0 || keptRequests == 100 {
+		t.Errorf("Unexpected number of requests sampled: %d", keptRequests)
+	}
+
+	if offset := codec.Teardown(); offset != 400 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSampleInvalid(t *testing.T) {
+	for _, unused := range []map[string]interface{}{
+		{"mode": "random", "ratio": int64(2)},
+		{"mode": "count"},
+		{"mode": "rate", "ratio": int64(2)},
+		{"mode": "hash", "ratio": int64(2)},
+		{"mode": "hash", "ratio": int64(2), "key pattern": "request=[0-9]+"},
+		{"mode": "count", "ratio": int64(2), "key pattern": "request=([0-9]+)"},
+	} {
+		if _, err := createSampleFactory(unused); err == nil {
+			t.Errorf("Invalid configuration was accepted: %v", unused)
+		}
+	}
+}
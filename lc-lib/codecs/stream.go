@@ -142,6 +142,27 @@ func (cs *Stream) Reset() {
 	cs.firstCodec.Reset()
 }
 
+// Flush causes all codecs that buffer events to ship them immediately, in
+// the order the codecs are used so that events flushed by one codec can be
+// flushed by the next
+func (cs *Stream) Flush() error {
+	if flusher, ok := cs.firstCodec.(Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, codec := range cs.codecChain {
+		if flusher, ok := codec.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // Meter causes the stream to calculate metrics for itself and all codecs
 func (cs *Stream) Meter() {
 	for _, codec := range cs.codecChain {
@@ -152,17 +152,23 @@ func (a *App) Run() {
 
 	badExit := false
 SignalLoop:
-	for signal := range a.signalChan {
-		if signal == nil || isShutdownSignal(signal) {
-			if signal == syscall.SIGKILL {
-				// Pipeline start failed
-				badExit = true
-			}
-			log.Notice("Gracefully shutting down due to signal, send again to force immediate shutdown (data loss may occur)")
+	for {
+		select {
+		case <-a.pipeline.Done():
+			// All sources finished and everything was published
 			break SignalLoop
-		}
+		case signal := <-a.signalChan:
+			if signal == nil || isShutdownSignal(signal) {
+				if signal == syscall.SIGKILL {
+					// Pipeline start failed
+					badExit = true
+				}
+				log.Notice("Gracefully shutting down due to signal, send again to force immediate shutdown (data loss may occur)")
+				break SignalLoop
+			}
 
-		a.ReloadConfig()
+			a.ReloadConfig()
+		}
 	}
 
 	a.pipeline.Shutdown()
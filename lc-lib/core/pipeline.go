@@ -36,6 +36,7 @@ type Pipeline struct {
 	services         []pipelineServiceSegment
 	signal           chan struct{}
 	signalSources    chan struct{}
+	done             chan struct{}
 	group            sync.WaitGroup
 	groupSources     sync.WaitGroup
 	groupServices    sync.WaitGroup
@@ -49,6 +50,7 @@ func NewPipeline() *Pipeline {
 	p := &Pipeline{
 		signal:        make(chan struct{}),
 		signalSources: make(chan struct{}),
+		done:          make(chan struct{}),
 		configSinks:   make(map[pipelineConfigSegment]chan *config.Config),
 	}
 
@@ -108,6 +110,14 @@ func (p *Pipeline) Run(config *config.Config, signalChan chan<- os.Signal) {
 	p.run(&p.group, p.sink.Run)
 	// If the sink is not accepting events anymore then nothing is needed anymore
 	p.shutdownAll()
+	close(p.done)
+}
+
+// Done returns a channel that is closed when the pipeline has completely
+// finished after running successfully, which happens when all sources finish
+// or after a shutdown is requested
+func (p *Pipeline) Done() <-chan struct{} {
+	return p.done
 }
 
 // Run the pipeline, starting up each segment and then waiting for sink to finish or shutdown
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+type testSource struct {
+	output       chan<- []*event.Event
+	shutdownChan <-chan struct{}
+	count        int
+	waitShutdown bool
+}
+
+func (s *testSource) Init(*config.Config) error {
+	return nil
+}
+
+func (s *testSource) Run() {
+	for i := 0; i < s.count; i++ {
+		s.output <- []*event.Event{event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "test"})}
+	}
+	if s.waitShutdown {
+		<-s.shutdownChan
+	}
+}
+
+func (s *testSource) SetShutdownChan(shutdownChan <-chan struct{}) {
+	s.shutdownChan = shutdownChan
+}
+
+func (s *testSource) SetOutput(output chan<- []*event.Event) {
+	s.output = output
+}
+
+type testSink struct {
+	input    chan []*event.Event
+	received int
+}
+
+func newTestSink() *testSink {
+	return &testSink{input: make(chan []*event.Event)}
+}
+
+func (s *testSink) Init(*config.Config) error {
+	return nil
+}
+
+func (s *testSink) Run() {
+	for spool := range s.input {
+		s.received += len(spool)
+	}
+}
+
+func (s *testSink) Input() chan<- []*event.Event {
+	return s.input
+}
+
+func waitPipelineDone(t *testing.T, p *Pipeline) {
+	select {
+	case <-p.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for pipeline to finish")
+	}
+}
+
+func TestPipelineDoneWhenSourcesFinish(t *testing.T) {
+	p := NewPipeline()
+	p.AddSource(&testSource{count: 3})
+	p.AddSource(&testSource{count: 2})
+	sink := newTestSink()
+	p.SetSink(sink)
+
+	go p.Run(config.NewConfig(), make(chan os.Signal, 1))
+	waitPipelineDone(t, p)
+
+	if sink.received != 5 {
+		t.Errorf("Unexpected event count, got: %d, expected: 5", sink.received)
+	}
+}
+
+func TestPipelineDoneAfterShutdown(t *testing.T) {
+	p := NewPipeline()
+	p.AddSource(&testSource{count: 1, waitShutdown: true})
+	sink := newTestSink()
+	p.SetSink(sink)
+
+	go p.Run(config.NewConfig(), make(chan os.Signal, 1))
+
+	select {
+	case <-p.Done():
+		t.Fatalf("Pipeline finished before shutdown")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	p.Shutdown()
+	waitPipelineDone(t, p)
+
+	if sink.received != 1 {
+		t.Errorf("Unexpected event count, got: %d, expected: 1", sink.received)
+	}
+}
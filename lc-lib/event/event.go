@@ -80,6 +80,18 @@ func NewEventFromBytes(ctx context.Context, acker Acknowledger, data []byte) *Ev
 	}
 }
 
+// Derive returns a new event that shares the context and data of this event
+// but has a different acknowledger and its own encoding cache. The data must
+// not be modified through either event whilst both are in use
+func (e *Event) Derive(acker Acknowledger) *Event {
+	return &Event{
+		ctx:     e.ctx,
+		acker:   acker,
+		data:    e.data,
+		encoded: e.encoded,
+	}
+}
+
 // Data returns the internal event data for reading or mutation
 // The return data must NOT be mutated
 func (e *Event) Data() map[string]interface{} {
@@ -149,8 +161,11 @@ func (e *Event) convertData() {
 	} else {
 		e.data["@timestamp"] = Timestamp(time.Now())
 	}
-	// Normalize "@metadata"
-	e.data["@metadata"] = Metadata{}
+	// Normalize "@metadata", keeping it only if it was populated internally as
+	// it can never be of type Metadata when it came in over the wire
+	if _, ok := e.data["@metadata"].(Metadata); !ok {
+		e.data["@metadata"] = Metadata{}
+	}
 }
 
 // MustResolve is the same as Resolve but will panic if an error occurs
@@ -180,7 +195,7 @@ func (e *Event) MustResolve(path string, set interface{}) interface{} {
 //	    If the existing value is invalid, the _timestamp_parse_failure tag is added
 //	    and the error is stored inside the timestamp_parse_error field
 //	@metadata: Metadata (Empty Metadata instance)
-//	    Always empty, and overrides any value that came in over the wire.
+//	    Empty unless populated internally, such as by a receiver, and overrides any value that came in over the wire.
 //	    It is purely for processing metadata and is removed prior to any sending
 //	tags: Tags (Empty Tags instance)
 //	    If no value currently exists, it is created empty with no tags.
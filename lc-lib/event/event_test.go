@@ -154,6 +154,20 @@ func TestNewEventInvalidMismatchTags(t *testing.T) {
 	}
 }
 
+func TestNewEventMetadataOverWire(t *testing.T) {
+	event := NewEvent(context.Background(), nil, map[string]interface{}{"@metadata": map[string]interface{}{"index": "test"}})
+	if metadata, ok := event.Data()["@metadata"].(Metadata); !ok || len(metadata) != 0 {
+		t.Fatalf("Metadata from the wire was not replaced: %v", event.Data())
+	}
+}
+
+func TestNewEventMetadataInternal(t *testing.T) {
+	event := NewEvent(context.Background(), nil, map[string]interface{}{"@metadata": Metadata{"index": "test"}})
+	if result := event.MustResolve("@metadata[index]", nil); result != "test" {
+		t.Fatalf("Internal metadata was not kept: %v", event.Data())
+	}
+}
+
 func TestNewEventBytes(t *testing.T) {
 	event := NewEventFromBytes(context.Background(), nil, []byte("{\"message\":\"basic event\"}"))
 	if timestamp, ok := event.Data()["@timestamp"].(Timestamp); ok {
@@ -669,6 +683,34 @@ func TestDispatchAckNilOrEmpty(t *testing.T) {
 	DispatchAck([]*Event{})
 }
 
+func TestDeriveAcknowledger(t *testing.T) {
+	acker := &TestAckknowledger{}
+	acker2 := &TestAckknowledger{}
+	event := NewEvent(context.Background(), acker, map[string]interface{}{"message": "Hello"})
+	derived := event.Derive(acker2)
+	if derived.Data()["message"] != "Hello" {
+		t.Fatalf("Derived event data does not match: %v", derived.Data())
+	}
+	DispatchAck([]*Event{derived})
+	if len(acker.events) != 0 || len(acker2.events) != 1 || acker2.events[0] != derived {
+		t.Fatalf("Derived event acknowledged incorrectly (acker1: %d of 0) (acker2: %d of 1)", len(acker.events), len(acker2.events))
+	}
+}
+
+func TestDeriveEncodingCache(t *testing.T) {
+	event := NewEvent(context.Background(), nil, map[string]interface{}{"message": "Hello"})
+	encoded := event.Bytes()
+	derived := event.Derive(nil)
+	derived.Data()["message"] = "Changed"
+	derived.ClearCache()
+	if !bytes.Contains(derived.Bytes(), []byte("Changed")) {
+		t.Fatalf("Derived event did not share data: %s", derived.Bytes())
+	}
+	if !bytes.Equal(event.Bytes(), encoded) {
+		t.Fatalf("Derived event shared encoding cache: %s", event.Bytes())
+	}
+}
+
 // TODO: Bytes() encoding error
 
 // TODO: Context
@@ -70,7 +70,18 @@ func (sc *StreamConfig) Validate(p *config.Parser, path string) (err error) {
 // Decorate applies all transformations necessary from the stream configuration
 // to the data that will eventually become an event
 func (sc *StreamConfig) Decorate(data map[string]interface{}) map[string]interface{} {
-	data["@timestamp"] = time.Now()
+	return sc.DecorateWithFields(data, nil)
+}
+
+// DecorateWithFields is the same as Decorate but additionally adds the given
+// fields, such as those extracted from a file's path, alongside the configured
+// fields, which take precedence over them
+func (sc *StreamConfig) DecorateWithFields(data map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	// Readers can provide the time from the log itself, which will always be a
+	// time.Time, whereas data decoded from JSON can only contain a string
+	if _, ok := data["@timestamp"].(time.Time); !ok {
+		data["@timestamp"] = time.Now()
+	}
 	if sc.AddHostField {
 		if sc.EnableECS {
 			data["host"] = map[string]interface{}{
@@ -106,6 +117,10 @@ func (sc *StreamConfig) Decorate(data map[string]interface{}) map[string]interfa
 		data[k] = sc.genConfig.GlobalFields[k]
 	}
 
+	for k := range fields {
+		data[k] = fields[k]
+	}
+
 	for k := range sc.Fields {
 		data[k] = sc.Fields[k]
 	}
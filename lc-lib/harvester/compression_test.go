@@ -0,0 +1,145 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func createCompressedFile(t *testing.T, compression string, data string) *os.File {
+	var buffer bytes.Buffer
+	switch compression {
+	case compressionGzip:
+		writer := gzip.NewWriter(&buffer)
+		writer.Write([]byte(data))
+		writer.Close()
+	case compressionZstd:
+		writer, err := zstd.NewWriter(&buffer)
+		if err != nil {
+			t.Fatalf("Failed to create zstd writer: %s", err)
+		}
+		writer.Write([]byte(data))
+		writer.Close()
+	}
+
+	path := filepath.Join(t.TempDir(), "test."+compression)
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %s", path, err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestCompressionForPath(t *testing.T) {
+	tests := []struct {
+		compression string
+		path        string
+		expected    string
+	}{
+		{compressionAuto, "/var/log/messages", compressionNone},
+		{compressionAuto, "/var/log/messages.1.gz", compressionGzip},
+		{compressionAuto, "/var/log/messages.1.GZ", compressionGzip},
+		{compressionAuto, "/var/log/messages.1.zst", compressionZstd},
+		{compressionNone, "/var/log/messages.1.gz", compressionNone},
+		{compressionZstd, "/var/log/messages.1", compressionZstd},
+	}
+
+	for _, test := range tests {
+		if result := compressionForPath(test.compression, test.path); result != test.expected {
+			t.Errorf("Unexpected compression for %s (%s): %s (expected %s)", test.path, test.compression, result, test.expected)
+		}
+	}
+}
+
+func testDecompressedRead(t *testing.T, compression string) {
+	file := createCompressedFile(t, compression, "first line\nsecond line\n")
+	decompressor, err := newDecompressor(compression, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer decompressor.Close()
+
+	reader := NewLineReader(decompressor, 100, 100)
+	checkLine(t, reader, "first line", 11, nil)
+	checkLine(t, reader, "second line", 12, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+}
+
+func TestDecompressedReadGzip(t *testing.T) {
+	testDecompressedRead(t, compressionGzip)
+}
+
+func TestDecompressedReadZstd(t *testing.T) {
+	testDecompressedRead(t, compressionZstd)
+}
+
+func TestPrepareDecompressorResumes(t *testing.T) {
+	h := &Harvester{
+		path:        "test.gz",
+		file:        createCompressedFile(t, compressionGzip, "first line\nsecond line\n"),
+		compression: compressionGzip,
+		offset:      11,
+	}
+	if err := h.prepareDecompressor(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer h.decompressor.Close()
+
+	if h.offset != 11 {
+		t.Errorf("Unexpected offset: %d (expected 11)", h.offset)
+	}
+
+	reader := NewLineReader(h.decompressor, 100, 100)
+	checkLine(t, reader, "second line", 12, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+
+	info, err := h.file.Stat()
+	if err != nil {
+		t.Fatalf("Failed to stat: %s", err)
+	}
+	if h.compressedReader.count != info.Size() {
+		t.Errorf("Unexpected compressed bytes read: %d (expected %d)", h.compressedReader.count, info.Size())
+	}
+}
+
+func TestPrepareDecompressorBeyondEnd(t *testing.T) {
+	h := &Harvester{
+		path:        "test.zst",
+		file:        createCompressedFile(t, compressionZstd, "first line\n"),
+		compression: compressionZstd,
+		offset:      100,
+	}
+	if err := h.prepareDecompressor(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer h.decompressor.Close()
+
+	if h.offset != 11 {
+		t.Errorf("Unexpected offset: %d (expected 11)", h.offset)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/codecs"
@@ -29,7 +30,9 @@ import (
 
 const (
 	defaultStreamAddPathField bool          = true
+	defaultStreamCompression  string        = compressionNone
 	defaultStreamDeadTime     time.Duration = 1 * time.Hour
+	defaultStreamDelimiter    string        = "\n"
 	defaultStreamHoldTime     time.Duration = 96 * time.Hour
 	defaultStreamReader       string        = "line"
 
@@ -42,17 +45,29 @@ const (
 type StreamConfig struct {
 	*codecs.StreamConfig `config:",embed"`
 
-	AddPathField bool          `config:"add path field"`
-	DeadTime     time.Duration `config:"dead time"`
-	HoldTime     time.Duration `config:"hold time"`
-	Reader       string        `config:"reader"`
+	AddPathField     bool          `config:"add path field"`
+	Compression      string        `config:"compression"`
+	DeadTime         time.Duration `config:"dead time"`
+	Delimiter        string        `config:"delimiter"`
+	DelimiterPattern string        `config:"delimiter pattern"`
+	Encoding         string        `config:"encoding"`
+	FlushOnTruncate  bool          `config:"flush on truncate"`
+	HoldTime         time.Duration `config:"hold time"`
+	RateLimit        RateLimit     `config:"rate limit"`
+	Reader           string        `config:"reader"`
+
+	delimiter        []byte
+	delimiterPattern *regexp.Regexp
+	lineEncoding     *lineEncoding
 }
 
 // Defaults sets the default harvester stream configuration
 // Ensure we override the one from codecs.StreamConfig
 func (sc *StreamConfig) Defaults() {
 	sc.AddPathField = defaultStreamAddPathField
+	sc.Compression = defaultStreamCompression
 	sc.DeadTime = defaultStreamDeadTime
+	sc.Delimiter = defaultStreamDelimiter
 	sc.HoldTime = defaultStreamHoldTime
 	sc.Reader = defaultStreamReader
 }
@@ -68,8 +83,58 @@ func (sc *StreamConfig) Init(p *config.Parser, path string) error {
 // validation function would otherwise be inherited
 // Ensure we override the one from codecs.StreamConfig
 func (sc *StreamConfig) Validate(p *config.Parser, path string) (err error) {
-	if sc.Reader != "line" && sc.Reader != "json" {
-		return fmt.Errorf("The specified reader, \"%s\", is unrecognised; the known readers are \"line\", \"json\"", sc.Reader)
+	switch sc.Reader {
+	case "line", "json", "regex", "docker", "cri":
+	default:
+		return fmt.Errorf("The specified reader, \"%s\", is unrecognised; the known readers are \"line\", \"json\", \"regex\", \"docker\", \"cri\"", sc.Reader)
+	}
+
+	switch sc.Compression {
+	case compressionAuto, compressionNone, compressionGzip, compressionZstd:
+	default:
+		return fmt.Errorf("The specified compression, \"%s\", is unrecognised; the known compressions are \"auto\", \"none\", \"gzip\", \"zstd\"", sc.Compression)
+	}
+
+	if sc.Encoding != "" {
+		if sc.Reader != "line" && sc.Reader != "regex" {
+			return fmt.Errorf("The specified encoding, \"%s\", is not supported by the \"%s\" reader", sc.Encoding, sc.Reader)
+		}
+		if sc.lineEncoding, err = newLineEncoding(sc.Encoding); err != nil {
+			return fmt.Errorf("The specified encoding, \"%s\", cannot be used as %s", sc.Encoding, err)
+		}
+	}
+
+	if sc.Delimiter != defaultStreamDelimiter {
+		if sc.Reader != "line" {
+			return fmt.Errorf("A delimiter can only be specified for the \"line\" reader")
+		}
+		if sc.Delimiter == "" {
+			return fmt.Errorf("The delimiter cannot be empty")
+		}
+		if sc.lineEncoding != nil {
+			if sc.delimiter, err = sc.lineEncoding.encode(sc.Delimiter); err != nil {
+				return fmt.Errorf("The specified delimiter cannot be represented in the \"%s\" encoding", sc.Encoding)
+			}
+		} else {
+			sc.delimiter = []byte(sc.Delimiter)
+		}
+	}
+
+	if sc.Reader == "regex" {
+		if sc.DelimiterPattern == "" {
+			return fmt.Errorf("A delimiter pattern is required for the \"regex\" reader")
+		}
+		if sc.delimiterPattern, err = regexp.Compile(sc.DelimiterPattern); err != nil {
+			return fmt.Errorf("The specified delimiter pattern, \"%s\", is invalid: %s", sc.DelimiterPattern, err)
+		}
+		if sc.delimiterPattern.MatchString("") {
+			return fmt.Errorf("The specified delimiter pattern, \"%s\", must not match an empty string", sc.DelimiterPattern)
+		}
+		if sc.lineEncoding != nil && sc.lineEncoding.unitSize() != 1 {
+			return fmt.Errorf("The \"regex\" reader does not support the \"%s\" encoding", sc.Encoding)
+		}
+	} else if sc.DelimiterPattern != "" {
+		return fmt.Errorf("A delimiter pattern can only be specified for the \"regex\" reader")
 	}
 
 	return nil
@@ -102,11 +167,22 @@ func (sc *StreamConfig) NewHarvester(ctx context.Context, path string, fileinfo
 	} else {
 		// Grab now so we can safely use them even if prospector changes them
 		ret.isStream = false
+		ret.compression = compressionForPath(sc.Compression, path)
 	}
 
 	return ret
 }
 
+// IsCompressed returns true if the file at the given path will be
+// decompressed when harvested. Compressed files are read from the beginning
+// and are not tailed once their end is reached
+func (sc *StreamConfig) IsCompressed(path string) bool {
+	if path == Stdin {
+		return false
+	}
+	return compressionForPath(sc.Compression, path) != compressionNone
+}
+
 // General contains extra general section configuration values for the
 // harvester
 type General struct {
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// containerLine is a single line from a container runtime log, which may be
+// part of a message that was split across multiple lines
+type containerLine struct {
+	message   string
+	stream    string
+	timestamp time.Time
+	partial   bool
+}
+
+// containerLineParser parses a line from a container runtime log, returning
+// false if it is not in the expected format
+type containerLineParser func(line string) (*containerLine, bool)
+
+// containerPending is a message from a single stream that is awaiting
+// completion
+type containerPending struct {
+	first          *containerLine
+	message        strings.Builder
+	complete       bool
+	isContinuation bool
+}
+
+// ContainerReader reads container runtime logs, unwrapping the log messages
+// and reassembling those that the runtime split across multiple lines
+// Runtimes interleave the lines of each stream so messages are reassembled
+// separately for each stream
+type ContainerReader struct {
+	lr      *LineReader
+	parse   containerLineParser
+	maxSize int
+
+	pending       []*containerPending
+	pendingLength int
+	ready         *containerPending
+}
+
+// NewDockerReader returns a new ContainerReader for the Docker json-file log
+// format, with the given buffer size and maximum message size
+func NewDockerReader(rd io.Reader, size int, maxSize int) *ContainerReader {
+	return newContainerReader(rd, size, maxSize, parseDockerLine)
+}
+
+// NewCRIReader returns a new ContainerReader for the Container Runtime
+// Interface (CRI) log format used by Kubernetes, with the given buffer size and
+// maximum message size
+func NewCRIReader(rd io.Reader, size int, maxSize int) *ContainerReader {
+	return newContainerReader(rd, size, maxSize, parseCRILine)
+}
+
+// newContainerReader returns a new ContainerReader using the given parser
+func newContainerReader(rd io.Reader, size int, maxSize int, parse containerLineParser) *ContainerReader {
+	// Raw lines are allowed to be larger than the maximum message size to
+	// account for the timestamp and stream that wrap the message, as well as
+	// any escaping of it
+	return &ContainerReader{
+		lr:      NewLineReader(rd, size, maxSize*2),
+		parse:   parse,
+		maxSize: maxSize,
+	}
+}
+
+// Reset the reader, still using the same io.Reader, but as if it had just
+// being constructed. This will cause any currently buffered data to be lost
+func (r *ContainerReader) Reset() {
+	r.lr.Reset()
+	r.pending = nil
+	r.pendingLength = 0
+	r.ready = nil
+}
+
+// BufferedLen returns the current number of bytes that have been read but not
+// yet returned, including any partial messages awaiting completion
+func (r *ContainerReader) BufferedLen() int {
+	return r.lr.BufferedLen() + r.pendingLength
+}
+
+// Flush returns an event for a partial message that is awaiting completion,
+// along with the number of bytes consumed. An incomplete line at the end of
+// the log is included if it can be parsed, which is only possible for the CRI
+// format as the Docker format cannot be parsed until the line is complete
+// There is a partial message for each stream so it should be called until it
+// returns nil
+func (r *ContainerReader) Flush() (map[string]interface{}, int) {
+	if item, length := r.lr.Flush(); item != nil {
+		if line, ok := r.parse(item["message"].(string)); ok {
+			r.pendingLength += length
+			r.appendLine(line)
+		}
+	}
+
+	r.ready = nil
+	if len(r.pending) == 0 {
+		return nil, 0
+	}
+
+	pending := r.pending[0]
+	event, length := r.emitPending(pending, pending.message.Len())
+	return event, length
+}
+
+// ReadItem returns the next complete message from the log
+// Returns ErrMaxDataSizeTruncation if the message was cut short because it was
+// longer than the maximum size allowed, in the same way as the LineReader
+func (r *ContainerReader) ReadItem() (map[string]interface{}, int, error) {
+	for {
+		if pending := r.ready; pending != nil {
+			if pending.message.Len() > r.maxSize {
+				// Message is too long so emit what we can, and the remainder
+				// will be emitted as a continuation
+				event, length := r.emitPending(pending, r.maxSize)
+				pending.isContinuation = true
+				return event, length, ErrMaxDataSizeTruncation
+			}
+
+			r.ready = nil
+			if pending.complete {
+				event, length := r.emitPending(pending, pending.message.Len())
+				if pending.isContinuation {
+					// Final part of a message that was too long
+					return event, length, ErrMaxDataSizeTruncation
+				}
+				return event, length, nil
+			}
+		}
+
+		item, length, err := r.lr.ReadItem()
+		if item == nil {
+			return nil, 0, err
+		}
+
+		r.pendingLength += length
+
+		line, ok := r.parse(item["message"].(string))
+		if !ok || err == ErrMaxDataSizeTruncation {
+			// Unrecognised, or too long to parse, so pass through unchanged,
+			// completing any partial message without a stream
+			line = &containerLine{message: item["message"].(string)}
+		}
+
+		pending := r.appendLine(line)
+		if err == ErrMaxDataSizeTruncation {
+			pending.isContinuation = true
+		}
+	}
+}
+
+// appendLine appends a line to the pending message for its stream, starting a
+// new one if there is none, and returns it
+func (r *ContainerReader) appendLine(line *containerLine) *containerPending {
+	var pending *containerPending
+	for _, candidate := range r.pending {
+		if candidate.first.stream == line.stream {
+			pending = candidate
+			break
+		}
+	}
+	if pending == nil {
+		pending = &containerPending{first: line}
+		r.pending = append(r.pending, pending)
+	}
+
+	pending.message.WriteString(line.message)
+	pending.complete = !line.partial
+	r.ready = pending
+	return pending
+}
+
+// emitPending returns an event for the given pending message, containing up
+// to the given number of bytes of it, along with the number of bytes consumed
+// from the log. Any remaining message is kept pending
+// Offsets can only be given once nothing remains pending, as resuming from an
+// offset must not skip any part of a pending message, so until then the bytes
+// consumed are accounted for by a later event
+func (r *ContainerReader) emitPending(pending *containerPending, size int) (map[string]interface{}, int) {
+	message := pending.message.String()
+	if size < len(message) {
+		// Do not split a multibyte character
+		for cut := size; cut > 0; cut-- {
+			if utf8.RuneStart(message[cut]) {
+				size = cut
+				break
+			}
+		}
+	}
+
+	event := map[string]interface{}{
+		"message": message[:size],
+	}
+	if pending.first.stream != "" {
+		event["stream"] = pending.first.stream
+	}
+	if !pending.first.timestamp.IsZero() {
+		event["@timestamp"] = pending.first.timestamp
+	}
+
+	pending.message.Reset()
+	if remaining := message[size:]; remaining != "" {
+		pending.message.WriteString(remaining)
+		return event, 0
+	}
+
+	for idx, candidate := range r.pending {
+		if candidate == pending {
+			r.pending = append(r.pending[:idx], r.pending[idx+1:]...)
+			break
+		}
+	}
+
+	if len(r.pending) != 0 {
+		return event, 0
+	}
+
+	length := r.pendingLength
+	r.pendingLength = 0
+	return event, length
+}
+
+// parseDockerLine parses a line from the Docker json-file log format
+func parseDockerLine(line string) (*containerLine, bool) {
+	var entry struct {
+		Log    *string   `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Log == nil {
+		return nil, false
+	}
+
+	// Messages that were split end without a new line
+	message, complete := strings.CutSuffix(*entry.Log, "\n")
+	if complete {
+		message = strings.TrimSuffix(message, "\r")
+	}
+	return &containerLine{
+		message:   message,
+		stream:    entry.Stream,
+		timestamp: entry.Time,
+		partial:   !complete,
+	}, true
+}
+
+// parseCRILine parses a line from the CRI log format, which is of the form:
+//
+//	<timestamp> <stream> <tags> <message>
+//
+// The tags are colon separated and the first is "P" for a partial message or
+// "F" for the final part of a message
+func parseCRILine(line string) (*containerLine, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return nil, false
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, false
+	}
+
+	var partial bool
+	tag, _, _ := strings.Cut(parts[2], ":")
+	switch tag {
+	case "P":
+		partial = true
+	case "F":
+	default:
+		return nil, false
+	}
+
+	ret := &containerLine{
+		stream:    parts[1],
+		timestamp: timestamp,
+		partial:   partial,
+	}
+	if len(parts) == 4 {
+		ret.message = parts[3]
+	}
+	return ret, true
+}
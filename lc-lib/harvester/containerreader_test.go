@@ -0,0 +1,208 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func checkContainerItem(t *testing.T, reader *ContainerReader, expected string, expectedStream string, expectedLength int, expectedErr error) map[string]interface{} {
+	item, length, err := reader.ReadItem()
+	if item == nil {
+		if expectedLength != 0 {
+			t.Error("No item returned")
+		}
+	} else if message, ok := item["message"].(string); !ok || message != expected {
+		t.Errorf("Message incorrect: [%s] (expected [%s])", message, expected)
+	} else if stream, _ := item["stream"].(string); stream != expectedStream {
+		t.Errorf("Stream incorrect: [%s] (expected [%s])", stream, expectedStream)
+	}
+	if length != expectedLength {
+		t.Errorf("Unexpected length: %d (expected %d)", length, expectedLength)
+	}
+	if err != expectedErr {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	return item
+}
+
+func TestDockerRead(t *testing.T) {
+	line1 := "{\"log\":\"first line\\n\",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:05.123456789Z\"}\n"
+	line2 := "{\"log\":\"second line\\r\\n\",\"stream\":\"stderr\",\"time\":\"2024-01-02T03:04:06Z\"}\n"
+	reader := NewDockerReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	item := checkContainerItem(t, reader, "first line", "stdout", len(line1), nil)
+	expectedTime := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if timestamp, ok := item["@timestamp"].(time.Time); !ok || !timestamp.Equal(expectedTime) {
+		t.Errorf("Timestamp incorrect: [%v] (expected [%v])", item["@timestamp"], expectedTime)
+	}
+	checkContainerItem(t, reader, "second line", "stderr", len(line2), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+	if reader.BufferedLen() != 0 {
+		t.Errorf("Unexpected buffered length: %d", reader.BufferedLen())
+	}
+}
+
+func TestDockerReadPartial(t *testing.T) {
+	line1 := "{\"log\":\"first \",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:05Z\"}\n"
+	line2 := "{\"log\":\"and second\\n\",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:06Z\"}\n"
+	reader := NewDockerReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	item := checkContainerItem(t, reader, "first and second", "stdout", len(line1)+len(line2), nil)
+	expectedTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if timestamp, ok := item["@timestamp"].(time.Time); !ok || !timestamp.Equal(expectedTime) {
+		t.Errorf("Timestamp incorrect: [%v] (expected [%v])", item["@timestamp"], expectedTime)
+	}
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestDockerReadPartialIncomplete(t *testing.T) {
+	line1 := "{\"log\":\"first \",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:05Z\"}\n"
+	reader := NewDockerReader(bytes.NewBufferString(line1), 1024, 1024)
+
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+	if reader.BufferedLen() != len(line1) {
+		t.Errorf("Unexpected buffered length: %d (expected %d)", reader.BufferedLen(), len(line1))
+	}
+}
+
+func TestDockerReadPartialInterleaved(t *testing.T) {
+	line1 := "{\"log\":\"first \",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:05Z\"}\n"
+	line2 := "{\"log\":\"error\\n\",\"stream\":\"stderr\",\"time\":\"2024-01-02T03:04:06Z\"}\n"
+	line3 := "{\"log\":\"and second\\n\",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:07Z\"}\n"
+	reader := NewDockerReader(bytes.NewBufferString(line1+line2+line3), 1024, 1024)
+
+	// The offset cannot move past the partial stdout message until it completes
+	checkContainerItem(t, reader, "error", "stderr", 0, nil)
+	item := checkContainerItem(t, reader, "first and second", "stdout", len(line1)+len(line2)+len(line3), nil)
+	expectedTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if timestamp, ok := item["@timestamp"].(time.Time); !ok || !timestamp.Equal(expectedTime) {
+		t.Errorf("Timestamp incorrect: [%v] (expected [%v])", item["@timestamp"], expectedTime)
+	}
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestDockerReadUnrecognised(t *testing.T) {
+	line1 := "not json\n"
+	line2 := "{\"log\":\"valid\\n\",\"stream\":\"stdout\",\"time\":\"2024-01-02T03:04:05Z\"}\n"
+	reader := NewDockerReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	item := checkContainerItem(t, reader, "not json", "", len(line1), nil)
+	if _, ok := item["@timestamp"]; ok {
+		t.Errorf("Unexpected timestamp: %v", item["@timestamp"])
+	}
+	checkContainerItem(t, reader, "valid", "stdout", len(line2), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestCRIRead(t *testing.T) {
+	line1 := "2024-01-02T03:04:05.123456789Z stdout F first line\n"
+	line2 := "2024-01-02T03:04:06Z stderr F \n"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	item := checkContainerItem(t, reader, "first line", "stdout", len(line1), nil)
+	expectedTime := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if timestamp, ok := item["@timestamp"].(time.Time); !ok || !timestamp.Equal(expectedTime) {
+		t.Errorf("Timestamp incorrect: [%v] (expected [%v])", item["@timestamp"], expectedTime)
+	}
+	checkContainerItem(t, reader, "", "stderr", len(line2), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestCRIReadPartial(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout P first \n"
+	line2 := "2024-01-02T03:04:05Z stdout P and \n"
+	line3 := "2024-01-02T03:04:06Z stdout F:extra third\n"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2+line3), 1024, 1024)
+
+	checkContainerItem(t, reader, "first and third", "stdout", len(line1)+len(line2)+len(line3), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestCRIReadFlush(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout P first \n"
+	line2 := "2024-01-02T03:04:05Z stdout F and second"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+
+	item, length := reader.Flush()
+	if item == nil || item["message"] != "first and second" || length != len(line1)+len(line2) {
+		t.Errorf("Unexpected flush: [%v] %d (expected [first and second] %d)", item, length, len(line1)+len(line2))
+	}
+	if reader.BufferedLen() != 0 {
+		t.Errorf("Unexpected buffered length: %d (expected 0)", reader.BufferedLen())
+	}
+}
+
+func TestCRIReadPartialInterleaved(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout P out \n"
+	line2 := "2024-01-02T03:04:05Z stderr P err \n"
+	line3 := "2024-01-02T03:04:06Z stdout F first\n"
+	line4 := "2024-01-02T03:04:06Z stderr F second\n"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2+line3+line4), 1024, 1024)
+
+	checkContainerItem(t, reader, "out first", "stdout", 0, nil)
+	checkContainerItem(t, reader, "err second", "stderr", len(line1)+len(line2)+len(line3)+len(line4), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestCRIReadFlushInterleaved(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout P out\n"
+	line2 := "2024-01-02T03:04:05Z stderr P err\n"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2), 1024, 1024)
+
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+
+	item, length := reader.Flush()
+	if item == nil || item["message"] != "out" || item["stream"] != "stdout" || length != 0 {
+		t.Errorf("Unexpected flush: [%v] %d (expected [out] 0)", item, length)
+	}
+	item, length = reader.Flush()
+	if item == nil || item["message"] != "err" || item["stream"] != "stderr" || length != len(line1)+len(line2) {
+		t.Errorf("Unexpected flush: [%v] %d (expected [err] %d)", item, length, len(line1)+len(line2))
+	}
+	if item, _ := reader.Flush(); item != nil {
+		t.Errorf("Unexpected flush after all streams were flushed: [%v]", item)
+	}
+}
+
+func TestCRIReadTooLongMultibyte(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout F 123456789012345678901234567890123456789\u00e9\u00e9\n"
+	reader := NewCRIReader(bytes.NewBufferString(line1), 1024, 40)
+
+	// The first two byte character would be split at 40 bytes so is kept for
+	// the continuation
+	checkContainerItem(t, reader, "123456789012345678901234567890123456789", "stdout", 0, ErrMaxDataSizeTruncation)
+	checkContainerItem(t, reader, "\u00e9\u00e9", "stdout", len(line1), ErrMaxDataSizeTruncation)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
+
+func TestCRIReadTooLong(t *testing.T) {
+	line1 := "2024-01-02T03:04:05Z stdout P 123456789012345\n"
+	line2 := "2024-01-02T03:04:05Z stdout F 123456789012345\n"
+	line3 := "2024-01-02T03:04:06Z stdout F next\n"
+	reader := NewCRIReader(bytes.NewBufferString(line1+line2+line3), 1024, 25)
+
+	checkContainerItem(t, reader, "1234567890123451234567890", "stdout", 0, ErrMaxDataSizeTruncation)
+	checkContainerItem(t, reader, "12345", "stdout", len(line1)+len(line2), ErrMaxDataSizeTruncation)
+	checkContainerItem(t, reader, "next", "stdout", len(line3), nil)
+	checkContainerItem(t, reader, "", "", 0, io.EOF)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"errors"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// byteOrderMarks contains the byte order marks that may appear at the start of
+// a file for those encodings that have one, keyed by canonical IANA name
+var byteOrderMarks = map[string][]byte{
+	"UTF-8":    {0xEF, 0xBB, 0xBF},
+	"UTF-16LE": {0xFF, 0xFE},
+	"UTF-16BE": {0xFE, 0xFF},
+}
+
+// lineEncoding holds the information the line reader needs to split and decode
+// lines in a specific character encoding
+type lineEncoding struct {
+	encoding       encoding.Encoding
+	newLine        []byte
+	carriageReturn []byte
+	bom            []byte
+}
+
+// newLineEncoding returns the lineEncoding for the encoding with the given
+// IANA name
+func newLineEncoding(name string) (*lineEncoding, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil {
+		return nil, errors.New("it is not a recognised encoding name")
+	}
+	if enc == nil {
+		return nil, errors.New("it is not supported")
+	}
+
+	canonicalName, err := ianaindex.IANA.Name(enc)
+	if err != nil {
+		return nil, err
+	}
+	if canonicalName == "UTF-16" {
+		// Byte order would need detecting from the BOM which is not available
+		// when resuming part way through a file
+		return nil, errors.New("the byte order must be specified using \"utf-16le\" or \"utf-16be\"")
+	}
+
+	encoder := enc.NewEncoder()
+	newLine, err := encoder.Bytes([]byte("\n"))
+	if err != nil {
+		return nil, errors.New("it cannot represent a new line")
+	}
+	carriageReturn, err := encoder.Bytes([]byte("\r"))
+	if err != nil || len(carriageReturn) != len(newLine) {
+		return nil, errors.New("it cannot represent a carriage return")
+	}
+
+	return &lineEncoding{
+		encoding:       enc,
+		newLine:        newLine,
+		carriageReturn: carriageReturn,
+		bom:            byteOrderMarks[canonicalName],
+	}, nil
+}
+
+// unitSize returns the size of a single code unit, which is the alignment
+// that new lines will appear on within a line
+func (e *lineEncoding) unitSize() int {
+	return len(e.newLine)
+}
+
+// encode returns the given string encoded in this encoding
+func (e *lineEncoding) encode(data string) ([]byte, error) {
+	return e.encoding.NewEncoder().Bytes([]byte(data))
+}
@@ -51,6 +51,10 @@ type FinishStatus struct {
 	LastReadOffset  int64
 	Error           error
 	LastStat        os.FileInfo
+	LineCount       uint64
+	ByteCount       uint64
+	Truncations     uint64
+	Completed       bool
 }
 
 // Harvester reads data from a file with a read, passes events through a codec,
@@ -69,6 +73,7 @@ type Harvester struct {
 	eventStream     *codecs.Stream
 	offset          int64
 	output          chan<- []*event.Event
+	pathFields      map[string]interface{}
 	file            *os.File
 	backOffTimer    *time.Timer
 	blockedTimer    *time.Timer
@@ -78,6 +83,14 @@ type Harvester struct {
 	staleBytes      int64
 	lastStaleOffset int64
 	isStream        bool
+	stopAtEOF       bool
+	rateLimiter     *rateLimiter
+	throttledWait   bool
+
+	compression      string
+	completed        bool
+	compressedReader *countingReader
+	decompressor     io.ReadCloser
 
 	lastReadTime    time.Time
 	lastMeasurement time.Time
@@ -98,6 +111,8 @@ type Harvester struct {
 	lastEOF              *time.Time
 	lastSize             int64
 	lastOffset           int64
+	throttled            bool
+	truncations          uint64
 }
 
 // SetOutput sets the harvester output
@@ -105,6 +120,18 @@ func (h *Harvester) SetOutput(output chan<- []*event.Event) {
 	h.output = output
 }
 
+// SetPathFields sets additional fields to add to every event, which are
+// extracted from the path of the file
+func (h *Harvester) SetPathFields(fields map[string]interface{}) {
+	h.pathFields = fields
+}
+
+// SetStopAtEOF tells the harvester to stop once it reaches the end of the file
+// instead of waiting for more data to be written
+func (h *Harvester) SetStopAtEOF() {
+	h.stopAtEOF = true
+}
+
 // Start runs the harvester, sending events to the output given, and returns immediately
 func (h *Harvester) Start() {
 	if h.output == nil {
@@ -123,6 +150,10 @@ func (h *Harvester) Start() {
 		status.LastEventOffset, status.Error = h.harvest()
 		status.LastReadOffset = h.offset
 		status.LastStat = h.fileinfo
+		status.LineCount = h.lineCount
+		status.ByteCount = h.byteCount
+		status.Truncations = h.truncations
+		status.Completed = h.completed
 		h.returnChan <- status
 		close(h.returnChan)
 	}()
@@ -147,9 +178,18 @@ func (h *Harvester) harvest() (int64, error) {
 
 	defer h.file.Close()
 
+	var source io.Reader = h.file
 	if h.isStream {
 		log.Info("Started harvester: %s", h.path)
 		h.offset = 0
+	} else if h.compression != compressionNone {
+		if err := h.prepareDecompressor(); err != nil {
+			log.Errorf("Failed to decompress %s: %s", h.path, err)
+			return h.offset, err
+		}
+
+		defer h.decompressor.Close()
+		source = h.decompressor
 	} else {
 		// Get current offset in file
 		offset, err := h.file.Seek(0, os.SEEK_CUR)
@@ -168,16 +208,33 @@ func (h *Harvester) harvest() (int64, error) {
 	}
 
 	// The buffer size limits the maximum line length we can read, including terminator
-	if h.streamConfig.Reader == "line" {
-		h.reader = NewLineReader(h.file, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
+	if h.streamConfig.Reader == "line" || h.streamConfig.Reader == "regex" {
+		lineReader := NewLineReader(source, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
+		if h.streamConfig.lineEncoding != nil {
+			lineReader.setEncoding(h.streamConfig.lineEncoding, h.offset == 0)
+		}
+		if h.streamConfig.delimiter != nil {
+			lineReader.setDelimiter(h.streamConfig.delimiter)
+		}
+		if h.streamConfig.delimiterPattern != nil {
+			lineReader.setDelimiterPattern(h.streamConfig.delimiterPattern)
+		}
+		h.reader = lineReader
+	} else if h.streamConfig.Reader == "docker" {
+		h.reader = NewDockerReader(source, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
+	} else if h.streamConfig.Reader == "cri" {
+		h.reader = NewCRIReader(source, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
 	} else {
-		h.reader = NewJSONReader(h.file, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
+		h.reader = NewJSONReader(source, int(h.genConfig.LineBufferBytes), int(h.genConfig.MaxLineBytes))
 	}
 
 	// Prepare internal data
 	h.lastReadTime = time.Now()
 	h.lastMeasurement = h.lastReadTime
 	h.lastCheck = h.lastReadTime
+	if h.streamConfig.RateLimit.enabled() {
+		h.rateLimiter = newRateLimiter(&h.streamConfig.RateLimit, h.lastReadTime)
+	}
 
 	for {
 		if err := h.performRead(); err != nil {
@@ -196,8 +253,7 @@ func (h *Harvester) harvest() (int64, error) {
 func (h *Harvester) performRead() error {
 	if measureErr := h.takeMeasurements(false); measureErr != nil {
 		if measureErr == errFileTruncated {
-			h.handleTruncation()
-			return nil
+			return h.handleTruncation()
 		}
 		return measureErr
 	}
@@ -213,6 +269,10 @@ func (h *Harvester) performRead() error {
 		h.lastReadTime = time.Now()
 		h.lineCount++
 		h.byteCount += uint64(bytesread)
+
+		if err == nil && h.rateLimiter != nil {
+			err = h.throttle(bytesread)
+		}
 		return err
 	}
 
@@ -227,6 +287,24 @@ func (h *Harvester) performRead() error {
 		return errStopRequested
 	}
 
+	if h.compression != compressionNone {
+		// Compressed files are complete so there is nothing to tail
+		log.Info("Stopping harvest of %s; end of compressed file reached", h.path)
+		if err := h.flushBuffered("end of compressed file"); err != nil {
+			return err
+		}
+		h.completed = true
+		return errStopRequested
+	}
+
+	if h.stopAtEOF {
+		log.Info("Stopping harvest of %s; EOF reached", h.path)
+		if err := h.flushBuffered("EOF"); err != nil {
+			return err
+		}
+		return errStopRequested
+	}
+
 	h.mutex.Lock()
 	if h.lastEOF == nil {
 		h.lastEOF = new(time.Time)
@@ -246,23 +324,102 @@ func (h *Harvester) performRead() error {
 	return nil
 }
 
-func (h *Harvester) handleTruncation() {
+// throttle waits as long as is necessary to stay within the rate limit after
+// reading an item of the given length, continuing to take measurements whilst
+// it waits
+func (h *Harvester) throttle(length int) error {
+	wait := h.rateLimiter.take(time.Now(), length)
+	if wait == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		// Flag for each measurement taken whilst we wait
+		h.throttledWait = true
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > time.Second {
+			remaining = time.Second
+		}
+
+		select {
+		case <-h.stopChan:
+			return errStopRequested
+		case <-time.After(remaining):
+		}
+
+		// We are not waiting for new data, so treat as blocked to avoid the
+		// checks for dead time and truncation
+		if err := h.takeMeasurements(true); err != nil {
+			return err
+		}
+	}
+}
+
+// handleTruncation seeks to the beginning of a file that was truncated,
+// discarding any incomplete data unless configured to flush it
+func (h *Harvester) handleTruncation() error {
 	log.Warning("Unexpected file truncation, seeking to beginning: %s", h.path)
 
+	h.mutex.Lock()
+	h.truncations++
+	h.mutex.Unlock()
+
+	if h.streamConfig.FlushOnTruncate {
+		// Logs any data the reader was unable to flush as lost
+		if err := h.flushBuffered("file truncation"); err != nil {
+			return err
+		}
+	} else if h.reader.BufferedLen() != 0 {
+		log.Errorf("%d bytes of incomplete log data was lost due to file truncation: %s", h.reader.BufferedLen(), h.path)
+	}
+
 	h.file.Seek(0, os.SEEK_SET)
 	h.offset = 0
 	h.staleOffset = 0
 	h.lastStaleOffset = 0
 
-	// TODO: Should we be allowing truncation to lose buffer data? Or should
-	//       we be flushing what we have?
-	if h.reader.BufferedLen() != 0 {
-		log.Errorf("%d bytes of incomplete log data was lost due to file truncation", h.reader.BufferedLen())
-	}
-
 	// Reset event buffer and codec buffers
 	h.reader.Reset()
 	h.eventStream.Reset()
+	return nil
+}
+
+// flushBuffered ships the incomplete data buffered by the reader as final
+// events, and then any events buffered by the codecs, as no more data will
+// arrive to complete them for the given reason
+func (h *Harvester) flushBuffered(reason string) error {
+	buffered := h.reader.BufferedLen()
+	flushed := 0
+	for {
+		item, length := h.reader.Flush()
+		if item == nil {
+			break
+		}
+
+		lineOffset := h.offset
+		h.offset += int64(length)
+		if err := h.eventStream.ProcessEvent(lineOffset, h.offset, item); err != nil {
+			return err
+		}
+
+		h.lineCount++
+		h.byteCount += uint64(length)
+		flushed += length
+	}
+
+	if flushed != 0 {
+		log.Info("Flushed %d bytes of incomplete log data due to %s: %s", flushed, reason, h.path)
+	}
+	if lost := buffered - flushed; lost > 0 {
+		log.Errorf("%d bytes of incomplete log data was lost due to %s: %s", lost, reason, h.path)
+	}
+
+	return h.eventStream.Flush()
 }
 
 func (h *Harvester) takeMeasurements(isPipelineBlocked bool) error {
@@ -321,16 +478,26 @@ func (h *Harvester) takeMeasurements(isPipelineBlocked bool) error {
 	h.lastByteCount = h.byteCount
 	h.lastLineCount = h.lineCount
 	h.lastOffset = h.offset
+	// Report throttling if we waited for the rate limit since the last
+	// measurement, as the waits between events are usually very short
+	h.throttled = h.throttledWait
+	h.throttledWait = false
 	if h.fileinfo != nil {
 		h.lastSize = h.fileinfo.Size()
 	}
-	if h.offset > h.lastSize {
-		h.lastSize = h.offset
+	// Offsets in compressed files are against the decompressed data so measure
+	// completion using the compressed data read instead
+	position := h.offset
+	if h.compressedReader != nil {
+		position = h.compressedReader.count
 	}
-	if h.lastOffset >= h.lastSize {
+	if position > h.lastSize {
+		h.lastSize = position
+	}
+	if position >= h.lastSize {
 		h.completion = 100
 	} else {
-		h.completion = float64(h.lastOffset) * 100 / float64(h.lastSize)
+		h.completion = float64(position) * 100 / float64(h.lastSize)
 		if h.completion >= 100 {
 			h.completion = 99
 		}
@@ -358,7 +525,9 @@ func (h *Harvester) takeMeasurements(isPipelineBlocked bool) error {
 func (h *Harvester) statCheck(info os.FileInfo, isPipelineBlocked bool) (err error) {
 	if !isPipelineBlocked {
 		// We are reading new data and nothing appeared yet - is it because truncated?
-		if info.Size() < h.offset {
+		// Compressed file offsets are against the decompressed data so cannot be
+		// compared with the file size
+		if h.compression == compressionNone && info.Size() < h.offset {
 			return errFileTruncated
 		}
 
@@ -422,7 +591,7 @@ func (h *Harvester) eventCallback(startOffset int64, endOffset int64, data map[s
 	}
 
 	ctx := context.WithValue(h.ctx, registrar.ContextEndOffset, endOffset)
-	data = h.streamConfig.Decorate(data)
+	data = h.streamConfig.DecorateWithFields(data, h.pathFields)
 	newEvent := event.NewEvent(ctx, h.acker, data)
 
 EventLoop:
@@ -473,9 +642,40 @@ func (h *Harvester) prepareHarvester() error {
 	// Store latest stat()
 	h.fileinfo = info
 
-	// TODO: Check error?
-	h.file.Seek(h.offset, os.SEEK_SET)
+	// Compressed files are read from the beginning and skipped to the offset
+	// once decompressing
+	if h.compression == compressionNone {
+		// TODO: Check error?
+		h.file.Seek(h.offset, os.SEEK_SET)
+	}
+
+	return nil
+}
+
+// prepareDecompressor starts decompressing the file and skips the
+// decompressed data up to the offset we are resuming from
+func (h *Harvester) prepareDecompressor() error {
+	h.compressedReader = &countingReader{reader: h.file}
+
+	var err error
+	h.decompressor, err = newDecompressor(h.compression, h.compressedReader)
+	if err != nil {
+		return err
+	}
+
+	offset, err := io.CopyN(io.Discard, h.decompressor, h.offset)
+	if err != nil && err != io.EOF {
+		h.decompressor.Close()
+		return err
+	}
+
+	if h.offset != offset {
+		log.Warning("Started harvester at decompressed position %d (requested %d): %s", offset, h.offset, h.path)
+	} else {
+		log.Info("Started harvester at decompressed position %d (requested %d): %s", offset, h.offset, h.path)
+	}
 
+	h.offset = offset
 	return nil
 }
 
@@ -532,6 +732,24 @@ func (h *Harvester) isOrphaned() bool {
 	return orphaned
 }
 
+// Truncations returns the number of times the file was truncated whilst it was
+// being harvested
+func (h *Harvester) Truncations() uint64 {
+	h.mutex.RLock()
+	truncations := h.truncations
+	h.mutex.RUnlock()
+	return truncations
+}
+
+// IsThrottled returns true if the harvester recently waited in order to stay
+// within its rate limit
+func (h *Harvester) IsThrottled() bool {
+	h.mutex.RLock()
+	throttled := h.throttled
+	h.mutex.RUnlock()
+	return throttled
+}
+
 // APIEncodable returns an admin API entry with harvester status
 func (h *Harvester) APIEncodable() api.Encodable {
 	h.mutex.RLock()
@@ -548,6 +766,12 @@ func (h *Harvester) APIEncodable() api.Encodable {
 	} else {
 		apiEncodable.SetEntry("orphaned", api.Number(0))
 	}
+	apiEncodable.SetEntry("truncations", api.Number(h.truncations))
+	if h.throttled {
+		apiEncodable.SetEntry("throttled", api.Number(1))
+	} else {
+		apiEncodable.SetEntry("throttled", api.Number(0))
+	}
 
 	apiEncodable.SetEntry("completion", api.Float(h.completion))
 	if h.lastEOFOff == nil {
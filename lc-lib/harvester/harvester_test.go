@@ -0,0 +1,123 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+
+	_ "github.com/driskell/log-courier/lc-lib/codecs/plain"
+)
+
+func createTestHarvester(t *testing.T, rawConfig map[string]interface{}, data string) (*Harvester, chan []*event.Event) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", path, err)
+	}
+
+	cfg := config.NewConfig()
+	streamConfig := &StreamConfig{}
+	parser := config.NewParser(cfg)
+	if err := parser.Populate(streamConfig, rawConfig, "/", true); err != nil {
+		t.Fatalf("Failed to populate stream configuration: %s", err)
+	}
+	// Validation only happens once a whole configuration file is loaded, so
+	// validate the event stream configuration so it has the general section
+	if err := streamConfig.StreamConfig.StreamConfig.Validate(parser, "/"); err != nil {
+		t.Fatalf("Failed to validate stream configuration: %s", err)
+	}
+
+	output := make(chan []*event.Event, 10)
+	h := streamConfig.NewHarvester(context.Background(), path, info, cfg, nil, 0)
+	h.SetOutput(output)
+	if err := h.prepareHarvester(); err != nil {
+		t.Fatalf("Failed to prepare harvester: %s", err)
+	}
+	t.Cleanup(func() { h.file.Close() })
+	h.reader = NewLineReader(h.file, 100, 100)
+	h.lastReadTime = time.Now()
+	h.lastMeasurement = h.lastReadTime
+	h.lastCheck = h.lastReadTime
+	return h, output
+}
+
+func receiveMessages(output chan []*event.Event) []string {
+	var messages []string
+	for {
+		select {
+		case events := <-output:
+			for _, evnt := range events {
+				message, _ := evnt.Data()["message"].(string)
+				messages = append(messages, message)
+			}
+		default:
+			return messages
+		}
+	}
+}
+
+func testHarvesterTruncation(t *testing.T, flushOnTruncate bool, expected []string) {
+	h, output := createTestHarvester(t, map[string]interface{}{"flush on truncate": flushOnTruncate}, "first\nsecond\npartial")
+
+	for i := 0; i < 2; i++ {
+		if err := h.performRead(); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	if err := os.Truncate(h.path, 0); err != nil {
+		t.Fatalf("Failed to truncate %s: %s", h.path, err)
+	}
+	if err := h.handleTruncation(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	messages := receiveMessages(output)
+	if len(messages) != len(expected) {
+		t.Fatalf("Unexpected events: %v (expected %v)", messages, expected)
+	}
+	for idx, message := range messages {
+		if message != expected[idx] {
+			t.Errorf("Unexpected event %d: %s (expected %s)", idx, message, expected[idx])
+		}
+	}
+
+	if h.Truncations() != 1 {
+		t.Errorf("Unexpected truncation count: %d (expected 1)", h.Truncations())
+	}
+	if h.offset != 0 || h.reader.BufferedLen() != 0 {
+		t.Errorf("Harvester was not reset: offset %d, buffered %d", h.offset, h.reader.BufferedLen())
+	}
+}
+
+func TestHarvesterTruncationDiscards(t *testing.T) {
+	testHarvesterTruncation(t, false, []string{"first", "second"})
+}
+
+func TestHarvesterTruncationFlushes(t *testing.T) {
+	testHarvesterTruncation(t, true, []string{"first", "second", "partial"})
+}
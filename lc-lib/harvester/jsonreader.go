@@ -66,6 +66,11 @@ func (jr *JSONReader) BufferedLen() int {
 	return jr.dec.Buffered().(*bytes.Reader).Len()
 }
 
+// Flush does nothing as an incomplete JSON structure cannot be decoded
+func (jr *JSONReader) Flush() (map[string]interface{}, int) {
+	return nil, 0
+}
+
 // ReadItem returns the next JSON structure from the file
 // Returns ErrMaxDataSizeExceeded if the data cannot be completed read because it is longer
 // than the maximum data length allowed
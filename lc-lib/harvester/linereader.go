@@ -19,8 +19,10 @@ package harvester
 import (
 	"bytes"
 	"io"
+	"regexp"
 
 	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+	"golang.org/x/text/encoding"
 )
 
 // LineReader is a read interface that tails and returns lines
@@ -35,6 +37,15 @@ type LineReader struct {
 	end            int
 	err            error
 	isContinuation bool
+	encoding       *lineEncoding
+	decoder        *encoding.Decoder
+	checkBOM       bool
+	bomLength      int
+
+	delimiter        []byte
+	delimiterPattern *regexp.Regexp
+	carriageReturn   []byte
+	unitSize         int
 }
 
 // NewLineReader creates a new line reader structure reading from the given
@@ -51,16 +62,59 @@ func NewLineReader(rd io.Reader, size int, maxLine int) *LineReader {
 		size:    size,
 		maxLine: maxLine,
 		curMax:  maxLine,
+
+		delimiter:      []byte{'\n'},
+		carriageReturn: []byte{'\r'},
+		unitSize:       1,
 	}
 
 	return lr
 }
 
+// setEncoding sets the character encoding of the data, which will be decoded
+// to UTF-8 once split into lines. If the reader is at the start of the data
+// then a byte order mark will be skipped if one is present. It must be called
+// before the first read
+func (lr *LineReader) setEncoding(enc *lineEncoding, atStart bool) {
+	lr.encoding = enc
+	lr.decoder = enc.encoding.NewDecoder()
+	lr.checkBOM = atStart && enc.bom != nil
+	lr.delimiter = enc.newLine
+	lr.carriageReturn = enc.carriageReturn
+	lr.unitSize = enc.unitSize()
+
+	// Keep buffers aligned to code units so new lines are never split across
+	// them and truncated lines are cut on a code unit boundary
+	if lr.size%lr.unitSize != 0 {
+		lr.size -= lr.size % lr.unitSize
+		lr.buf = make([]byte, lr.size)
+	}
+	lr.maxLine -= lr.maxLine % lr.unitSize
+	lr.curMax = lr.maxLine
+}
+
+// setDelimiter sets the delimiter that separates records in place of a new
+// line. If an encoding is set the delimiter must already be encoded in it.
+// Unlike a new line, a carriage return preceeding the delimiter is not removed
+func (lr *LineReader) setDelimiter(delimiter []byte) {
+	lr.delimiter = delimiter
+	lr.carriageReturn = nil
+}
+
+// setDelimiterPattern sets a regular expression that matches the delimiter
+// that separates records in place of a new line
+func (lr *LineReader) setDelimiterPattern(pattern *regexp.Regexp) {
+	lr.delimiterPattern = pattern
+	lr.carriageReturn = nil
+}
+
 // Reset the linereader, still using the same io.Reader, but as if it had just
 // being constructed. This will cause any currently buffered data to be lost
 func (lr *LineReader) Reset() {
 	lr.start = 0
 	lr.end = 0
+	lr.checkBOM = lr.encoding != nil && lr.encoding.bom != nil
+	lr.bomLength = 0
 }
 
 // BufferedLen returns the current number of bytes sitting in the buffer
@@ -69,6 +123,33 @@ func (lr *LineReader) BufferedLen() int {
 	return lr.end - lr.start
 }
 
+// Flush returns an event for the incomplete line in the buffer, as though its
+// delimiter had been found, along with the number of bytes it consumed
+// Returns nil if there is no incomplete line
+func (lr *LineReader) Flush() (map[string]interface{}, int) {
+	if lr.end == lr.start && lr.overflow == nil {
+		return nil, 0
+	}
+
+	line := lr.buf[lr.start:lr.end]
+	lr.start = lr.end
+
+	if lr.overflow != nil {
+		lr.overflow = append(lr.overflow, line)
+		line = bytes.Join(lr.overflow, []byte{})
+		lr.overflow = nil
+		lr.curMax = lr.maxLine
+	}
+
+	length := len(line) + lr.bomLength
+	lr.bomLength = 0
+	lr.isContinuation = false
+
+	return map[string]interface{}{
+		"message": lr.decode(line),
+	}, length
+}
+
 // ReadItem returns a line event from the file
 // Returns ErrMaxDataSizeTruncation if the line was cut short because it was longer
 // than the maximum line length allowed. Subsequent returned lines will be a
@@ -76,15 +157,21 @@ func (lr *LineReader) BufferedLen() int {
 func (lr *LineReader) ReadItem() (map[string]interface{}, int, error) {
 	var err error
 	var line []byte
+	var delimiterLen int
 
 	if lr.end == 0 {
 		err = lr.fill()
 	}
 
 	for {
-		if n := bytes.IndexByte(lr.buf[lr.start:lr.end], '\n'); n >= 0 && n < lr.curMax {
-			line = lr.buf[lr.start : lr.start+n+1]
-			lr.start += n + 1
+		if lr.checkBOM {
+			lr.skipBOM()
+		}
+
+		var n int
+		if n, delimiterLen = lr.indexDelimiter(); n >= 0 && n < lr.curMax {
+			line = lr.buf[lr.start : lr.start+n+delimiterLen]
+			lr.start += len(line)
 			err = nil
 			break
 		}
@@ -101,13 +188,18 @@ func (lr *LineReader) ReadItem() (map[string]interface{}, int, error) {
 		}
 
 		if lr.end-lr.start >= len(lr.buf) {
-			lr.start, lr.end = 0, 0
+			// Keep the end of the buffer so we can still find a delimiter that
+			// may have been split across the buffers
+			keep := lr.overlap()
 			if lr.overflow == nil {
 				lr.overflow = make([][]byte, 0, 1)
 			}
-			lr.overflow = append(lr.overflow, lr.buf)
-			lr.curMax -= len(lr.buf)
-			lr.buf = make([]byte, lr.size)
+			lr.overflow = append(lr.overflow, lr.buf[:len(lr.buf)-keep])
+			lr.curMax -= len(lr.buf) - keep
+			newBuf := make([]byte, lr.size)
+			copy(newBuf, lr.buf[len(lr.buf)-keep:])
+			lr.buf = newBuf
+			lr.start, lr.end = 0, keep
 		}
 
 		err = lr.fill()
@@ -124,19 +216,17 @@ func (lr *LineReader) ReadItem() (map[string]interface{}, int, error) {
 	length := len(line)
 	if err == ErrMaxDataSizeTruncation {
 		event = map[string]interface{}{
-			"message": string(line),
+			"message": lr.decode(line),
 		}
 		lr.isContinuation = true
 	} else {
-		// Line will always end in LF, but check also for CR
-		var newLine int
-		if length > 1 && line[length-2] == '\r' {
-			newLine = 2
-		} else {
-			newLine = 1
+		// Line will always end in the delimiter, but if it is LF check also for CR
+		trimLen := delimiterLen
+		if lr.carriageReturn != nil && length >= delimiterLen+len(lr.carriageReturn) && bytes.Equal(line[length-delimiterLen-len(lr.carriageReturn):length-delimiterLen], lr.carriageReturn) {
+			trimLen += len(lr.carriageReturn)
 		}
 		event = map[string]interface{}{
-			"message": string(line[:length-newLine]),
+			"message": lr.decode(line[:length-trimLen]),
 		}
 		// If this is the continuation from a previously cut line - also return max data exceeded just so it can be tagged accordingly
 		if lr.isContinuation {
@@ -145,9 +235,108 @@ func (lr *LineReader) ReadItem() (map[string]interface{}, int, error) {
 		}
 	}
 
+	// Any skipped byte order mark is included in the length of the first line
+	// so that offsets remain accurate
+	length += lr.bomLength
+	lr.bomLength = 0
+
 	return event, length, err
 }
 
+// skipBOM skips the byte order mark at the start of the data if there is one,
+// waiting until enough data is available to know
+func (lr *LineReader) skipBOM() {
+	data := lr.buf[lr.start:lr.end]
+	if len(data) < len(lr.encoding.bom) && bytes.HasPrefix(lr.encoding.bom, data) {
+		return
+	}
+
+	lr.checkBOM = false
+	if bytes.HasPrefix(data, lr.encoding.bom) {
+		lr.start += len(lr.encoding.bom)
+		lr.bomLength = len(lr.encoding.bom)
+	}
+}
+
+// indexDelimiter returns the index of the first delimiter in the buffer
+// relative to the start of the unread data, or -1 if there is none, along with
+// the length of the delimiter
+func (lr *LineReader) indexDelimiter() (int, int) {
+	data := lr.buf[lr.start:lr.end]
+
+	if lr.delimiterPattern != nil {
+		offset := 0
+		for offset < len(data) {
+			loc := lr.delimiterPattern.FindIndex(data[offset:])
+			if loc == nil {
+				break
+			}
+			if loc[1] > loc[0] {
+				// A match that reaches the end of the data might match more once
+				// more data is read, such as for "\n{2,}", so unless there is no
+				// more data to read treat it as incomplete
+				if offset+loc[1] == len(data) && lr.err == nil {
+					return -1, 0
+				}
+				return offset + loc[0], loc[1] - loc[0]
+			}
+			// Empty matches cannot delimit anything
+			offset += loc[0] + 1
+		}
+		return -1, 0
+	}
+
+	if len(lr.delimiter) == 1 {
+		return bytes.IndexByte(data, lr.delimiter[0]), 1
+	}
+
+	offset := 0
+	for {
+		n := bytes.Index(data[offset:], lr.delimiter)
+		if n < 0 {
+			return -1, 0
+		}
+		n += offset
+		if n%lr.unitSize == 0 {
+			return n, len(lr.delimiter)
+		}
+		// Not aligned to a code unit so is part of another character
+		offset = n + 1
+	}
+}
+
+// overlap returns how many bytes at the end of a full buffer must be carried
+// over into the next buffer when a record overflows, so that a delimiter that
+// is split across the buffers can still be found
+func (lr *LineReader) overlap() int {
+	var keep int
+	if lr.delimiterPattern != nil {
+		// Length of a match is unknown so keep a good amount
+		keep = len(lr.buf) / 2
+	} else {
+		// Delimiter will always start on a code unit boundary
+		keep = len(lr.delimiter) - lr.unitSize
+	}
+	if keep >= len(lr.buf) {
+		keep = len(lr.buf) - lr.unitSize
+	}
+	return keep
+}
+
+// decode returns the given data decoded to a UTF-8 string
+func (lr *LineReader) decode(data []byte) string {
+	if lr.decoder == nil {
+		return string(data)
+	}
+	decoded, err := lr.decoder.Bytes(data)
+	if err != nil {
+		// Decoders replace invalid data so this should not occur, but if it
+		// does pass through the original data rather than losing it
+		return string(data)
+	}
+	return string(decoded)
+}
+
 // fill reads from the reader and fills the buffer, shifting all unread bytes to
 // the front of the buffer to make room
 func (lr *LineReader) fill() error {
@@ -19,7 +19,9 @@ package harvester
 import (
 	"bytes"
 	"io"
+	"regexp"
 	"testing"
+	"testing/iotest"
 )
 
 func checkLine(t *testing.T, reader *LineReader, expected string, expectedLength int, expectedErr error) {
@@ -85,6 +87,26 @@ func TestLineReadIncomplete(t *testing.T) {
 	checkBufferedLen(t, reader, 6)
 }
 
+func TestLineReadFlush(t *testing.T) {
+	data := bytes.NewBufferString("12345678901234567890\n123456")
+
+	reader := NewLineReader(data, 100, 100)
+
+	checkLine(t, reader, string("12345678901234567890"), 21, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 6)
+
+	item, length := reader.Flush()
+	if item == nil || item["message"] != "123456" || length != 6 {
+		t.Errorf("Unexpected flush: [%v] %d (expected [123456] 6)", item, length)
+	}
+	checkBufferedLen(t, reader, 0)
+
+	if item, _ := reader.Flush(); item != nil {
+		t.Errorf("Unexpected flush of empty buffer: [%v]", item)
+	}
+}
+
 func TestLineReadOverflow(t *testing.T) {
 	data := bytes.NewBufferString("12345678901234567890\n123456789012345678901234567890\n12345678901234567890\n")
 
@@ -130,3 +152,190 @@ func TestLineReadTooLong(t *testing.T) {
 	checkLine(t, reader, "", 0, io.EOF)
 	checkBufferedLen(t, reader, 0)
 }
+
+func createEncodedLineReader(t *testing.T, name string, data []byte, size int, maxLine int, atStart bool) *LineReader {
+	enc, err := newLineEncoding(name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	reader := NewLineReader(bytes.NewBuffer(data), size, maxLine)
+	reader.setEncoding(enc, atStart)
+	return reader
+}
+
+func TestLineReadUTF16LE(t *testing.T) {
+	// BOM, then "héllo\r\n" and "wörld\n"
+	data := []byte{0xFF, 0xFE, 'h', 0, 0xE9, 0, 'l', 0, 'l', 0, 'o', 0, '\r', 0, '\n', 0, 'w', 0, 0xF6, 0, 'r', 0, 'l', 0, 'd', 0, '\n', 0}
+
+	reader := createEncodedLineReader(t, "utf-16le", data, 100, 100, true)
+
+	// BOM is included in the length of the first line
+	checkLine(t, reader, "héllo", 16, nil)
+	checkLine(t, reader, "wörld", 12, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadUTF16BEResumed(t *testing.T) {
+	// When not at the start the BOM is not expected and is decoded as normal
+	data := []byte{0xFE, 0xFF, 0, 'a', 0, '\n'}
+
+	reader := createEncodedLineReader(t, "utf-16be", data, 100, 100, false)
+
+	checkLine(t, reader, "\uFEFFa", 6, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+}
+
+func TestLineReadUTF16Unaligned(t *testing.T) {
+	// U+0A41 U+4100 contains the bytes of a new line across code units
+	data := []byte{0x41, 0x0A, 0x00, 0x41, '\n', 0}
+
+	// Odd buffer size is rounded to keep alignment during overflow
+	reader := createEncodedLineReader(t, "utf-16le", data, 3, 100, true)
+
+	checkLine(t, reader, "\u0A41\u4100", 6, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadUTF16TooLong(t *testing.T) {
+	data := []byte{'a', 0, 'b', 0, 'c', 0, '\n', 0}
+
+	// Odd max line is rounded so lines are not cut within a code unit
+	reader := createEncodedLineReader(t, "utf-16le", data, 100, 5, true)
+
+	checkLine(t, reader, "ab", 4, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "c", 4, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "", 0, io.EOF)
+}
+
+func TestLineReadISO88591(t *testing.T) {
+	data := []byte{'c', 'a', 'f', 0xE9, '\r', '\n', 0xA3, '5', '\n'}
+
+	reader := createEncodedLineReader(t, "iso-8859-1", data, 100, 100, true)
+
+	checkLine(t, reader, "café", 6, nil)
+	checkLine(t, reader, "£5", 3, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+}
+
+func TestLineReadUTF8BOM(t *testing.T) {
+	data := []byte{0xEF, 0xBB, 0xBF, 't', 'e', 's', 't', '\n'}
+
+	reader := createEncodedLineReader(t, "utf-8", data, 100, 100, true)
+
+	checkLine(t, reader, "test", 8, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+}
+
+func TestLineEncodingInvalid(t *testing.T) {
+	for _, name := range []string{"invalid", "utf-16"} {
+		if _, err := newLineEncoding(name); err == nil {
+			t.Errorf("Expected error for encoding: %s", name)
+		}
+	}
+}
+
+func TestLineReadDelimiterNUL(t *testing.T) {
+	data := bytes.NewBufferString("first\r\x00second\x00")
+
+	reader := NewLineReader(data, 100, 100)
+	reader.setDelimiter([]byte{0})
+
+	// Carriage return is only removed for new lines
+	checkLine(t, reader, "first\r", 7, nil)
+	checkLine(t, reader, "second", 7, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadDelimiterCR(t *testing.T) {
+	data := bytes.NewBufferString("first\rsecond\n\rthird")
+
+	reader := NewLineReader(data, 100, 100)
+	reader.setDelimiter([]byte{'\r'})
+
+	checkLine(t, reader, "first", 6, nil)
+	checkLine(t, reader, "second\n", 8, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 5)
+}
+
+func TestLineReadDelimiterSentinelOverflow(t *testing.T) {
+	data := bytes.NewBufferString("123456789<END>12345<END>")
+
+	// Sentinel is split across the buffer when it overflows
+	reader := NewLineReader(data, 12, 100)
+	reader.setDelimiter([]byte("<END>"))
+
+	checkLine(t, reader, "123456789", 14, nil)
+	checkLine(t, reader, "12345", 10, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadDelimiterSentinelTooLong(t *testing.T) {
+	data := bytes.NewBufferString("1234567890<END>123<END>")
+
+	reader := NewLineReader(data, 8, 8)
+	reader.setDelimiter([]byte("<END>"))
+
+	checkLine(t, reader, "12345678", 8, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "90", 7, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "123", 8, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadDelimiterPattern(t *testing.T) {
+	data := bytes.NewBufferString("first\n\nsecond\nline\n\n\nthird")
+
+	reader := NewLineReader(data, 100, 100)
+	reader.setDelimiterPattern(regexp.MustCompile(`\n\n+`))
+
+	checkLine(t, reader, "first", 7, nil)
+	checkLine(t, reader, "second\nline", 14, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 5)
+}
+
+func TestLineReadDelimiterPatternOverflow(t *testing.T) {
+	data := bytes.NewBufferString("1234567\n\n1234567890123\n\n12\n\n")
+
+	// Pattern is split across the buffer when it overflows
+	reader := NewLineReader(data, 8, 100)
+	reader.setDelimiterPattern(regexp.MustCompile(`\n\n`))
+
+	checkLine(t, reader, "1234567", 9, nil)
+	checkLine(t, reader, "1234567890123", 15, nil)
+	checkLine(t, reader, "12", 4, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadDelimiterPatternSplit(t *testing.T) {
+	// Each read returns a single byte so the delimiter is always split across
+	// reads, and must not be cut short
+	data := iotest.OneByteReader(bytes.NewBufferString("first\n\n\nsecond\n\nthird\n\n"))
+
+	reader := NewLineReader(data, 100, 100)
+	reader.setDelimiterPattern(regexp.MustCompile(`\n{2,}`))
+
+	checkLine(t, reader, "first", 8, nil)
+	checkLine(t, reader, "second", 8, nil)
+	checkLine(t, reader, "third", 7, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadDelimiterPatternTooLong(t *testing.T) {
+	data := bytes.NewBufferString("1234567890||12||")
+
+	reader := NewLineReader(data, 100, 8)
+	reader.setDelimiterPattern(regexp.MustCompile(`\|\|`))
+
+	checkLine(t, reader, "12345678", 8, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "90", 4, ErrMaxDataSizeTruncation)
+	checkLine(t, reader, "12", 4, nil)
+	checkLine(t, reader, "", 0, io.EOF)
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// RateLimit holds the maximum rate at which a harvester will read events from
+// a file, with zero meaning unlimited
+type RateLimit struct {
+	Bytes  int64 `config:"bytes"`
+	Events int64 `config:"events"`
+}
+
+// Validate the rate limit configuration
+func (rl *RateLimit) Validate(p *config.Parser, path string) (err error) {
+	if rl.Bytes < 0 {
+		err = fmt.Errorf("%sbytes can not be negative", path)
+		return
+	}
+
+	if rl.Events < 0 {
+		err = fmt.Errorf("%sevents can not be negative", path)
+		return
+	}
+
+	return
+}
+
+// enabled returns true if either limit is set
+func (rl *RateLimit) enabled() bool {
+	return rl.Bytes != 0 || rl.Events != 0
+}
+
+// rateLimiter is a token bucket for each of the limits in a RateLimit, each
+// holding up to one second of allowance so that short bursts are permitted
+type rateLimiter struct {
+	limit  *RateLimit
+	events float64
+	bytes  float64
+	last   time.Time
+}
+
+// newRateLimiter creates a new rateLimiter with full buckets
+func newRateLimiter(limit *RateLimit, now time.Time) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		events: float64(limit.Events),
+		bytes:  float64(limit.Bytes),
+		last:   now,
+	}
+}
+
+// take consumes an event of the given length from the buckets, returning how
+// long to wait before continuing in order to stay within the limits. An event
+// larger than the allowance is permitted, and the time it borrowed is repaid
+// by waiting longer
+func (r *rateLimiter) take(now time.Time, length int) time.Duration {
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+
+	var wait time.Duration
+	if r.limit.Events != 0 {
+		r.events = refillBucket(r.events, elapsed, float64(r.limit.Events)) - 1
+		if eventsWait := bucketWait(r.events, float64(r.limit.Events)); eventsWait > wait {
+			wait = eventsWait
+		}
+	}
+	if r.limit.Bytes != 0 {
+		r.bytes = refillBucket(r.bytes, elapsed, float64(r.limit.Bytes)) - float64(length)
+		if bytesWait := bucketWait(r.bytes, float64(r.limit.Bytes)); bytesWait > wait {
+			wait = bytesWait
+		}
+	}
+
+	return wait
+}
+
+// refillBucket adds the allowance for the elapsed time to a bucket, up to the
+// allowance for one second
+func refillBucket(tokens float64, elapsed float64, rate float64) float64 {
+	tokens += elapsed * rate
+	if tokens > rate {
+		return rate
+	}
+	return tokens
+}
+
+// bucketWait returns how long until a bucket is no longer in debt
+func bucketWait(tokens float64, rate float64) time.Duration {
+	if tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tokens / rate * float64(time.Second))
+}
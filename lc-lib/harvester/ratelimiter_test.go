@@ -0,0 +1,76 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterEvents(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(&RateLimit{Events: 10}, now)
+
+	// A full second of events is allowed as a burst
+	for i := 0; i < 10; i++ {
+		if wait := limiter.take(now, 100); wait != 0 {
+			t.Fatalf("Unexpected wait for event %d: %s", i, wait)
+		}
+	}
+
+	if wait := limiter.take(now, 100); wait != 100*time.Millisecond {
+		t.Fatalf("Unexpected wait after burst: %s", wait)
+	}
+
+	// Repaying the wait allows the next event after another interval
+	now = now.Add(100 * time.Millisecond)
+	if wait := limiter.take(now, 100); wait != 100*time.Millisecond {
+		t.Fatalf("Unexpected wait after repaying: %s", wait)
+	}
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(&RateLimit{Bytes: 1000}, now)
+
+	if wait := limiter.take(now, 500); wait != 0 {
+		t.Fatalf("Unexpected wait: %s", wait)
+	}
+
+	// An event larger than the remaining allowance borrows the time
+	if wait := limiter.take(now, 2500); wait != 2*time.Second {
+		t.Fatalf("Unexpected wait for large event: %s", wait)
+	}
+}
+
+func TestRateLimiterRefillLimited(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(&RateLimit{Events: 10, Bytes: 1000}, now)
+
+	// A long pause only refills one second of allowance
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		if wait := limiter.take(now, 10); wait != 0 {
+			t.Fatalf("Unexpected wait for event %d: %s", i, wait)
+		}
+	}
+
+	// The longer of the two waits is used
+	if wait := limiter.take(now, 1300); wait != 400*time.Millisecond {
+		t.Fatalf("Unexpected wait: %s", wait)
+	}
+}
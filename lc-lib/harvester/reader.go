@@ -39,8 +39,11 @@ var (
 
 // Reader is implemented by the various harvester readers and reads events from
 // a file or stream
+// Flush returns events for any incomplete data that is buffered, and should be
+// called until it returns nil
 type Reader interface {
 	BufferedLen() int
+	Flush() (map[string]interface{}, int)
 	ReadItem() (map[string]interface{}, int, error)
 	Reset()
 }
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+)
+
+type apiActions struct {
+	api.KeyValue
+
+	p *Pool
+}
+
+// Update updates the per-action status information
+func (a *apiActions) Update() error {
+	a.p.mutex.RLock()
+	actions := a.p.pipelines.actions
+	a.p.mutex.RUnlock()
+
+	// Actions are replaced on configuration reload so rebuild from scratch
+	a.KeyValue = api.KeyValue{}
+	for _, action := range actions {
+		processed, failed, average := action.Stats()
+
+		actionAPI := &api.KeyValue{}
+		actionAPI.SetEntry("name", api.String(action.name))
+		actionAPI.SetEntry("processedEvents", api.Number(processed))
+		actionAPI.SetEntry("failedEvents", api.Number(failed))
+		actionAPI.SetEntry("averageMicroseconds", api.Number(average.Microseconds()))
+		a.SetEntry(action.Key(), actionAPI)
+	}
+
+	return nil
+}
+
+type apiStatus struct {
+	api.KeyValue
+
+	p *Pool
+}
+
+// Update updates the processor status information
+func (a *apiStatus) Update() error {
+	a.p.mutex.RLock()
+	general := a.p.cfg.GeneralPart("processor").(*General)
+	a.SetEntry("routines", api.Number(general.ProcessorRoutines))
+	a.SetEntry("actions", api.Number(len(a.p.pipelines.actions)))
+	a.p.mutex.RUnlock()
+
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+// astAction wraps an action entry in the pipeline so that statistics on the
+// events it processes can be reported through the admin API
+type astAction struct {
+	ASTEntry
+
+	name       string
+	path       string
+	failureTag string
+
+	processedEvents uint64
+	failedEvents    uint64
+	processingTime  int64
+}
+
+// newASTAction wraps the given action entry
+func newASTAction(entry ASTEntry, name string, path string) *astAction {
+	return &astAction{
+		ASTEntry:   entry,
+		name:       name,
+		path:       path,
+		failureTag: fmt.Sprintf("_%s_failure", name),
+	}
+}
+
+// Process passes the event to the action, recording the time taken and
+// whether the action added its failure tag to the event
+func (a *astAction) Process(subject *event.Event) *event.Event {
+	alreadyFailed := hasTag(subject, a.failureTag)
+
+	start := time.Now()
+	subject = a.ASTEntry.Process(subject)
+	atomic.AddInt64(&a.processingTime, int64(time.Since(start)))
+
+	atomic.AddUint64(&a.processedEvents, 1)
+	if !alreadyFailed && hasTag(subject, a.failureTag) {
+		atomic.AddUint64(&a.failedEvents, 1)
+	}
+	return subject
+}
+
+// Key returns the key to use for the action in the admin API, derived from its
+// path in the configuration
+func (a *astAction) Key() string {
+	return strings.ReplaceAll(strings.Trim(a.path, "/"), "/", ".")
+}
+
+// Stats returns the number of events processed, the number of those that
+// failed, and the average time spent processing each event
+func (a *astAction) Stats() (processed uint64, failed uint64, average time.Duration) {
+	processed = atomic.LoadUint64(&a.processedEvents)
+	failed = atomic.LoadUint64(&a.failedEvents)
+	if processed != 0 {
+		average = time.Duration(atomic.LoadInt64(&a.processingTime) / int64(processed))
+	}
+	return
+}
+
+// MarshalJSON renders the wrapped action so the configuration output is
+// unaffected by the wrapping
+func (a *astAction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.ASTEntry)
+}
+
+// hasTag returns true if the event has the given tag
+func hasTag(evnt *event.Event, tag string) bool {
+	tags, ok := evnt.Data()["tags"].(event.Tags)
+	if !ok {
+		return false
+	}
+	idx := sort.SearchStrings(tags, tag)
+	return idx < len(tags) && tags[idx] == tag
+}
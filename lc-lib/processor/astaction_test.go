@@ -0,0 +1,100 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+type testFailingAction struct{}
+
+func (a *testFailingAction) Process(subject *event.Event) *event.Event {
+	subject.AddError("test", "failed")
+	return subject
+}
+
+func createTestActionEvent() *event.Event {
+	return event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "test"})
+}
+
+func TestASTActionCountsEvents(t *testing.T) {
+	action := newASTAction(&addTagAction{Tag: "test"}, "add_tag", "/pipelines[0]/")
+	action.Process(createTestActionEvent())
+	action.Process(createTestActionEvent())
+
+	processed, failed, _ := action.Stats()
+	if processed != 2 {
+		t.Errorf("Unexpected processed count, got: %d, expected: 2", processed)
+	}
+	if failed != 0 {
+		t.Errorf("Unexpected failed count, got: %d, expected: 0", failed)
+	}
+}
+
+func TestASTActionCountsFailures(t *testing.T) {
+	action := newASTAction(&testFailingAction{}, "test", "/pipelines[0]/")
+	evnt := action.Process(createTestActionEvent())
+
+	// A failure tag that was already present should not count again
+	action.Process(evnt)
+
+	processed, failed, _ := action.Stats()
+	if processed != 2 {
+		t.Errorf("Unexpected processed count, got: %d, expected: 2", processed)
+	}
+	if failed != 1 {
+		t.Errorf("Unexpected failed count, got: %d, expected: 1", failed)
+	}
+}
+
+func TestASTActionKey(t *testing.T) {
+	action := newASTAction(&addTagAction{}, "add_tag", "/pipelines[1]/then[0]/")
+	if key := action.Key(); key != "pipelines[1].then[0]" {
+		t.Errorf("Unexpected key, got: %s, expected: pipelines[1].then[0]", key)
+	}
+}
+
+func TestConfigCollectsActions(t *testing.T) {
+	rawConfig := []interface{}{
+		map[string]interface{}{"name": "add_tag", "tag": "first"},
+		map[string]interface{}{
+			"if":   "true",
+			"then": []interface{}{map[string]interface{}{"name": "add_tag", "tag": "then"}},
+		},
+		map[string]interface{}{
+			"else": []interface{}{map[string]interface{}{"name": "remove_tag", "tag": "else"}},
+		},
+	}
+
+	cfg := &Config{}
+	if err := config.NewParser(config.NewConfig()).Populate(cfg, rawConfig, "/pipelines/", true); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(cfg.actions) != 3 {
+		t.Fatalf("Unexpected action count, got: %d, expected: 3", len(cfg.actions))
+	}
+	for idx, expected := range []string{"pipelines[0]", "pipelines[1].then[0]", "pipelines[2].else[0]"} {
+		if key := cfg.actions[idx].Key(); key != expected {
+			t.Errorf("Unexpected key for action %d, got: %s, expected: %s", idx, key, expected)
+		}
+	}
+}
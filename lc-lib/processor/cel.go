@@ -17,6 +17,8 @@
 package processor
 
 import (
+	"fmt"
+
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	celext "github.com/google/cel-go/ext"
@@ -56,23 +58,52 @@ func normalizeType(value interface{}) interface{} {
 
 // ParseExpression parses an expression using cel-go and returns the evaluatable program
 func ParseExpression(expression string) (cel.Program, error) {
-	env, err := cachedCelEnv()
+	env, checked, err := checkExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.Program(checked)
+}
+
+// ParseConditionExpression parses an expression that must evaluate to a bool
+// using cel-go and returns the evaluatable program, failing if the expression
+// is known to evaluate to another type
+func ParseConditionExpression(expression string) (cel.Program, error) {
+	env, checked, err := checkExpression(expression)
 	if err != nil {
 		return nil, err
 	}
 
+	// Event fields can be of any type so can only be checked during evaluation
+	switch outputType := checked.OutputType(); outputType.String() {
+	case cel.BoolType.String(), cel.DynType.String(), cel.AnyType.String():
+	default:
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", outputType)
+	}
+
+	return env.Program(checked)
+}
+
+// checkExpression parses and checks an expression using cel-go
+func checkExpression(expression string) (*cel.Env, *cel.Ast, error) {
+	env, err := cachedCelEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Parse using the environment
 	parsed, issues := env.Parse(expression)
 	if issues != nil && issues.Err() != nil {
-		return nil, issues.Err()
+		return nil, nil, issues.Err()
 	}
 
 	// Likely this does nothing at the moment as we don't prepare any declarations
 	// But keep it here in case we improve the environment
 	checked, issues := env.Check(parsed)
 	if issues != nil && issues.Err() != nil {
-		return nil, issues.Err()
+		return nil, nil, issues.Err()
 	}
 
-	return env.Program(checked)
+	return env, checked, nil
 }
@@ -44,6 +44,8 @@ type Config struct {
 	Pipeline []*ConfigASTEntry `config:",embed_slice" json:",omitempty"`
 
 	AST []ASTEntry
+
+	actions []*astAction
 }
 
 // ConfigASTEntry is a configuration entry we need to parse into an ASTEntry
@@ -65,6 +67,7 @@ func (gc *General) Validate(p *config.Parser, path string) (err error) {
 // Init the pipeline configuration
 func (c *Config) Init(p *config.Parser, path string) error {
 	c.AST = make([]ASTEntry, 0, len(c.Pipeline))
+	c.actions = nil
 
 	var (
 		ifEntry, elseEntry *ConfigASTEntry
@@ -168,7 +171,10 @@ func (c *Config) initAction(p *config.Parser, entry *ConfigASTEntry) (ASTEntry,
 		return nil, err
 	}
 
-	return ast, nil
+	// Wrap so we can report per-action status
+	wrapped := newASTAction(ast, action, entry.Path)
+	c.actions = append(c.actions, wrapped)
+	return wrapped, nil
 }
 
 // initLogic creates and returns a new ASTLogic entry
@@ -178,6 +184,7 @@ func (c *Config) initLogic(p *config.Parser, ifEntry *ConfigASTEntry, elseIfEntr
 	if err := p.Populate(ifAST, ifEntry.Unused, ifEntry.Path, true); err != nil {
 		return nil, err
 	}
+	c.actions = append(c.actions, ifAST.Then.actions...)
 
 	// Next, create all the "else if" branches
 	if len(elseIfEntries) != 0 {
@@ -187,6 +194,7 @@ func (c *Config) initLogic(p *config.Parser, ifEntry *ConfigASTEntry, elseIfEntr
 			if err := p.Populate(elseIfAST, entry.Unused, entry.Path, true); err != nil {
 				return nil, err
 			}
+			c.actions = append(c.actions, elseIfAST.Then.actions...)
 			ifAST.ElseIfBranches = append(ifAST.ElseIfBranches, elseIfAST)
 		}
 	}
@@ -197,6 +205,7 @@ func (c *Config) initLogic(p *config.Parser, ifEntry *ConfigASTEntry, elseIfEntr
 		if err := p.Populate(ifAST.ElseBranch, elseEntry.Unused, elseEntry.Path, true); err != nil {
 			return nil, err
 		}
+		c.actions = append(c.actions, ifAST.ElseBranch.Else.actions...)
 	}
 
 	return ifAST, nil
@@ -18,8 +18,11 @@ package processor
 
 import (
 	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/admin/api"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/event"
@@ -32,19 +35,23 @@ type Pool struct {
 	shutdownChan <-chan struct{}
 	configChan   <-chan *config.Config
 
+	mutex       sync.RWMutex
 	cfg         *config.Config
 	pipelines   *Config
 	debugEvents bool
 	sequencer   *event.Sequencer
 	fanout      chan *event.Bundle
 	collector   chan *event.Bundle
+
+	adminConfig *admin.Config
 }
 
 // NewPool creates a new processor pool
 func NewPool(app *core.App) *Pool {
 	return &Pool{
-		input:     make(chan []*event.Event, 1),
-		sequencer: event.NewSequencer(),
+		input:       make(chan []*event.Event, 1),
+		sequencer:   event.NewSequencer(),
+		adminConfig: admin.FetchConfig(app.Config()),
 	}
 }
 
@@ -71,6 +78,7 @@ func (p *Pool) SetConfigChan(configChan <-chan *config.Config) {
 // Init initialises
 func (p *Pool) Init(cfg *config.Config) error {
 	p.applyConfig(cfg)
+	p.initAPI()
 	return nil
 }
 
@@ -224,7 +232,23 @@ func (p *Pool) processEvent(evnt *event.Event) *event.Event {
 
 // applyConfig applies the given configuration
 func (p *Pool) applyConfig(cfg *config.Config) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.cfg = cfg
 	p.pipelines = FetchConfig(cfg)
 	p.debugEvents = cfg.GeneralPart("processor").(*General).DebugEvents
 }
+
+// initAPI initialises the processor API entries
+func (p *Pool) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !p.adminConfig.Enabled {
+		return
+	}
+
+	processorAPI := &api.Node{}
+	processorAPI.SetEntry("status", &apiStatus{p: p})
+	processorAPI.SetEntry("actions", &apiActions{p: p})
+
+	p.adminConfig.SetEntry("processor", processorAPI)
+}
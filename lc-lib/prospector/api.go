@@ -35,6 +35,17 @@ func (a *apiStatus) Update() error {
 	a.p.mutex.RLock()
 	a.SetEntry("watchedFiles", api.Number(len(a.p.prospectorindex)))
 	a.SetEntry("activeStates", api.Number(len(a.p.prospectors)))
+	a.SetEntry("queuedFiles", api.Number(len(a.p.queue)))
+	if a.p.notifier != nil {
+		a.SetEntry("mode", api.String(prospectModeNotify))
+	} else {
+		a.SetEntry("mode", api.String(prospectModeScan))
+	}
+	if a.p.notifyErr != nil {
+		a.SetEntry("notifyError", api.String(a.p.notifyErr.Error()))
+	} else {
+		a.SetEntry("notifyError", api.Null)
+	}
 	a.p.mutex.RUnlock()
 
 	return nil
@@ -97,13 +108,23 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	switch info.status {
 	default:
 		if info.running {
-			status = "running"
+			if info.harvester.IsThrottled() {
+				status = "throttled"
+			} else {
+				status = "running"
+			}
+		} else if info.queued {
+			status = "queued"
 		} else {
 			status = "dead"
 		}
 		errString = api.Null
 	case statusResume:
-		status = "resuming"
+		if info.queued {
+			status = "queued"
+		} else {
+			status = "resuming"
+		}
 		errString = api.Null
 	case statusFailed:
 		if info.failedUntil.IsZero() {
@@ -131,6 +152,13 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	apiEntry.SetEntry("status", status)
 	apiEntry.SetEntry("error", errString)
 
+	// Include truncations by the current harvester as well as previous ones
+	truncations := info.truncations
+	if info.running {
+		truncations += info.harvester.Truncations()
+	}
+	apiEntry.SetEntry("truncations", api.Number(truncations))
+
 	if info.running {
 		apiEntry.SetEntry("harvester", info.apiEncodable())
 	}
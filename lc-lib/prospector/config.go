@@ -19,6 +19,8 @@ package prospector
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
@@ -32,9 +34,23 @@ var (
 )
 
 const (
-	defaultStreamDeadTime time.Duration = 1 * time.Hour
+	defaultStreamDeadTime         time.Duration = 1 * time.Hour
+	defaultStreamFingerprintBytes int64         = 1024
+	defaultStreamIdentity         string        = identityInode
 
-	defaultGeneralProspectInterval time.Duration = 10 * time.Second
+	defaultGeneralNotifyScanInterval time.Duration = 5 * time.Minute
+	defaultGeneralProspectInterval   time.Duration = 10 * time.Second
+	defaultGeneralProspectMode       string        = prospectModeScan
+)
+
+const (
+	identityInode       = "inode"
+	identityFingerprint = "fingerprint"
+)
+
+const (
+	prospectModeScan   = "scan"
+	prospectModeNotify = "notify"
 )
 
 // FileConfig holds the configuration for a set of paths that share the same
@@ -42,8 +58,39 @@ const (
 type FileConfig struct {
 	*harvester.StreamConfig `config:",embed"`
 
-	DeadTime time.Duration `config:"dead time"`
-	Paths    []string      `config:"paths"`
+	DeadTime         time.Duration `config:"dead time"`
+	ExcludePaths     []string      `config:"exclude paths"`
+	FingerprintBytes int64         `config:"fingerprint bytes"`
+	Identity         string        `config:"identity"`
+	IgnoreOlder      time.Duration `config:"ignore older"`
+	PathFields       string        `config:"path fields"`
+	Paths            []string      `config:"paths"`
+
+	pathFields *regexp.Regexp
+}
+
+// excludedBy returns the exclude pattern that matches the given path, if any
+// Patterns without a path separator are matched against the file name only
+func (fc *FileConfig) excludedBy(path string) (string, bool) {
+	for _, pattern := range fc.ExcludePaths {
+		target := path
+		if !strings.ContainsRune(pattern, '/') && !strings.ContainsRune(pattern, filepath.Separator) {
+			target = filepath.Base(path)
+		}
+		if matched, _ := doublestar.PathMatch(pattern, target); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// newFileHead returns a fileHead for the given path if the configuration
+// identifies files by fingerprint, otherwise it returns nil
+func (fc *FileConfig) newFileHead(path string) *fileHead {
+	if fc.Identity != identityFingerprint {
+		return nil
+	}
+	return newFileHead(path, fc.FingerprintBytes)
 }
 
 // IncludeConfig holds additional files that need to be loaded into the
@@ -56,6 +103,8 @@ type Config []*FileConfig
 // Defaults sets up the FileConfig defaults prior to population
 func (fc *FileConfig) Defaults() {
 	fc.DeadTime = defaultStreamDeadTime
+	fc.FingerprintBytes = defaultStreamFingerprintBytes
+	fc.Identity = defaultStreamIdentity
 }
 
 // Validate does nothing for a prospector stream
@@ -121,7 +170,30 @@ func (c Config) Validate(p *config.Parser, path string) (err error) {
 // General contains extra general section configuration values for the
 // prospector and registrar
 type General struct {
-	ProspectInterval time.Duration `config:"prospect interval"`
+	MaxHarvesters      int64         `config:"max harvesters"`
+	NotifyScanInterval time.Duration `config:"notify scan interval"`
+	ProspectInterval   time.Duration `config:"prospect interval"`
+	ProspectMode       string        `config:"prospect mode"`
+}
+
+// Validate the additional general configuration
+func (gc *General) Validate(p *config.Parser, path string) (err error) {
+	if gc.MaxHarvesters < 0 {
+		err = fmt.Errorf("%smax harvesters can not be negative", path)
+		return
+	}
+
+	if gc.NotifyScanInterval <= 0 {
+		err = fmt.Errorf("%snotify scan interval must be greater than 0", path)
+		return
+	}
+
+	if gc.ProspectMode != prospectModeScan && gc.ProspectMode != prospectModeNotify {
+		err = fmt.Errorf("%sprospect mode must be either \"%s\" or \"%s\"", path, prospectModeScan, prospectModeNotify)
+		return
+	}
+
+	return
 }
 
 // Validate validates all config structures and initialises streams
@@ -141,6 +213,35 @@ func validateFileConfigs(p *config.Parser) (err error) {
 			}
 		}
 
+		for l, path := range c[k].ExcludePaths {
+			if !doublestar.ValidatePattern(path) {
+				err = fmt.Errorf("pattern at /files[%d]/exclude paths[%d] is invalid: %s", k, l, path)
+				return
+			}
+		}
+
+		if c[k].Identity != identityInode && c[k].Identity != identityFingerprint {
+			err = fmt.Errorf("/files[%d]/identity must be either \"%s\" or \"%s\"", k, identityInode, identityFingerprint)
+			return
+		}
+
+		if c[k].FingerprintBytes < 1 {
+			err = fmt.Errorf("/files[%d]/fingerprint bytes must be greater than 0", k)
+			return
+		}
+
+		if c[k].IgnoreOlder < 0 {
+			err = fmt.Errorf("/files[%d]/ignore older can not be negative", k)
+			return
+		}
+
+		if c[k].PathFields != "" {
+			if c[k].pathFields, err = compilePathFields(c[k].PathFields); err != nil {
+				err = fmt.Errorf("/files[%d]/path fields is invalid: %s", k, err)
+				return
+			}
+		}
+
 		// Init the harvester config
 		if err = c[k].Init(p, fmt.Sprintf("/files[%d]", k)); err != nil {
 			return
@@ -161,7 +262,9 @@ func init() {
 
 	config.RegisterGeneral("prospector", func() interface{} {
 		return &General{
-			ProspectInterval: defaultGeneralProspectInterval,
+			NotifyScanInterval: defaultGeneralNotifyScanInterval,
+			ProspectInterval:   defaultGeneralProspectInterval,
+			ProspectMode:       defaultGeneralProspectMode,
 		}
 	})
 }
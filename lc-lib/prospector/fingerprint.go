@@ -0,0 +1,73 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"os"
+
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// fileHead lazily reads the data at the start of a file so that it can be
+// compared against the fingerprints of known files
+type fileHead struct {
+	path   string
+	length int64
+	data   []byte
+	err    error
+	read   bool
+}
+
+// newFileHead returns a new fileHead for the file at the given path, which will
+// read up to the given length
+func newFileHead(path string, length int64) *fileHead {
+	return &fileHead{
+		path:   path,
+		length: length,
+	}
+}
+
+// Data returns the data from the start of the file, reading it on first use
+func (h *fileHead) Data() ([]byte, error) {
+	if !h.read {
+		h.data, h.err = registrar.ReadFingerprintData(h.path, h.length)
+		h.read = true
+	}
+	return h.data, h.err
+}
+
+// sameFile returns true if the file the info refers to is the given file
+// When head is given and the info has a fingerprint it is compared against the
+// data at the start of the file, and if they differ it is not the same file.
+// If they match but the fingerprint is shorter than the given length, or no
+// fingerprint is available, the operating system identifiers are compared
+func sameFile(info *prospectorInfo, fileinfo os.FileInfo, head *fileHead, minLength int64) bool {
+	if head != nil && info.fingerprint != nil {
+		data, err := head.Data()
+		if err == nil {
+			if !info.fingerprint.Matches(data) {
+				return false
+			}
+			if info.fingerprint.Length >= minLength {
+				return true
+			}
+		} else {
+			log.Warning("Failed to read fingerprint data, falling back to file identifiers: %s", err)
+		}
+	}
+	return info.identity.SameAs(fileinfo)
+}
@@ -44,11 +44,22 @@ type prospectorInfo struct {
 	ctx          context.Context
 	file         string
 	identity     registrar.FileIdentity
+	fingerprint  *registrar.Fingerprint
+	completion   *registrar.Completion
+	completed    bool
 	lastSeen     uint32
 	status       int
 	running      bool
+	stopping     bool
+	queued       bool
+	yielding     bool
+	started      time.Time
+	fileConfig   *FileConfig
 	orphaned     int
 	finishOffset int64
+	lineCount    uint64
+	byteCount    uint64
+	truncations  uint64
 	harvester    *harvester.Harvester
 	err          error
 	backoff      *core.ExpBackoff
@@ -59,6 +70,8 @@ func newProspectorInfoFromFileState(file string, filestate *registrar.FileState)
 	info := &prospectorInfo{
 		file:         file,
 		identity:     filestate,
+		fingerprint:  filestate.Fingerprint,
+		completion:   filestate.Completion,
 		status:       statusResume,
 		finishOffset: filestate.Offset,
 		// TODO: Make configurable
@@ -106,10 +119,11 @@ func (pi *prospectorInfo) isRunning() bool {
 }
 
 func (pi *prospectorInfo) stop() {
-	if !pi.running {
+	if !pi.running || pi.stopping {
 		return
 	}
 	pi.harvester.Stop()
+	pi.stopping = true
 }
 
 func (pi *prospectorInfo) wait() {
@@ -120,15 +134,30 @@ func (pi *prospectorInfo) wait() {
 	pi.setHarvesterStopped(status)
 }
 
+// isComplete returns true if the file is a compressed file that was read to
+// the end, has not changed since, and all of its events were acknowledged
+func (pi *prospectorInfo) isComplete(fileinfo os.FileInfo) bool {
+	return pi.completion != nil && pi.completion.Size == fileinfo.Size() && pi.completion.Offset == pi.finishOffset
+}
+
 func (pi *prospectorInfo) apiEncodable() api.Encodable {
 	return pi.harvester.APIEncodable()
 }
 
 func (pi *prospectorInfo) setHarvesterStopped(status *harvester.FinishStatus) {
 	pi.running = false
+	pi.stopping = false
 	// Resume harvesting from the last event offset, not the last read, to allow codec to read from the last event
 	// This ensures multiline codec populates correctly on resume
 	pi.finishOffset = status.LastEventOffset
+	pi.lineCount += status.LineCount
+	pi.byteCount += status.ByteCount
+	pi.truncations += status.Truncations
+	if status.Completed && status.LastStat != nil {
+		// Record the completion so it can be persisted by the prospector
+		pi.completion = &registrar.Completion{Size: status.LastStat.Size(), Offset: status.LastEventOffset}
+		pi.completed = true
+	}
 	if status.Error != nil {
 		pi.status = statusFailed
 		pi.err = status.Error
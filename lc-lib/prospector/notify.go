@@ -0,0 +1,140 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// notifyScanDelay is how long to wait after a notification before scanning,
+	// so that a burst of changes only triggers a single scan
+	notifyScanDelay = 250 * time.Millisecond
+)
+
+// dirNotifier watches directories for the creation, renaming and deletion of
+// files so that scans can be triggered as soon as they occur
+type dirNotifier struct {
+	watcher *fsnotify.Watcher
+	watched map[string]struct{}
+}
+
+// newDirNotifier creates a new dirNotifier, returning an error if
+// notifications are not supported
+func newDirNotifier() (*dirNotifier, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirNotifier{
+		watcher: watcher,
+		watched: make(map[string]struct{}),
+	}, nil
+}
+
+// Events returns the channel that receives notifications
+func (n *dirNotifier) Events() <-chan fsnotify.Event {
+	return n.watcher.Events
+}
+
+// Errors returns the channel that receives errors
+func (n *dirNotifier) Errors() <-chan error {
+	return n.watcher.Errors
+}
+
+// Watch updates the set of watched directories to those given, returning an
+// error if a directory that exists could not be watched, such as when the
+// limit on the number of watches has been reached
+func (n *dirNotifier) Watch(dirs map[string]struct{}) error {
+	for dir := range n.watched {
+		if _, ok := dirs[dir]; !ok {
+			// Directories that were deleted will have already been removed
+			n.watcher.Remove(dir)
+			delete(n.watched, dir)
+		}
+	}
+
+	for dir := range dirs {
+		if _, ok := n.watched[dir]; ok {
+			continue
+		}
+		if err := n.watcher.Add(dir); err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				// Deleted since it was found, or we can't read it, in which case
+				// the scan will not be able to either
+				continue
+			}
+			return err
+		}
+		n.watched[dir] = struct{}{}
+	}
+
+	return nil
+}
+
+// Close stops watching all directories
+func (n *dirNotifier) Close() {
+	n.watcher.Close()
+}
+
+// isNotifyScanEvent returns true if the notification is for a change that
+// requires a scan
+func isNotifyScanEvent(evnt fsnotify.Event) bool {
+	return evnt.Has(fsnotify.Create) || evnt.Has(fsnotify.Remove) || evnt.Has(fsnotify.Rename)
+}
+
+// notifyDirsForPattern adds to dirs the directories that need to be watched to
+// be notified of changes to the files matching the given pattern. This is the
+// directory containing the first wildcard and every directory beneath it that
+// could contain matching files, so that new directories are also noticed
+func notifyDirsForPattern(pattern string, dirs map[string]struct{}) {
+	base, rest := doublestar.SplitPattern(filepath.ToSlash(pattern))
+	addNotifyDir(filepath.FromSlash(base), dirs)
+
+	components := strings.Split(path.Dir(rest), "/")
+	if components[0] == "." {
+		return
+	}
+
+	for idx := range components {
+		prefix := path.Join(base, path.Join(components[:idx+1]...))
+		matches, err := doublestar.FilepathGlob(filepath.FromSlash(prefix))
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			addNotifyDir(match, dirs)
+		}
+	}
+}
+
+// addNotifyDir adds the path to dirs if it is a directory
+func addNotifyDir(dir string, dirs map[string]struct{}) {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		dirs[dir] = struct{}{}
+	}
+}
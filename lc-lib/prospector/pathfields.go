@@ -0,0 +1,93 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// pathFieldsPresets contains the patterns that can be given by name to the
+// "path fields" option instead of a regular expression
+var pathFieldsPresets = map[string]string{
+	// Kubernetes container logs are stored in a directory for each pod named
+	// <namespace>_<pod name>_<pod uid>, with a directory for each container
+	"kubernetes": `^/var/log/pods/(?P<kubernetes_namespace>[^_/]+)_(?P<kubernetes_pod_name>[^_/]+)_(?P<kubernetes_pod_uid>[^_/]+)/(?P<kubernetes_container_name>[^/]+)/`,
+}
+
+// pathFieldsReserved contains the names of fields that are set by the
+// harvester or the stream configuration and so cannot be used as captures
+var pathFieldsReserved = map[string]bool{
+	"@metadata":     true,
+	"@timestamp":    true,
+	"event":         true,
+	"host":          true,
+	"log":           true,
+	"message":       true,
+	"offset":        true,
+	"path":          true,
+	"tags":          true,
+	"timezone":      true,
+	"timezone_name": true,
+}
+
+// compilePathFields compiles the given "path fields" pattern or preset,
+// ensuring it has named captures to extract fields from
+func compilePathFields(pattern string) (*regexp.Regexp, error) {
+	if preset, ok := pathFieldsPresets[pattern]; ok {
+		pattern = preset
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	hasNames := false
+	for _, name := range compiled.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if pathFieldsReserved[name] {
+			return nil, fmt.Errorf("the named capture \"%s\" would replace a built-in field", name)
+		}
+		hasNames = true
+	}
+
+	if !hasNames {
+		return nil, fmt.Errorf("it is not a known preset and contains no named captures")
+	}
+
+	return compiled, nil
+}
+
+// extractPathFields returns the fields captured by the "path fields" pattern
+// from the given path, or nil if the pattern does not match
+func extractPathFields(pattern *regexp.Regexp, path string) map[string]interface{} {
+	matches := pattern.FindStringSubmatch(path)
+	if matches == nil {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+	for idx, name := range pattern.SubexpNames() {
+		if name != "" && matches[idx] != "" {
+			fields[name] = matches[idx]
+		}
+	}
+	return fields
+}
@@ -20,7 +20,9 @@
 package prospector
 
 import (
+	"fmt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/event"
 	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/fsnotify/fsnotify"
 )
 
 // Prospector handles the crawling of paths and starting and stopping of
@@ -42,7 +45,10 @@ type Prospector struct {
 	fileConfigs     Config
 	prospectorindex map[string]*prospectorInfo
 	prospectors     map[*prospectorInfo]*prospectorInfo
+	queue           []*prospectorInfo
 	fromBeginning   bool
+	once            bool
+	completed       bool
 	iteration       uint32
 	lastscan        time.Time
 	registrar       *registrar.Registrar
@@ -51,13 +57,18 @@ type Prospector struct {
 	configChan      <-chan *config.Config
 	output          chan<- []*event.Event
 	didWarnIoError  bool
+	notifier        *dirNotifier
+	notifyErr       error
 }
 
 // NewProspector creates a new path crawler with the given configuration
 // If fromBeginning is true and registrar reports no state was loaded, all new
 // files on the FIRST scan will be started from the beginning, as opposed to
 // from the end
-func NewProspector(app *core.App, fromBeginning bool) *Prospector {
+// If once is true, a single scan is performed and all files are read from the
+// beginning, or from where they were previously left off, until their end,
+// after which the prospector exits
+func NewProspector(app *core.App, fromBeginning bool, once bool) *Prospector {
 	cfg := app.Config()
 	genConfig := cfg.GeneralPart("prospector").(*General)
 
@@ -73,7 +84,8 @@ func NewProspector(app *core.App, fromBeginning bool) *Prospector {
 		fileConfigs:     cfg.Section("files").(Config),
 		registrar:       registrarImpl,
 		registrarSpool:  registrar.NewEventSpooler(registrarImpl),
-		fromBeginning:   fromBeginning,
+		fromBeginning:   fromBeginning || once,
+		once:            once,
 	}
 }
 
@@ -122,9 +134,13 @@ func (p *Prospector) loadCallback(file string, state *registrar.FileState) (regi
 
 // Run begins the prospector loop
 func (p *Prospector) Run() {
-	for {
-		if p.runOnce() {
-			break
+	if p.once {
+		p.runBatch()
+	} else {
+		for {
+			if p.runOnce() {
+				break
+			}
 		}
 	}
 
@@ -137,6 +153,12 @@ func (p *Prospector) Run() {
 	for _, info := range p.prospectors {
 		info.wait()
 	}
+	p.persistCompletions()
+	p.registrarSpool.Send()
+	if p.notifier != nil {
+		p.notifier.Close()
+		p.notifier = nil
+	}
 	p.mutex.Unlock()
 
 	log.Info("Prospector exiting")
@@ -145,22 +167,203 @@ func (p *Prospector) Run() {
 // runOnce handles a single prospector iteration
 // Returns true if shutdown is necessary
 func (p *Prospector) runOnce() bool {
+	p.scanAll()
+
+	// Watch for changes that will need a scan before the next is due
+	p.updateNotifier()
+
+	// Defer next scan for a bit
+	now := time.Now()
+	scanDeadline := now.Add(p.genConfig.ProspectInterval)
+
+	// Whilst notifications are active, changes trigger a scan, so scanning is
+	// only needed as a safety net and can happen less often. Harvesters are
+	// still scheduled every prospect interval
+	var notifyEvents <-chan fsnotify.Event
+	var notifyErrors <-chan error
+	var maintainChan <-chan time.Time
+	if p.notifier != nil {
+		notifyEvents, notifyErrors = p.notifier.Events(), p.notifier.Errors()
+		if p.genConfig.NotifyScanInterval > p.genConfig.ProspectInterval {
+			scanDeadline = now.Add(p.genConfig.NotifyScanInterval)
+			maintainTicker := time.NewTicker(p.genConfig.ProspectInterval)
+			defer maintainTicker.Stop()
+			maintainChan = maintainTicker.C
+		}
+	}
+
+DelayLoop:
+	for {
+		select {
+		case <-time.After(scanDeadline.Sub(now)):
+			break DelayLoop
+		case <-p.shutdownChan:
+			return true
+		case <-maintainChan:
+			if p.maintain() {
+				break DelayLoop
+			}
+		case evnt := <-notifyEvents:
+			if isNotifyScanEvent(evnt) || p.isNotifyResumeEvent(evnt) {
+				log.Debug("Change notification received: %s", evnt)
+				if notifyDeadline := time.Now().Add(notifyScanDelay); notifyDeadline.Before(scanDeadline) {
+					scanDeadline = notifyDeadline
+				}
+			}
+		case err := <-notifyErrors:
+			if err == fsnotify.ErrEventOverflow {
+				// Notifications were lost, so scan to catch up
+				log.Debug("Change notifications overflowed")
+				if notifyDeadline := time.Now().Add(notifyScanDelay); notifyDeadline.Before(scanDeadline) {
+					scanDeadline = notifyDeadline
+				}
+			} else {
+				p.fallbackToScan(err)
+				notifyEvents, notifyErrors, maintainChan = nil, nil, nil
+				if fallbackDeadline := time.Now().Add(p.genConfig.ProspectInterval); fallbackDeadline.Before(scanDeadline) {
+					scanDeadline = fallbackDeadline
+				}
+			}
+		case cfg := <-p.configChan:
+			p.genConfig = cfg.GeneralPart("prospector").(*General)
+			p.fileConfigs = cfg.Section("files").(Config)
+			// Reset flag to warn on IO errors again
+			p.didWarnIoError = false
+			// Allow notifications to be attempted again if they previously failed
+			p.mutex.Lock()
+			p.notifyErr = nil
+			p.mutex.Unlock()
+			// Reset failed entries to allow immediate retry on reload
+			for _, info := range p.prospectors {
+				if info.status == statusFailed {
+					info.failedUntil = time.Now()
+				}
+			}
+			// Apply a change of mode or interval at the next scan
+			if reloadDeadline := time.Now().Add(p.genConfig.ProspectInterval); reloadDeadline.Before(scanDeadline) {
+				scanDeadline = reloadDeadline
+			}
+		}
+
+		now = time.Now()
+		if now.After(scanDeadline) {
+			break
+		}
+	}
+
+	return false
+}
+
+// runBatch performs a single scan and then waits for all harvesters to reach
+// the end of their files, or for shutdown to be requested
+func (p *Prospector) runBatch() {
+	p.scanAll()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		p.mutex.Lock()
+		p.scheduleHarvesters()
+		p.mutex.Unlock()
+
+		if !p.anyRunning() {
+			log.Notice("All files have been harvested")
+			p.mutex.Lock()
+			p.completed = true
+			p.mutex.Unlock()
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.shutdownChan:
+			return
+		case <-p.configChan:
+			// There will be no further scans so there is nothing to update
+		}
+	}
+}
+
+// anyRunning returns true if any harvesters are still running or waiting to
+// run
+func (p *Prospector) anyRunning() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	running := len(p.queue) != 0
+	for _, info := range p.prospectors {
+		if info.isRunning() || info.yielding {
+			running = true
+		}
+	}
+	return running
+}
+
+// BatchSummary contains the totals for the files harvested in once mode
+type BatchSummary struct {
+	Completed bool
+	Files     int
+	Skipped   int
+	Failed    int
+	Lines     uint64
+	Bytes     uint64
+}
+
+// BatchSummary returns the totals for the files harvested in once mode, and
+// whether or not all files were harvested to the end
+func (p *Prospector) BatchSummary() *BatchSummary {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	summary := &BatchSummary{Completed: p.completed}
+	for _, info := range p.prospectors {
+		switch info.status {
+		case statusInvalid:
+			if _, ok := info.err.(*prospectorSkipError); ok {
+				summary.Skipped++
+			} else {
+				summary.Failed++
+			}
+			continue
+		case statusFailed:
+			summary.Failed++
+		default:
+			summary.Files++
+		}
+		summary.Lines += info.lineCount
+		summary.Bytes += info.byteCount
+	}
+	return summary
+}
+
+// scanAll crawls all configured paths, starting and stopping harvesters as
+// necessary
+func (p *Prospector) scanAll() {
 	newlastscan := time.Now()
 	p.iteration++ // Overflow is allowed
 
+	skipped := make(map[string]error)
 	for configKey, config := range p.fileConfigs {
 		for _, path := range config.Paths {
 			log.Debug("Scanning %s", path)
-			p.scan(path, p.fileConfigs[configKey])
+			p.scan(path, p.fileConfigs[configKey], skipped)
 		}
 	}
 
+	// Record files skipped by one file configuration that were not then
+	// processed by another
+	for file, err := range skipped {
+		p.processSkipped(file, err)
+	}
+
 	// We only obey *fromBeginning (which is stored in this) on startup, if no
 	// persist file exists. Afterwards we force from beginning
 	p.fromBeginning = true
 
 	// Clean up the prospector collections
 	p.mutex.Lock()
+	p.persistCompletions()
 	for _, info := range p.prospectors {
 		if info.orphaned >= orphanedMaybe {
 			if !info.isRunning() {
@@ -178,48 +381,119 @@ func (p *Prospector) runOnce() bool {
 			p.registrarSpool.Add(registrar.NewDeletedEvent(info))
 		}
 	}
+
+	p.scheduleHarvesters()
 	p.mutex.Unlock()
 
 	// Flush the accumulated registrar events
 	p.registrarSpool.Send()
 
 	p.lastscan = newlastscan
+}
 
-	// Defer next scan for a bit
-	now := time.Now()
-	scanDeadline := now.Add(p.genConfig.ProspectInterval)
-
-DelayLoop:
-	for {
-		select {
-		case <-time.After(scanDeadline.Sub(now)):
-			break DelayLoop
-		case <-p.shutdownChan:
+// maintain schedules harvesters and informs the registrar of completed files
+// between scans whilst notifications are active, returning true if a scan is
+// needed to restart a failed harvester
+func (p *Prospector) maintain() bool {
+	p.mutex.Lock()
+	for _, info := range p.prospectors {
+		if info.status == statusFailed && !info.isRunning() && info.canRestartFailed() {
+			p.mutex.Unlock()
 			return true
-		case cfg := <-p.configChan:
-			p.genConfig = cfg.GeneralPart("prospector").(*General)
-			p.fileConfigs = cfg.Section("files").(Config)
-			// Reset flag to warn on IO errors again
-			p.didWarnIoError = false
-			// Reset failed entries to allow immediate retry on reload
-			for _, info := range p.prospectors {
-				if info.status == statusFailed {
-					info.failedUntil = time.Now()
-				}
-			}
 		}
+	}
+	p.persistCompletions()
+	p.scheduleHarvesters()
+	p.mutex.Unlock()
 
-		now = time.Now()
-		if now.After(scanDeadline) {
-			break
+	p.registrarSpool.Send()
+	return false
+}
+
+// isNotifyResumeEvent returns true if the notification is for a write to a
+// file that is not being harvested, which requires a scan to resume it
+func (p *Prospector) isNotifyResumeEvent(evnt fsnotify.Event) bool {
+	if !evnt.Has(fsnotify.Write) {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	info, ok := p.prospectorindex[evnt.Name]
+	return ok && info.status != statusInvalid && !info.isRunning() && !info.queued && !info.yielding
+}
+
+// persistCompletions informs the registrar of compressed files that have been
+// read to the end since it was last called, so they are not read again on
+// restart
+func (p *Prospector) persistCompletions() {
+	for _, info := range p.prospectors {
+		if info.completed {
+			p.registrarSpool.Add(registrar.NewCompletionEvent(info, info.completion))
+			info.completed = false
 		}
 	}
+}
 
-	return false
+// updateNotifier starts, stops or updates the watching of directories for
+// changes according to the configured prospect mode
+func (p *Prospector) updateNotifier() {
+	if p.genConfig.ProspectMode != prospectModeNotify {
+		if p.notifier != nil {
+			p.mutex.Lock()
+			p.notifier.Close()
+			p.notifier = nil
+			p.mutex.Unlock()
+		}
+		return
+	}
+
+	if p.notifier == nil {
+		if p.notifyErr != nil {
+			// We already fell back to scanning
+			return
+		}
+
+		notifier, err := newDirNotifier()
+		if err != nil {
+			p.fallbackToScan(err)
+			return
+		}
+
+		p.mutex.Lock()
+		p.notifier = notifier
+		p.mutex.Unlock()
+	}
+
+	dirs := make(map[string]struct{})
+	for _, config := range p.fileConfigs {
+		for _, path := range config.Paths {
+			notifyDirsForPattern(path, dirs)
+		}
+	}
+
+	if err := p.notifier.Watch(dirs); err != nil {
+		p.fallbackToScan(err)
+	}
 }
 
-// scan crawls a path for file movements
-func (p *Prospector) scan(path string, cfg *FileConfig) {
+// fallbackToScan stops watching for changes after a failure, so that changes
+// are only discovered by scanning every prospect interval
+func (p *Prospector) fallbackToScan(err error) {
+	log.Warningf("Change notifications are unavailable, falling back to scanning every %v: %s", p.genConfig.ProspectInterval, err)
+
+	p.mutex.Lock()
+	if p.notifier != nil {
+		p.notifier.Close()
+		p.notifier = nil
+	}
+	p.notifyErr = err
+	p.mutex.Unlock()
+}
+
+// scan crawls a path for file movements, adding to skipped any files that are
+// excluded by the configuration
+func (p *Prospector) scan(path string, cfg *FileConfig, skipped map[string]error) {
 	// Evaluate the path as a wildcards/shell glob
 	var matches []string
 	var err error
@@ -240,12 +514,18 @@ func (p *Prospector) scan(path string, cfg *FileConfig) {
 
 	// Check any matched files to see if we need to start a harvester
 	for _, file := range matches {
-		p.processFile(file, cfg)
+		if err := p.processFile(file, cfg); err != nil {
+			if _, ok := skipped[file]; !ok {
+				skipped[file] = err
+			}
+		}
 	}
 }
 
 // processFile works out if a single discovered file has moved or is new etc.
-func (p *Prospector) processFile(file string, cfg *FileConfig) {
+// If the file is excluded by the configuration it is not processed and the
+// reason is returned so that another file configuration can process it
+func (p *Prospector) processFile(file string, cfg *FileConfig) error {
 	defer func() {
 		p.mutex.Unlock()
 	}()
@@ -258,7 +538,11 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 	// Have we already processed this file in an earlier prospector declaration?
 	// We do not support merging as it requires a full rewrite of how we handle file status
 	if isKnown && info.seenInIteration(p.iteration) {
-		return
+		return nil
+	}
+
+	if pattern, excluded := cfg.excludedBy(file); excluded {
+		return newProspectorSkipError(fmt.Sprintf("Excluded by %s", pattern))
 	}
 
 	// Stat the file, following any symlinks
@@ -268,52 +552,37 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 	if err == nil {
 		if fileinfo.IsDir() {
 			err = newProspectorSkipError("Directory")
+		} else if cfg.IgnoreOlder != 0 && (!isKnown || info.status == statusInvalid) && time.Since(fileinfo.ModTime()) > cfg.IgnoreOlder {
+			// Only new files are ignored, so that files we are already
+			// harvesting or resuming are not interrupted
+			return newProspectorSkipError(fmt.Sprintf("Older than ignore older of %v", cfg.IgnoreOlder))
 		}
 	}
 
 	if err != nil {
-		// Do we know this entry?
-		if isKnown {
-			if info.status != statusInvalid {
-				// The current entry is not an error, orphan it so we can log one
-				info.maybeOrphaned()
-			} else if info.err.Error() == err.Error() {
-				// The same error occurred - don't log it again
-				info.update(nil, p.iteration)
-				return
-			}
-		}
-
-		// This is a new error
-		info = newProspectorInfoInvalid(file, err)
-		info.update(nil, p.iteration)
-
-		// Print a friendly log message
-		if _, ok := err.(*prospectorSkipError); ok {
-			log.Info("Skipping %s: %s", file, err)
-		} else {
-			log.Errorf("Error prospecting %s: %s", file, err)
-		}
-
-		p.prospectors[info] = info
-		p.prospectorindex[file] = info
-		return
+		p.flagInvalid(file, info, isKnown, err)
+		return nil
 	} else if isKnown && info.status == statusInvalid {
 		// We have an error stub and we've just successfully got fileinfo
-		// Mark isKnown so we treat as a new file still
+		// Mark isKnown so we treat as a new file still, and discard the stub
+		// so it does not remove the new entry from the index when cleaned up
 		isKnown = false
+		delete(p.prospectors, info)
 	}
 
+	// Data from the start of the file if files are identified by fingerprint
+	head := cfg.newFileHead(file)
+
 	// Conditions for starting a new harvester:
 	// - file path hasn't been seen before
-	// - the file's inode or device changed
+	// - the file's inode or device changed, or its fingerprint changed
 	if !isKnown {
 		// Is this a rename/move?
-		if previous, previousinfo := p.lookupFileIds(file, fileinfo); previous != "" {
+		if previous, previousinfo := p.lookupFileIds(file, fileinfo, head); previous != "" {
 			// Symlinks could mean we see the same file twice - skip if we have
 			if previousinfo == nil {
 				p.flagDuplicateError(file, info)
-				return
+				return nil
 			}
 
 			// This file was simply renamed (known inode+dev) - link the same harvester channel as the old file
@@ -328,7 +597,8 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 
 			// Check for dead time, but only if the file modification time is before the last scan started
 			// This ensures we don't skip genuine creations with dead times less than 10s
-			if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > cfg.DeadTime {
+			// In once mode all files are read regardless of their age
+			if !p.once && fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > cfg.DeadTime {
 				// Old file, skip it, but push offset of file size so we start from the end if this file changes and needs picking up
 				log.Info("Skipping file (older than dead time of %v): %s", cfg.DeadTime, file)
 
@@ -345,15 +615,23 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 		// Store the new entry
 		p.prospectors[info] = info
 	} else {
-		if !info.identity.SameAs(fileinfo) {
+		if !sameFile(info, fileinfo, head, 0) {
+			if head != nil && info.identity.SameAs(fileinfo) {
+				// The data at the start of the file was replaced, which happens
+				// when it is truncated after being copied, so stop the existing
+				// harvester as the data it was reading is gone
+				log.Info("File content was replaced: %s", file)
+				info.stop()
+			}
+
 			// Keep the old file in case we find it again shortly
 			info.maybeOrphaned()
 
-			if previous, previousinfo := p.lookupFileIds(file, fileinfo); previous != "" {
+			if previous, previousinfo := p.lookupFileIds(file, fileinfo, head); previous != "" {
 				// Symlinks could mean we see the same file twice - skip if we have
 				if previousinfo == nil {
 					p.flagDuplicateError(file, nil)
-					return
+					return nil
 				}
 
 				// This file was renamed from another file we know - link the same harvester channel as the old file
@@ -378,11 +656,16 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 		}
 	}
 
-	// Resume stopped harvesters
-	resume := !info.isRunning()
+	// Resume stopped harvesters that are not already waiting to start
+	resume := !info.isRunning() && !info.queued && !info.yielding
 	if resume {
 		if info.status == statusResume {
-			if info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > cfg.DeadTime {
+			if info.isComplete(fileinfo) {
+				// Compressed file that was already read to the end, skip it
+				log.Info("Skipping compressed file that was previously read to the end: %s", file)
+				info.status = statusOk
+				resume = false
+			} else if !cfg.StreamConfig.IsCompressed(file) && info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > cfg.DeadTime {
 				// Old file with an unchanged offset, skip it
 				log.Info("Skipping file (older than dead time of %v): %s", cfg.DeadTime, file)
 				info.status = statusOk
@@ -408,11 +691,88 @@ func (p *Prospector) processFile(file string, cfg *FileConfig) {
 
 	info.update(fileinfo, p.iteration)
 
+	if head != nil {
+		p.updateFingerprint(info, fileinfo, head)
+	}
+
 	if resume {
 		p.startHarvesterWithOffset(info, cfg, info.finishOffset)
 	}
 
 	p.prospectorindex[file] = info
+	return nil
+}
+
+// updateFingerprint calculates the fingerprint for a file if it does not have
+// one, or if the file has grown since it was calculated and it does not yet
+// cover the configured number of bytes. Previous states without a fingerprint
+// are migrated this way after being matched using the file identifiers
+func (p *Prospector) updateFingerprint(info *prospectorInfo, fileinfo os.FileInfo, head *fileHead) {
+	if info.fingerprint != nil && (info.fingerprint.Length >= head.length || fileinfo.Size() <= info.fingerprint.Length) {
+		return
+	}
+
+	data, err := head.Data()
+	if err != nil {
+		log.Warning("Failed to read fingerprint data for %s: %s", info.file, err)
+		return
+	}
+
+	fingerprint := registrar.NewFingerprint(data)
+	if fingerprint == nil {
+		// Empty files cannot be fingerprinted
+		return
+	}
+
+	info.fingerprint = fingerprint
+	p.registrarSpool.Add(registrar.NewFingerprintEvent(info, fingerprint))
+}
+
+// processSkipped records a file that was skipped due to the configuration,
+// unless another file configuration has since processed it
+func (p *Prospector) processSkipped(file string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	info, isKnown := p.prospectorindex[file]
+	if isKnown && info.seenInIteration(p.iteration) {
+		return
+	}
+
+	p.flagInvalid(file, info, isKnown, err)
+}
+
+// flagInvalid records an error for a file, only reporting it to the log if it
+// is not the same as the error that was already recorded
+func (p *Prospector) flagInvalid(file string, info *prospectorInfo, isKnown bool, err error) {
+	// Do we know this entry?
+	if isKnown {
+		if info.status != statusInvalid {
+			// The current entry is not an error, orphan it so we can log one
+			info.maybeOrphaned()
+		} else if info.err.Error() == err.Error() {
+			// The same error occurred - don't log it again
+			info.update(nil, p.iteration)
+			return
+		} else {
+			// Replace the previous error
+			delete(p.prospectors, info)
+		}
+	}
+
+	// This is a new error
+	info = newProspectorInfoInvalid(file, err)
+	info.update(nil, p.iteration)
+
+	// Print a friendly log message
+	if _, ok := err.(*prospectorSkipError); ok {
+		log.Info("Skipping %s: %s", file, err)
+	} else {
+		log.Errorf("Error prospecting %s: %s", file, err)
+	}
+
+	p.prospectors[info] = info
+	p.prospectorindex[file] = info
 }
 
 // flagDuplicateError notes a file as a duplicate of another file (symlink?)
@@ -438,7 +798,8 @@ func (p *Prospector) flagDuplicateError(file string, info *prospectorInfo) {
 func (p *Prospector) startHarvester(info *prospectorInfo, fileConfig *FileConfig) {
 	var offset int64
 
-	if p.fromBeginning {
+	if p.fromBeginning || fileConfig.StreamConfig.IsCompressed(info.file) {
+		// Compressed files do not grow so there is nothing to tail
 		offset = 0
 	} else {
 		offset = info.identity.Stat().Size()
@@ -451,19 +812,142 @@ func (p *Prospector) startHarvester(info *prospectorInfo, fileConfig *FileConfig
 }
 
 // startHarvesterWithOffset starts a new harvester against a file starting at
-// the given offset
+// the given offset, or adds it to the back of the queue to be started later if
+// the number of harvesters is limited
 func (p *Prospector) startHarvesterWithOffset(info *prospectorInfo, fileConfig *FileConfig, offset int64) {
+	if p.genConfig.MaxHarvesters == 0 && len(p.queue) == 0 {
+		p.launchHarvester(info, fileConfig, offset)
+		return
+	}
+
+	if info.queued {
+		return
+	}
+
+	info.queued = true
+	info.fileConfig = fileConfig
+	info.finishOffset = offset
+	p.queue = append(p.queue, info)
+}
+
+// scheduleHarvesters starts queued harvesters whilst fewer than max harvesters
+// are running. So that each file gets a fair share when there are more files
+// than can be harvested at once, harvesters that have been running for at
+// least the prospect interval are stopped and moved to the back of the queue
+// to give the files waiting in the queue a turn
+func (p *Prospector) scheduleHarvesters() {
+	// Harvesters that were paused go to the back of the queue once they stop
+	p.requeueYielded()
+
+	if len(p.queue) == 0 {
+		return
+	}
+
+	// Discard queued files that have since been deleted
+	queue := make([]*prospectorInfo, 0, len(p.queue))
+	for _, info := range p.queue {
+		if _, ok := p.prospectors[info]; !ok {
+			log.Warning("Queued file was deleted before it was harvested: %s", info.file)
+			info.queued = false
+			continue
+		}
+		queue = append(queue, info)
+	}
+	p.queue = queue
+
+	// Without a limit, such as after a reload, everything can start
+	start := len(p.queue)
+
+	if maxHarvesters := int(p.genConfig.MaxHarvesters); maxHarvesters != 0 {
+		running, yielding := 0, 0
+		var candidates []*prospectorInfo
+		for _, info := range p.prospectors {
+			if !info.isRunning() {
+				continue
+			}
+			running++
+			if info.yielding {
+				yielding++
+			} else if !info.stopping && time.Since(info.started) >= p.genConfig.ProspectInterval {
+				candidates = append(candidates, info)
+			}
+		}
+
+		// Stop the longest running harvesters until there is enough room for
+		// the files waiting in the queue, taking into account those that are
+		// already stopping to make room
+		yield := len(p.queue) - (maxHarvesters - running) - yielding
+		if yield > len(candidates) {
+			yield = len(candidates)
+		}
+		if yield > 0 {
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].started.Before(candidates[j].started)
+			})
+			candidates = candidates[:yield]
+
+			// They are requeued once they stop, so that we do not wait here
+			for _, info := range candidates {
+				log.Info("Pausing harvester to allow waiting files to be harvested: %s", info.file)
+				info.stop()
+				info.yielding = true
+			}
+		}
+
+		if start > maxHarvesters-running {
+			start = maxHarvesters - running
+		}
+	}
+
+	if start <= 0 {
+		return
+	}
+
+	starting := p.queue[:start]
+	p.queue = p.queue[start:]
+	for _, info := range starting {
+		info.queued = false
+		p.launchHarvester(info, info.fileConfig, info.finishOffset)
+	}
+}
+
+// requeueYielded moves harvesters that were paused to give waiting files a
+// turn to the back of the queue once they have stopped
+func (p *Prospector) requeueYielded() {
+	for _, info := range p.prospectors {
+		if !info.yielding || info.isRunning() {
+			continue
+		}
+		info.yielding = false
+		if info.status != statusFailed {
+			info.queued = true
+			p.queue = append(p.queue, info)
+		}
+	}
+}
+
+// launchHarvester creates and starts the harvester for a file
+func (p *Prospector) launchHarvester(info *prospectorInfo, fileConfig *FileConfig, offset int64) {
 	// TODO: Hook in a shutdown channel (via context?)
 	info.harvester = fileConfig.StreamConfig.NewHarvester(info.ctx, info.file, info.identity.Stat(), p.config, p.registrar, offset)
 	info.running = true
 	info.status = statusOk
+	info.fileConfig = fileConfig
+	info.started = time.Now()
 	info.harvester.SetOutput(p.output)
+	if p.once {
+		info.harvester.SetStopAtEOF()
+	}
+	if fileConfig.pathFields != nil {
+		info.harvester.SetPathFields(extractPathFields(fileConfig.pathFields, info.file))
+	}
 	info.harvester.Start()
 }
 
-// lookupFileIds checks a file's filesystem identifiers against all other known
-// files so we can handle file movements and renames
-func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *prospectorInfo) {
+// lookupFileIds checks a file's filesystem identifiers, or its fingerprint if
+// head is given, against all other known files so we can handle file movements
+// and renames
+func (p *Prospector) lookupFileIds(file string, info os.FileInfo, head *fileHead) (string, *prospectorInfo) {
 	for _, ki := range p.prospectors {
 		if ki.status == statusInvalid {
 			// Don't consider error placeholders
@@ -473,7 +957,13 @@ func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *pros
 			// We already know the prospector info for this file doesn't match, so don't check again
 			continue
 		}
-		if ki.identity.SameAs(info) {
+		// Only complete fingerprints are used, as files that have only just
+		// begun may not yet contain enough data to tell them apart
+		var minLength int64
+		if head != nil {
+			minLength = head.length
+		}
+		if sameFile(ki, info, head, minLength) {
 			// Already seen?
 			if ki.lastSeen == p.iteration {
 				return ki.file, nil
@@ -0,0 +1,591 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/fsnotify/fsnotify"
+
+	_ "github.com/driskell/log-courier/lc-lib/codecs/plain"
+	_ "github.com/driskell/log-courier/lc-lib/transports/test"
+)
+
+// createTestConfig loads a configuration containing the given general and
+// files sections, each given as YAML indented beneath the section
+func createTestConfig(t *testing.T, general string, files string) *config.Config {
+	dir := t.TempDir()
+	rawConfig := fmt.Sprintf("general:\n  persist directory: %q\n%sfiles:\n%snetwork:\n  transport: test\n  servers: [\"localhost:1\"]\n", dir, general, files)
+
+	path := filepath.Join(dir, "log-courier.yaml")
+	if err := os.WriteFile(path, []byte(rawConfig), 0600); err != nil {
+		t.Fatalf("Failed to write configuration: %s", err)
+	}
+
+	// Files configuration is validated once both it and includes have been
+	// validated, so reset that for each new configuration
+	validationReady = 0
+
+	cfg := config.NewConfig()
+	if err := cfg.Load(path, true); err != nil {
+		t.Fatalf("Failed to load configuration: %s", err)
+	}
+	return cfg
+}
+
+func createTestProspector(t *testing.T, cfg *config.Config, once bool) (*Prospector, chan []*event.Event) {
+	registrarImpl := registrar.NewRegistrar(cfg.General().PersistDir)
+	output := make(chan []*event.Event, 100)
+
+	p := &Prospector{
+		prospectorindex: make(map[string]*prospectorInfo),
+		prospectors:     make(map[*prospectorInfo]*prospectorInfo),
+		config:          cfg,
+		genConfig:       cfg.GeneralPart("prospector").(*General),
+		fileConfigs:     cfg.Section("files").(Config),
+		registrar:       registrarImpl,
+		registrarSpool:  registrar.NewEventSpooler(registrarImpl),
+		fromBeginning:   true,
+		once:            once,
+		output:          output,
+	}
+
+	t.Cleanup(func() {
+		for _, info := range p.prospectors {
+			info.stop()
+		}
+		for _, info := range p.prospectors {
+			info.wait()
+		}
+	})
+
+	return p, output
+}
+
+func createTestFile(t *testing.T, path string, lines int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	var data []byte
+	for i := 0; i < lines; i++ {
+		data = append(data, fmt.Sprintf("line %d\n", i)...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+}
+
+func TestProspectorBatch(t *testing.T) {
+	dir := t.TempDir()
+	createTestFile(t, filepath.Join(dir, "a.log"), 3)
+	createTestFile(t, filepath.Join(dir, "b.log"), 2)
+	createTestFile(t, filepath.Join(dir, "c.log"), 4)
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  exclude paths: [\"c.log\"]\n", filepath.Join(dir, "*.log")))
+	p, output := createTestProspector(t, cfg, true)
+
+	done := make(chan struct{})
+	go func() {
+		p.runBatch()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for batch to complete")
+	}
+
+	if len(output) != 5 {
+		t.Errorf("Unexpected event count, got: %d, expected: 5", len(output))
+	}
+
+	summary := p.BatchSummary()
+	if !summary.Completed {
+		t.Errorf("Batch was not completed")
+	}
+	if summary.Files != 2 || summary.Skipped != 1 || summary.Failed != 0 {
+		t.Errorf("Unexpected file counts, got: %d/%d/%d, expected: 2/1/0", summary.Files, summary.Skipped, summary.Failed)
+	}
+	if summary.Lines != 5 {
+		t.Errorf("Unexpected line count, got: %d, expected: 5", summary.Lines)
+	}
+}
+
+func TestProspectorBatchSummary(t *testing.T) {
+	p, _ := createTestProspector(t, createTestConfig(t, "", "- paths: [\"/nonexistent/*.log\"]\n"), true)
+
+	harvested := newProspectorInfoFromFileInfo("harvested.log", nil)
+	harvested.lineCount, harvested.byteCount = 10, 100
+	failed := newProspectorInfoFromFileInfo("failed.log", nil)
+	failed.status = statusFailed
+	failed.lineCount, failed.byteCount = 2, 20
+	skipped := newProspectorInfoInvalid("skipped.log", newProspectorSkipError("Directory"))
+	invalid := newProspectorInfoInvalid("invalid.log", os.ErrPermission)
+	for _, info := range []*prospectorInfo{harvested, failed, skipped, invalid} {
+		p.prospectors[info] = info
+	}
+
+	summary := p.BatchSummary()
+	if summary.Completed {
+		t.Errorf("Batch was completed before it was run")
+	}
+	if summary.Files != 1 || summary.Skipped != 1 || summary.Failed != 2 {
+		t.Errorf("Unexpected file counts, got: %d/%d/%d, expected: 1/1/2", summary.Files, summary.Skipped, summary.Failed)
+	}
+	if summary.Lines != 12 || summary.Bytes != 120 {
+		t.Errorf("Unexpected totals, got: %d/%d, expected: 12/120", summary.Lines, summary.Bytes)
+	}
+}
+
+func TestProspectorBatchShutdown(t *testing.T) {
+	dir := t.TempDir()
+	createTestFile(t, filepath.Join(dir, "a.log"), 10)
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  rate limit:\n    events: 1\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, true)
+
+	shutdownChan := make(chan struct{})
+	close(shutdownChan)
+	p.SetShutdownChan(shutdownChan)
+	p.runBatch()
+
+	if p.BatchSummary().Completed {
+		t.Errorf("Batch was completed despite shutdown")
+	}
+}
+
+func TestCompilePathFieldsPreset(t *testing.T) {
+	pattern, err := compilePathFields("kubernetes")
+	if err != nil {
+		t.Fatalf("Failed to compile preset: %s", err)
+	}
+
+	fields := extractPathFields(pattern, "/var/log/pods/default_web-1_0123/nginx/0.log")
+	expected := map[string]string{
+		"kubernetes_namespace":      "default",
+		"kubernetes_pod_name":       "web-1",
+		"kubernetes_pod_uid":        "0123",
+		"kubernetes_container_name": "nginx",
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("Unexpected fields, got: %v, expected: %v", fields, expected)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("Unexpected value for %s, got: %v, expected: %s", k, fields[k], v)
+		}
+	}
+}
+
+func TestCompilePathFieldsNoNamedCaptures(t *testing.T) {
+	if _, err := compilePathFields(`^/var/log/([^/]+)/`); err == nil {
+		t.Errorf("Pattern without named captures was accepted")
+	}
+}
+
+func TestCompilePathFieldsReserved(t *testing.T) {
+	if _, err := compilePathFields(`^/var/log/(?P<message>[^/]+)/`); err == nil {
+		t.Errorf("Pattern with a capture replacing a built-in field was accepted")
+	}
+}
+
+func TestExtractPathFieldsNoMatch(t *testing.T) {
+	pattern, err := compilePathFields(`^/var/log/(?P<service>[^/]+)/`)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %s", err)
+	}
+
+	if fields := extractPathFields(pattern, "/opt/app/app.log"); fields != nil {
+		t.Errorf("Unexpected fields for path that does not match: %v", fields)
+	}
+}
+
+func TestExtractPathFieldsEmptyCaptures(t *testing.T) {
+	pattern, err := compilePathFields(`^/var/log/(?P<service>[^/]+)/(?P<instance>[^/]*)/?`)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %s", err)
+	}
+
+	fields := extractPathFields(pattern, "/var/log/api//app.log")
+	if len(fields) != 1 || fields["service"] != "api" {
+		t.Errorf("Unexpected fields, got: %v, expected: map[service:api]", fields)
+	}
+}
+
+func createTestGzipFile(t *testing.T, path string, data string) os.FileInfo {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(data))
+	writer.Close()
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", path, err)
+	}
+	return fileinfo
+}
+
+func testProspectorCompressedResume(t *testing.T, offset int64) *prospectorInfo {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log.gz")
+	fileinfo := createTestGzipFile(t, path, "line 0\nline 1\n")
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  compression: auto\n", filepath.Join(dir, "*.gz")))
+	p, _ := createTestProspector(t, cfg, false)
+
+	state := &registrar.FileState{
+		Source:     &path,
+		Offset:     offset,
+		Completion: &registrar.Completion{Size: fileinfo.Size(), Offset: 14},
+	}
+	state.PopulateFileIds(fileinfo)
+	info := newProspectorInfoFromFileState(path, state)
+	p.prospectorindex[path] = info
+	p.prospectors[info] = info
+
+	p.iteration++
+	if err := p.processFile(path, p.fileConfigs[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return info
+}
+
+func TestProspectorCompressedCompleted(t *testing.T) {
+	info := testProspectorCompressedResume(t, 14)
+	if info.running {
+		t.Errorf("Harvester was resumed on a completed compressed file")
+	}
+}
+
+func TestProspectorCompressedIncomplete(t *testing.T) {
+	// Not all events were acknowledged before the restart
+	info := testProspectorCompressedResume(t, 7)
+	if !info.running {
+		t.Errorf("Harvester was not resumed on an incomplete compressed file")
+	}
+}
+
+func TestProspectorYieldHarvester(t *testing.T) {
+	dir := t.TempDir()
+	createTestFile(t, filepath.Join(dir, "a.log"), 1)
+	createTestFile(t, filepath.Join(dir, "b.log"), 1)
+
+	cfg := createTestConfig(t, "  max harvesters: 1\n", fmt.Sprintf("- paths: [%q]\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+
+	p.iteration++
+	for _, name := range []string{"a.log", "b.log"} {
+		if err := p.processFile(filepath.Join(dir, name), p.fileConfigs[0]); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	p.scheduleHarvesters()
+	first, second := p.prospectorindex[filepath.Join(dir, "a.log")], p.prospectorindex[filepath.Join(dir, "b.log")]
+	if !first.running {
+		first, second = second, first
+	}
+	if !first.running || second.running || len(p.queue) != 1 {
+		t.Fatalf("Unexpected harvesters running after first schedule")
+	}
+
+	// Pausing the first harvester should not wait for it to stop
+	first.started = time.Now().Add(-time.Hour)
+	p.scheduleHarvesters()
+	if !first.yielding || second.running {
+		t.Fatalf("Harvester was not paused or the second was started before it stopped")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !second.running {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for the second harvester to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+		p.scheduleHarvesters()
+	}
+
+	if first.yielding || !first.queued || len(p.queue) != 1 || p.queue[0] != first {
+		t.Errorf("Paused harvester was not moved to the back of the queue")
+	}
+}
+
+func TestNotifyDirsForPattern(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"a/logs", "b/logs", "b/other"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+	}
+	createTestFile(t, filepath.Join(dir, "a", "logs", "app.log"), 1)
+
+	dirs := make(map[string]struct{})
+	notifyDirsForPattern(filepath.Join(dir, "*", "logs", "*.log"), dirs)
+
+	// The directory containing the first wildcard and those beneath it that
+	// could contain matching files, but no others
+	expected := []string{dir, filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "a", "logs"), filepath.Join(dir, "b", "logs")}
+	if len(dirs) != len(expected) {
+		t.Errorf("Unexpected directories, got: %v, expected: %v", dirs, expected)
+	}
+	for _, expectedDir := range expected {
+		if _, ok := dirs[expectedDir]; !ok {
+			t.Errorf("Missing directory: %s", expectedDir)
+		}
+	}
+}
+
+func TestNotifyDirsForPatternMissing(t *testing.T) {
+	dirs := make(map[string]struct{})
+	notifyDirsForPattern(filepath.Join(t.TempDir(), "missing", "*.log"), dirs)
+	if len(dirs) != 0 {
+		t.Errorf("Unexpected directories for missing directory: %v", dirs)
+	}
+}
+
+func TestProspectorNotifyFallback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := createTestConfig(t, "  prospect mode: notify\n", fmt.Sprintf("- paths: [%q]\n", filepath.Join(dir, "*", "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+
+	status := &apiStatus{p: p}
+	checkMode := func(expected string) {
+		if err := status.Update(); err != nil {
+			t.Fatalf("Failed to update status: %s", err)
+		}
+		mode, _ := status.Get("mode")
+		if mode != api.String(expected) {
+			t.Errorf("Unexpected mode, got: %v, expected: %s", mode, expected)
+		}
+	}
+
+	p.updateNotifier()
+	if p.notifier == nil {
+		t.Fatalf("Notifications were not started: %s", p.notifyErr)
+	}
+	checkMode(prospectModeNotify)
+
+	// A failure to add a watch, such as when the limit is reached, should fall
+	// back to scanning and report why
+	p.notifier.watcher.Close()
+	if err := os.Mkdir(filepath.Join(dir, "new"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	p.updateNotifier()
+	if p.notifier != nil || p.notifyErr == nil {
+		t.Fatalf("Failed watch did not fall back to scanning")
+	}
+	checkMode(prospectModeScan)
+	if notifyError, _ := status.Get("notifyError"); notifyError == api.Null {
+		t.Errorf("Notify error was not reported")
+	}
+
+	// Notifications are not attempted again until the configuration is reloaded
+	p.updateNotifier()
+	if p.notifier != nil {
+		t.Errorf("Notifications were restarted after falling back to scanning")
+	}
+}
+
+func TestProspectorNotifyResumeEvent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	createTestFile(t, path, 1)
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+
+	info := newProspectorInfoFromFileInfo(path, nil)
+	p.prospectorindex[path] = info
+	p.prospectors[info] = info
+
+	if !p.isNotifyResumeEvent(fsnotify.Event{Name: path, Op: fsnotify.Write}) {
+		t.Errorf("Write to a stopped file did not require a scan")
+	}
+	if p.isNotifyResumeEvent(fsnotify.Event{Name: filepath.Join(dir, "other.log"), Op: fsnotify.Write}) {
+		t.Errorf("Write to an unknown file required a scan")
+	}
+	if p.isNotifyResumeEvent(fsnotify.Event{Name: path, Op: fsnotify.Chmod}) {
+		t.Errorf("Change of permissions required a scan")
+	}
+}
+
+func createFingerprintTestProspector(t *testing.T, dir string) *Prospector {
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  identity: fingerprint\n  fingerprint bytes: 16\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+	return p
+}
+
+func TestProspectorFingerprintRename(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	createTestFile(t, oldPath, 5)
+
+	p := createFingerprintTestProspector(t, dir)
+	p.iteration++
+	if err := p.processFile(oldPath, p.fileConfigs[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	info := p.prospectorindex[oldPath]
+	if info.fingerprint == nil {
+		t.Fatalf("Fingerprint was not calculated")
+	}
+
+	// Copying to a new file gives it new file identifiers, such as on a
+	// network filesystem, but the fingerprint stays the same
+	createTestFile(t, newPath, 5)
+	if err := os.Remove(oldPath); err != nil {
+		t.Fatalf("Failed to remove %s: %s", oldPath, err)
+	}
+
+	p.iteration++
+	if err := p.processFile(newPath, p.fileConfigs[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if p.prospectorindex[newPath] != info || info.file != newPath {
+		t.Errorf("Rename was not detected using the fingerprint")
+	}
+}
+
+func TestProspectorFingerprintContentReplaced(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	createTestFile(t, path, 5)
+
+	p := createFingerprintTestProspector(t, dir)
+	p.iteration++
+	if err := p.processFile(path, p.fileConfigs[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	info := p.prospectorindex[path]
+
+	// Truncating after copying, then writing new data, keeps the same inode
+	if err := os.WriteFile(path, []byte("replaced line 0\nreplaced line 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+
+	p.iteration++
+	if err := p.processFile(path, p.fileConfigs[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !info.stopping {
+		t.Errorf("Harvester for the replaced content was not stopped")
+	}
+	newInfo := p.prospectorindex[path]
+	if newInfo == info || !newInfo.running {
+		t.Errorf("Harvester was not started for the new content")
+	}
+}
+
+func TestProspectorExcludePaths(t *testing.T) {
+	dir := t.TempDir()
+	included, excluded := filepath.Join(dir, "app.log"), filepath.Join(dir, "debug", "app.log")
+	createTestFile(t, included, 1)
+	createTestFile(t, excluded, 1)
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  exclude paths: [%q]\n", filepath.Join(dir, "**", "*.log"), filepath.Join(dir, "debug", "*")))
+	p, _ := createTestProspector(t, cfg, false)
+	p.scanAll()
+
+	if info := p.prospectorindex[included]; info == nil || !info.running {
+		t.Errorf("Included file was not harvested")
+	}
+	info := p.prospectorindex[excluded]
+	if info == nil || info.running || info.status != statusInvalid {
+		t.Fatalf("Excluded file was harvested or not recorded as skipped")
+	}
+	if _, ok := info.err.(*prospectorSkipError); !ok {
+		t.Errorf("Unexpected error for excluded file: %v", info.err)
+	}
+}
+
+func TestProspectorIgnoreOlder(t *testing.T) {
+	dir := t.TempDir()
+	recent, old := filepath.Join(dir, "recent.log"), filepath.Join(dir, "old.log")
+	createTestFile(t, recent, 1)
+	createTestFile(t, old, 1)
+	oldTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(old, oldTime, oldTime); err != nil {
+		t.Fatalf("Failed to change times: %s", err)
+	}
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  ignore older: 1h\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+	p.scanAll()
+
+	if info := p.prospectorindex[recent]; info == nil || !info.running {
+		t.Errorf("Recent file was not harvested")
+	}
+	info := p.prospectorindex[old]
+	if info == nil || info.running || info.status != statusInvalid {
+		t.Fatalf("Old file was harvested or not recorded as skipped")
+	}
+
+	// Once modified it is harvested as a new file
+	if err := os.Chtimes(old, time.Now(), time.Now()); err != nil {
+		t.Fatalf("Failed to change times: %s", err)
+	}
+	p.scanAll()
+	if info := p.prospectorindex[old]; info == nil || !info.running {
+		t.Errorf("Old file was not harvested after it was modified")
+	}
+}
+
+func TestProspectorIgnoreOlderTracked(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.log")
+	createTestFile(t, path, 2)
+	oldTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, oldTime, oldTime); err != nil {
+		t.Fatalf("Failed to change times: %s", err)
+	}
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", path, err)
+	}
+
+	cfg := createTestConfig(t, "", fmt.Sprintf("- paths: [%q]\n  ignore older: 1h\n  dead time: 3h\n", filepath.Join(dir, "*.log")))
+	p, _ := createTestProspector(t, cfg, false)
+
+	// A file that was being harvested in a previous run is resumed even though
+	// it is older than ignore older
+	state := &registrar.FileState{Source: &path, Offset: 7}
+	state.PopulateFileIds(fileinfo)
+	info := newProspectorInfoFromFileState(path, state)
+	p.prospectorindex[path] = info
+	p.prospectors[info] = info
+
+	p.scanAll()
+	if p.prospectorindex[path] != info || !info.running {
+		t.Errorf("Tracked file older than ignore older was not resumed")
+	}
+}
@@ -23,17 +23,44 @@ import (
 type apiStatus struct {
 	api.KeyValue
 
-	p *Publisher
+	o *output
 }
 
-// Update updates the publisher status information
+// Update updates the output status information
 func (a *apiStatus) Update() error {
 	// Update the values and pass through to node
+	a.o.mutex.RLock()
+	a.SetEntry("speed", api.Float(a.o.lineSpeed))
+	a.SetEntry("publishedLines", api.Number(a.o.lastLineCount))
+	a.SetEntry("pendingPayloads", api.Number(a.o.numPayloads))
+	a.SetEntry("maxPendingPayloads", api.Number(a.o.netConfig.MaxPendingPayloads))
+	a.o.mutex.RUnlock()
+
+	return nil
+}
+
+type apiPublisherStatus struct {
+	api.KeyValue
+
+	p *Publisher
+}
+
+// Update updates the publisher status information, which totals the status of
+// all outputs
+func (a *apiPublisherStatus) Update() error {
+	var pendingPayloads, maxPendingPayloads int64
 	a.p.mutex.RLock()
+	for _, output := range a.p.outputs {
+		output.mutex.RLock()
+		pendingPayloads += output.numPayloads
+		maxPendingPayloads += output.netConfig.MaxPendingPayloads
+		output.mutex.RUnlock()
+	}
 	a.SetEntry("speed", api.Float(a.p.lineSpeed))
 	a.SetEntry("publishedLines", api.Number(a.p.lastLineCount))
-	a.SetEntry("pendingPayloads", api.Number(a.p.numPayloads))
-	a.SetEntry("maxPendingPayloads", api.Number(a.p.netConfig.MaxPendingPayloads))
+	a.SetEntry("pendingPayloads", api.Number(pendingPayloads))
+	a.SetEntry("maxPendingPayloads", api.Number(maxPendingPayloads))
+	a.SetEntry("outputs", api.Number(len(a.p.outputs)))
 	a.p.mutex.RUnlock()
 
 	return nil
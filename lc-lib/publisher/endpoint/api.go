@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/transports"
 )
 
 type apiEndpoint struct {
@@ -43,6 +44,9 @@ func (a *apiEndpoint) Update() error {
 	a.SetEntry("pendingPayloads", api.Number(a.e.NumPending()))
 	a.SetEntry("publishedLines", api.Number(a.e.LineCount()))
 	a.SetEntry("averageLatency", api.Float(a.e.AverageLatency()/time.Millisecond))
+	if counter, ok := a.e.transport.(transports.DropCounter); ok {
+		a.SetEntry("droppedEvents", api.Number(counter.DroppedEvents()))
+	}
 	a.e.mutex.RUnlock()
 
 	return nil
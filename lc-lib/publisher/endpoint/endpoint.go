@@ -76,7 +76,7 @@ type Endpoint struct {
 // Init prepares the internal Element structures for InternalList and prepares
 // the pending payload structures
 func (e *Endpoint) Init() {
-	e.ctx = context.WithValue(context.Background(), ContextSelf, e)
+	e.ctx = context.WithValue(context.WithValue(context.Background(), transports.ContextConfig, e.sink.config), ContextSelf, e)
 
 	e.warming = true
 	backoffName := fmt.Sprintf("[E %s] Recovery", e.poolEntry.Desc)
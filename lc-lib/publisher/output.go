@@ -0,0 +1,542 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * This file is a modification of code from Logstash Forwarder.
+ * Copyright 2012-2013 Jordan Sissel and contributors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/internallist"
+	"github.com/driskell/log-courier/lc-lib/publisher/endpoint"
+	"github.com/driskell/log-courier/lc-lib/publisher/payload"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+var (
+	errNetworkTimeout = errors.New("server did not respond within network timeout")
+	errNetworkPing    = errors.New("server did not respond to keepalive")
+)
+
+const (
+	// TODO(driskell): Make the idle timeout configurable like the network timeout is?
+	keepaliveTimeout time.Duration = 10 * time.Second
+)
+
+// output handles payloads for a single named output and is responsible for
+// passing ordered acknowledgements to the acknowledgement handlers
+// It makes all the load balancing and distribution decisions, leaving
+// transport state management to the EndpointSink
+// We have always used a Push mechanism for load balancing, in the sense that
+// the output will push out events to transports and potentially pull them
+// back if it deems there's a problem, rather than letting transports pull the
+// events from the output and then the transport making decisions on whether
+// there is a problem. This pattern continues that tradition but with there now
+// potentially being multiple transports rather than just one
+type output struct {
+	mutex sync.RWMutex
+
+	name         string
+	entry        *transports.OutputConfigEntry
+	netConfig    *transports.Config
+	endpointSink *endpoint.Sink
+	method       method
+	api          *api.Node
+
+	payloadList  internallist.List
+	numPayloads  int64
+	outOfSync    int
+	spoolChan    chan []*event.Event
+	shuttingDown bool
+
+	lineCount       int64
+	lineSpeed       float64
+	lastLineCount   int64
+	lastMeasurement time.Time
+	secondsNoAck    int
+
+	measurementTimer *time.Timer
+	ifSpoolChan      <-chan []*event.Event
+	configChan       chan *transports.Config
+	doneChan         chan struct{}
+	nextSpool        []*event.Event
+	resendList       internallist.List
+}
+
+// newOutput creates a new output from the given configuration
+// The entry is only ever accessed by the Publisher for routing, and the output
+// itself uses only the network configuration it is given
+func newOutput(entry *transports.OutputConfigEntry) *output {
+	ret := &output{
+		name:         entry.Name,
+		entry:        entry,
+		netConfig:    entry.Config,
+		endpointSink: endpoint.NewSink(entry.Config),
+		spoolChan:    make(chan []*event.Event, 1),
+		configChan:   make(chan *transports.Config, 1),
+		doneChan:     make(chan struct{}),
+	}
+
+	ret.endpointSink.OnAck = ret.OnAck
+	ret.endpointSink.OnStarted = ret.OnStarted
+	ret.endpointSink.OnFinish = ret.OnFinish
+	ret.endpointSink.OnFail = ret.OnFail
+	ret.endpointSink.OnPong = ret.OnPong
+
+	ret.initAPI()
+	ret.initMethod()
+
+	return ret
+}
+
+// initMethod initialises the method the output uses to manage multiple
+// endpoints
+func (o *output) initMethod() {
+	// Destroy any previous method
+	if o.method != nil {
+		o.method.destroy()
+	}
+
+	// TODO: Factory registration for methods
+	switch o.netConfig.Method {
+	case "random":
+		o.method = newMethodRandom(o.endpointSink, o.netConfig)
+		return
+	case "failover":
+		o.method = newMethodFailover(o.endpointSink, o.netConfig)
+		return
+	case "loadbalance":
+		o.method = newMethodLoadbalance(o.endpointSink, o.netConfig)
+		return
+	}
+
+	panic(fmt.Sprintf("Internal error: Unknown publishing method: %s", o.netConfig.Method))
+}
+
+// run starts the output, it handles endpoint status changes send from the
+// EndpointSink so it can make payload distribution decisions
+// The output shuts down once its spool channel is closed and all pending
+// payloads are acknowledged
+func (o *output) run() {
+	o.measurementTimer = time.NewTimer(time.Second)
+	o.ifSpoolChan = o.spoolChan
+
+	for {
+		if o.runOnce() {
+			break
+		}
+	}
+
+	log.Infof("[O %s] Output exiting", o.name)
+	o.method.destroy()
+	close(o.doneChan)
+}
+
+// runOnce runs a single iteration of the output loop
+// Called continuously by run until shutdown is completed, at which point the
+// return value changed from false to true to signal completion
+func (o *output) runOnce() bool {
+	select {
+	case event := <-o.endpointSink.EventChan():
+		// Endpoint Sink processes the events, and feeds back relevant changes
+		if endpoint, err := o.endpointSink.ProcessEvent(event); err != nil {
+			o.forceEndpointFailure(endpoint, err)
+		}
+
+		// If all finished, we're done
+		if o.shuttingDown && o.endpointSink.Count() == 0 {
+			// TODO: What about out of sync ACK?
+			return true
+		}
+	case <-o.endpointSink.Scheduler.OnNext():
+		// Process triggered timeouts
+		for o.endpointSink.Scheduler.Next() != nil {
+			panic("Unexpected non-callback item returned from Endpoint Scheduler")
+		}
+		o.endpointSink.Scheduler.Reschedule()
+	case spool := <-o.ifSpoolChan:
+		// When input closes, the output is being shutdown or removed
+		if spool == nil {
+			o.ifSpoolChan = nil
+			o.nextSpool = nil
+			o.shuttingDown = true
+
+			// If no payloads held, nothing to wait for
+			if !o.eventsHeld() && o.numPayloads == 0 {
+				// If no endpoints, no shutdown necessary
+				if o.endpointSink.Count() == 0 {
+					return true
+				}
+				o.endpointSink.Shutdown()
+			} else {
+				log.Infof("[O %s] Output will prevent shutdown until outsanding payloads have been acknowledged or fail", o.name)
+			}
+			break
+		}
+
+		if o.numPayloads >= o.netConfig.MaxPendingPayloads {
+			log.Debug("Maximum pending payloads of %d reached, holding %d new events", o.netConfig.MaxPendingPayloads, len(spool))
+		} else if o.resendList.Len() != 0 {
+			log.Debug("Holding %d new events until the resend queue is flushed", len(spool))
+		} else if o.endpointSink.CanQueue() {
+			if _, ok := o.sendEvents(spool); ok {
+				break
+			}
+
+			log.Debug("Holding %d new events until an endpoint is ready", len(spool))
+		}
+
+		// No ready endpoint, wait for one
+		o.nextSpool = spool
+		o.ifSpoolChan = nil
+	case <-o.measurementTimer.C:
+		o.takeMeasurements()
+		o.measurementTimer.Reset(time.Second)
+	case netConfig := <-o.configChan:
+		o.reloadConfig(netConfig)
+	}
+
+	return false
+}
+
+// updateConfig passes new network configuration to the output routine
+// It never blocks, replacing any configuration the routine has yet to receive,
+// so that it is safe to call even after the routine has finished
+func (o *output) updateConfig(netConfig *transports.Config) {
+	for {
+		select {
+		case o.configChan <- netConfig:
+			return
+		default:
+		}
+
+		// Discard the pending configuration as it is now outdated
+		select {
+		case <-o.configChan:
+		default:
+		}
+	}
+}
+
+// reloadConfig applies new network configuration to the output
+func (o *output) reloadConfig(netConfig *transports.Config) {
+	oldMethod := o.netConfig.Method
+	o.mutex.Lock()
+	o.netConfig = netConfig
+	o.mutex.Unlock()
+
+	// Give sink the new config
+	o.endpointSink.ReloadConfig(o.netConfig)
+
+	// Has method changed? Init the new method and discard the old one...
+	if o.netConfig.Method != oldMethod {
+		o.initMethod()
+	} else {
+		// ...otherwise give the existing method the new configuraton
+		o.method.reloadConfig(o.netConfig)
+	}
+
+	// The sink may have changed the priority endpoint after the reload, making
+	// an endpoint available
+	o.tryQueueHeld()
+}
+
+// OnStarted handles an endpoint that has moved from idle to now active
+func (o *output) OnStarted(endpoint *endpoint.Endpoint) {
+	o.method.onStarted(endpoint)
+
+	if endpoint.NumPending() != 0 {
+		return
+	}
+
+	if o.tryQueueHeld() {
+		return
+	}
+
+	log.Debugf("[P %s] Starting keepalive timeout", endpoint.Server())
+	o.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
+		o.timeoutKeepalive(endpoint)
+	})
+}
+
+// OnFinish handles when endpoints are finished
+// Should return false if the endpoint is not to be reinitialised, such as when
+// shutting down
+func (o *output) OnFinish(endpoint *endpoint.Endpoint) bool {
+	// Don't recreate anything if shutting down
+	if o.shuttingDown {
+		return false
+	}
+
+	if endpoint.NumPending() != 0 {
+		o.pullBackPending(endpoint)
+	}
+
+	// Method defines how we handle finished endpoints
+	return o.method.onFinish(endpoint)
+}
+
+// OnFail handles a failed endpoint
+func (o *output) OnFail(endpoint *endpoint.Endpoint) {
+	if endpoint.NumPending() != 0 {
+		o.pullBackPending(endpoint)
+	}
+
+	// Allow method to handle what we do due to the failed endpoint
+	o.method.onFail(endpoint)
+}
+
+// pullBackPending returns undelivered payloads from the endpoint back to the
+// output for redelivery
+func (o *output) pullBackPending(endpoint *endpoint.Endpoint) {
+	// Pull back pending payloads so we can requeue them onto other endpoints
+	payloads := endpoint.PullBackPending()
+	for _, pendingPayload := range payloads {
+		pendingPayload.Resending = true
+		pendingPayload.ResetSequence()
+		o.resendList.PushBack(&pendingPayload.ResendElement)
+	}
+
+	// If any ready now, requeue immediately
+	o.tryQueueHeld()
+
+	log.Debugf("[P %s] %d payloads pulled back for resend (%d total now held for resend)", endpoint.Server(), len(payloads), o.resendList.Len())
+}
+
+// OnAck handles acknowledgements from endpoints
+// It keeps track of how many out of sync acknowldgements have been made so
+// shutdown can be postponed if we've received Acks for newer events before
+// older events. It also serialises the Ack offsets for correct handling
+// so events are always acknowledged sequentially
+// TODO: Use event.Sequencer to simplify this?
+func (o *output) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.Payload, firstAck bool, lineCount int) {
+	// Expect next ACK within network timeout if we still have pending
+	if endpoint.NumPending() > 0 {
+		o.endpointSink.Scheduler.SetCallback(endpoint, o.netConfig.Timeout, func() {
+			o.timeoutPending(endpoint)
+		})
+	} else {
+		o.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
+			o.timeoutKeepalive(endpoint)
+		})
+	}
+
+	complete := pendingPayload.Complete()
+
+	// If we're on the resend queue and just completed, remove it
+	// Handle the condition occurring where the endpoint incorrectly reports a
+	// failure but then afterwards reports an acknowledgement, which means we're
+	// acknowledging a payload still on the resendList
+	if pendingPayload.Resending && complete {
+		pendingPayload.Resending = false
+		o.resendList.Remove(&pendingPayload.ResendElement)
+	}
+
+	numComplete := int64(0)
+
+	// We potentially receive out-of-order ACKs due to payloads distributed across servers
+	// This is where we enforce ordering again to ensure the handlers receive ACKs in order
+	if pendingPayload == o.payloadList.Front().Value.(*payload.Payload) {
+		// The out of sync count we have will never include the first payload, so
+		// take the value +1
+		outOfSync := o.outOfSync + 1
+
+		// For each full payload we mark off, we decrease this count, the first we
+		// mark off will always be the first payload - thus the +1. Subsequent
+		// payloads are the out of sync ones - so if we mark them off we decrease
+		// the out of sync count
+		for pendingPayload.HasAck() {
+			event.DispatchAck(pendingPayload.Rollup())
+
+			if !pendingPayload.Complete() {
+				break
+			}
+
+			o.payloadList.Remove(&pendingPayload.Element)
+			outOfSync--
+			o.outOfSync = outOfSync
+
+			numComplete++
+
+			if o.payloadList.Len() == 0 {
+				break
+			}
+
+			pendingPayload = o.payloadList.Front().Value.(*payload.Payload)
+		}
+	} else if firstAck {
+		// If this is NOT the first payload, and this is the first acknowledgement
+		// for this payload, then increase out of sync payload count
+		o.outOfSync++
+	}
+
+	o.mutex.Lock()
+	if numComplete != 0 {
+		o.numPayloads -= numComplete
+	}
+	o.lineCount += int64(lineCount)
+	o.mutex.Unlock()
+
+	if complete {
+		// Resume sending if we stopped due to excessive pending payload count
+		o.tryQueueHeld()
+
+		// If last payload confirmed, begin shutdown
+		if o.shuttingDown && !o.eventsHeld() && o.numPayloads == 0 {
+			o.endpointSink.Shutdown()
+		}
+	}
+}
+
+// OnPong handles when endpoints receive a pong message
+func (o *output) OnPong(endpoint *endpoint.Endpoint) {
+	// If we haven't started sending anything, return to keepalive timeout
+	if endpoint.NumPending() == 0 {
+		log.Debugf("[P %s] Resetting keepalive timeout", endpoint.Server())
+		o.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
+			o.timeoutKeepalive(endpoint)
+		})
+	}
+}
+
+// forceEndpointFailure is called to force an endpoint to enter
+// the failed status. It reports the error and then processes the failure.
+func (o *output) forceEndpointFailure(endpoint *endpoint.Endpoint, err error) {
+	log.Warningf("[P %s] Forcing endpoint failure: %s", endpoint.Server(), err)
+	o.endpointSink.ForceFailure(endpoint, err)
+}
+
+// eventsHeld returns true if there are events held waiting to be queued
+func (o *output) eventsHeld() bool {
+	return o.resendList.Len() > 0 || o.nextSpool != nil
+}
+
+// tryQueueHeld attempts to queue held payloads
+func (o *output) tryQueueHeld() bool {
+	if o.resendList.Len() > 0 {
+		didSend := false
+
+		for o.resendList.Len() > 0 && o.endpointSink.CanQueue() {
+			pendingPayload := o.resendList.Front().Value.(*payload.Payload)
+
+			// We have a payload to resend, send it now
+			if _, ok := o.sendPayload(pendingPayload); ok {
+				pendingPayload.Resending = false
+				pendingPayload.ResetSequence()
+				o.resendList.Remove(&pendingPayload.ResendElement)
+				log.Debugf("%d payloads remain held for resend", o.resendList.Len())
+				didSend = true
+			}
+		}
+
+		return didSend
+	}
+
+	// Only take from nextSpool if we have space below the limit
+	if o.numPayloads < o.netConfig.MaxPendingPayloads && o.nextSpool != nil {
+		// We have events, send it to the endpoint and wait for more
+		if _, ok := o.sendEvents(o.nextSpool); ok {
+			o.nextSpool = nil
+			o.ifSpoolChan = o.spoolChan
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *output) sendEvents(events []*event.Event) (*endpoint.Endpoint, bool) {
+	pendingPayload := payload.NewPayload(events)
+
+	o.payloadList.PushBack(&pendingPayload.Element)
+
+	o.mutex.Lock()
+	o.numPayloads++
+	o.mutex.Unlock()
+
+	return o.sendPayload(pendingPayload)
+}
+
+func (o *output) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endpoint, bool) {
+	// Attempt to queue the payload with the best endpoint
+	endpoint, err := o.endpointSink.QueuePayload(pendingPayload)
+	if err != nil {
+		if err == transports.ErrCongestion {
+			// No need to force failure - just wait for another endpoint
+			return nil, false
+		}
+		o.forceEndpointFailure(endpoint, err)
+		return nil, false
+	}
+	if endpoint == nil {
+		// No endpoints available
+		return nil, false
+	}
+
+	// If this is the first payload, start the network timeout
+	if endpoint.NumPending() == 1 {
+		log.Debugf("[P %s] Starting timout timer", endpoint.Server())
+		o.endpointSink.Scheduler.SetCallback(endpoint, o.netConfig.Timeout, func() {
+			o.timeoutPending(endpoint)
+		})
+	}
+
+	return endpoint, true
+}
+
+func (o *output) timeoutPending(endpoint *endpoint.Endpoint) {
+	// Trigger a failure
+	if endpoint.IsPinging() {
+		o.forceEndpointFailure(endpoint, errNetworkPing)
+	} else {
+		o.forceEndpointFailure(endpoint, errNetworkTimeout)
+	}
+}
+
+func (o *output) timeoutKeepalive(endpoint *endpoint.Endpoint) {
+	// Timeout for PING
+	log.Debugf("[P %s] Sending ping and starting pending timeout", endpoint.Server())
+	o.endpointSink.Scheduler.SetCallback(endpoint, o.netConfig.Timeout, func() {
+		o.timeoutPending(endpoint)
+	})
+
+	if err := endpoint.SendPing(); err != nil {
+		o.forceEndpointFailure(endpoint, err)
+	}
+}
+
+func (o *output) takeMeasurements() {
+	o.mutex.Lock()
+	o.lineSpeed = core.CalculateSpeed(time.Since(o.lastMeasurement), o.lineSpeed, float64(o.lineCount-o.lastLineCount), &o.secondsNoAck)
+	o.lastLineCount = o.lineCount
+	o.lastMeasurement = time.Now()
+	o.mutex.Unlock()
+}
+
+// initAPI initialises the output API entries
+func (o *output) initAPI() {
+	o.api = &api.Node{}
+	o.api.SetEntry("endpoints", o.endpointSink.APINavigatable())
+	o.api.SetEntry("status", &apiStatus{o: o})
+}
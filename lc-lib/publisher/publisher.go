@@ -20,8 +20,6 @@
 package publisher
 
 import (
-	"errors"
-	"fmt"
 	"sync"
 	"time"
 
@@ -31,44 +29,27 @@ import (
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/event"
 	"github.com/driskell/log-courier/lc-lib/internallist"
-	"github.com/driskell/log-courier/lc-lib/publisher/endpoint"
-	"github.com/driskell/log-courier/lc-lib/publisher/payload"
 	"github.com/driskell/log-courier/lc-lib/transports"
 )
 
-var (
-	errNetworkTimeout = errors.New("server did not respond within network timeout")
-	errNetworkPing    = errors.New("server did not respond to keepalive")
-)
-
-const (
-	// TODO(driskell): Make the idle timeout configurable like the network timeout is?
-	keepaliveTimeout time.Duration = 10 * time.Second
-)
-
-// Publisher handles payloads and is responsible for passing ordered
-// acknowledgements to the acknowledgement handlers
-// It makes all the load balancing and distribution decisions, leaving
-// transport state management to the EndpointSink
-// We have always used a Push mechanism for load balancing, in the sense that
-// the Publisher will push out events to transports and potentially pull them
-// back if it deems there's a problem, rather than letting transports pull the
-// events from the Publisher and then the transport making decisions on whether
-// there is a problem. This pattern continues that tradition but with there now
-// potentially being multiple transports rather than just one
+// Publisher receives spools of events and routes them to one or more outputs
+// Each output manages its own endpoints and payloads, and the Publisher
+// ensures that each event is only acknowledged once every output it was
+// routed to has acknowledged it, and that acknowledgements are passed to the
+// acknowledgement handlers in order
 type Publisher struct {
 	mutex sync.RWMutex
 
-	netConfig    *transports.Config
-	adminConfig  *admin.Config
-	endpointSink *endpoint.Sink
-	method       method
+	adminConfig *admin.Config
+	outputs     []*output
+	retiring    []*output
+	outputsWait sync.WaitGroup
+	api         *api.Node
+	outputsAPI  *api.Node
 
-	payloadList  internallist.List
-	numPayloads  int64
-	outOfSync    int
-	spoolChan    chan []*event.Event
-	shuttingDown bool
+	spoolList  internallist.List
+	spoolChan  chan []*event.Event
+	configChan <-chan *config.Config
 
 	lineCount       int64
 	lineSpeed       float64
@@ -77,10 +58,6 @@ type Publisher struct {
 	secondsNoAck    int
 
 	measurementTimer *time.Timer
-	ifSpoolChan      <-chan []*event.Event
-	configChan       <-chan *config.Config
-	nextSpool        []*event.Event
-	resendList       internallist.List
 }
 
 // NewPublisher creates a new publisher instance on the given pipeline
@@ -92,44 +69,16 @@ func NewPublisher() *Publisher {
 
 // Init initialises configuration
 func (p *Publisher) Init(cfg *config.Config) error {
-	p.netConfig = transports.FetchConfig(cfg)
 	p.adminConfig = cfg.Section("admin").(*admin.Config)
-	p.endpointSink = endpoint.NewSink(p.netConfig)
-
-	p.endpointSink.OnAck = p.OnAck
-	p.endpointSink.OnStarted = p.OnStarted
-	p.endpointSink.OnFinish = p.OnFinish
-	p.endpointSink.OnFail = p.OnFail
-	p.endpointSink.OnPong = p.OnPong
-
 	p.initAPI()
-	p.initMethod()
-
-	return nil
-}
 
-// initMethod initialises the method the Publisher uses to manage multiple
-// endpoints
-func (p *Publisher) initMethod() {
-	// Destroy any previous method
-	if p.method != nil {
-		p.method.destroy()
+	for _, entry := range transports.FetchOutputs(cfg) {
+		p.addOutput(entry)
 	}
 
-	// TODO: Factory registration for methods
-	switch p.netConfig.Method {
-	case "random":
-		p.method = newMethodRandom(p.endpointSink, p.netConfig)
-		return
-	case "failover":
-		p.method = newMethodFailover(p.endpointSink, p.netConfig)
-		return
-	case "loadbalance":
-		p.method = newMethodLoadbalance(p.endpointSink, p.netConfig)
-		return
-	}
+	p.updateEndpointsAPI(cfg)
 
-	panic(fmt.Sprintf("Internal error: Unknown publishing method: %s", p.netConfig.Method))
+	return nil
 }
 
 // Input returns the channel that receives events to be published
@@ -143,371 +92,231 @@ func (p *Publisher) SetConfigChan(configChan <-chan *config.Config) {
 	p.configChan = configChan
 }
 
-// Run starts the publisher, it handles endpoint status changes send from the
-// EndpointSink so it can make payload distribution decisions
+// Run starts the publisher and the outputs, routing each spool received to
+// the outputs
 func (p *Publisher) Run() {
 	p.measurementTimer = time.NewTimer(time.Second)
-	p.ifSpoolChan = p.spoolChan
 
-	for {
-		if p.runOnce() {
-			break
-		}
+	for _, output := range p.outputs {
+		p.startOutput(output)
 	}
 
-	log.Info("Publisher exiting")
-	p.method.destroy()
-}
-
-// runOnce runs a single iteration of the Publisher loop
-// Called continuously by Run until shutdown is completed, at which point the
-// return value changed from false to true to signal completion
-func (p *Publisher) runOnce() bool {
-	select {
-	case event := <-p.endpointSink.EventChan():
-		// Endpoint Sink processes the events, and feeds back relevant changes
-		if endpoint, err := p.endpointSink.ProcessEvent(event); err != nil {
-			p.forceEndpointFailure(endpoint, err)
-		}
-
-		// If all finished, we're done
-		if p.shuttingDown && p.endpointSink.Count() == 0 {
-			// TODO: What about out of sync ACK?
-			return true
-		}
-	case <-p.endpointSink.Scheduler.OnNext():
-		// Process triggered timeouts
-		for p.endpointSink.Scheduler.Next() != nil {
-			panic("Unexpected non-callback item returned from Endpoint Scheduler")
-		}
-		p.endpointSink.Scheduler.Reschedule()
-	case spool := <-p.ifSpoolChan:
-		// When inputs close, sources are all closed
-		if spool == nil {
-			p.ifSpoolChan = nil
-			p.nextSpool = nil
-			p.shuttingDown = true
-
-			// If no payloads held, nothing to wait for
-			if !p.eventsHeld() && p.numPayloads == 0 {
-				// If no endpoints, no shutdown necessary
-				if p.endpointSink.Count() == 0 {
-					return true
-				}
-				p.endpointSink.Shutdown()
-			} else {
-				log.Info("Publisher will prevent shutdown until outsanding payloads have been acknowledged or fail")
-			}
-			break
-		}
-
-		if p.numPayloads >= p.netConfig.MaxPendingPayloads {
-			log.Debug("Maximum pending payloads of %d reached, holding %d new events", p.netConfig.MaxPendingPayloads, len(spool))
-		} else if p.resendList.Len() != 0 {
-			log.Debug("Holding %d new events until the resend queue is flushed", len(spool))
-		} else if p.endpointSink.CanQueue() {
-			if _, ok := p.sendEvents(spool); ok {
-				break
+PublisherLoop:
+	for {
+		select {
+		case spool := <-p.spoolChan:
+			// When inputs close, sources are all closed
+			if spool == nil {
+				break PublisherLoop
 			}
 
-			log.Debug("Holding %d new events until an endpoint is ready", len(spool))
+			p.dispatch(p.route(spool))
+		case <-p.measurementTimer.C:
+			p.takeMeasurements()
+			p.measurementTimer.Reset(time.Second)
+		case config := <-p.configChan:
+			p.reloadConfig(config)
+			p.closeRetired()
 		}
-
-		// No ready endpoint, wait for one
-		p.nextSpool = spool
-		p.ifSpoolChan = nil
-	case <-p.measurementTimer.C:
-		p.takeMeasurements()
-		p.measurementTimer.Reset(time.Second)
-	case config := <-p.configChan:
-		p.reloadConfig(config)
 	}
 
-	return false
-}
-
-func (p *Publisher) reloadConfig(cfg *config.Config) {
-	oldMethod := p.netConfig.Method
-	p.netConfig = transports.FetchConfig(cfg)
+	p.shutdown()
 
-	// Give sink the new config
-	p.endpointSink.ReloadConfig(p.netConfig)
-
-	// Has method changed? Init the new method and discard the old one...
-	if p.netConfig.Method != oldMethod {
-		p.initMethod()
-	} else {
-		// ...otherwise give the existing method the new configuraton
-		p.method.reloadConfig(p.netConfig)
-	}
-
-	// The sink may have changed the priority endpoint after the reload, making
-	// an endpoint available
-	p.tryQueueHeld()
+	log.Info("Publisher exiting")
 }
 
-// OnStarted handles an endpoint that has moved from idle to now active
-func (p *Publisher) OnStarted(endpoint *endpoint.Endpoint) {
-	p.method.onStarted(endpoint)
-
-	if endpoint.NumPending() != 0 {
-		return
-	}
-
-	if p.tryQueueHeld() {
-		return
+// shutdown closes all outputs and waits for them to finish, which they will
+// do after all outstanding payloads are acknowledged
+func (p *Publisher) shutdown() {
+	for _, output := range p.outputs {
+		close(output.spoolChan)
 	}
+	p.closeRetired()
 
-	log.Debugf("[P %s] Starting keepalive timeout", endpoint.Server())
-	p.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
-		p.timeoutKeepalive(endpoint)
-	})
-}
-
-// OnFinish handles when endpoints are finished
-// Should return false if the endpoint is not to be reinitialised, such as when
-// shutting down
-func (p *Publisher) OnFinish(endpoint *endpoint.Endpoint) bool {
-	// Don't recreate anything if shutting down
-	if p.shuttingDown {
-		return false
-	}
+	doneChan := make(chan struct{})
+	go func() {
+		p.outputsWait.Wait()
+		close(doneChan)
+	}()
 
-	if endpoint.NumPending() != 0 {
-		p.pullBackPending(endpoint)
+	for {
+		select {
+		case <-doneChan:
+			return
+		case <-p.measurementTimer.C:
+			p.takeMeasurements()
+			p.measurementTimer.Reset(time.Second)
+		case config := <-p.configChan:
+			// Outputs may still be waiting on endpoints so continue to update
+			// those that remain, but do not start any new outputs
+			outputsConfig := transports.FetchOutputs(config)
+			for _, output := range p.outputs {
+				for _, entry := range outputsConfig {
+					if entry.Name == output.name {
+						output.updateConfig(entry.Config)
+						break
+					}
+				}
+			}
+		}
 	}
-
-	// Method defines how we handle finished endpoints
-	return p.method.onFinish(endpoint)
 }
 
-// OnFail handles a failed endpoint
-func (p *Publisher) OnFail(endpoint *endpoint.Endpoint) {
-	if endpoint.NumPending() != 0 {
-		p.pullBackPending(endpoint)
-	}
+// addOutput creates a new output from the given configuration
+func (p *Publisher) addOutput(entry *transports.OutputConfigEntry) *output {
+	output := newOutput(entry)
 
-	// Allow method to handle what we do due to the failed endpoint
-	p.method.onFail(endpoint)
-}
+	p.mutex.Lock()
+	p.outputs = append(p.outputs, output)
+	p.mutex.Unlock()
 
-// pullBackPending returns undelivered payloads from the endpoint back to the
-// publisher for redelivery
-func (p *Publisher) pullBackPending(endpoint *endpoint.Endpoint) {
-	// Pull back pending payloads so we can requeue them onto other endpoints
-	payloads := endpoint.PullBackPending()
-	for _, pendingPayload := range payloads {
-		pendingPayload.Resending = true
-		pendingPayload.ResetSequence()
-		p.resendList.PushBack(&pendingPayload.ResendElement)
+	if p.outputsAPI != nil {
+		p.outputsAPI.SetEntry(output.name, output.api)
 	}
 
-	// If any ready now, requeue immediately
-	p.tryQueueHeld()
-
-	log.Debugf("[P %s] %d payloads pulled back for resend (%d total now held for resend)", endpoint.Server(), len(payloads), p.resendList.Len())
+	return output
 }
 
-// OnAck handles acknowledgements from endpoints
-// It keeps track of how many out of sync acknowldgements have been made so
-// shutdown can be postponed if we've received Acks for newer events before
-// older events. It also serialises the Ack offsets for correct handling
-// so events are always acknowledged sequentially
-// TODO: Use event.Sequencer to simplify this?
-func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.Payload, firstAck bool, lineCount int) {
-	// Expect next ACK within network timeout if we still have pending
-	if endpoint.NumPending() > 0 {
-		p.endpointSink.Scheduler.SetCallback(endpoint, p.netConfig.Timeout, func() {
-			p.timeoutPending(endpoint)
-		})
-	} else {
-		p.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
-			p.timeoutKeepalive(endpoint)
-		})
-	}
+// startOutput starts the routine for an output
+func (p *Publisher) startOutput(output *output) {
+	p.outputsWait.Add(1)
+	go func() {
+		defer p.outputsWait.Done()
+		output.run()
+	}()
+}
 
-	complete := pendingPayload.Complete()
+// reloadConfig applies a new configuration, passing new network configuration
+// to existing outputs, starting any new outputs and retiring any outputs that
+// were removed
+func (p *Publisher) reloadConfig(cfg *config.Config) {
+	outputsConfig := transports.FetchOutputs(cfg)
 
-	// If we're on the resend queue and just completed, remove it
-	// Handle the condition occurring where the endpoint incorrectly reports a
-	// failure but then afterwards reports an acknowledgement, which means we're
-	// acknowledging a payload still on the resendList
-	if pendingPayload.Resending && complete {
-		pendingPayload.Resending = false
-		p.resendList.Remove(&pendingPayload.ResendElement)
+	existing := make(map[string]*output, len(p.outputs))
+	for _, output := range p.outputs {
+		existing[output.name] = output
 	}
 
-	numComplete := int64(0)
-
-	// We potentially receive out-of-order ACKs due to payloads distributed across servers
-	// This is where we enforce ordering again to ensure the handlers receive ACKs in order
-	if pendingPayload == p.payloadList.Front().Value.(*payload.Payload) {
-		// The out of sync count we have will never include the first payload, so
-		// take the value +1
-		outOfSync := p.outOfSync + 1
-
-		// For each full payload we mark off, we decrease this count, the first we
-		// mark off will always be the first payload - thus the +1. Subsequent
-		// payloads are the out of sync ones - so if we mark them off we decrease
-		// the out of sync count
-		for pendingPayload.HasAck() {
-			event.DispatchAck(pendingPayload.Rollup())
-
-			if !pendingPayload.Complete() {
-				break
-			}
-
-			p.payloadList.Remove(&pendingPayload.Element)
-			outOfSync--
-			p.outOfSync = outOfSync
-
-			numComplete++
-
-			if p.payloadList.Len() == 0 {
-				break
-			}
-
-			pendingPayload = p.payloadList.Front().Value.(*payload.Payload)
+	outputs := make([]*output, 0, len(outputsConfig))
+	for _, entry := range outputsConfig {
+		if output, ok := existing[entry.Name]; ok {
+			output.entry = entry
+			output.updateConfig(entry.Config)
+			outputs = append(outputs, output)
+			delete(existing, entry.Name)
+			continue
 		}
-	} else if firstAck {
-		// If this is NOT the first payload, and this is the first acknowledgement
-		// for this payload, then increase out of sync payload count
-		p.outOfSync++
-	}
 
-	p.mutex.Lock()
-	if numComplete != 0 {
-		p.numPayloads -= numComplete
+		log.Infof("[O %s] Starting new output", entry.Name)
+		output := newOutput(entry)
+		if p.outputsAPI != nil {
+			p.outputsAPI.SetEntry(output.name, output.api)
+		}
+		p.startOutput(output)
+		outputs = append(outputs, output)
 	}
-	p.lineCount += int64(lineCount)
-	p.mutex.Unlock()
 
-	if complete {
-		// Resume sending if we stopped due to excessive pending payload count
-		p.tryQueueHeld()
-
-		// If last payload confirmed, begin shutdown
-		if p.shuttingDown && !p.eventsHeld() && p.numPayloads == 0 {
-			p.endpointSink.Shutdown()
+	// Any outputs remaining were removed from the configuration, retire them
+	// so they are closed once any spool currently being dispatched is complete
+	for name, output := range existing {
+		log.Infof("[O %s] Output was removed from the configuration and will shutdown once outstanding payloads are acknowledged", name)
+		if p.outputsAPI != nil {
+			p.outputsAPI.RemoveEntry(name)
 		}
+		p.retiring = append(p.retiring, output)
 	}
-}
 
-// OnPong handles when endpoints receive a pong message
-func (p *Publisher) OnPong(endpoint *endpoint.Endpoint) {
-	// If we haven't started sending anything, return to keepalive timeout
-	if endpoint.NumPending() == 0 {
-		log.Debugf("[P %s] Resetting keepalive timeout", endpoint.Server())
-		p.endpointSink.Scheduler.SetCallback(endpoint, keepaliveTimeout, func() {
-			p.timeoutKeepalive(endpoint)
-		})
-	}
-}
+	p.mutex.Lock()
+	p.outputs = outputs
+	p.mutex.Unlock()
 
-// forceEndpointFailure is called to force an endpoint to enter
-// the failed status. It reports the error and then processes the failure.
-func (p *Publisher) forceEndpointFailure(endpoint *endpoint.Endpoint, err error) {
-	log.Warningf("[P %s] Forcing endpoint failure: %s", endpoint.Server(), err)
-	p.endpointSink.ForceFailure(endpoint, err)
+	p.updateEndpointsAPI(cfg)
 }
 
-// eventsHeld returns true if there are events held waiting to be queued
-func (p *Publisher) eventsHeld() bool {
-	return p.resendList.Len() > 0 || p.nextSpool != nil
-}
+// route evaluates the route of each output against each event in the spool
+// and returns the batches of events to be sent to each output
+func (p *Publisher) route(events []*event.Event) []*routedBatch {
+	spool := newRoutedSpool(events)
+
+	batches := make([]*routedBatch, 0, len(p.outputs))
+	for _, output := range p.outputs {
+		batch := &routedBatch{
+			publisher: p,
+			spool:     spool,
+			output:    output,
+		}
 
-// tryQueueHeld attempts to queue held payloads
-func (p *Publisher) tryQueueHeld() bool {
-	if p.resendList.Len() > 0 {
-		didSend := false
-
-		for p.resendList.Len() > 0 && p.endpointSink.CanQueue() {
-			pendingPayload := p.resendList.Front().Value.(*payload.Payload)
-
-			// We have a payload to resend, send it now
-			if _, ok := p.sendPayload(pendingPayload); ok {
-				pendingPayload.Resending = false
-				pendingPayload.ResetSequence()
-				p.resendList.Remove(&pendingPayload.ResendElement)
-				log.Debugf("%d payloads remain held for resend", p.resendList.Len())
-				didSend = true
+		for idx, evnt := range events {
+			if output.entry.Routes(evnt) {
+				batch.add(idx)
 			}
 		}
 
-		return didSend
-	}
-
-	// Only take from nextSpool if we have space below the limit
-	if p.numPayloads < p.netConfig.MaxPendingPayloads && p.nextSpool != nil {
-		// We have events, send it to the endpoint and wait for more
-		if _, ok := p.sendEvents(p.nextSpool); ok {
-			p.nextSpool = nil
-			p.ifSpoolChan = p.spoolChan
-			return true
+		if len(batch.events) != 0 {
+			batches = append(batches, batch)
 		}
 	}
 
-	return false
-}
-
-func (p *Publisher) sendEvents(events []*event.Event) (*endpoint.Endpoint, bool) {
-	pendingPayload := payload.NewPayload(events)
-
-	p.payloadList.PushBack(&pendingPayload.Element)
-
 	p.mutex.Lock()
-	p.numPayloads++
+	p.spoolList.PushBack(&spool.element)
+	// Events routed to no output can be released immediately if in order
+	p.releaseSpools()
 	p.mutex.Unlock()
 
-	return p.sendPayload(pendingPayload)
+	return batches
 }
 
-func (p *Publisher) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endpoint, bool) {
-	// Attempt to queue the payload with the best endpoint
-	endpoint, err := p.endpointSink.QueuePayload(pendingPayload)
-	if err != nil {
-		if err == transports.ErrCongestion {
-			// No need to force failure - just wait for another endpoint
-			return nil, false
+// dispatch sends each routed batch to its output, continuing to process
+// configuration changes whilst waiting for outputs to accept them
+func (p *Publisher) dispatch(batches []*routedBatch) {
+	for _, batch := range batches {
+	DispatchLoop:
+		for {
+			select {
+			case batch.output.spoolChan <- batch.events:
+				break DispatchLoop
+			case <-p.measurementTimer.C:
+				p.takeMeasurements()
+				p.measurementTimer.Reset(time.Second)
+			case config := <-p.configChan:
+				p.reloadConfig(config)
+			}
 		}
-		p.forceEndpointFailure(endpoint, err)
-		return nil, false
-	}
-	if endpoint == nil {
-		// No endpoints available
-		return nil, false
 	}
 
-	// If this is the first payload, start the network timeout
-	if endpoint.NumPending() == 1 {
-		log.Debugf("[P %s] Starting timout timer", endpoint.Server())
-		p.endpointSink.Scheduler.SetCallback(endpoint, p.netConfig.Timeout, func() {
-			p.timeoutPending(endpoint)
-		})
-	}
-
-	return endpoint, true
+	p.closeRetired()
 }
 
-func (p *Publisher) timeoutPending(endpoint *endpoint.Endpoint) {
-	// Trigger a failure
-	if endpoint.IsPinging() {
-		p.forceEndpointFailure(endpoint, errNetworkPing)
-	} else {
-		p.forceEndpointFailure(endpoint, errNetworkTimeout)
+// closeRetired closes any retired outputs, and should be called only when
+// nothing more will be dispatched to them
+func (p *Publisher) closeRetired() {
+	for _, output := range p.retiring {
+		close(output.spoolChan)
 	}
+	p.retiring = nil
 }
 
-func (p *Publisher) timeoutKeepalive(endpoint *endpoint.Endpoint) {
-	// Timeout for PING
-	log.Debugf("[P %s] Sending ping and starting pending timeout", endpoint.Server())
-	p.endpointSink.Scheduler.SetCallback(endpoint, p.netConfig.Timeout, func() {
-		p.timeoutPending(endpoint)
-	})
+// releaseSpools acknowledges, in order, all events at the front of the spool
+// list that have been acknowledged by all outputs they were routed to
+// The mutex must be held by the caller
+func (p *Publisher) releaseSpools() {
+	var released []*event.Event
+	for p.spoolList.Len() != 0 {
+		spool := p.spoolList.Front().Value.(*routedSpool)
+		events, complete := spool.release()
+		released = append(released, events...)
+		if !complete {
+			break
+		}
+		p.spoolList.Remove(&spool.element)
+	}
 
-	if err := endpoint.SendPing(); err != nil {
-		p.forceEndpointFailure(endpoint, err)
+	if len(released) == 0 {
+		return
 	}
+
+	p.lineCount += int64(len(released))
+	event.DispatchAck(released)
 }
 
 func (p *Publisher) takeMeasurements() {
@@ -525,9 +334,26 @@ func (p *Publisher) initAPI() {
 		return
 	}
 
-	publisherAPI := &api.Node{}
-	publisherAPI.SetEntry("endpoints", p.endpointSink.APINavigatable())
-	publisherAPI.SetEntry("status", &apiStatus{p: p})
+	p.outputsAPI = &api.Node{}
+
+	p.api = &api.Node{}
+	p.api.SetEntry("outputs", p.outputsAPI)
+	p.api.SetEntry("status", &apiPublisherStatus{p: p})
 
-	p.adminConfig.SetEntry("publisher", publisherAPI)
+	p.adminConfig.SetEntry("publisher", p.api)
+}
+
+// updateEndpointsAPI exposes the endpoints of the output created from the
+// network section at the top level of the publisher API, as it was before
+// multiple outputs were available, but only if outputs are not configured
+func (p *Publisher) updateEndpointsAPI(cfg *config.Config) {
+	if p.api == nil {
+		return
+	}
+
+	if len(transports.FetchOutputsConfig(cfg)) == 0 {
+		p.api.SetEntry("endpoints", p.outputs[0].endpointSink.APINavigatable())
+	} else {
+		p.api.RemoveEntry("endpoints")
+	}
 }
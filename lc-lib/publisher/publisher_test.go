@@ -0,0 +1,172 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+type testAcker struct {
+	acked []*event.Event
+}
+
+func (a *testAcker) Acknowledge(events []*event.Event) {
+	a.acked = append(a.acked, events...)
+}
+
+func createTestOutput(t *testing.T, name string, route string) *output {
+	entry := &transports.OutputConfigEntry{Name: name, Route: route}
+	if err := entry.Init(nil, "/"); err != nil {
+		t.Fatalf("Failed to initialise output: %s", err)
+	}
+	return &output{
+		name:       name,
+		entry:      entry,
+		configChan: make(chan *transports.Config, 1),
+	}
+}
+
+func createTestPublisher(outputs ...*output) *Publisher {
+	p := NewPublisher()
+	p.outputs = outputs
+	return p
+}
+
+func createTestEvents(acker event.Acknowledger, types ...string) []*event.Event {
+	events := make([]*event.Event, len(types))
+	for idx, eventType := range types {
+		events[idx] = event.NewEvent(context.Background(), acker, map[string]interface{}{"message": "test", "type": eventType})
+	}
+	return events
+}
+
+func findBatch(t *testing.T, batches []*routedBatch, output *output) *routedBatch {
+	for _, batch := range batches {
+		if batch.output == output {
+			return batch
+		}
+	}
+	t.Fatalf("No batch was routed to output %s", output.name)
+	return nil
+}
+
+func verifyAcked(t *testing.T, acker *testAcker, expected []*event.Event) {
+	if len(acker.acked) != len(expected) {
+		t.Fatalf("Unexpected acknowledged count, got: %d, expected: %d", len(acker.acked), len(expected))
+	}
+	for idx, evnt := range expected {
+		if acker.acked[idx] != evnt {
+			t.Errorf("Event %d was acknowledged out of order", idx)
+		}
+	}
+}
+
+func TestPublisherAckAfterAllOutputs(t *testing.T) {
+	first, second := createTestOutput(t, "first", ""), createTestOutput(t, "second", "")
+	p := createTestPublisher(first, second)
+	acker := &testAcker{}
+	events := createTestEvents(acker, "a", "a", "a")
+
+	batches := p.route(events)
+	if len(batches) != 2 {
+		t.Fatalf("Unexpected batch count, got: %d, expected: 2", len(batches))
+	}
+
+	// Outputs acknowledge in the opposite order
+	event.DispatchAck(findBatch(t, batches, second).events)
+	verifyAcked(t, acker, nil)
+	event.DispatchAck(findBatch(t, batches, first).events)
+	verifyAcked(t, acker, events)
+}
+
+func TestPublisherPartialAck(t *testing.T) {
+	first, second := createTestOutput(t, "first", ""), createTestOutput(t, "second", "")
+	p := createTestPublisher(first, second)
+	acker := &testAcker{}
+	events := createTestEvents(acker, "a", "a", "a")
+
+	batches := p.route(events)
+	firstBatch, secondBatch := findBatch(t, batches, first), findBatch(t, batches, second)
+
+	event.DispatchAck(firstBatch.events[:2])
+	event.DispatchAck(secondBatch.events[:1])
+	verifyAcked(t, acker, events[:1])
+	event.DispatchAck(secondBatch.events[1:2])
+	verifyAcked(t, acker, events[:2])
+	event.DispatchAck(secondBatch.events[2:])
+	verifyAcked(t, acker, events[:2])
+	event.DispatchAck(firstBatch.events[2:])
+	verifyAcked(t, acker, events)
+}
+
+func TestPublisherUnroutedEvents(t *testing.T) {
+	routed := createTestOutput(t, "routed", "event.type == \"a\"")
+	p := createTestPublisher(routed)
+	acker := &testAcker{}
+	events := createTestEvents(acker, "b", "a", "b")
+
+	batches := p.route(events)
+	if len(batches) != 1 || len(batches[0].events) != 1 {
+		t.Fatalf("Unexpected routing, got %d batches", len(batches))
+	}
+
+	// Events routed nowhere are acknowledged immediately, but only in order
+	verifyAcked(t, acker, events[:1])
+	event.DispatchAck(batches[0].events)
+	verifyAcked(t, acker, events)
+
+	// A spool routed nowhere at all is acknowledged immediately
+	unrouted := createTestEvents(acker, "b")
+	if batches := p.route(unrouted); len(batches) != 0 {
+		t.Fatalf("Unexpected batch count, got: %d, expected: 0", len(batches))
+	}
+	verifyAcked(t, acker, append(events, unrouted...))
+}
+
+func TestPublisherSpoolOrder(t *testing.T) {
+	output := createTestOutput(t, "output", "")
+	p := createTestPublisher(output)
+	acker := &testAcker{}
+	firstEvents, secondEvents := createTestEvents(acker, "a"), createTestEvents(acker, "a")
+
+	firstBatches := p.route(firstEvents)
+	secondBatches := p.route(secondEvents)
+
+	// A later spool is held until the earlier spool is acknowledged
+	event.DispatchAck(secondBatches[0].events)
+	verifyAcked(t, acker, nil)
+	event.DispatchAck(firstBatches[0].events)
+	verifyAcked(t, acker, append(firstEvents, secondEvents...))
+}
+
+func TestOutputUpdateConfig(t *testing.T) {
+	output := createTestOutput(t, "output", "")
+
+	// Nothing is receiving so this must not block, and the most recent
+	// configuration must replace the previous
+	first, second := &transports.Config{}, &transports.Config{}
+	output.updateConfig(first)
+	output.updateConfig(second)
+
+	if received := <-output.configChan; received != second {
+		t.Errorf("Unexpected configuration received")
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/internallist"
+)
+
+// routedSpool tracks a spool of events received by the Publisher that has
+// been routed to one or more outputs, so that each event can be acknowledged
+// once all outputs it was routed to have acknowledged it
+type routedSpool struct {
+	element  internallist.Element
+	events   []*event.Event
+	pending  []int
+	released int
+}
+
+// newRoutedSpool creates a new routedSpool for the given events
+func newRoutedSpool(events []*event.Event) *routedSpool {
+	ret := &routedSpool{
+		events:  events,
+		pending: make([]int, len(events)),
+	}
+
+	ret.element.Value = ret
+
+	return ret
+}
+
+// release returns the events at the front of the spool that have now been
+// acknowledged by all outputs they were routed to, and true if the entire
+// spool is now released
+func (s *routedSpool) release() ([]*event.Event, bool) {
+	start := s.released
+	for s.released < len(s.events) && s.pending[s.released] == 0 {
+		s.released++
+	}
+	return s.events[start:s.released], s.released == len(s.events)
+}
+
+// routedBatch holds the events from a routedSpool that were routed to a single
+// output, and is the acknowledger for those events
+// Outputs always acknowledge events in the order they received them, so we
+// need only count the acknowledged events to know which were acknowledged
+type routedBatch struct {
+	publisher *Publisher
+	spool     *routedSpool
+	output    *output
+	indexes   []int
+	events    []*event.Event
+	acked     int
+}
+
+// add derives an event for this batch from the event at the given index in
+// the spool
+func (b *routedBatch) add(idx int) {
+	b.indexes = append(b.indexes, idx)
+	b.events = append(b.events, b.spool.events[idx].Derive(b))
+	b.spool.pending[idx]++
+}
+
+// Acknowledge processes event acknowledgements from the output (implements
+// event.Acknowledger)
+func (b *routedBatch) Acknowledge(events []*event.Event) {
+	b.publisher.mutex.Lock()
+	defer b.publisher.mutex.Unlock()
+
+	for _, idx := range b.indexes[b.acked : b.acked+len(events)] {
+		b.spool.pending[idx]--
+	}
+	b.acked += len(events)
+
+	b.publisher.releaseSpools()
+}
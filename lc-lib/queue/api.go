@@ -0,0 +1,42 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+)
+
+type apiStatus struct {
+	api.KeyValue
+
+	q *Queue
+}
+
+// Update updates the queue status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.q.mutex.RLock()
+	a.SetEntry("queuedEvents", api.Number(a.q.queuedEvents))
+	a.SetEntry("diskBytes", api.Number(a.q.diskBytes))
+	a.SetEntry("maxDiskBytes", api.Number(a.q.cfg.MaxSize))
+	a.SetEntry("segments", api.Number(len(a.q.segments)))
+	a.SetEntry("replayTotal", api.Number(a.q.replayTotal))
+	a.SetEntry("replayedEvents", api.Number(a.q.replayedEvents))
+	a.q.mutex.RUnlock()
+
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+const (
+	// FsyncAlways syncs every write to disk before it is acknowledged
+	FsyncAlways = "always"
+	// FsyncInterval syncs writes to disk periodically, and acknowledges them
+	// once synced
+	FsyncInterval = "interval"
+	// FsyncNever never syncs and acknowledges writes once they are passed to
+	// the operating system
+	FsyncNever = "never"
+)
+
+const (
+	defaultQueueEnabled        bool          = false
+	defaultQueueFsync          string        = FsyncAlways
+	defaultQueueFsyncInterval  time.Duration = 1 * time.Second
+	defaultQueueMaxSegmentSize int64         = 64 * 1024 * 1024   // 64 MiB
+	defaultQueueMaxSize        int64         = 1024 * 1024 * 1024 // 1 GiB
+)
+
+// Config holds the queue configuration
+type Config struct {
+	Enabled        bool          `config:"enabled"`
+	Directory      string        `config:"directory"`
+	Fsync          string        `config:"fsync"`
+	FsyncInterval  time.Duration `config:"fsync interval"`
+	MaxSegmentSize int64         `config:"max segment size"`
+	MaxSize        int64         `config:"max size"`
+}
+
+// Validate the queue configuration
+func (c *Config) Validate(p *config.Parser, path string) (err error) {
+	if c.Directory == "" {
+		c.Directory = filepath.Join(p.Config().General().PersistDir, "queue")
+	}
+
+	switch c.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		err = fmt.Errorf("%sfsync must be one of \"%s\", \"%s\" or \"%s\"", path, FsyncAlways, FsyncInterval, FsyncNever)
+		return
+	}
+
+	if c.FsyncInterval <= 0 {
+		err = fmt.Errorf("%sfsync interval must be greater than 0", path)
+		return
+	}
+
+	if c.MaxSegmentSize <= 0 {
+		err = fmt.Errorf("%smax segment size must be greater than 0", path)
+		return
+	}
+
+	if c.MaxSize < c.MaxSegmentSize {
+		err = fmt.Errorf("%smax size can not be less than %smax segment size", path, path)
+		return
+	}
+
+	return
+}
+
+// FetchConfig returns the queue configuration from a Config structure
+func FetchConfig(cfg *config.Config) *Config {
+	return cfg.Section("queue").(*Config)
+}
+
+func init() {
+	config.RegisterSection("queue", func() interface{} {
+		return &Config{
+			Enabled:        defaultQueueEnabled,
+			Fsync:          defaultQueueFsync,
+			FsyncInterval:  defaultQueueFsyncInterval,
+			MaxSegmentSize: defaultQueueMaxSegmentSize,
+			MaxSize:        defaultQueueMaxSize,
+		}
+	})
+}
@@ -0,0 +1,668 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/admin/api"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+const (
+	stateFile = "queue.state"
+)
+
+// position is a location within the queue
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// pendingAck holds an acknowledgement received for a batch
+type pendingAck struct {
+	batch *batch
+	count int
+}
+
+// batch is a group of events read from a single segment and sent onwards,
+// and is the acknowledger for those events
+type batch struct {
+	queue   *Queue
+	segment *segment
+	ends    []int64
+	acked   int
+}
+
+// Acknowledge queues acknowledgement of events so that the queue can advance
+// (implements event.Acknowledger)
+// It must not block as it may be called whilst the publisher holds locks that
+// prevent it receiving further events, except after shutdown when nothing
+// more will be sent
+func (b *batch) Acknowledge(events []*event.Event) {
+	q := b.queue
+	q.ackMutex.Lock()
+	defer q.ackMutex.Unlock()
+
+	if q.closed {
+		// The queue routine has exited, so apply and save immediately
+		q.applyAcks([]pendingAck{{batch: b, count: len(events)}})
+		q.tryWriteState()
+		return
+	}
+
+	q.pendingAcks = append(q.pendingAcks, pendingAck{batch: b, count: len(events)})
+
+	select {
+	case q.ackChan <- struct{}{}:
+	default:
+	}
+}
+
+// Queue persists events to disk and acknowledges them only once they are
+// durably written, and then sends them onwards from disk, so that they
+// survive a restart or a long outage of the publisher
+type Queue struct {
+	mutex sync.RWMutex
+
+	cfg         *Config
+	genConfig   *spooler.General
+	adminConfig *admin.Config
+	directory   string
+	statePath   string
+	input       chan []*event.Event
+	output      chan<- []*event.Event
+	configChan  <-chan *config.Config
+
+	segments      []*segment
+	writeFile     *os.File
+	readFile      *os.File
+	readSegment   *segment
+	readPos       position
+	ackPos        position
+	replaySegment uint64
+	pendingWrite  []*event.Event
+	unsynced      []*event.Event
+	nextSpool     []*event.Event
+	stateDirty    bool
+	syncTimer     *time.Timer
+	syncPending   bool
+	stateTimer    *time.Timer
+
+	ackMutex    sync.Mutex
+	ackChan     chan struct{}
+	pendingAcks []pendingAck
+	closed      bool
+
+	queuedEvents   int64
+	diskBytes      int64
+	replayTotal    int64
+	replayedEvents int64
+}
+
+// NewQueue creates a new disk queue
+func NewQueue(app *core.App) *Queue {
+	return &Queue{
+		input:   make(chan []*event.Event, 1),
+		ackChan: make(chan struct{}, 1),
+	}
+}
+
+// Input returns the channel to send events to the queue with
+func (q *Queue) Input() chan<- []*event.Event {
+	return q.input
+}
+
+// SetOutput sets the output channel
+func (q *Queue) SetOutput(output chan<- []*event.Event) {
+	q.output = output
+}
+
+// SetConfigChan sets the config channel
+func (q *Queue) SetConfigChan(configChan <-chan *config.Config) {
+	q.configChan = configChan
+}
+
+// Init opens the queue directory and loads any events that were not
+// acknowledged before the last shutdown so they can be replayed
+func (q *Queue) Init(cfg *config.Config) error {
+	q.cfg = FetchConfig(cfg)
+	q.genConfig = cfg.GeneralPart("spooler").(*spooler.General)
+	q.adminConfig = admin.FetchConfig(cfg)
+	q.directory = q.cfg.Directory
+	q.statePath = filepath.Join(q.directory, stateFile)
+
+	if err := os.MkdirAll(q.directory, 0700); err != nil {
+		return fmt.Errorf("Failed to create queue directory: %s", err)
+	}
+
+	if err := q.loadState(); err != nil {
+		return fmt.Errorf("Failed to load queue state: %s", err)
+	}
+
+	if err := q.loadSegments(); err != nil {
+		return fmt.Errorf("Failed to load queue: %s", err)
+	}
+
+	q.initAPI()
+
+	return nil
+}
+
+// loadState loads the position of the first unacknowledged event
+func (q *Queue) loadState() error {
+	file, err := os.Open(q.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(&q.ackPos)
+}
+
+// loadSegments verifies the existing segments, removing any that were
+// entirely acknowledged, and prepares to replay the rest
+func (q *Queue) loadSegments() error {
+	ids, err := listSegments(q.directory)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id < q.ackPos.Segment {
+			if err := os.Remove(segmentPath(q.directory, id)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		from := segmentHeaderSize
+		if id == q.ackPos.Segment {
+			from = q.ackPos.Offset
+		}
+
+		seg, count, err := scanSegment(q.directory, id, from)
+		if err == errEmptySegment {
+			if err := os.Remove(segmentPath(q.directory, id)); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		q.segments = append(q.segments, seg)
+		q.diskBytes += seg.size
+		q.replayTotal += count
+	}
+
+	q.queuedEvents = q.replayTotal
+	q.readPos = q.ackPos
+	q.replaySegment = 1
+	if len(q.segments) != 0 {
+		q.replaySegment = q.segments[len(q.segments)-1].id + 1
+	}
+
+	if q.replayTotal != 0 {
+		log.Notice("Replaying %d events from %d queue segments in %s", q.replayTotal, len(q.segments), q.directory)
+	}
+
+	return nil
+}
+
+// Run starts the queue routine
+func (q *Queue) Run() {
+	q.syncTimer = time.NewTimer(0)
+	<-q.syncTimer.C
+	q.stateTimer = time.NewTimer(time.Second)
+
+	if err := q.removeAcknowledged(); err != nil {
+		log.Errorf("Failed to remove acknowledged queue segments: %s", err)
+	}
+
+QueueLoop:
+	for {
+		// Stop accepting events whilst full or unable to write
+		var inputChan <-chan []*event.Event
+		if q.pendingWrite == nil && q.diskBytes < q.cfg.MaxSize {
+			inputChan = q.input
+		}
+
+		var outputChan chan<- []*event.Event
+		if q.nextSpool == nil {
+			q.nextSpool = q.readSpool()
+		}
+		if q.nextSpool != nil {
+			outputChan = q.output
+		}
+
+		select {
+		case events := <-inputChan:
+			// Closed input means shutting down gracefully, anything not yet sent
+			// onwards remains on disk for the next startup
+			if events == nil {
+				break QueueLoop
+			}
+
+			if len(events) == 0 {
+				continue
+			}
+
+			if err := q.write(events); err != nil {
+				log.Errorf("Queue write failed, will retry: %s", err)
+				q.pendingWrite = events
+			}
+		case outputChan <- q.nextSpool:
+			q.nextSpool = nil
+		case <-q.ackChan:
+			q.processAcks()
+		case <-q.syncTimer.C:
+			q.syncPending = false
+			q.sync()
+		case <-q.stateTimer.C:
+			q.stateTimer.Reset(time.Second)
+
+			if q.pendingWrite != nil {
+				if err := q.write(q.pendingWrite); err != nil {
+					log.Errorf("Queue write failed, will retry: %s", err)
+				} else {
+					q.pendingWrite = nil
+				}
+			}
+
+			if q.stateDirty {
+				q.tryWriteState()
+			}
+		case config := <-q.configChan:
+			q.reloadConfig(config)
+		}
+	}
+
+	q.sync()
+	close(q.output)
+
+	if q.writeFile != nil {
+		q.closeWriteFile()
+	}
+	q.closeReadFile()
+
+	// Acknowledgements for events already sent onwards will continue to arrive
+	// until the publisher has finished, so from now on they are applied as they
+	// arrive
+	q.ackMutex.Lock()
+	q.closed = true
+	q.applyAcks(q.pendingAcks)
+	q.pendingAcks = nil
+	if q.stateDirty {
+		q.tryWriteState()
+	}
+	q.ackMutex.Unlock()
+
+	log.Info("Queue exiting")
+}
+
+// write appends events to the current segment, starting a new one when it
+// reaches the maximum segment size
+func (q *Queue) write(events []*event.Event) error {
+	var buf []byte
+	for _, evnt := range events {
+		buf = encodeRecord(buf, encodeEvent(evnt))
+	}
+
+	if q.writeFile == nil || (q.writeSegment().size > segmentHeaderSize && q.writeSegment().size+int64(len(buf)) > q.cfg.MaxSegmentSize) {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	seg := q.writeSegment()
+	if _, err := q.writeFile.Write(buf); err != nil {
+		// Abandon this segment, anything partially written after the recorded
+		// size will be ignored, and removed during the next startup
+		q.closeWriteFile()
+		return err
+	}
+
+	q.mutex.Lock()
+	seg.size += int64(len(buf))
+	q.diskBytes += int64(len(buf))
+	q.queuedEvents += int64(len(events))
+	q.mutex.Unlock()
+
+	q.unsynced = append(q.unsynced, events...)
+
+	switch q.cfg.Fsync {
+	case FsyncAlways:
+		q.sync()
+	case FsyncInterval:
+		if !q.syncPending {
+			q.syncPending = true
+			q.syncTimer.Reset(q.cfg.FsyncInterval)
+		}
+	case FsyncNever:
+		q.ackUnsynced()
+	}
+
+	return nil
+}
+
+// writeSegment returns the segment currently being written to
+func (q *Queue) writeSegment() *segment {
+	return q.segments[len(q.segments)-1]
+}
+
+// rotate closes the current segment and starts a new one
+func (q *Queue) rotate() error {
+	if q.writeFile != nil {
+		if err := q.closeWriteFile(); err != nil {
+			return err
+		}
+	}
+
+	id := q.replaySegment
+	if len(q.segments) != 0 && q.segments[len(q.segments)-1].id >= id {
+		id = q.segments[len(q.segments)-1].id + 1
+	}
+
+	seg, file, err := createSegment(q.directory, id)
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	q.segments = append(q.segments, seg)
+	q.diskBytes += seg.size
+	q.mutex.Unlock()
+
+	q.writeFile = file
+	return nil
+}
+
+// closeWriteFile syncs and closes the current segment, after which anything
+// written to it can be acknowledged
+func (q *Queue) closeWriteFile() error {
+	if q.cfg.Fsync != FsyncNever {
+		if err := q.writeFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	err := q.writeFile.Close()
+	q.writeFile = nil
+	q.ackUnsynced()
+	return err
+}
+
+// sync flushes written events to disk and then acknowledges them
+func (q *Queue) sync() {
+	if len(q.unsynced) == 0 {
+		return
+	}
+
+	if q.writeFile != nil && q.cfg.Fsync != FsyncNever {
+		if err := q.writeFile.Sync(); err != nil {
+			log.Errorf("Queue sync failed, will retry: %s", err)
+			if !q.syncPending {
+				q.syncPending = true
+				q.syncTimer.Reset(time.Second)
+			}
+			return
+		}
+	}
+
+	q.ackUnsynced()
+}
+
+// ackUnsynced acknowledges upstream all events that are now written
+func (q *Queue) ackUnsynced() {
+	event.DispatchAck(q.unsynced)
+	q.unsynced = nil
+}
+
+// readSpool reads the next spool of events to send onwards, returning nil if
+// there are none
+func (q *Queue) readSpool() []*event.Event {
+	for {
+		seg := q.findReadSegment()
+		if seg == nil {
+			return nil
+		}
+
+		if q.readPos.Offset < seg.size {
+			if seg != q.readSegment {
+				if err := q.openReadSegment(seg); err != nil {
+					log.Errorf("Failed to open queue segment for reading: %s", err)
+					return nil
+				}
+			}
+
+			records, ends, err := seg.readRecords(q.readFile, q.readPos.Offset, int(q.genConfig.SpoolSize), q.genConfig.SpoolMaxBytes)
+			if err != nil {
+				// Skip the remainder of a segment we are unable to read, it will be
+				// removed once everything before it is acknowledged
+				log.Errorf("Failed to read queue segment, discarding remaining events in it: %s", err)
+				q.readPos.Offset = seg.size
+				continue
+			}
+
+			b := &batch{queue: q, segment: seg, ends: ends}
+			events := make([]*event.Event, len(records))
+			for idx, data := range records {
+				if events[idx], err = decodeEvent(context.Background(), b, data); err != nil {
+					// Keep the record so it is acknowledged along with the rest,
+					// but mark it as unusable in the same way as an event that
+					// fails to unmarshal
+					log.Errorf("Failed to decode queued event at offset %d of %s: %s", ends[idx], seg.path, err)
+					events[idx] = event.NewEventFromBytes(context.Background(), b, []byte{})
+				}
+			}
+
+			q.readPos.Offset = ends[len(ends)-1]
+			if seg.id < q.replaySegment {
+				q.mutex.Lock()
+				q.replayedEvents += int64(len(events))
+				q.mutex.Unlock()
+			}
+
+			return events
+		}
+
+		if seg == q.segments[len(q.segments)-1] {
+			// Reached the end of the segment still being written
+			return nil
+		}
+
+		// Move to the next segment
+		q.readPos = position{Segment: seg.id + 1}
+	}
+}
+
+// findReadSegment returns the segment containing the read position, moving the
+// read position to the start of the next segment if that segment was removed
+func (q *Queue) findReadSegment() *segment {
+	for _, seg := range q.segments {
+		if seg.id == q.readPos.Segment {
+			if q.readPos.Offset < segmentHeaderSize {
+				q.readPos.Offset = segmentHeaderSize
+			}
+			return seg
+		}
+		if seg.id > q.readPos.Segment {
+			q.readPos = position{Segment: seg.id, Offset: segmentHeaderSize}
+			return seg
+		}
+	}
+	return nil
+}
+
+// openReadSegment opens the given segment for reading
+func (q *Queue) openReadSegment(seg *segment) error {
+	q.closeReadFile()
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+
+	q.readFile = file
+	q.readSegment = seg
+	return nil
+}
+
+// closeReadFile closes the segment being read
+func (q *Queue) closeReadFile() {
+	if q.readFile != nil {
+		q.readFile.Close()
+		q.readFile = nil
+		q.readSegment = nil
+	}
+}
+
+// processAcks applies any acknowledgements received
+func (q *Queue) processAcks() {
+	q.ackMutex.Lock()
+	pendingAcks := q.pendingAcks
+	q.pendingAcks = nil
+	q.ackMutex.Unlock()
+
+	q.applyAcks(pendingAcks)
+}
+
+// applyAcks advances the acknowledged position and removes segments that are
+// now entirely acknowledged
+func (q *Queue) applyAcks(pendingAcks []pendingAck) {
+	if len(pendingAcks) == 0 {
+		return
+	}
+
+	var count int64
+	for _, ack := range pendingAcks {
+		ack.batch.acked += ack.count
+		q.ackPos = position{Segment: ack.batch.segment.id, Offset: ack.batch.ends[ack.batch.acked-1]}
+		count += int64(ack.count)
+	}
+
+	q.mutex.Lock()
+	q.queuedEvents -= count
+	q.mutex.Unlock()
+
+	q.stateDirty = true
+
+	if err := q.removeAcknowledged(); err != nil {
+		log.Errorf("Failed to remove acknowledged queue segments: %s", err)
+	}
+}
+
+// removeAcknowledged removes segments that are entirely acknowledged, other
+// than the one currently being written
+func (q *Queue) removeAcknowledged() error {
+	for len(q.segments) > 1 {
+		seg := q.segments[0]
+		if seg.id > q.ackPos.Segment || (seg.id == q.ackPos.Segment && q.ackPos.Offset < seg.size) {
+			break
+		}
+
+		if seg == q.readSegment {
+			q.closeReadFile()
+		}
+
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+
+		q.mutex.Lock()
+		q.segments = q.segments[1:]
+		q.diskBytes -= seg.size
+		q.mutex.Unlock()
+	}
+
+	return nil
+}
+
+// tryWriteState attempts to write the state file and logs any problems
+func (q *Queue) tryWriteState() {
+	if err := q.writeState(); err != nil {
+		log.Errorf("Queue state write failed: %s", err)
+		return
+	}
+
+	q.stateDirty = false
+}
+
+// writeState saves the position of the first unacknowledged event
+func (q *Queue) writeState() error {
+	// Open tmp file, write, flush, rename
+	tname := q.statePath + ".new"
+	file, err := os.Create(tname)
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(file).Encode(&q.ackPos); err == nil && q.cfg.Fsync != FsyncNever {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tname, q.statePath)
+}
+
+// reloadConfig updates the queue configuration after a reload
+func (q *Queue) reloadConfig(cfg *config.Config) {
+	newConfig := FetchConfig(cfg)
+	if !newConfig.Enabled || newConfig.Directory != q.directory {
+		log.Warning("Queue configuration changes to enabled or directory require a restart to take effect")
+	}
+
+	q.mutex.Lock()
+	q.cfg = newConfig
+	q.genConfig = cfg.GeneralPart("spooler").(*spooler.General)
+	q.mutex.Unlock()
+
+	// Policy may have changed to always so sync anything outstanding now
+	if q.cfg.Fsync != FsyncInterval {
+		q.sync()
+	}
+}
+
+// initAPI initialises the queue API entries
+func (q *Queue) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !q.adminConfig.Enabled {
+		return
+	}
+
+	queueAPI := &api.Node{}
+	queueAPI.SetEntry("status", &apiStatus{q: q})
+
+	q.adminConfig.SetEntry("queue", queueAPI)
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+type testAcker struct {
+	acked int
+}
+
+func (a *testAcker) Acknowledge(events []*event.Event) {
+	a.acked += len(events)
+}
+
+func createTestQueue(t *testing.T, dir string, fsync string) *Queue {
+	q := NewQueue(nil)
+	q.cfg = &Config{
+		Directory:      dir,
+		Fsync:          fsync,
+		FsyncInterval:  time.Second,
+		MaxSegmentSize: 1024,
+		MaxSize:        1024 * 1024,
+	}
+	q.genConfig = &spooler.General{SpoolSize: 10, SpoolMaxBytes: 1024 * 1024}
+	q.directory = dir
+	q.statePath = filepath.Join(dir, stateFile)
+	q.syncTimer = time.NewTimer(time.Hour)
+
+	if err := q.loadState(); err != nil {
+		t.Fatalf("Failed to load state: %s", err)
+	}
+	if err := q.loadSegments(); err != nil {
+		t.Fatalf("Failed to load segments: %s", err)
+	}
+
+	return q
+}
+
+func createTestEvents(acker event.Acknowledger, start int, count int) []*event.Event {
+	events := make([]*event.Event, count)
+	for idx := range events {
+		events[idx] = event.NewEvent(context.Background(), acker, map[string]interface{}{"message": fmt.Sprintf("line %d", start+idx)})
+	}
+	return events
+}
+
+func verifyEvents(t *testing.T, events []*event.Event, start int, count int) {
+	if len(events) != count {
+		t.Fatalf("Unexpected event count, got: %d, expected: %d", len(events), count)
+	}
+	for idx, evnt := range events {
+		expected := fmt.Sprintf("line %d", start+idx)
+		if message := evnt.Data()["message"]; message != expected {
+			t.Errorf("Unexpected event %d, got: %v, expected: %s", idx, message, expected)
+		}
+	}
+}
+
+func TestQueueAcknowledgesWhenWritten(t *testing.T) {
+	acker := &testAcker{}
+	q := createTestQueue(t, t.TempDir(), FsyncInterval)
+	defer q.closeWriteFile()
+
+	if err := q.write(createTestEvents(acker, 0, 5)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if acker.acked != 0 {
+		t.Fatalf("Events were acknowledged before being synced")
+	}
+
+	q.sync()
+	if acker.acked != 5 {
+		t.Fatalf("Unexpected acknowledged count, got: %d, expected: 5", acker.acked)
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	acker := &testAcker{}
+
+	q := createTestQueue(t, dir, FsyncAlways)
+	for i := 0; i < 30; i += 10 {
+		if err := q.write(createTestEvents(acker, i, 10)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if acker.acked != 30 {
+		t.Fatalf("Unexpected acknowledged count, got: %d, expected: 30", acker.acked)
+	}
+	if len(q.segments) < 2 {
+		t.Fatalf("Expected multiple segments, got: %d", len(q.segments))
+	}
+
+	// Acknowledge the first spool and half of the second
+	first := q.readSpool()
+	verifyEvents(t, first, 0, 10)
+	second := q.readSpool()
+	verifyEvents(t, second, 10, 10)
+	event.DispatchAck(first)
+	event.DispatchAck(second[:5])
+	q.processAcks()
+
+	if q.queuedEvents != 15 {
+		t.Errorf("Unexpected queued count, got: %d, expected: 15", q.queuedEvents)
+	}
+
+	if err := q.writeState(); err != nil {
+		t.Fatalf("Failed to write state: %s", err)
+	}
+	q.closeWriteFile()
+	q.closeReadFile()
+
+	// Restart and expect the unacknowledged events only
+	q = createTestQueue(t, dir, FsyncAlways)
+	defer q.closeReadFile()
+
+	if q.replayTotal != 15 {
+		t.Errorf("Unexpected replay count, got: %d, expected: 15", q.replayTotal)
+	}
+
+	replayed := q.readSpool()
+	verifyEvents(t, replayed, 15, 5)
+	replayed = q.readSpool()
+	verifyEvents(t, replayed, 20, 10)
+	if q.readSpool() != nil {
+		t.Errorf("Unexpected events after replay")
+	}
+	if q.replayedEvents != 15 {
+		t.Errorf("Unexpected replayed count, got: %d, expected: 15", q.replayedEvents)
+	}
+}
+
+func TestQueuePreservesMetadata(t *testing.T) {
+	acker := &testAcker{}
+	q := createTestQueue(t, t.TempDir(), FsyncAlways)
+	defer q.closeWriteFile()
+	defer q.closeReadFile()
+
+	events := createTestEvents(acker, 0, 2)
+	events[0].MustResolve("@metadata[index]", "logs-courier")
+	events[0].MustResolve("@metadata[receiver][name]", "test")
+	if err := q.write(events); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	read := q.readSpool()
+	verifyEvents(t, read, 0, 2)
+	if index, _ := read[0].Resolve("@metadata[index]", nil); index != "logs-courier" {
+		t.Errorf("Unexpected @metadata[index], got: %v, expected: logs-courier", index)
+	}
+	if name, _ := read[0].Resolve("@metadata[receiver][name]", nil); name != "test" {
+		t.Errorf("Unexpected @metadata[receiver][name], got: %v, expected: test", name)
+	}
+	if _, ok := read[0].Data()["@metadata"].(event.Metadata); !ok {
+		t.Errorf("Unexpected @metadata type: %T", read[0].Data()["@metadata"])
+	}
+	if metadata := read[1].Data()["@metadata"].(event.Metadata); len(metadata) != 0 {
+		t.Errorf("Unexpected @metadata: %v", metadata)
+	}
+	if string(read[0].Bytes()) != string(events[0].Bytes()) {
+		t.Errorf("Unexpected encoding, got: %s, expected: %s", read[0].Bytes(), events[0].Bytes())
+	}
+}
+
+func TestQueueRemovesAcknowledgedSegments(t *testing.T) {
+	acker := &testAcker{}
+	q := createTestQueue(t, t.TempDir(), FsyncNever)
+	defer q.closeWriteFile()
+	defer q.closeReadFile()
+
+	for i := 0; i < 30; i += 10 {
+		if err := q.write(createTestEvents(acker, i, 10)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	for events := q.readSpool(); events != nil; events = q.readSpool() {
+		event.DispatchAck(events)
+	}
+	q.processAcks()
+
+	if len(q.segments) != 1 {
+		t.Errorf("Unexpected segment count, got: %d, expected: 1", len(q.segments))
+	}
+	if q.queuedEvents != 0 {
+		t.Errorf("Unexpected queued count, got: %d, expected: 0", q.queuedEvents)
+	}
+
+	ids, err := listSegments(q.directory)
+	if err != nil {
+		t.Fatalf("Failed to list segments: %s", err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("Unexpected segment files, got: %d, expected: 1", len(ids))
+	}
+}
@@ -0,0 +1,320 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+const (
+	segmentExtension = ".seg"
+
+	// Each record has a uint32 length followed by a uint32 CRC32 of the data
+	recordHeaderSize = 8
+
+	// The data of each record begins with the uint32 length of the encoded
+	// event metadata
+	eventHeaderSize = 4
+)
+
+var (
+	// segmentHeader begins every segment file and identifies its format
+	segmentHeader     = []byte("LCQ2")
+	segmentHeaderSize = int64(len(segmentHeader))
+
+	errCorruptRecord = errors.New("record checksum mismatch")
+	errCorruptEvent  = errors.New("record does not contain a valid event")
+	errEmptySegment  = errors.New("segment is empty")
+)
+
+// segment is a single file within the queue directory containing a sequence
+// of length prefixed and checksummed records, each holding a single encoded
+// event
+type segment struct {
+	id   uint64
+	path string
+	size int64
+}
+
+// segmentPath returns the path of the segment with the given identifier
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentExtension))
+}
+
+// listSegments returns the identifiers of all segments within the given
+// directory in ascending order
+func listSegments(dir string) ([]uint64, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(matches))
+	for _, match := range matches {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(match), segmentExtension), 10, 64)
+		if err != nil {
+			log.Warningf("Ignoring unrecognised file in queue directory: %s", match)
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// createSegment creates a new empty segment and returns it along with the file
+// opened for appending
+func createSegment(dir string, id uint64) (*segment, *os.File, error) {
+	seg := &segment{id: id, path: segmentPath(dir, id)}
+
+	file, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = file.Write(segmentHeader); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	seg.size = segmentHeaderSize
+	return seg, file, nil
+}
+
+// scanSegment verifies an existing segment, returning it along with the
+// number of records that start at or after the given offset
+// If the segment ends with an incomplete or corrupt record, such as after a
+// crash during a write, the segment is truncated to remove it
+func scanSegment(dir string, id uint64, from int64) (*segment, int64, error) {
+	seg := &segment{id: id, path: segmentPath(dir, id)}
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	// A crash immediately after creation can leave a segment without a header
+	if info.Size() < segmentHeaderSize {
+		file.Close()
+		return nil, 0, errEmptySegment
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, segmentHeaderSize)
+	if _, err = io.ReadFull(reader, header); err != nil || !bytes.Equal(header, segmentHeader) {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s is not a valid queue segment", seg.path)
+	}
+
+	seg.size = segmentHeaderSize
+
+	var count int64
+	var recordHeader [recordHeaderSize]byte
+	var data []byte
+	for {
+		if _, err = io.ReadFull(reader, recordHeader[:]); err != nil {
+			break
+		}
+
+		length := binary.BigEndian.Uint32(recordHeader[0:4])
+		if int64(length) > info.Size()-seg.size-recordHeaderSize {
+			err = io.ErrUnexpectedEOF
+			break
+		}
+
+		if cap(data) < int(length) {
+			data = make([]byte, length)
+		}
+		data = data[:length]
+		if _, err = io.ReadFull(reader, data); err != nil {
+			break
+		}
+
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(recordHeader[4:8]) {
+			err = errCorruptRecord
+			break
+		}
+
+		if seg.size >= from {
+			count++
+		}
+		seg.size += recordHeaderSize + int64(length)
+	}
+
+	file.Close()
+
+	if err != io.EOF {
+		log.Warningf("Truncating queue segment %s at offset %d of %d: %s", seg.path, seg.size, info.Size(), err)
+		if err = os.Truncate(seg.path, seg.size); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return seg, count, nil
+}
+
+// encodeRecord appends a record containing the given data to the buffer
+func encodeRecord(buf []byte, data []byte) []byte {
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(data))
+	buf = append(buf, header[:]...)
+	return append(buf, data...)
+}
+
+// encodeEvent encodes an event for storage in a record, along with its
+// "@metadata" which is excluded from the encoded event but must be available
+// to everything that processes the event after the queue
+func encodeEvent(evnt *event.Event) []byte {
+	var metadata []byte
+	if value, ok := evnt.Data()["@metadata"].(event.Metadata); ok && len(value) != 0 {
+		var err error
+		if metadata, err = json.Marshal(value); err != nil {
+			log.Warningf("Discarding event metadata that could not be encoded: %s", err)
+			metadata = nil
+		}
+	}
+
+	body := evnt.Bytes()
+	data := make([]byte, eventHeaderSize, eventHeaderSize+len(metadata)+len(body))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(metadata)))
+	data = append(data, metadata...)
+	return append(data, body...)
+}
+
+// decodeEvent decodes an event stored in a record by encodeEvent, restoring
+// its "@metadata"
+func decodeEvent(ctx context.Context, acker event.Acknowledger, data []byte) (*event.Event, error) {
+	if len(data) < eventHeaderSize {
+		return nil, errCorruptEvent
+	}
+
+	length := int64(binary.BigEndian.Uint32(data[0:4]))
+	if int64(len(data)) < eventHeaderSize+length {
+		return nil, errCorruptEvent
+	}
+
+	evnt := event.NewEventFromBytes(ctx, acker, data[eventHeaderSize+length:])
+	if length == 0 {
+		return evnt, nil
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(data[eventHeaderSize:eventHeaderSize+length], &metadata); err != nil {
+		return nil, err
+	}
+
+	// Metadata is not part of the encoded event so can be restored without
+	// clearing the cached encoding
+	target := evnt.Data()["@metadata"].(event.Metadata)
+	for key, value := range metadata {
+		target[key] = value
+	}
+
+	return evnt, nil
+}
+
+// decodeRecord decodes the record at the start of the given buffer, returning
+// its data and its total length, or a length of 0 if the buffer does not
+// contain the entire record
+func decodeRecord(buf []byte) ([]byte, int64, error) {
+	if len(buf) < recordHeaderSize {
+		return nil, 0, nil
+	}
+
+	length := int64(binary.BigEndian.Uint32(buf[0:4]))
+	if int64(len(buf)) < recordHeaderSize+length {
+		return nil, 0, nil
+	}
+
+	data := buf[recordHeaderSize : recordHeaderSize+length]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(buf[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+
+	return data, recordHeaderSize + length, nil
+}
+
+// readRecords reads up to maxCount records from the segment, starting at the
+// given offset and reading no more than maxBytes unless the first record is
+// itself larger, and returns the data of each along with the offset of the end
+// of each
+func (s *segment) readRecords(file *os.File, offset int64, maxCount int, maxBytes int64) ([][]byte, []int64, error) {
+	length := s.size - offset
+	if length > maxBytes {
+		length = maxBytes
+		if length < recordHeaderSize {
+			length = recordHeaderSize
+		}
+	}
+
+	buf := make([]byte, length)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return nil, nil, err
+	}
+
+	var records [][]byte
+	var ends []int64
+	for len(records) < maxCount {
+		data, recordLength, err := decodeRecord(buf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s at offset %d of %s", err, offset, s.path)
+		}
+
+		if recordLength == 0 {
+			if len(records) != 0 || len(buf) < recordHeaderSize {
+				break
+			}
+
+			// The first record is larger than maxBytes so read it alone
+			buf = make([]byte, recordHeaderSize+int64(binary.BigEndian.Uint32(buf[0:4])))
+			if _, err := file.ReadAt(buf, offset); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		offset += recordLength
+		records = append(records, data)
+		ends = append(ends, offset)
+		buf = buf[recordLength:]
+	}
+
+	return records, ends, nil
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"os"
+	"testing"
+)
+
+func createTestSegment(t *testing.T, dir string, records ...string) *segment {
+	seg, file, err := createSegment(dir, 1)
+	if err != nil {
+		t.Fatalf("Failed to create segment: %s", err)
+	}
+	defer file.Close()
+
+	var buf []byte
+	for _, record := range records {
+		buf = encodeRecord(buf, []byte(record))
+	}
+	if _, err := file.Write(buf); err != nil {
+		t.Fatalf("Failed to write segment: %s", err)
+	}
+	seg.size += int64(len(buf))
+
+	return seg
+}
+
+func verifyRecords(t *testing.T, records [][]byte, expected ...string) {
+	if len(records) != len(expected) {
+		t.Fatalf("Unexpected record count, got: %d, expected: %d", len(records), len(expected))
+	}
+	for idx, record := range records {
+		if string(record) != expected[idx] {
+			t.Errorf("Unexpected record %d, got: %s, expected: %s", idx, record, expected[idx])
+		}
+	}
+}
+
+func TestSegmentReadRecords(t *testing.T) {
+	seg := createTestSegment(t, t.TempDir(), "one", "two", "three")
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %s", err)
+	}
+	defer file.Close()
+
+	records, ends, err := seg.readRecords(file, segmentHeaderSize, 2, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	verifyRecords(t, records, "one", "two")
+
+	records, ends, err = seg.readRecords(file, ends[len(ends)-1], 2, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	verifyRecords(t, records, "three")
+	if ends[0] != seg.size {
+		t.Errorf("Unexpected end offset, got: %d, expected: %d", ends[0], seg.size)
+	}
+}
+
+func TestSegmentReadRecordsMaxBytes(t *testing.T) {
+	seg := createTestSegment(t, t.TempDir(), "one", "two", "three")
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %s", err)
+	}
+	defer file.Close()
+
+	records, _, err := seg.readRecords(file, segmentHeaderSize, 10, recordHeaderSize*2+6)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	verifyRecords(t, records, "one", "two")
+
+	// A single record larger than max bytes should still be read
+	records, _, err = seg.readRecords(file, segmentHeaderSize, 10, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	verifyRecords(t, records, "one")
+}
+
+func TestSegmentScan(t *testing.T) {
+	dir := t.TempDir()
+	seg := createTestSegment(t, dir, "one", "two", "three")
+
+	scanned, count, err := scanSegment(dir, seg.id, segmentHeaderSize+recordHeaderSize+3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if scanned.size != seg.size {
+		t.Errorf("Unexpected size, got: %d, expected: %d", scanned.size, seg.size)
+	}
+	if count != 2 {
+		t.Errorf("Unexpected count, got: %d, expected: 2", count)
+	}
+}
+
+func TestSegmentScanTruncates(t *testing.T) {
+	dir := t.TempDir()
+	seg := createTestSegment(t, dir, "one", "two")
+
+	// Simulate an incomplete write
+	file, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %s", err)
+	}
+	file.Write(encodeRecord(nil, []byte("three"))[:recordHeaderSize+2])
+	file.Close()
+
+	scanned, count, err := scanSegment(dir, seg.id, segmentHeaderSize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if scanned.size != seg.size {
+		t.Errorf("Unexpected size, got: %d, expected: %d", scanned.size, seg.size)
+	}
+	if count != 2 {
+		t.Errorf("Unexpected count, got: %d, expected: 2", count)
+	}
+
+	info, err := os.Stat(seg.path)
+	if err != nil {
+		t.Fatalf("Failed to stat segment: %s", err)
+	}
+	if info.Size() != seg.size {
+		t.Errorf("Segment was not truncated, got: %d, expected: %d", info.Size(), seg.size)
+	}
+}
+
+func TestSegmentScanCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	seg := createTestSegment(t, dir, "one", "two")
+
+	// Corrupt the data of the second record
+	file, err := os.OpenFile(seg.path, os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %s", err)
+	}
+	file.WriteAt([]byte("x"), seg.size-1)
+	file.Close()
+
+	scanned, count, err := scanSegment(dir, seg.id, segmentHeaderSize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Unexpected count, got: %d, expected: 1", count)
+	}
+	if expected := segmentHeaderSize + recordHeaderSize + 3; scanned.size != expected {
+		t.Errorf("Unexpected size, got: %d, expected: %d", scanned.size, expected)
+	}
+}
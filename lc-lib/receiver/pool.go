@@ -416,7 +416,7 @@ func (r *Pool) updateReceivers(newConfig *config.Config) {
 					}
 				}
 				// Create new receiver
-				newReceiversByListen[listen] = cfgEntry.Factory.NewReceiver(context.Background(), listen, r.eventChan)
+				newReceiversByListen[listen] = cfgEntry.Factory.NewReceiver(context.WithValue(context.Background(), transports.ContextReceiverConfig, cfgEntry), listen, r.eventChan)
 				newReceivers[newReceiversByListen[listen]] = &poolReceiverStatus{config: cfgEntry, listen: listen, active: true}
 				receiverApi := &api.KeyValue{}
 				receiverApi.SetEntry("listen", api.String(listen))
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+// CompletionEvent informs the registrar that a compressed file was read to the
+// end, so that it is not read again on restart once all of its events are
+// acknowledged
+type CompletionEvent struct {
+	entry      Entry
+	completion *Completion
+}
+
+// NewCompletionEvent creates a new completion event
+func NewCompletionEvent(entry Entry, completion *Completion) *CompletionEvent {
+	return &CompletionEvent{
+		entry:      entry,
+		completion: completion,
+	}
+}
+
+func (e *CompletionEvent) process(state map[Entry]*FileState) {
+	_, isFound := state[e.entry]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a completion event for %s", *state[e.entry].Source)
+
+	// Update the stored completion
+	state[e.entry].Completion = e.completion
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+// FingerprintEvent informs the registrar of a new or updated fingerprint for a
+// file that needs to be reflected within the state file
+type FingerprintEvent struct {
+	entry       Entry
+	fingerprint *Fingerprint
+}
+
+// NewFingerprintEvent creates a new fingerprint event
+func NewFingerprintEvent(entry Entry, fingerprint *Fingerprint) *FingerprintEvent {
+	return &FingerprintEvent{
+		entry:       entry,
+		fingerprint: fingerprint,
+	}
+}
+
+func (e *FingerprintEvent) process(state map[Entry]*FileState) {
+	_, isFound := state[e.entry]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a fingerprint event for %s", *state[e.entry].Source)
+
+	// Update the stored fingerprint
+	state[e.entry].Fingerprint = e.fingerprint
+}
@@ -38,7 +38,7 @@ func (e *RenamedEvent) process(state map[Entry]*FileState) {
 		return
 	}
 
-	log.Debug("Registrar received a rename event for %s -> %s", state[e.entry].Source, e.source)
+	log.Debug("Registrar received a rename event for %s -> %s", *state[e.entry].Source, e.source)
 
 	// Update the stored file name
 	state[e.entry].Source = &e.source
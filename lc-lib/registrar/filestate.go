@@ -59,8 +59,20 @@ func (fs *FileInfo) Update(fileinfo os.FileInfo, identity *FileIdentity) {
 // more reliable than using the identifiers
 type FileState struct {
 	FileStateOS
-	Source *string `json:"source,omitempty"`
-	Offset int64   `json:"offset,omitempty"`
+	Source      *string      `json:"source,omitempty"`
+	Offset      int64        `json:"offset,omitempty"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+	Completion  *Completion  `json:"completion,omitempty"`
+}
+
+// Completion records that a compressed file was read to the end. Offsets
+// within compressed files are against the decompressed data so cannot be
+// compared with the file size to tell whether a file was completed
+type Completion struct {
+	// Size is the size of the compressed file when it was completed
+	Size int64 `json:"size"`
+	// Offset is the offset within the decompressed data the file ended at
+	Offset int64 `json:"offset"`
 }
 
 // Stat returns nil for a yet to be discovered file
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Fingerprint identifies a file by a hash of the data at its start, allowing
+// it to be recognised where the operating system identifiers are unreliable
+type Fingerprint struct {
+	Hash   string `json:"hash"`
+	Length int64  `json:"length"`
+}
+
+// NewFingerprint returns a Fingerprint for the given data from the start of a
+// file, or nil if there is no data
+func NewFingerprint(data []byte) *Fingerprint {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return &Fingerprint{
+		Hash:   hashFingerprintData(data),
+		Length: int64(len(data)),
+	}
+}
+
+// Matches returns true if the given data from the start of a file begins with
+// the data this Fingerprint was created from
+func (f *Fingerprint) Matches(data []byte) bool {
+	if int64(len(data)) < f.Length {
+		return false
+	}
+	return hashFingerprintData(data[:f.Length]) == f.Hash
+}
+
+// ReadFingerprintData reads up to length bytes from the start of the file at
+// the given path
+func ReadFingerprintData(path string, length int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, length))
+}
+
+// hashFingerprintData returns the hash of data from the start of a file
+func hashFingerprintData(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -194,6 +194,22 @@ RegistrarLoop:
 		}
 	}
 
+	// Process any events that were sent before shutdown so that the final
+	// acknowledgements are saved
+DrainLoop:
+	for {
+		select {
+		case spool := <-r.registrarChan:
+			for _, event := range spool {
+				event.process(r.state)
+			}
+
+			pendingWrite = true
+		default:
+			break DrainLoop
+		}
+	}
+
 	if pendingWrite {
 		r.tryWriteRegistry()
 	}
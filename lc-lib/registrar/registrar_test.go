@@ -0,0 +1,135 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+func createTestAckEvent(entry Entry, endOffset int64) *event.Event {
+	ctx := context.WithValue(context.Background(), ContextEntry, entry)
+	ctx = context.WithValue(ctx, ContextEndOffset, endOffset)
+	return event.NewEvent(ctx, nil, map[string]interface{}{"message": "test"})
+}
+
+func loadTestState(t *testing.T, dir string) map[string]*FileState {
+	data, err := os.ReadFile(filepath.Join(dir, ".log-courier"))
+	if err != nil {
+		t.Fatalf("Failed to read state: %s", err)
+	}
+
+	state := make(map[string]*FileState)
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to parse state: %s", err)
+	}
+	return state
+}
+
+func TestRegistrarDrainsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	if err := os.WriteFile(path, []byte("test\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %s", err)
+	}
+
+	r := NewRegistrar(dir)
+	shutdownChan := make(chan struct{})
+	r.SetShutdownChan(shutdownChan)
+
+	// Events sent before shutdown but not yet received must still be saved
+	entry := "entry"
+	spooler := NewEventSpooler(r)
+	spooler.Add(NewDiscoverEvent(entry, path, 0, fileinfo))
+	spooler.Send()
+	r.Acknowledge([]*event.Event{createTestAckEvent(entry, 5)})
+	close(shutdownChan)
+
+	r.Run()
+
+	state := loadTestState(t, dir)
+	if fileState, ok := state[path]; !ok {
+		t.Errorf("State for %s was not saved", path)
+	} else if fileState.Offset != 5 {
+		t.Errorf("Unexpected offset, got: %d, expected: 5", fileState.Offset)
+	}
+}
+
+func TestNewFingerprintEmpty(t *testing.T) {
+	if fingerprint := NewFingerprint(nil); fingerprint != nil {
+		t.Errorf("Unexpected fingerprint for empty data: %v", fingerprint)
+	}
+}
+
+func TestFingerprintMatches(t *testing.T) {
+	fingerprint := NewFingerprint([]byte("first line\n"))
+	if fingerprint.Length != 11 {
+		t.Errorf("Unexpected length, got: %d, expected: 11", fingerprint.Length)
+	}
+
+	if !fingerprint.Matches([]byte("first line\n")) {
+		t.Errorf("Fingerprint did not match the same data")
+	}
+	if !fingerprint.Matches([]byte("first line\nsecond line\n")) {
+		t.Errorf("Fingerprint did not match data that was appended to")
+	}
+	if fingerprint.Matches([]byte("other line\n")) {
+		t.Errorf("Fingerprint matched different data")
+	}
+	if fingerprint.Matches([]byte("first")) {
+		t.Errorf("Fingerprint matched shorter data")
+	}
+	if fingerprint.Matches(nil) {
+		t.Errorf("Fingerprint matched empty data")
+	}
+}
+
+func TestFileStateFingerprintRoundTrip(t *testing.T) {
+	source := "test.log"
+	state := &FileState{Source: &source, Offset: 5, Fingerprint: NewFingerprint([]byte("first line\n"))}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Failed to encode state: %s", err)
+	}
+
+	decoded := &FileState{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("Failed to decode state: %s", err)
+	}
+	if decoded.Fingerprint == nil || *decoded.Fingerprint != *state.Fingerprint {
+		t.Errorf("Unexpected fingerprint, got: %v, expected: %v", decoded.Fingerprint, state.Fingerprint)
+	}
+
+	// States saved before fingerprints were introduced have none
+	legacy := &FileState{}
+	if err := json.Unmarshal([]byte(`{"source":"test.log","offset":5}`), legacy); err != nil {
+		t.Fatalf("Failed to decode state: %s", err)
+	}
+	if legacy.Fingerprint != nil {
+		t.Errorf("Unexpected fingerprint for legacy state: %v", legacy.Fingerprint)
+	}
+}
@@ -61,6 +61,14 @@ const (
 
 	// ContextReceiver provides the Receiver that a connection relates to
 	ContextReceiver TransportContext = "receiver"
+
+	// ContextConfig provides the network configuration of the output that a
+	// transport was created for
+	ContextConfig TransportContext = "config"
+
+	// ContextReceiverConfig provides the configuration entry of the receiver
+	// that a receiver was created for
+	ContextReceiverConfig TransportContext = "receiverConfig"
 )
 
 // StatusChange holds a value that represents a change in transport status
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/processor"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+const (
+	// DefaultOutputName is the name given to the output created from the
+	// network section when no outputs are configured
+	DefaultOutputName = "default"
+)
+
+// OutputsConfig is the top level section configuration, and is an array of
+// outputs
+type OutputsConfig []*OutputConfigEntry
+
+// Validate the outputs configuration
+func (c OutputsConfig) Validate(p *config.Parser, path string) (err error) {
+	names := make(map[string]bool)
+	for _, entry := range c {
+		if _, exists := names[entry.Name]; exists {
+			err = fmt.Errorf("%s entries must have unique names: %s appears multiple times", path, entry.Name)
+			return
+		}
+		names[entry.Name] = true
+	}
+
+	return nil
+}
+
+// OutputConfigEntry contains configuration for a single output, which is the
+// same as the network section with the addition of a name and a route
+type OutputConfigEntry struct {
+	// These must appear before the embedded Config so that they are not taken
+	// into its Unused field
+	Name  string `config:"name"`
+	Route string `config:"route"`
+
+	*Config `config:",embed"`
+
+	routeProgram cel.Program
+}
+
+// Defaults is a no-op that prevents the Defaults of the embedded Config from
+// being called before the parser has allocated it
+func (c *OutputConfigEntry) Defaults() {
+}
+
+// Init the output configuration
+func (c *OutputConfigEntry) Init(p *config.Parser, path string) (err error) {
+	if c.Route == "" {
+		return nil
+	}
+
+	if c.routeProgram, err = processor.ParseConditionExpression(c.Route); err != nil {
+		return fmt.Errorf("Route failed to parse at %sroute: [%s] -> %s", path, c.Route, err)
+	}
+	return nil
+}
+
+// Validate the output configuration
+func (c *OutputConfigEntry) Validate(p *config.Parser, path string) (err error) {
+	if c.Name == "" {
+		err = fmt.Errorf("%sname is required", path)
+		return
+	}
+
+	return nil
+}
+
+// Routes returns true if the given event should be sent to this output
+func (c *OutputConfigEntry) Routes(subject *event.Event) bool {
+	if c.routeProgram == nil {
+		return true
+	}
+
+	val, _, err := c.routeProgram.Eval(map[string]interface{}{"event": subject.Data()})
+	if err != nil {
+		log.Warningf("Failed to evaluate route for output %s: [%s] -> %s", c.Name, c.Route, err)
+		return false
+	}
+	if val.Type() != types.BoolType {
+		log.Errorf("Route for output %s evaluated to a %s instead of a bool, so the event will not be sent to it: [%s]", c.Name, val.Type().TypeName(), c.Route)
+		return false
+	}
+	return val == types.True
+}
+
+// FetchOutputsConfig returns the outputs configuration from a Config structure
+func FetchOutputsConfig(cfg *config.Config) OutputsConfig {
+	return cfg.Section("outputs").(OutputsConfig)
+}
+
+// FetchOutputs returns the configured outputs, or if none are configured, a
+// single output that routes all events using the network section
+func FetchOutputs(cfg *config.Config) OutputsConfig {
+	outputs := FetchOutputsConfig(cfg)
+	if len(outputs) != 0 {
+		return outputs
+	}
+
+	return OutputsConfig{
+		&OutputConfigEntry{
+			Name:   DefaultOutputName,
+			Config: FetchConfig(cfg),
+		},
+	}
+}
+
+func init() {
+	config.RegisterSection("outputs", func() interface{} {
+		return OutputsConfig{}
+	})
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"context"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+func TestOutputRoute(t *testing.T) {
+	entry := &OutputConfigEntry{Name: "output", Route: "event.type == \"a\""}
+	if err := entry.Init(nil, "/"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !entry.Routes(event.NewEvent(context.Background(), nil, map[string]interface{}{"type": "a"})) {
+		t.Errorf("Matching event was not routed")
+	}
+	if entry.Routes(event.NewEvent(context.Background(), nil, map[string]interface{}{"type": "b"})) {
+		t.Errorf("Non-matching event was routed")
+	}
+}
+
+func TestOutputRouteNotBool(t *testing.T) {
+	// Fields can be of any type so can only be checked during evaluation
+	entry := &OutputConfigEntry{Name: "output", Route: "event.type"}
+	if err := entry.Init(nil, "/"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if entry.Routes(event.NewEvent(context.Background(), nil, map[string]interface{}{"type": "a"})) {
+		t.Errorf("Event was routed by an expression that did not evaluate to a bool")
+	}
+
+	entry = &OutputConfigEntry{Name: "output", Route: "\"a\" + \"b\""}
+	if err := entry.Init(nil, "/"); err == nil {
+		t.Errorf("Expression that does not evaluate to a bool was accepted")
+	}
+}
@@ -17,6 +17,7 @@
 package transports
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/driskell/log-courier/lc-lib/config"
@@ -76,6 +77,17 @@ func FetchReceiversConfig(cfg *config.Config) ReceiverConfig {
 	return cfg.Section("receivers").(ReceiverConfig)
 }
 
+// ReceiverConfigFromContext returns the receiver configuration entry stored in
+// the context, or a default entry if the context does not contain one
+func ReceiverConfigFromContext(ctx context.Context) *ReceiverConfigEntry {
+	if cfgEntry, ok := ctx.Value(ContextReceiverConfig).(*ReceiverConfigEntry); ok {
+		return cfgEntry
+	}
+	ret := &ReceiverConfigEntry{}
+	ret.Defaults()
+	return ret
+}
+
 func init() {
 	config.RegisterSection("receivers", func() interface{} {
 		return ReceiverConfig{}
@@ -17,6 +17,7 @@
 package transports
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -40,20 +41,29 @@ type Config struct {
 	Unused map[string]interface{} `json:",omitempty"`
 }
 
-// Init the transport configuration based on which was chosen
-func (nc *Config) Init(p *config.Parser, path string) (err error) {
-	registrarFunc, ok := registeredTransports[nc.Transport]
-	if !ok {
-		err = fmt.Errorf("unrecognised transport %s", nc.Transport)
-		return
-	}
-
-	nc.Factory, err = registrarFunc(p, path, nc.Unused, nc.Transport)
-	return
+// Defaults sets the default network configuration
+func (nc *Config) Defaults() {
+	nc.Backoff = defaultNetworkBackoff
+	nc.BackoffMax = defaultNetworkBackoffMax
+	nc.MaxPendingPayloads = defaultNetworkMaxPendingPayloads
+	nc.Method = defaultNetworkMethod
+	nc.Rfc2782Service = defaultNetworkRfc2782Service
+	nc.Rfc2782Srv = defaultNetworkRfc2782Srv
+	nc.Timeout = defaultNetworkTimeout
+	nc.Transport = defaultNetworkTransport
 }
 
 // Validate configuration
+// The transport is initialised here rather than during Init as we only know
+// if the network section is in use once the outputs section is available
 func (nc *Config) Validate(p *config.Parser, path string) (err error) {
+	if nc == FetchConfig(p.Config()) && len(FetchOutputsConfig(p.Config())) != 0 {
+		if len(nc.Servers) != 0 {
+			err = fmt.Errorf("%sservers cannot be specified when outputs are configured", path)
+		}
+		return
+	}
+
 	if nc.Method == "" {
 		nc.Method = defaultNetworkMethod
 	}
@@ -62,6 +72,26 @@ func (nc *Config) Validate(p *config.Parser, path string) (err error) {
 		return
 	}
 
+	registrarFunc, ok := registeredTransports[nc.Transport]
+	if !ok {
+		err = fmt.Errorf("unrecognised transport %s", nc.Transport)
+		return
+	}
+
+	nc.Factory, err = registrarFunc(p, path, nc.Unused, nc.Transport)
+	if err != nil {
+		return
+	}
+
+	if localFactory, ok := nc.Factory.(LocalTransportFactory); ok {
+		if len(nc.Servers) == 0 {
+			nc.Servers = []string{localFactory.LocalServer()}
+		} else if len(nc.Servers) > 1 {
+			err = fmt.Errorf("%sservers cannot contain more than one entry when transport is %s", path, nc.Transport)
+		}
+		return
+	}
+
 	if len(nc.Servers) == 0 {
 		err = fmt.Errorf("%sservers is required", path)
 		return
@@ -84,17 +114,17 @@ func FetchConfig(cfg *config.Config) *Config {
 	return cfg.Section("network").(*Config)
 }
 
+// ConfigFromContext returns the network configuration for the output that
+// a transport was created for, falling back to the network section
+func ConfigFromContext(ctx context.Context, cfg *config.Config) *Config {
+	if netConfig, ok := ctx.Value(ContextConfig).(*Config); ok {
+		return netConfig
+	}
+	return FetchConfig(cfg)
+}
+
 func init() {
 	config.RegisterSection("network", func() interface{} {
-		return &Config{
-			Backoff:            defaultNetworkBackoff,
-			BackoffMax:         defaultNetworkBackoffMax,
-			MaxPendingPayloads: defaultNetworkMaxPendingPayloads,
-			Method:             defaultNetworkMethod,
-			Rfc2782Service:     defaultNetworkRfc2782Service,
-			Rfc2782Srv:         defaultNetworkRfc2782Srv,
-			Timeout:            defaultNetworkTimeout,
-			Transport:          defaultNetworkTransport,
-		}
+		return &Config{}
 	})
 }
@@ -179,7 +179,7 @@ func (f *TransportDorisFactory) NewTransport(ctx context.Context, poolEntry *add
 		ctx:          ctx,
 		shutdownFunc: shutdownFunc,
 		config:       f,
-		netConfig:    transports.FetchConfig(f.config),
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
 		poolEntry:    poolEntry,
 		eventChan:    eventChan,
 		clientCache:  make(map[string]*clientCacheItem),
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package es
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+// bulkActionMeta is the metadata of an action line within a bulk request
+type bulkActionMeta struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// bulkItem is a single action received within a bulk request
+type bulkItem struct {
+	action string
+	index  string
+	id     string
+	event  map[string]interface{}
+}
+
+// bulkResponseItem is the result of a single action returned in a bulk
+// response
+type bulkResponseItem struct {
+	Index   string             `json:"_index"`
+	ID      string             `json:"_id,omitempty"`
+	Version int                `json:"_version,omitempty"`
+	Result  string             `json:"result,omitempty"`
+	Status  int                `json:"status"`
+	Error   *bulkResponseError `json:"error,omitempty"`
+}
+
+// bulkResponseBody is the body returned in response to a bulk request
+type bulkResponseBody struct {
+	Took   int64                          `json:"took"`
+	Errors bool                           `json:"errors"`
+	Items  []map[string]*bulkResponseItem `json:"items"`
+}
+
+// decodeBulkItems decodes the action and source pairs of a bulk request
+// Only index and create actions produce events - delete and update actions
+// are accepted but have no event so that they can be reported as failed
+func decodeBulkItems(reader io.Reader, defaultIndex string) ([]*bulkItem, error) {
+	decoder := json.NewDecoder(reader)
+
+	var items []*bulkItem
+	for {
+		var action map[string]*bulkActionMeta
+		if err := decoder.Decode(&action); err != nil {
+			if err == io.EOF {
+				return items, nil
+			}
+			return nil, err
+		}
+		if len(action) != 1 {
+			return nil, fmt.Errorf("malformed action/metadata for item %d, expected a single action", len(items))
+		}
+
+		item := &bulkItem{index: defaultIndex}
+		for name, meta := range action {
+			item.action = name
+			if meta != nil {
+				if meta.Index != "" {
+					item.index = meta.Index
+				}
+				item.id = meta.ID
+			}
+		}
+
+		switch item.action {
+		case "index", "create":
+			if err := decoder.Decode(&item.event); err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("missing source for item %d", len(items))
+				}
+				return nil, err
+			}
+			if item.event == nil {
+				return nil, fmt.Errorf("source for item %d is not an object", len(items))
+			}
+			if item.index == "" {
+				return nil, fmt.Errorf("index is missing for item %d", len(items))
+			}
+		case "update":
+			// Discard the partial document or script that follows
+			var source json.RawMessage
+			if err := decoder.Decode(&source); err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("missing source for item %d", len(items))
+				}
+				return nil, err
+			}
+		case "delete":
+		default:
+			return nil, fmt.Errorf("unknown action [%s] for item %d", item.action, len(items))
+		}
+
+		items = append(items, item)
+	}
+}
+
+// bulkItemEvents returns the events for the given items, with the index and
+// any ID stored in the event metadata, generating IDs for any events that do
+// not have one
+func bulkItemEvents(items []*bulkItem) ([]map[string]interface{}, error) {
+	var events []map[string]interface{}
+	for _, item := range items {
+		if item.event == nil {
+			continue
+		}
+
+		metadata := event.Metadata{"index": item.index}
+		if item.id == "" {
+			id, err := generateID()
+			if err != nil {
+				return nil, err
+			}
+			item.id = id
+		} else {
+			metadata["id"] = item.id
+		}
+		item.event["@metadata"] = metadata
+
+		events = append(events, item.event)
+	}
+	return events, nil
+}
+
+// newBulkResponseBody returns the response to the given items once all events
+// have been acknowledged
+func newBulkResponseBody(items []*bulkItem, took int64) *bulkResponseBody {
+	ret := &bulkResponseBody{
+		Took:  took,
+		Items: make([]map[string]*bulkResponseItem, 0, len(items)),
+	}
+
+	for _, item := range items {
+		result := &bulkResponseItem{Index: item.index, ID: item.id}
+		if item.event == nil {
+			ret.Errors = true
+			result.Status = 400
+			result.Error = &bulkResponseError{
+				Type:   "illegal_argument_exception",
+				Reason: fmt.Sprintf("%s action is not supported", item.action),
+			}
+		} else {
+			result.Version = 1
+			result.Result = "created"
+			result.Status = 201
+		}
+		ret.Items = append(ret.Items, map[string]*bulkResponseItem{item.action: result})
+	}
+
+	return ret
+}
+
+// generateID returns a random ID of the same length as automatically generated
+// Elasticsearch IDs
+func generateID() (string, error) {
+	var data [15]byte
+	if _, err := rand.Read(data[:]); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data[:]), nil
+}
@@ -27,7 +27,7 @@ import (
 type bulkResponseError struct {
 	Type      string                 `json:"type"`
 	Reason    string                 `json:"reason"`
-	CausedBy  *bulkResponseError     `json:"caused_by"`
+	CausedBy  *bulkResponseError     `json:"caused_by,omitempty"`
 	EventData map[string]interface{} `json:"-"`
 }
 
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package es
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/webhook"
+)
+
+const (
+	// compatibleVersion is the Elasticsearch version reported to clients that
+	// check it before sending
+	compatibleVersion = "7.10.2"
+)
+
+// receiverES implements a receiver that accepts Elasticsearch bulk requests,
+// with each request treated as a separate connection that receives a
+// response only once all of its events are acknowledged
+type receiverES struct {
+	*webhook.ReceiverBase
+
+	config *ReceiverESFactory
+}
+
+// Factory returns the associated factory
+func (t *receiverES) Factory() transports.ReceiverFactory {
+	return t.config
+}
+
+// ServeHTTP routes a request to the bulk handler, or responds with cluster
+// information so that clients which check the version are able to connect
+func (t *receiverES) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !t.isAuthorised(req) {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"security\" charset=\"UTF-8\"")
+		t.respondError(w, req, http.StatusUnauthorized, "security_exception", errors.New("unable to authenticate user"))
+		return
+	}
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "":
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			t.respondMethodNotAllowed(w, req, http.MethodGet, http.MethodHead)
+			return
+		}
+		t.serveInfo(w)
+	case len(segments) == 1 && segments[0] == "_bulk":
+		t.serveBulk(w, req, "")
+	case len(segments) == 2 && segments[1] == "_bulk" && segments[0] != "":
+		t.serveBulk(w, req, segments[0])
+	default:
+		t.respondError(w, req, http.StatusNotFound, "not_found_exception", fmt.Errorf("no handler found for uri [%s] and method [%s]", req.URL.Path, req.Method))
+	}
+}
+
+// serveInfo responds with the cluster information
+func (t *receiverES) serveInfo(w http.ResponseWriter) {
+	t.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"name":         "log-carver",
+		"cluster_name": "log-carver",
+		"version": map[string]interface{}{
+			"number":                              compatibleVersion,
+			"build_flavor":                        "default",
+			"lucene_version":                      "8.7.0",
+			"minimum_wire_compatibility_version":  "6.8.0",
+			"minimum_index_compatibility_version": "6.0.0-beta1",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// serveBulk handles a bulk request, passing its events through the pipeline
+// and responding once they are acknowledged
+func (t *receiverES) serveBulk(w http.ResponseWriter, req *http.Request, defaultIndex string) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		t.respondMethodNotAllowed(w, req, http.MethodPost, http.MethodPut)
+		return
+	}
+
+	start := time.Now()
+
+	items, size, status, err := t.readItems(req, defaultIndex)
+	if err != nil {
+		t.respondError(w, req, status, "parse_exception", err)
+		return
+	}
+
+	events, err := bulkItemEvents(items)
+	if err != nil {
+		t.respondError(w, req, http.StatusInternalServerError, "exception", err)
+		return
+	}
+
+	if len(events) != 0 {
+		if status, err := t.ProcessEvents(req, events, size); err != nil {
+			errType := "unavailable_shards_exception"
+			if status == http.StatusTooManyRequests {
+				errType = "es_rejected_execution_exception"
+			}
+			t.respondError(w, req, status, errType, err)
+			return
+		}
+	}
+
+	t.respondJSON(w, http.StatusOK, newBulkResponseBody(items, time.Since(start).Milliseconds()))
+}
+
+// respondJSON encodes the given body as the response
+func (t *receiverES) respondJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Warningf("[R %s] Failed to write response: %s", t.Bind(), err)
+	}
+}
+
+// respondMethodNotAllowed responds that the request method is not supported
+func (t *receiverES) respondMethodNotAllowed(w http.ResponseWriter, req *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	t.respondError(w, req, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Errorf("incorrect HTTP method for uri [%s] and method [%s], allowed: %s", req.URL.Path, req.Method, allowed))
+}
+
+// respondError logs a failed request and returns the error to the client in
+// the same form Elasticsearch does
+func (t *receiverES) respondError(w http.ResponseWriter, req *http.Request, status int, errType string, err error) {
+	log.Warningf("[R %s - %s] Request failed with status %d: %s", t.Bind(), req.RemoteAddr, status, err)
+	t.respondJSON(w, status, map[string]interface{}{
+		"error":  &bulkResponseError{Type: errType, Reason: err.Error()},
+		"status": status,
+	})
+}
+
+// isAuthorised checks the request's basic authentication if a username is
+// configured
+func (t *receiverES) isAuthorised(req *http.Request) bool {
+	if t.config.Username == "" {
+		return true
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(t.config.Username))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(t.config.Password))
+	return usernameMatch&passwordMatch == 1
+}
+
+// readItems reads and decodes the request body, returning the items and the
+// size of the body, or the status code and error to respond with
+func (t *receiverES) readItems(req *http.Request, defaultIndex string) ([]*bulkItem, int, int, error) {
+	reader, status, err := webhook.ReadRequestBody(req, t.config.MaxRequestSize)
+	if err != nil {
+		return nil, 0, status, err
+	}
+
+	items, err := decodeBulkItems(reader, defaultIndex)
+	if err != nil {
+		return nil, 0, webhook.RequestErrorStatus(err), err
+	}
+
+	return items, reader.BytesRead(), http.StatusOK, nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/webhook"
+)
+
+func createTestReceiver(t *testing.T, acknowledge bool) (*receiverES, <-chan map[string]interface{}) {
+	factory := &ReceiverESFactory{transport: TransportES, ServerTlsConfiguration: &transports.ServerTlsConfiguration{TlsConfiguration: &transports.TlsConfiguration{}}}
+	factory.Defaults()
+	if err := factory.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	ctx := context.WithValue(context.Background(), transports.ContextReceiverConfig, &transports.ReceiverConfigEntry{MaxPendingPayloads: 10})
+	receiver := &receiverES{config: factory}
+	receiver.ReceiverBase = webhook.NewReceiverBase(ctx, receiver, factory.ServerTlsConfiguration, false, "test", eventChan)
+
+	// Act as the receiver pool, acknowledging all events and then closing the
+	// connection once the end is received
+	receivedChan := make(chan map[string]interface{}, 10)
+	go func() {
+		for evnt := range eventChan {
+			switch eventImpl := evnt.(type) {
+			case transports.EventsEvent:
+				for _, data := range eventImpl.Events() {
+					receivedChan <- data
+				}
+				if acknowledge {
+					receiver.Acknowledge(eventImpl.Context(), eventImpl.Nonce(), eventImpl.Count())
+				} else {
+					receiver.ShutdownConnectionRead(eventImpl.Context(), errors.New("max queue size exceeded"))
+				}
+			case *transports.EndEvent:
+				receiver.ShutdownConnection(eventImpl.Context())
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(eventChan)
+	})
+
+	return receiver, receivedChan
+}
+
+func sendTestRequest(receiver *receiverES, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestReceiverESBulk(t *testing.T) {
+	receiver, receivedChan := createTestReceiver(t, true)
+
+	body := "{\"index\":{}}\n{\"message\":\"one\"}\n{\"create\":{\"_index\":\"other\",\"_id\":\"abc\"}}\n{\"message\":\"two\"}\n{\"delete\":{\"_id\":\"def\"}}\n"
+	recorder := sendTestRequest(receiver, http.MethodPost, "/logs/_bulk", body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status, got: %d, expected: %d (%s)", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+
+	var response struct {
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %s", err)
+	}
+	if !response.Errors || len(response.Items) != 3 {
+		t.Fatalf("Unexpected response: %s", recorder.Body.String())
+	}
+	if item := response.Items[0]["index"]; item["_index"] != "logs" || item["status"] != float64(201) || item["_id"] == "" {
+		t.Errorf("Unexpected first item: %v", response.Items[0])
+	}
+	if item := response.Items[1]["create"]; item["_index"] != "other" || item["status"] != float64(201) || item["_id"] != "abc" {
+		t.Errorf("Unexpected second item: %v", response.Items[1])
+	}
+	if item := response.Items[2]["delete"]; item["status"] != float64(400) || item["error"] == nil {
+		t.Errorf("Unexpected third item: %v", response.Items[2])
+	}
+
+	first := event.NewEvent(context.Background(), nil, <-receivedChan)
+	if index := first.MustResolve("@metadata[index]", nil); index != "logs" {
+		t.Errorf("Unexpected index for first event: %v", index)
+	}
+	second := event.NewEvent(context.Background(), nil, <-receivedChan)
+	if index := second.MustResolve("@metadata[index]", nil); index != "other" {
+		t.Errorf("Unexpected index for second event: %v", index)
+	}
+	if id := second.MustResolve("@metadata[id]", nil); id != "abc" {
+		t.Errorf("Unexpected id for second event: %v", id)
+	}
+}
+
+func TestReceiverESRejected(t *testing.T) {
+	receiver, _ := createTestReceiver(t, false)
+
+	recorder := sendTestRequest(receiver, http.MethodPost, "/_bulk", "{\"index\":{\"_index\":\"logs\"}}\n{\"message\":\"one\"}\n")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReceiverESMissingIndex(t *testing.T) {
+	receiver, _ := createTestReceiver(t, true)
+
+	recorder := sendTestRequest(receiver, http.MethodPost, "/_bulk", "{\"index\":{}}\n{\"message\":\"one\"}\n")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestReceiverESInfo(t *testing.T) {
+	receiver, _ := createTestReceiver(t, true)
+
+	recorder := sendTestRequest(receiver, http.MethodGet, "/", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), compatibleVersion) {
+		t.Errorf("Unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = sendTestRequest(receiver, http.MethodGet, "/_bulk", "")
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+
+	recorder = sendTestRequest(receiver, http.MethodGet, "/logs/_search", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestReceiverESBasicAuth(t *testing.T) {
+	receiver, _ := createTestReceiver(t, true)
+	receiver.config.Username = "user"
+	receiver.config.Password = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", "wrong")
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", "secret")
+	recorder = httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestDecodeBulkItems(t *testing.T) {
+	if _, err := decodeBulkItems(strings.NewReader("{\"index\":{}}\n"), "logs"); err == nil {
+		t.Errorf("Missing source did not fail")
+	}
+
+	if _, err := decodeBulkItems(strings.NewReader("{\"index\":{},\"create\":{}}\n{}\n"), "logs"); err == nil {
+		t.Errorf("Multiple actions did not fail")
+	}
+
+	if _, err := decodeBulkItems(strings.NewReader("{\"upsert\":{}}\n{}\n"), "logs"); err == nil {
+		t.Errorf("Unknown action did not fail")
+	}
+
+	items, err := decodeBulkItems(strings.NewReader("{\"update\":{\"_id\":\"a\"}}\n{\"doc\":{}}\n{\"index\":{}}\n{\"a\":1}\n"), "logs")
+	if err != nil || len(items) != 2 || items[0].event != nil || items[1].event == nil {
+		t.Errorf("Unexpected result: %v (%v)", items, err)
+	}
+}
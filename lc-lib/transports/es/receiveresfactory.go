@@ -0,0 +1,107 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package es
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/webhook"
+)
+
+const (
+	defaultMaxRequestSize int64 = 10485760
+)
+
+// ReceiverESFactory holds the configuration from the configuration file
+// It allows creation of ReceiverES instances that use this configuration
+type ReceiverESFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	MaxRequestSize int64  `config:"max request size"`
+	Password       string `config:"password"`
+	Username       string `config:"username"`
+
+	*transports.ServerTlsConfiguration `config:",embed"`
+}
+
+// NewReceiverESFactory create a new ReceiverESFactory from the provided
+// configuration data, reporting back any configuration errors it discovers
+func NewReceiverESFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.ReceiverFactory, error) {
+	ret := &ReceiverESFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *ReceiverESFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.MaxRequestSize < 1 {
+		return fmt.Errorf("%smax request size must be greater than 0", configPath)
+	}
+	if f.Password != "" && f.Username == "" {
+		return fmt.Errorf("%spassword requires %susername to be set", configPath, configPath)
+	}
+
+	return f.ServerTlsConfiguration.TlsValidate(f.transport == TransportESHTTPS, p, configPath)
+}
+
+// Defaults sets the default configuration values
+func (f *ReceiverESFactory) Defaults() {
+	f.MaxRequestSize = defaultMaxRequestSize
+}
+
+// NewReceiver returns a new Receiver interface using the settings from the
+// ReceiverESFactory
+func (f *ReceiverESFactory) NewReceiver(ctx context.Context, bind string, eventChan chan<- transports.Event) transports.Receiver {
+	ret := &receiverES{config: f}
+	ret.ReceiverBase = webhook.NewReceiverBase(ctx, ret, f.ServerTlsConfiguration, f.transport == TransportESHTTPS, bind, eventChan)
+	ret.Start()
+	return ret
+}
+
+// ShouldRestart returns true if the receiver needs to be restarted in order
+// for the new configuration to apply
+func (f *ReceiverESFactory) ShouldRestart(newFactory transports.ReceiverFactory) bool {
+	newFactoryImpl := newFactory.(*ReceiverESFactory)
+	if newFactoryImpl.MaxRequestSize != f.MaxRequestSize {
+		return true
+	}
+	if newFactoryImpl.Password != f.Password {
+		return true
+	}
+	if newFactoryImpl.Username != f.Username {
+		return true
+	}
+
+	return f.ServerTlsConfiguration.HasChanged(newFactoryImpl.ServerTlsConfiguration)
+}
+
+// Register the receivers
+func init() {
+	transports.RegisterReceiver(TransportES, NewReceiverESFactory)
+	transports.RegisterReceiver(TransportESHTTPS, NewReceiverESFactory)
+}
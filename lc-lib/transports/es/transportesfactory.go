@@ -150,7 +150,7 @@ func (f *TransportESFactory) NewTransport(ctx context.Context, poolEntry *addres
 		ctx:          ctx,
 		shutdownFunc: shutdownFunc,
 		config:       f,
-		netConfig:    transports.FetchConfig(f.config),
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
 		poolEntry:    poolEntry,
 		eventChan:    eventChan,
 		clientCache:  make(map[string]*clientCacheItem),
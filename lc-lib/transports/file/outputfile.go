@@ -0,0 +1,203 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// rotatedSuffixFormat is appended to the path of a file when it is rotated
+	rotatedSuffixFormat = "20060102150405"
+
+	compressedSuffix = ".gz"
+)
+
+var newLine = []byte("\n")
+
+// outputFile is a single open file that events are appended to
+type outputFile struct {
+	path     string
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	opened   time.Time
+	lastUsed time.Time
+}
+
+// openOutputFile opens the file at the given path for appending, creating it
+// and its parent directories if necessary
+func openOutputFile(path string) (*outputFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	now := time.Now()
+	return &outputFile{
+		path:     path,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		size:     info.Size(),
+		opened:   now,
+		lastUsed: now,
+	}, nil
+}
+
+// Write appends the data to the file followed by a new line
+func (f *outputFile) Write(data []byte) error {
+	if _, err := f.writer.Write(data); err != nil {
+		return err
+	}
+	if _, err := f.writer.Write(newLine); err != nil {
+		return err
+	}
+	f.size += int64(len(data)) + 1
+	f.lastUsed = time.Now()
+	return nil
+}
+
+// Flush writes any buffered data to the file, and syncs it to disk if
+// requested
+func (f *outputFile) Flush(sync bool) error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	if sync {
+		return f.file.Sync()
+	}
+	return nil
+}
+
+// Discard drops any buffered data and truncates the file back to the given
+// size, removing anything written since the file was that size
+func (f *outputFile) Discard(size int64) error {
+	f.writer.Reset(f.file)
+	if err := os.Truncate(f.path, size); err != nil {
+		return err
+	}
+	f.size = size
+	return nil
+}
+
+// Close flushes and closes the file
+func (f *outputFile) Close() error {
+	err := f.writer.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Rotate closes the file and renames it with a timestamp suffix so that
+// subsequent writes to the path begin a new file, returning the new path of
+// the closed file
+func (f *outputFile) Rotate(sync bool) (string, error) {
+	if err := f.Flush(sync); err != nil {
+		f.file.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	rotatedPath, err := rotatedPath(f.path, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(f.path, rotatedPath); err != nil {
+		return "", err
+	}
+
+	return rotatedPath, nil
+}
+
+// rotatedPath returns an unused path to rename a rotated file to
+func rotatedPath(path string, now time.Time) (string, error) {
+	base := path + "." + now.Format(rotatedSuffixFormat)
+	candidate := base
+	for index := 1; ; index++ {
+		if !pathExists(candidate) && !pathExists(candidate+compressedSuffix) {
+			return candidate, nil
+		}
+		if index == 1000 {
+			return "", fmt.Errorf("unable to find an unused path to rotate %s to", path)
+		}
+		candidate = fmt.Sprintf("%s.%d", base, index)
+	}
+}
+
+// pathExists returns true if something exists at the given path
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// compressFile compresses the file at the given path using gzip, removing the
+// original once complete
+func compressFile(path string) (err error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	compressedPath := path + compressedSuffix
+	target, err := os.OpenFile(compressedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			target.Close()
+			os.Remove(compressedPath)
+		}
+	}()
+
+	gzipWriter := gzip.NewWriter(target)
+	if _, err = io.Copy(gzipWriter, source); err != nil {
+		return err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return err
+	}
+	if err = target.Sync(); err != nil {
+		return err
+	}
+	if err = target.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,370 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	// maintenanceInterval is how often open files are checked for rotation
+	// and idle timeout
+	maintenanceInterval = 10 * time.Second
+)
+
+// payload contains nonce and events information
+type payload struct {
+	nonce  *string
+	events []*event.Event
+}
+
+// transportFile implements a transport that writes events to local files
+type transportFile struct {
+	// Constructor
+	ctx          context.Context
+	shutdownFunc context.CancelFunc
+	config       *TransportFileFactory
+	netConfig    *transports.Config
+	poolEntry    *addresspool.PoolEntry
+	eventChan    chan<- transports.Event
+	files        map[string]*outputFile
+
+	// Internal
+	payloadChan  chan *payload
+	payloadMutex sync.Mutex
+	compressWait sync.WaitGroup
+}
+
+// Factory returns the associated factory
+func (t *transportFile) Factory() transports.TransportFactory {
+	return t.config
+}
+
+// startController starts the controller
+func (t *transportFile) startController() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine is the master routine which handles writing
+func (t *transportFile) controllerRoutine() {
+	defer func() {
+		// Close all files and wait for any compression to complete
+		t.closeAll()
+		t.compressWait.Wait()
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	// Setup payload chan with max write count of pending payloads
+	t.payloadMutex.Lock()
+	t.payloadChan = make(chan *payload, t.netConfig.MaxPendingPayloads)
+	t.payloadMutex.Unlock()
+
+	t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Started, nil)
+
+	t.writeRoutine()
+
+	// Ensure all resources for the cancel are cleaned up
+	t.shutdownFunc()
+}
+
+// writeRoutine writes received payloads to files
+func (t *transportFile) writeRoutine() {
+	backoffName := fmt.Sprintf("%s Retry", t.poolEntry.Server)
+	backoff := core.NewExpBackoff(backoffName, t.config.Retry, t.config.RetryMax)
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case <-ticker.C:
+			t.maintainFiles()
+		case payload := <-t.payloadChan:
+			if payload == nil {
+				// Graceful shutdown
+				log.Infof("[T %s] File routine stopped gracefully", t.poolEntry.Server)
+				return
+			}
+
+			eventsByPath := t.groupByPath(payload.events)
+			for {
+				err := t.writeEvents(eventsByPath)
+				if err == nil {
+					break
+				}
+
+				log.Errorf("[T %s] Failed to write events: %s", t.poolEntry.Server, err)
+
+				if t.retryWait(backoff) {
+					return
+				}
+			}
+
+			select {
+			case <-t.ctx.Done():
+				// Forced failure
+				return
+			case t.eventChan <- transports.NewAckEvent(t.ctx, payload.nonce, uint32(len(payload.events))):
+			}
+		}
+	}
+}
+
+// groupByPath groups the events by the path they are to be written to,
+// discarding any events for which the path could not be determined
+func (t *transportFile) groupByPath(events []*event.Event) map[string][]*event.Event {
+	if t.config.pathPattern.IsStatic() {
+		return map[string][]*event.Event{t.config.PathPattern: events}
+	}
+
+	eventsByPath := make(map[string][]*event.Event)
+	for _, evnt := range events {
+		path, err := t.config.pathPattern.Format(evnt)
+		if err != nil {
+			log.Warningf("[T %s] Discarding event for which the path could not be determined: %s", t.poolEntry.Server, err)
+			continue
+		}
+		eventsByPath[path] = append(eventsByPath[path], evnt)
+	}
+	return eventsByPath
+}
+
+// writeEvents writes each group of events to its path, removing each group
+// once it has been successfully written and flushed, and removing the events
+// from a group that were written to a file that was since rotated, so that a
+// retry after a failure does not write them again
+func (t *transportFile) writeEvents(eventsByPath map[string][]*event.Event) error {
+	for path, events := range eventsByPath {
+		if written, err := t.writeFile(path, events); err != nil {
+			eventsByPath[path] = events[written:]
+
+			// Discard the file so it is reopened on retry
+			if file, ok := t.files[path]; ok {
+				file.Close()
+				delete(t.files, path)
+			}
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		delete(eventsByPath, path)
+	}
+	return nil
+}
+
+// writeFile writes events to the file at the given path, rotating it when it
+// reaches the configured size, and then flushes it. If it fails, anything
+// written to the current file is removed, and the number of events that were
+// written to files that were rotated before the failure is returned
+func (t *transportFile) writeFile(path string, events []*event.Event) (int, error) {
+	file, err := t.getFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	start := file.size
+	for idx, evnt := range events {
+		data := evnt.Bytes()
+		if t.config.RotateSize != 0 && file.size != 0 && file.size+int64(len(data))+1 > t.config.RotateSize {
+			if _, err := t.rotateFile(file, false); err != nil {
+				t.discardWrites(file, start)
+				return written, err
+			}
+
+			// Events written before the rotation are now complete
+			written = idx
+			if file, err = t.getFile(path); err != nil {
+				return written, err
+			}
+			start = file.size
+		}
+
+		if err := file.Write(data); err != nil {
+			t.discardWrites(file, start)
+			return written, err
+		}
+	}
+
+	if err := file.Flush(t.config.Fsync); err != nil {
+		t.discardWrites(file, start)
+		return written, err
+	}
+
+	return len(events), nil
+}
+
+// discardWrites removes the data written to a file since it was the given size
+// after a failure, so that the events are not written twice when retried
+func (t *transportFile) discardWrites(file *outputFile, size int64) {
+	if err := file.Discard(size); err != nil {
+		log.Errorf("[T %s] Failed to remove partially written events from %s, they may be duplicated on retry: %s", t.poolEntry.Server, file.path, err)
+	}
+}
+
+// getFile returns the open file for the given path, opening it if necessary
+func (t *transportFile) getFile(path string) (*outputFile, error) {
+	if file, ok := t.files[path]; ok {
+		return file, nil
+	}
+
+	file, err := openOutputFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("[T %s] Opened %s", t.poolEntry.Server, path)
+	t.files[path] = file
+	return file, nil
+}
+
+// rotateFile rotates the given file, compressing it if configured to do so,
+// and reopens the path if requested
+func (t *transportFile) rotateFile(file *outputFile, reopen bool) (*outputFile, error) {
+	delete(t.files, file.path)
+
+	rotatedPath, err := file.Rotate(t.config.Fsync)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("[T %s] Rotated %s to %s", t.poolEntry.Server, file.path, rotatedPath)
+
+	if t.config.Compress {
+		t.compressWait.Add(1)
+		go func() {
+			defer t.compressWait.Done()
+			if err := compressFile(rotatedPath); err != nil {
+				log.Errorf("[T %s] Failed to compress %s: %s", t.poolEntry.Server, rotatedPath, err)
+				return
+			}
+			log.Debugf("[T %s] Compressed %s", t.poolEntry.Server, rotatedPath)
+		}()
+	}
+
+	if !reopen {
+		return nil, nil
+	}
+
+	return t.getFile(file.path)
+}
+
+// maintainFiles rotates files that have reached the rotate interval and
+// closes files that have been idle for longer than the idle timeout
+// When a rotate interval is configured idle files are left open until they
+// are rotated
+func (t *transportFile) maintainFiles() {
+	now := time.Now()
+	for path, file := range t.files {
+		if t.config.RotateInterval != 0 {
+			if now.Sub(file.opened) >= t.config.RotateInterval {
+				if _, err := t.rotateFile(file, false); err != nil {
+					log.Errorf("[T %s] Failed to rotate %s: %s", t.poolEntry.Server, path, err)
+				}
+			}
+			continue
+		}
+
+		if now.Sub(file.lastUsed) >= t.config.IdleTimeout {
+			log.Debugf("[T %s] Closing idle file %s", t.poolEntry.Server, path)
+			if err := file.Close(); err != nil {
+				log.Errorf("[T %s] Failed to close %s: %s", t.poolEntry.Server, path, err)
+			}
+			delete(t.files, path)
+		}
+	}
+}
+
+// closeAll closes all open files
+func (t *transportFile) closeAll() {
+	for path, file := range t.files {
+		if err := file.Close(); err != nil {
+			log.Errorf("[T %s] Failed to close %s: %s", t.poolEntry.Server, path, err)
+		}
+		delete(t.files, path)
+	}
+}
+
+// retryWait waits the backoff timeout before attempting to retry
+// It also monitors for shutdown whilst waiting
+func (t *transportFile) retryWait(backoff *core.ExpBackoff) bool {
+	now := time.Now()
+	retryDue := now.Add(backoff.Trigger())
+
+	select {
+	case <-t.ctx.Done():
+		// Shutdown request
+		return true
+	case <-time.After(retryDue.Sub(now)):
+	}
+
+	return false
+}
+
+// SendEvents sends events to the transport - only valid after Started transport event received
+func (t *transportFile) SendEvents(nonce string, events []*event.Event) error {
+	// Are we ready?
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		return transports.ErrInvalidState
+	}
+	t.payloadChan <- &payload{&nonce, events}
+	return nil
+}
+
+// Ping the transport - there is no remote server so immediately respond with
+// a pong
+func (t *transportFile) Ping() error {
+	go func() {
+		log.Debugf("[T %s] Responding with pong", t.poolEntry.Server)
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case t.eventChan <- transports.NewPongEvent(t.ctx):
+		}
+	}()
+	return nil
+}
+
+// Fail the transport
+func (t *transportFile) Fail() {
+	t.shutdownFunc()
+}
+
+// Shutdown the transport - only valid after Started transport event received
+func (t *transportFile) Shutdown() {
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		// Not yet started so just fail
+		t.shutdownFunc()
+	} else {
+		// Trigger graceful shutdown
+		close(t.payloadChan)
+	}
+}
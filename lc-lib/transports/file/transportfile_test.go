@@ -0,0 +1,233 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createTestFactory(pathPattern string) *TransportFileFactory {
+	f := &TransportFileFactory{transport: TransportFile}
+	f.Defaults()
+	f.PathPattern = pathPattern
+	return f
+}
+
+func createTestTransport(t *testing.T, f *TransportFileFactory) (transports.Transport, chan transports.Event) {
+	if err := f.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+
+	pool, err := addresspool.GeneratePool([]string{f.LocalServer()}, false, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate pool: %s", err)
+	}
+
+	netConfig := &transports.Config{}
+	netConfig.Defaults()
+	ctx := context.WithValue(context.Background(), transports.ContextConfig, netConfig)
+
+	eventChan := make(chan transports.Event, 10)
+	transport := f.NewTransport(ctx, pool[0], eventChan)
+	expectStatus(t, eventChan, transports.Started)
+	return transport, eventChan
+}
+
+func createTestEvents(hosts ...string) []*event.Event {
+	events := make([]*event.Event, len(hosts))
+	for idx, host := range hosts {
+		events[idx] = event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "test", "host": host})
+	}
+	return events
+}
+
+func receiveEvent(t *testing.T, eventChan <-chan transports.Event) transports.Event {
+	select {
+	case evnt := <-eventChan:
+		return evnt
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for transport event")
+	}
+	return nil
+}
+
+func expectStatus(t *testing.T, eventChan <-chan transports.Event, expected transports.StatusChange) {
+	evnt := receiveEvent(t, eventChan)
+	status, ok := evnt.(*transports.StatusEvent)
+	if !ok {
+		t.Fatalf("Unexpected transport event: %T", evnt)
+	}
+	if status.StatusChange() != expected {
+		t.Fatalf("Unexpected status change, got: %d, expected: %d", status.StatusChange(), expected)
+	}
+}
+
+func expectAck(t *testing.T, eventChan <-chan transports.Event, expected uint32) {
+	evnt := receiveEvent(t, eventChan)
+	ack, ok := evnt.(transports.AckEvent)
+	if !ok {
+		t.Fatalf("Unexpected transport event: %T", evnt)
+	}
+	if ack.Sequence() != expected {
+		t.Fatalf("Unexpected sequence, got: %d, expected: %d", ack.Sequence(), expected)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", path, err)
+	}
+	return strings.Count(string(content), "\n")
+}
+
+func TestTransportFileWritesByPath(t *testing.T) {
+	dir := t.TempDir()
+	transport, eventChan := createTestTransport(t, createTestFactory(filepath.Join(dir, "%{host}", "events.ndjson")))
+
+	if err := transport.SendEvents("nonce", createTestEvents("a", "b", "a")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectAck(t, eventChan, 3)
+
+	transport.Shutdown()
+	expectStatus(t, eventChan, transports.Finished)
+
+	if lines := countLines(t, filepath.Join(dir, "a", "events.ndjson")); lines != 2 {
+		t.Errorf("Unexpected line count for a, got: %d, expected: 2", lines)
+	}
+	if lines := countLines(t, filepath.Join(dir, "b", "events.ndjson")); lines != 1 {
+		t.Errorf("Unexpected line count for b, got: %d, expected: 1", lines)
+	}
+}
+
+func TestTransportFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.ndjson")
+
+	events := createTestEvents("a", "a", "a")
+	factory := createTestFactory(path)
+	factory.RotateSize = int64(len(events[0].Bytes())+1) * 2
+	factory.Compress = true
+	transport, eventChan := createTestTransport(t, factory)
+
+	if err := transport.SendEvents("nonce", events); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectAck(t, eventChan, 3)
+
+	transport.Shutdown()
+	expectStatus(t, eventChan, transports.Finished)
+
+	if lines := countLines(t, path); lines != 1 {
+		t.Errorf("Unexpected line count for current file, got: %d, expected: 1", lines)
+	}
+
+	rotated, err := filepath.Glob(path + ".*" + compressedSuffix)
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("Unexpected rotated files: %v (%v)", rotated, err)
+	}
+
+	content, err := os.ReadFile(rotated[0])
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", rotated[0], err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to decompress %s: %s", rotated[0], err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress %s: %s", rotated[0], err)
+	}
+	if lines := strings.Count(string(decompressed), "\n"); lines != 2 {
+		t.Errorf("Unexpected line count for rotated file, got: %d, expected: 2", lines)
+	}
+}
+
+func TestRotatedPathUnique(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.ndjson")
+	now := time.Now()
+
+	first, err := rotatedPath(path, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.WriteFile(first+compressedSuffix, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+
+	second, err := rotatedPath(path, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if second == first {
+		t.Errorf("Rotated path was not unique: %s", second)
+	}
+}
+
+func TestOutputFileDiscard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	file, err := openOutputFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %s", path, err)
+	}
+	defer file.Close()
+
+	file.Write([]byte("first"))
+	if err := file.Flush(false); err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+	start := file.size
+
+	// Both flushed and buffered data since the start should be removed
+	file.Write([]byte("second"))
+	file.Flush(false)
+	file.Write([]byte("third"))
+	if err := file.Discard(start); err != nil {
+		t.Fatalf("Failed to discard: %s", err)
+	}
+
+	file.Write([]byte("fourth"))
+	if err := file.Flush(false); err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", path, err)
+	}
+	if string(content) != "first\nfourth\n" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+	if file.size != int64(len(content)) {
+		t.Errorf("Unexpected size, got: %d, expected: %d", file.size, len(content))
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	defaultCompress       bool          = false
+	defaultFsync          bool          = true
+	defaultIdleTimeout    time.Duration = 300 * time.Second
+	defaultRetry          time.Duration = 0 * time.Second
+	defaultRetryMax       time.Duration = 300 * time.Second
+	defaultRotateInterval time.Duration = 0
+	defaultRotateSize     int64         = 0
+)
+
+var (
+	// TransportFile is the transport name for the file transport
+	TransportFile = "file"
+)
+
+// TransportFileFactory holds the configuration from the configuration file
+// It allows creation of TransportFile instances that use this configuration
+type TransportFileFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	Compress       bool          `config:"compress"`
+	Fsync          bool          `config:"fsync"`
+	IdleTimeout    time.Duration `config:"idle timeout"`
+	PathPattern    string        `config:"path pattern"`
+	Retry          time.Duration `config:"retry backoff"`
+	RetryMax       time.Duration `config:"retry backoff max"`
+	RotateInterval time.Duration `config:"rotate interval"`
+	RotateSize     int64         `config:"rotate size"`
+
+	// Internal
+	pathPattern event.Pattern
+}
+
+// NewTransportFileFactory create a new TransportFileFactory from the provided
+// configuration data, reporting back any configuration errors it discovers
+func NewTransportFileFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.TransportFactory, error) {
+	ret := &TransportFileFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *TransportFileFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.PathPattern == "" {
+		return fmt.Errorf("%spath pattern is required", configPath)
+	}
+	f.pathPattern = event.NewPatternFromString(f.PathPattern)
+
+	if f.IdleTimeout <= 0 {
+		return fmt.Errorf("%sidle timeout must be greater than 0", configPath)
+	}
+	if f.RotateInterval < 0 {
+		return fmt.Errorf("%srotate interval cannot be negative", configPath)
+	}
+	if f.RotateSize < 0 {
+		return fmt.Errorf("%srotate size cannot be negative", configPath)
+	}
+	if f.Compress && f.RotateInterval == 0 && f.RotateSize == 0 {
+		return fmt.Errorf("%[1]scompress requires %[1]srotate interval or %[1]srotate size to be set", configPath)
+	}
+
+	return nil
+}
+
+// Defaults sets the default configuration values
+func (f *TransportFileFactory) Defaults() {
+	f.Compress = defaultCompress
+	f.Fsync = defaultFsync
+	f.IdleTimeout = defaultIdleTimeout
+	f.Retry = defaultRetry
+	f.RetryMax = defaultRetryMax
+	f.RotateInterval = defaultRotateInterval
+	f.RotateSize = defaultRotateSize
+}
+
+// LocalServer returns the server name to use for the single endpoint when no
+// servers are configured
+func (f *TransportFileFactory) LocalServer() string {
+	return f.transport
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportFileFactory.
+func (f *TransportFileFactory) NewTransport(ctx context.Context, poolEntry *addresspool.PoolEntry, eventChan chan<- transports.Event) transports.Transport {
+	ctx, shutdownFunc := context.WithCancel(ctx)
+
+	ret := &transportFile{
+		ctx:          ctx,
+		shutdownFunc: shutdownFunc,
+		config:       f,
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
+		poolEntry:    poolEntry,
+		eventChan:    eventChan,
+		files:        make(map[string]*outputFile),
+	}
+
+	ret.startController()
+	return ret
+}
+
+// ShouldRestart returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (f *TransportFileFactory) ShouldRestart(newConfig transports.TransportFactory) bool {
+	newConfigImpl := newConfig.(*TransportFileFactory)
+	if newConfigImpl.Compress != f.Compress {
+		return true
+	}
+	if newConfigImpl.Fsync != f.Fsync {
+		return true
+	}
+	if newConfigImpl.IdleTimeout != f.IdleTimeout {
+		return true
+	}
+	if newConfigImpl.PathPattern != f.PathPattern {
+		return true
+	}
+	if newConfigImpl.Retry != f.Retry {
+		return true
+	}
+	if newConfigImpl.RetryMax != f.RetryMax {
+		return true
+	}
+	if newConfigImpl.RotateInterval != f.RotateInterval {
+		return true
+	}
+	if newConfigImpl.RotateSize != f.RotateSize {
+		return true
+	}
+	return false
+}
+
+// Register the transports
+func init() {
+	transports.RegisterTransport(TransportFile, NewTransportFileFactory)
+}
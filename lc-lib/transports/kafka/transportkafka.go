@@ -0,0 +1,438 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/xdg-go/scram"
+)
+
+var (
+	// ErrHardCloseRequested occurs when the transport is failed whilst producing
+	ErrHardCloseRequested = errors.New("transport shutdown was requested")
+)
+
+// payload contains nonce and events information, and tracks which of the
+// events have been acknowledged by Kafka
+type payload struct {
+	nonce    *string
+	events   []*event.Event
+	acked    []bool
+	sequence uint32
+}
+
+// advance moves the sequence forward past all contiguously acknowledged
+// events, returning true if it changed
+func (p *payload) advance() bool {
+	sequence := p.sequence
+	for int(p.sequence) < len(p.acked) && p.acked[p.sequence] {
+		p.sequence++
+	}
+	return p.sequence != sequence
+}
+
+// messageMetadata is attached to each produced message so the result can be
+// mapped back to the event within its payload
+type messageMetadata struct {
+	payload *payload
+	index   int
+}
+
+// transportKafka implements a transport that produces events to Kafka
+type transportKafka struct {
+	// Constructor
+	ctx          context.Context
+	shutdownFunc context.CancelFunc
+	config       *TransportKafkaFactory
+	netConfig    *transports.Config
+	poolEntry    *addresspool.PoolEntry
+	eventChan    chan<- transports.Event
+	backoff      *core.ExpBackoff
+
+	// Internal
+	// payloadMutex is so we can easily discard existing payloadChan and its contents each time we reset
+	payloadChan  chan *payload
+	payloadMutex sync.Mutex
+	shutdown     bool
+	dropped      uint64
+}
+
+// Factory returns the associated factory
+func (t *transportKafka) Factory() transports.TransportFactory {
+	return t.config
+}
+
+// DroppedEvents returns the number of events that were discarded because
+// their topic pattern failed to resolve
+func (t *transportKafka) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// startController starts the controller
+func (t *transportKafka) startController() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine is the master routine which handles connection and reconnection
+func (t *transportKafka) controllerRoutine() {
+	defer func() {
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	// Main connect loop
+MainLoop:
+	for {
+		producer, err := t.connect()
+		if err == nil {
+			payloadChan := make(chan *payload, t.netConfig.MaxPendingPayloads)
+
+			t.payloadMutex.Lock()
+			if t.shutdown {
+				// Shutdown was requested before we started
+				t.payloadMutex.Unlock()
+				producer.Close()
+				break MainLoop
+			}
+			t.payloadChan = payloadChan
+			t.payloadMutex.Unlock()
+
+			// Send a started signal to say we're ready
+			t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Started, nil)
+
+			err = t.produceRoutine(producer, payloadChan)
+
+			t.payloadMutex.Lock()
+			t.payloadChan = nil
+			t.payloadMutex.Unlock()
+		}
+
+		if err != nil {
+			if err == ErrHardCloseRequested {
+				log.Noticef("[T %s] Transport forcefully disconnected", t.poolEntry.Server)
+			} else {
+				log.Errorf("[T %s] Transport error, disconnected: %s", t.poolEntry.Server, err)
+			}
+		} else {
+			log.Noticef("[T %s] Transport disconnected gracefully", t.poolEntry.Server)
+			break MainLoop
+		}
+
+		select {
+		case <-t.ctx.Done():
+			break MainLoop
+		case t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Failed, err):
+		}
+
+		if t.reconnectWait() {
+			break
+		}
+	}
+
+	// Ensure all resources cleared up for the context
+	t.shutdownFunc()
+}
+
+// reconnectWait waits the backoff timeout before attempting to reconnect
+// It also monitors for shutdown whilst waiting.
+func (t *transportKafka) reconnectWait() bool {
+	now := time.Now()
+	reconnectDue := now.Add(t.backoff.Trigger())
+
+	select {
+	case <-t.ctx.Done():
+		// Failed transport
+		return true
+	case <-time.After(reconnectDue.Sub(now)):
+	}
+
+	return false
+}
+
+// getSaramaConfig returns the producer configuration
+func (t *transportKafka) getSaramaConfig() *sarama.Config {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "log-carver"
+
+	saramaConfig.Net.DialTimeout = t.netConfig.Timeout
+	saramaConfig.Net.ReadTimeout = t.netConfig.Timeout
+	saramaConfig.Net.WriteTimeout = t.netConfig.Timeout
+
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Timeout = t.netConfig.Timeout
+	saramaConfig.Producer.Compression = compressionCodecs[t.config.Compression]
+	saramaConfig.Producer.RequiredAcks = requiredAcksLevels[t.config.RequiredAcks]
+	saramaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+
+	if t.config.transport == TransportKafkaTLS {
+		tlsConfig := new(tls.Config)
+		tlsConfig.MinVersion = t.config.MinTLSVersion
+		tlsConfig.MaxVersion = t.config.MaxTLSVersion
+
+		// Set the certificate if we set one
+		if t.config.Certificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*t.config.Certificate}
+		}
+
+		// Set CA for server verification
+		tlsConfig.RootCAs = x509.NewCertPool()
+		for _, cert := range t.config.CaList {
+			tlsConfig.RootCAs.AddCert(cert)
+		}
+
+		// ServerName is left empty so that it is populated per broker
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig
+	}
+
+	if t.config.SASLMechanism != "" {
+		saramaConfig.Net.SASL.Enable = true
+		saramaConfig.Net.SASL.Mechanism = saslMechanisms[t.config.SASLMechanism]
+		saramaConfig.Net.SASL.User = t.config.SASLUsername
+		saramaConfig.Net.SASL.Password = t.config.SASLPassword
+
+		switch saramaConfig.Net.SASL.Mechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: scram.SHA256}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: scram.SHA512}
+			}
+		}
+	}
+
+	return saramaConfig
+}
+
+// connect selects the next address to use and creates a producer using it as
+// the bootstrap broker
+func (t *transportKafka) connect() (sarama.AsyncProducer, error) {
+	addr, err := t.poolEntry.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select next address: %s", err)
+	}
+
+	log.Infof("[T %s] Attempting to connect", addr.Desc())
+
+	bootstrap := net.JoinHostPort(addr.Host(), strconv.Itoa(addr.Addr().Port))
+	producer, err := sarama.NewAsyncProducer([]string{bootstrap}, t.getSaramaConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %s", addr.Desc(), err)
+	}
+
+	log.Noticef("[T %s] Connected", addr.Desc())
+	return producer, nil
+}
+
+// newMessage creates the message to produce for the given event within a
+// payload, or returns an error if the topic could not be determined
+func (t *transportKafka) newMessage(payload *payload, index int) (*sarama.ProducerMessage, error) {
+	evnt := payload.events[index]
+
+	topic, err := t.config.topicPattern.Format(evnt)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:    topic,
+		Value:    sarama.ByteEncoder(evnt.Bytes()),
+		Metadata: &messageMetadata{payload: payload, index: index},
+	}
+
+	if t.config.PartitionKey != "" {
+		key, err := evnt.Resolve(t.config.PartitionKey, nil)
+		if err == nil && key != nil {
+			switch keyValue := key.(type) {
+			case string:
+				message.Key = sarama.StringEncoder(keyValue)
+			default:
+				message.Key = sarama.StringEncoder(fmt.Sprintf("%v", keyValue))
+			}
+		}
+	}
+
+	return message, nil
+}
+
+// produceRoutine feeds events from received payloads into the producer and
+// maps the results back into acknowledgements
+func (t *transportKafka) produceRoutine(producer sarama.AsyncProducer, payloadChan <-chan *payload) error {
+	var (
+		pending  []*sarama.ProducerMessage
+		inFlight int
+		shutdown bool
+	)
+
+	for {
+		if shutdown && len(pending) == 0 && inFlight == 0 {
+			// Graceful shutdown, all events have been acknowledged
+			return producer.Close()
+		}
+
+		var (
+			inputChan      chan<- *sarama.ProducerMessage
+			nextMessage    *sarama.ProducerMessage
+			payloadReceive <-chan *payload
+		)
+		if len(pending) != 0 {
+			inputChan = producer.Input()
+			nextMessage = pending[0]
+		} else if !shutdown {
+			payloadReceive = payloadChan
+		}
+
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			t.closeProducer(producer)
+			return ErrHardCloseRequested
+		case payload := <-payloadReceive:
+			if payload == nil {
+				shutdown = true
+				break
+			}
+
+			payload.acked = make([]bool, len(payload.events))
+			for index := range payload.events {
+				message, err := t.newMessage(payload, index)
+				if err != nil {
+					log.Warningf("[T %s] Dropping event that failed to produce a topic: %s", t.poolEntry.Server, err)
+					atomic.AddUint64(&t.dropped, 1)
+					payload.acked[index] = true
+					continue
+				}
+				pending = append(pending, message)
+			}
+
+			if !t.sendAck(payload) {
+				t.closeProducer(producer)
+				return ErrHardCloseRequested
+			}
+		case inputChan <- nextMessage:
+			pending = pending[1:]
+			inFlight++
+		case message := <-producer.Successes():
+			inFlight--
+			metadata := message.Metadata.(*messageMetadata)
+			metadata.payload.acked[metadata.index] = true
+			if !t.sendAck(metadata.payload) {
+				t.closeProducer(producer)
+				return ErrHardCloseRequested
+			}
+		case produceErr := <-producer.Errors():
+			t.closeProducer(producer)
+			return produceErr.Err
+		}
+	}
+}
+
+// sendAck sends an acknowledgement for the payload if its sequence has
+// advanced, returning false if the transport was failed whilst sending
+func (t *transportKafka) sendAck(payload *payload) bool {
+	if !payload.advance() {
+		return true
+	}
+
+	select {
+	case <-t.ctx.Done():
+		// Forced failure
+		return false
+	case t.eventChan <- transports.NewAckEvent(t.ctx, payload.nonce, payload.sequence):
+	}
+
+	return true
+}
+
+// closeProducer closes the producer without waiting for in-flight messages,
+// discarding any further results
+func (t *transportKafka) closeProducer(producer sarama.AsyncProducer) {
+	producer.AsyncClose()
+	go func() {
+		for range producer.Successes() {
+		}
+	}()
+	go func() {
+		for range producer.Errors() {
+		}
+	}()
+}
+
+// SendEvents sends events to the transport - only valid after Started transport event received
+func (t *transportKafka) SendEvents(nonce string, events []*event.Event) error {
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil || t.shutdown {
+		return transports.ErrInvalidState
+	}
+	t.payloadChan <- &payload{nonce: &nonce, events: events}
+	return nil
+}
+
+// Ping the remote server - not implemented for Kafka as the producer manages
+// its own connections
+// Immediately respond with a pong
+func (t *transportKafka) Ping() error {
+	go func() {
+		log.Debugf("[T %s] Responding with pong", t.poolEntry.Server)
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case t.eventChan <- transports.NewPongEvent(t.ctx):
+		}
+	}()
+	return nil
+}
+
+// Fail the transport / Shutdown hard
+func (t *transportKafka) Fail() {
+	t.shutdownFunc()
+}
+
+// Shutdown the transport gracefully
+func (t *transportKafka) Shutdown() {
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if !t.shutdown {
+		if t.payloadChan == nil {
+			// No producer to gracefully shutdown, use context to fail and cancel any retries
+			t.shutdownFunc()
+		} else {
+			// Closing the channel triggers graceful shutdown
+			close(t.payloadChan)
+		}
+		t.shutdown = true
+	}
+}
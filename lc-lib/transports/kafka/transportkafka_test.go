@@ -0,0 +1,238 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const testTopic = "test-topic"
+
+func createTestFactory(t *testing.T, partitionKey string) *TransportKafkaFactory {
+	f := &TransportKafkaFactory{
+		transport: TransportKafka,
+		ClientTlsConfiguration: &transports.ClientTlsConfiguration{
+			TlsConfiguration: &transports.TlsConfiguration{},
+		},
+	}
+	f.Defaults()
+	f.TopicPattern = "%{topic}"
+	f.PartitionKey = partitionKey
+	if err := f.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+	return f
+}
+
+func createTestBroker(t *testing.T, produceResponse *sarama.MockProduceResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"ProduceRequest": produceResponse,
+	})
+	return broker
+}
+
+func createTestTransport(t *testing.T, f *TransportKafkaFactory, broker *sarama.MockBroker) (transports.Transport, chan transports.Event) {
+	pool, err := addresspool.GeneratePool([]string{broker.Addr()}, false, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate pool: %s", err)
+	}
+
+	netConfig := &transports.Config{}
+	netConfig.Defaults()
+	ctx := context.WithValue(context.Background(), transports.ContextConfig, netConfig)
+
+	eventChan := make(chan transports.Event, 10)
+	return f.NewTransport(ctx, pool[0], eventChan), eventChan
+}
+
+func createTestEvents(count int) []*event.Event {
+	events := make([]*event.Event, count)
+	for idx := range events {
+		events[idx] = event.NewEvent(context.Background(), nil, map[string]interface{}{
+			"message": fmt.Sprintf("line %d", idx),
+			"topic":   testTopic,
+			"host":    "example",
+		})
+	}
+	return events
+}
+
+func receiveEvent(t *testing.T, eventChan <-chan transports.Event) transports.Event {
+	select {
+	case evnt := <-eventChan:
+		return evnt
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for transport event")
+	}
+	return nil
+}
+
+func expectStatus(t *testing.T, eventChan <-chan transports.Event, expected transports.StatusChange) *transports.StatusEvent {
+	evnt := receiveEvent(t, eventChan)
+	status, ok := evnt.(*transports.StatusEvent)
+	if !ok {
+		t.Fatalf("Unexpected transport event: %T", evnt)
+	}
+	if status.StatusChange() != expected {
+		t.Fatalf("Unexpected status change, got: %d, expected: %d (%v)", status.StatusChange(), expected, status.Err())
+	}
+	return status
+}
+
+func TestTransportKafkaAcknowledges(t *testing.T) {
+	broker := createTestBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	transport, eventChan := createTestTransport(t, createTestFactory(t, "host"), broker)
+	defer transport.Fail()
+
+	expectStatus(t, eventChan, transports.Started)
+
+	if err := transport.SendEvents("nonce", createTestEvents(5)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var sequence uint32
+	for sequence != 5 {
+		evnt := receiveEvent(t, eventChan)
+		ack, ok := evnt.(transports.AckEvent)
+		if !ok {
+			t.Fatalf("Unexpected transport event: %T", evnt)
+		}
+		if *ack.Nonce() != "nonce" {
+			t.Fatalf("Unexpected nonce: %s", *ack.Nonce())
+		}
+		if ack.Sequence() <= sequence {
+			t.Fatalf("Acknowledgement sequence did not advance, got: %d, previous: %d", ack.Sequence(), sequence)
+		}
+		sequence = ack.Sequence()
+	}
+
+	transport.Shutdown()
+	expectStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportKafkaProduceError(t *testing.T) {
+	broker := createTestBroker(t, sarama.NewMockProduceResponse(t).SetError(testTopic, 0, sarama.ErrInvalidMessage))
+	defer broker.Close()
+
+	transport, eventChan := createTestTransport(t, createTestFactory(t, ""), broker)
+	defer transport.Fail()
+
+	expectStatus(t, eventChan, transports.Started)
+
+	if err := transport.SendEvents("nonce", createTestEvents(1)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	status := expectStatus(t, eventChan, transports.Failed)
+	if status.Err() != sarama.ErrInvalidMessage {
+		t.Errorf("Unexpected error, got: %v, expected: %v", status.Err(), sarama.ErrInvalidMessage)
+	}
+}
+
+func TestTransportKafkaDropsUnresolvedTopic(t *testing.T) {
+	broker := createTestBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	transport, eventChan := createTestTransport(t, createTestFactory(t, ""), broker)
+	defer transport.Fail()
+
+	expectStatus(t, eventChan, transports.Started)
+
+	// Events whose topic cannot be rendered cannot be produced so are
+	// acknowledged and counted as dropped
+	events := createTestEvents(2)
+	events[1] = event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "invalid topic", "topic": make(chan int)})
+	if err := transport.SendEvents("nonce", events); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var sequence uint32
+	for sequence != 2 {
+		evnt := receiveEvent(t, eventChan)
+		ack, ok := evnt.(transports.AckEvent)
+		if !ok {
+			t.Fatalf("Unexpected transport event: %T", evnt)
+		}
+		sequence = ack.Sequence()
+	}
+
+	if dropped := transport.(transports.DropCounter).DroppedEvents(); dropped != 1 {
+		t.Errorf("Unexpected dropped events, got: %d, expected: 1", dropped)
+	}
+
+	transport.Shutdown()
+	expectStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportKafkaMessage(t *testing.T) {
+	transport := &transportKafka{config: createTestFactory(t, "host")}
+
+	payload := &payload{events: createTestEvents(1)}
+	message, err := transport.newMessage(payload, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if message.Topic != testTopic {
+		t.Errorf("Unexpected topic, got: %s, expected: %s", message.Topic, testTopic)
+	}
+	if message.Key != sarama.StringEncoder("example") {
+		t.Errorf("Unexpected key, got: %v, expected: example", message.Key)
+	}
+
+	// Events without the partition key field should have no key
+	transport.config = createTestFactory(t, "missing")
+	message, err = transport.newMessage(payload, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if message.Key != nil {
+		t.Errorf("Unexpected key, got: %v, expected: nil", message.Key)
+	}
+}
+
+func TestPayloadAdvance(t *testing.T) {
+	payload := &payload{acked: make([]bool, 3)}
+
+	payload.acked[1] = true
+	if payload.advance() {
+		t.Errorf("Sequence advanced before first event acknowledged")
+	}
+
+	payload.acked[0] = true
+	if !payload.advance() || payload.sequence != 2 {
+		t.Errorf("Unexpected sequence, got: %d, expected: 2", payload.sequence)
+	}
+
+	payload.acked[2] = true
+	if !payload.advance() || payload.sequence != 3 {
+		t.Errorf("Unexpected sequence, got: %d, expected: 3", payload.sequence)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	defaultTopicPattern string        = "logstash"
+	defaultCompression  string        = "none"
+	defaultRequiredAcks string        = "all"
+	defaultRetry        time.Duration = 0 * time.Second
+	defaultRetryMax     time.Duration = 300 * time.Second
+)
+
+var (
+	// TransportKafka is the transport name for plain Kafka
+	TransportKafka = "kafka"
+	// TransportKafkaTLS is the transport name for Kafka over TLS
+	TransportKafkaTLS = "kafka-tls"
+
+	compressionCodecs = map[string]sarama.CompressionCodec{
+		"none":   sarama.CompressionNone,
+		"gzip":   sarama.CompressionGZIP,
+		"snappy": sarama.CompressionSnappy,
+		"lz4":    sarama.CompressionLZ4,
+		"zstd":   sarama.CompressionZSTD,
+	}
+
+	requiredAcksLevels = map[string]sarama.RequiredAcks{
+		"none":   sarama.NoResponse,
+		"leader": sarama.WaitForLocal,
+		"all":    sarama.WaitForAll,
+	}
+
+	saslMechanisms = map[string]sarama.SASLMechanism{
+		"plain":         sarama.SASLTypePlaintext,
+		"scram-sha-256": sarama.SASLTypeSCRAMSHA256,
+		"scram-sha-512": sarama.SASLTypeSCRAMSHA512,
+	}
+)
+
+// TransportKafkaFactory holds the configuration from the configuration file
+// It allows creation of TransportKafka instances that use this configuration
+type TransportKafkaFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	Compression   string        `config:"compression"`
+	PartitionKey  string        `config:"partition key"`
+	RequiredAcks  string        `config:"required acks"`
+	Retry         time.Duration `config:"retry backoff"`
+	RetryMax      time.Duration `config:"retry backoff max"`
+	SASLMechanism string        `config:"sasl mechanism"`
+	SASLPassword  string        `config:"sasl password"`
+	SASLUsername  string        `config:"sasl username"`
+	TopicPattern  string        `config:"topic pattern"`
+
+	// Internal
+	topicPattern event.Pattern
+
+	*transports.ClientTlsConfiguration `config:",embed"`
+}
+
+// NewTransportKafkaFactory create a new TransportKafkaFactory from the
+// provided configuration data, reporting back any configuration errors it
+// discovers
+func NewTransportKafkaFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.TransportFactory, error) {
+	ret := &TransportKafkaFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *TransportKafkaFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.TopicPattern == "" {
+		return fmt.Errorf("%stopic pattern is required", configPath)
+	}
+	f.topicPattern = event.NewPatternFromString(f.TopicPattern)
+
+	if _, ok := compressionCodecs[f.Compression]; !ok {
+		return fmt.Errorf("%scompression must be one of \"none\", \"gzip\", \"snappy\", \"lz4\" or \"zstd\"", configPath)
+	}
+
+	if _, ok := requiredAcksLevels[f.RequiredAcks]; !ok {
+		return fmt.Errorf("%srequired acks must be one of \"none\", \"leader\" or \"all\"", configPath)
+	}
+
+	if f.SASLMechanism != "" {
+		if _, ok := saslMechanisms[f.SASLMechanism]; !ok {
+			return fmt.Errorf("%ssasl mechanism must be one of \"plain\", \"scram-sha-256\" or \"scram-sha-512\"", configPath)
+		}
+		if f.SASLUsername == "" {
+			return fmt.Errorf("%[1]ssasl username is required when %[1]ssasl mechanism is set", configPath)
+		}
+	} else if f.SASLUsername != "" || f.SASLPassword != "" {
+		return fmt.Errorf("%[1]ssasl username and %[1]ssasl password require %[1]ssasl mechanism to be set", configPath)
+	}
+
+	return f.ClientTlsConfiguration.TlsValidate(f.transport == TransportKafkaTLS, p, configPath)
+}
+
+// Defaults sets the default configuration values
+func (f *TransportKafkaFactory) Defaults() {
+	f.Compression = defaultCompression
+	f.RequiredAcks = defaultRequiredAcks
+	f.Retry = defaultRetry
+	f.RetryMax = defaultRetryMax
+	f.TopicPattern = defaultTopicPattern
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportKafkaFactory.
+func (f *TransportKafkaFactory) NewTransport(ctx context.Context, poolEntry *addresspool.PoolEntry, eventChan chan<- transports.Event) transports.Transport {
+	ctx, shutdownFunc := context.WithCancel(ctx)
+
+	backoffName := fmt.Sprintf("[T %s] Reconnect", poolEntry.Server)
+	ret := &transportKafka{
+		ctx:          ctx,
+		shutdownFunc: shutdownFunc,
+		config:       f,
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
+		poolEntry:    poolEntry,
+		eventChan:    eventChan,
+		backoff:      core.NewExpBackoff(backoffName, f.Retry, f.RetryMax),
+	}
+
+	ret.startController()
+	return ret
+}
+
+// ShouldRestart returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (f *TransportKafkaFactory) ShouldRestart(newConfig transports.TransportFactory) bool {
+	newConfigImpl := newConfig.(*TransportKafkaFactory)
+	if newConfigImpl.Compression != f.Compression {
+		return true
+	}
+	if newConfigImpl.PartitionKey != f.PartitionKey {
+		return true
+	}
+	if newConfigImpl.RequiredAcks != f.RequiredAcks {
+		return true
+	}
+	if newConfigImpl.Retry != f.Retry {
+		return true
+	}
+	if newConfigImpl.RetryMax != f.RetryMax {
+		return true
+	}
+	if newConfigImpl.SASLMechanism != f.SASLMechanism {
+		return true
+	}
+	if newConfigImpl.SASLPassword != f.SASLPassword {
+		return true
+	}
+	if newConfigImpl.SASLUsername != f.SASLUsername {
+		return true
+	}
+	if newConfigImpl.TopicPattern != f.TopicPattern {
+		return true
+	}
+
+	return f.ClientTlsConfiguration.HasChanged(newConfigImpl.ClientTlsConfiguration)
+}
+
+// Register the transports
+func init() {
+	transports.RegisterTransport(TransportKafka, NewTransportKafkaFactory)
+	transports.RegisterTransport(TransportKafkaTLS, NewTransportKafkaFactory)
+}
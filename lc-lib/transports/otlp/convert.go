@@ -0,0 +1,367 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+var (
+	// resourceFields are the top level fields of an event that describe where
+	// it came from, and are sent as resource attributes
+	resourceFields = map[string]bool{
+		"cloud":      true,
+		"container":  true,
+		"deployment": true,
+		"host":       true,
+		"k8s":        true,
+		"os":         true,
+		"process":    true,
+		"service":    true,
+	}
+
+	// severityNumbers maps common level names to the severity number of their
+	// range, for events that have a level but no severity number
+	severityNumbers = map[string]logspb.SeverityNumber{
+		"trace":    logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+		"debug":    logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+		"info":     logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		"notice":   logspb.SeverityNumber_SEVERITY_NUMBER_INFO2,
+		"warn":     logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+		"warning":  logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+		"error":    logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+		"critical": logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+		"fatal":    logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	}
+)
+
+// logsToEvents converts received log records into event data
+// Attributes of the resource, scope and log record are expanded from their
+// dotted names into nested fields, so that semantic convention attributes
+// such as "host.name" and "log.file.path" match the ECS fields Log Courier
+// generates when "enable ecs" is set
+func logsToEvents(logs *logspb.LogsData) []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, resourceLogs := range logs.GetResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				data := map[string]interface{}{}
+				setAttributes(data, resourceLogs.GetResource().GetAttributes())
+				setAttributes(data, scopeLogs.GetScope().GetAttributes())
+				setAttributes(data, record.GetAttributes())
+
+				if name := scopeLogs.GetScope().GetName(); name != "" {
+					setField(data, "log.logger", name)
+				}
+				if record.GetSeverityText() != "" {
+					setField(data, "log.level", record.GetSeverityText())
+				}
+				if record.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+					setField(data, "event.severity", int(record.GetSeverityNumber()))
+				}
+				if len(record.GetTraceId()) != 0 {
+					setField(data, "trace.id", hex.EncodeToString(record.GetTraceId()))
+				}
+				if len(record.GetSpanId()) != 0 {
+					setField(data, "span.id", hex.EncodeToString(record.GetSpanId()))
+				}
+
+				if body, ok := record.GetBody().GetValue().(*commonpb.AnyValue_StringValue); ok {
+					data["message"] = body.StringValue
+				} else if record.GetBody() != nil {
+					data["body"] = fromAnyValue(record.GetBody())
+				}
+
+				if record.GetTimeUnixNano() != 0 {
+					data["@timestamp"] = time.Unix(0, int64(record.GetTimeUnixNano()))
+				} else if record.GetObservedTimeUnixNano() != 0 {
+					data["@timestamp"] = time.Unix(0, int64(record.GetObservedTimeUnixNano()))
+				} else {
+					data["@timestamp"] = time.Now()
+				}
+
+				events = append(events, data)
+			}
+		}
+	}
+	return events
+}
+
+// setAttributes sets the given attributes into the event data
+func setAttributes(data map[string]interface{}, attributes []*commonpb.KeyValue) {
+	for _, attribute := range attributes {
+		setField(data, attribute.GetKey(), fromAnyValue(attribute.GetValue()))
+	}
+}
+
+// setField sets the value at the nested location described by a dotted key,
+// replacing any values that are in the way
+// Keys that contain empty segments are set as they are
+func setField(data map[string]interface{}, key string, value interface{}) {
+	segments := strings.Split(key, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			data[key] = value
+			return
+		}
+	}
+
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := data[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			data[segment] = next
+		}
+		data = next
+	}
+	data[segments[len(segments)-1]] = value
+}
+
+// fromAnyValue converts an attribute or body value
+func fromAnyValue(value *commonpb.AnyValue) interface{} {
+	switch typedValue := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return typedValue.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return typedValue.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return typedValue.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return typedValue.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(typedValue.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		ret := make([]interface{}, 0, len(typedValue.ArrayValue.GetValues()))
+		for _, entry := range typedValue.ArrayValue.GetValues() {
+			ret = append(ret, fromAnyValue(entry))
+		}
+		return ret
+	case *commonpb.AnyValue_KvlistValue:
+		ret := make(map[string]interface{}, len(typedValue.KvlistValue.GetValues()))
+		for _, entry := range typedValue.KvlistValue.GetValues() {
+			ret[entry.GetKey()] = fromAnyValue(entry.GetValue())
+		}
+		return ret
+	}
+	return nil
+}
+
+// eventsToLogs converts events into log records, grouping them by their
+// resource and then by their scope
+// It is the reverse of logsToEvents, with the fields that describe the
+// resource sent as resource attributes and the remaining fields sent as log
+// record attributes using their dotted names
+func eventsToLogs(events []*event.Event) *logspb.LogsData {
+	var (
+		ret              = &logspb.LogsData{}
+		resourcesByKey   = map[string]*logspb.ResourceLogs{}
+		scopesByResource = map[*logspb.ResourceLogs]map[string]*logspb.ScopeLogs{}
+		observed         = uint64(time.Now().UnixNano())
+	)
+
+	for _, evnt := range events {
+		data := evnt.Data()
+		record := &logspb.LogRecord{ObservedTimeUnixNano: observed}
+
+		if timestamp, ok := data["@timestamp"].(event.Timestamp); ok {
+			record.TimeUnixNano = uint64(time.Time(timestamp).UnixNano())
+		}
+		if message, ok := data["message"].(string); ok {
+			record.Body = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: message}}
+		} else if body, ok := data["body"]; ok {
+			record.Body = toAnyValue(body)
+		}
+
+		var (
+			resourceAttributes []*commonpb.KeyValue
+			scopeName          string
+		)
+		for _, attribute := range flattenFields(data) {
+			switch attribute.Key {
+			case "log.logger":
+				scopeName = attribute.Value.GetStringValue()
+			case "log.level":
+				record.SeverityText = attribute.Value.GetStringValue()
+			case "event.severity":
+				if number, ok := attribute.Value.GetValue().(*commonpb.AnyValue_IntValue); ok {
+					record.SeverityNumber = logspb.SeverityNumber(number.IntValue)
+				}
+			case "trace.id":
+				record.TraceId, _ = hex.DecodeString(attribute.Value.GetStringValue())
+			case "span.id":
+				record.SpanId, _ = hex.DecodeString(attribute.Value.GetStringValue())
+			default:
+				if resourceFields[strings.SplitN(attribute.Key, ".", 2)[0]] {
+					resourceAttributes = append(resourceAttributes, attribute)
+				} else {
+					record.Attributes = append(record.Attributes, attribute)
+				}
+			}
+		}
+		if record.SeverityNumber == logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+			record.SeverityNumber = severityNumbers[strings.ToLower(record.SeverityText)]
+		}
+
+		resourceKey := attributesKey(resourceAttributes)
+		resourceLogs, ok := resourcesByKey[resourceKey]
+		if !ok {
+			resourceLogs = &logspb.ResourceLogs{Resource: &resourcepb.Resource{Attributes: resourceAttributes}}
+			resourcesByKey[resourceKey] = resourceLogs
+			scopesByResource[resourceLogs] = map[string]*logspb.ScopeLogs{}
+			ret.ResourceLogs = append(ret.ResourceLogs, resourceLogs)
+		}
+
+		scopeLogs, ok := scopesByResource[resourceLogs][scopeName]
+		if !ok {
+			scopeLogs = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: scopeName}}
+			scopesByResource[resourceLogs][scopeName] = scopeLogs
+			resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, scopeLogs)
+		}
+
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+	}
+
+	return ret
+}
+
+// flattenFields returns the fields of the event as attributes with dotted
+// names, sorted by name, excluding those that form the timestamp and body
+// A "host" field that is not nested, as generated when "enable ecs" is not
+// set, is treated as the host name
+func flattenFields(data map[string]interface{}) []*commonpb.KeyValue {
+	var ret []*commonpb.KeyValue
+	for key, value := range data {
+		switch key {
+		case "@metadata", "@timestamp", "body":
+			continue
+		case "tags":
+			if tags, ok := value.(event.Tags); ok && len(tags) == 0 {
+				continue
+			}
+		case "message":
+			if _, ok := value.(string); ok {
+				continue
+			}
+		case "host":
+			if hostname, ok := value.(string); ok {
+				ret = append(ret, &commonpb.KeyValue{Key: "host.name", Value: toAnyValue(hostname)})
+				continue
+			}
+		}
+		ret = appendFlattened(ret, key, value)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
+
+// appendFlattened appends the value as attributes, flattening any nested
+// fields into dotted names
+func appendFlattened(attributes []*commonpb.KeyValue, key string, value interface{}) []*commonpb.KeyValue {
+	if nested, ok := value.(map[string]interface{}); ok {
+		for nestedKey, nestedValue := range nested {
+			attributes = appendFlattened(attributes, key+"."+nestedKey, nestedValue)
+		}
+		return attributes
+	}
+	return append(attributes, &commonpb.KeyValue{Key: key, Value: toAnyValue(value)})
+}
+
+// attributesKey returns a key that uniquely identifies a sorted set of
+// attributes so that events sharing them can be grouped
+func attributesKey(attributes []*commonpb.KeyValue) string {
+	values := make([]interface{}, 0, len(attributes)*2)
+	for _, attribute := range attributes {
+		values = append(values, attribute.Key, fromAnyValue(attribute.Value))
+	}
+	key, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprintf("%v", values)
+	}
+	return string(key)
+}
+
+// toAnyValue converts a field value into an attribute or body value
+func toAnyValue(value interface{}) *commonpb.AnyValue {
+	switch typedValue := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: typedValue}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: typedValue}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(typedValue)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: typedValue}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: typedValue}}
+	case json.Number:
+		if intValue, err := typedValue.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: intValue}}
+		}
+		floatValue, _ := typedValue.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: floatValue}}
+	case event.Tags:
+		values := make([]*commonpb.AnyValue, 0, len(typedValue))
+		for _, tag := range typedValue {
+			values = append(values, toAnyValue(tag))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case []interface{}:
+		values := make([]*commonpb.AnyValue, 0, len(typedValue))
+		for _, entry := range typedValue {
+			values = append(values, toAnyValue(entry))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typedValue))
+		for key := range typedValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]*commonpb.KeyValue, 0, len(typedValue))
+		for _, key := range keys {
+			values = append(values, &commonpb.KeyValue{Key: key, Value: toAnyValue(typedValue[key])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}
+	case nil:
+		return &commonpb.AnyValue{}
+	}
+
+	// Anything else, such as timestamps, is sent in its JSON encoding
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return toAnyValue(fmt.Sprintf("%v", value))
+	}
+	var stringValue string
+	if err := json.Unmarshal(encoded, &stringValue); err == nil {
+		return toAnyValue(stringValue)
+	}
+	return toAnyValue(string(encoded))
+}
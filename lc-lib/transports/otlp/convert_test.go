@@ -0,0 +1,147 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringValue(value string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+}
+
+func createTestLogs() *logspb.LogsData {
+	return &logspb.LogsData{
+		ResourceLogs: []*logspb.ResourceLogs{
+			{
+				Resource: &resourcepb.Resource{
+					Attributes: []*commonpb.KeyValue{
+						{Key: "host.name", Value: stringValue("server")},
+						{Key: "service.name", Value: stringValue("app")},
+					},
+				},
+				ScopeLogs: []*logspb.ScopeLogs{
+					{
+						Scope: &commonpb.InstrumentationScope{Name: "logger"},
+						LogRecords: []*logspb.LogRecord{
+							{
+								TimeUnixNano:   uint64(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()),
+								SeverityText:   "WARN",
+								SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+								Body:           stringValue("hello"),
+								Attributes: []*commonpb.KeyValue{
+									{Key: "log.file.path", Value: stringValue("/var/log/app.log")},
+									{Key: "count", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 5}}},
+								},
+								TraceId: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+								SpanId:  []byte{0, 1, 2, 3, 4, 5, 6, 7},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLogsToEvents(t *testing.T) {
+	events := logsToEvents(createTestLogs())
+	if len(events) != 1 {
+		t.Fatalf("Unexpected event count: %d", len(events))
+	}
+
+	evnt := event.NewEvent(context.Background(), nil, events[0])
+	expected := map[string]interface{}{
+		"message":         "hello",
+		"host[name]":      "server",
+		"service[name]":   "app",
+		"log[logger]":     "logger",
+		"log[level]":      "WARN",
+		"log[file][path]": "/var/log/app.log",
+		"event[severity]": 13,
+		"count":           int64(5),
+		"trace[id]":       "000102030405060708090a0b0c0d0e0f",
+		"span[id]":        "0001020304050607",
+	}
+	for path, value := range expected {
+		if result := evnt.MustResolve(path, nil); result != value {
+			t.Errorf("Unexpected value for %s, got: %v (%T), expected: %v", path, result, result, value)
+		}
+	}
+
+	timestamp := time.Time(evnt.MustResolve("@timestamp", nil).(event.Timestamp))
+	if !timestamp.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp: %s", timestamp)
+	}
+}
+
+func TestEventsToLogs(t *testing.T) {
+	events := []*event.Event{
+		event.NewEvent(context.Background(), nil, logsToEvents(createTestLogs())[0]),
+		event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "other", "host": "server2", "log": map[string]interface{}{"level": "error"}}),
+	}
+
+	logs := eventsToLogs(events)
+	if len(logs.ResourceLogs) != 2 {
+		t.Fatalf("Unexpected resource count: %d", len(logs.ResourceLogs))
+	}
+
+	first := logs.ResourceLogs[0]
+	if len(first.Resource.Attributes) != 2 || first.Resource.Attributes[0].Key != "host.name" || first.Resource.Attributes[1].Key != "service.name" {
+		t.Errorf("Unexpected resource attributes: %v", first.Resource.Attributes)
+	}
+	if first.ScopeLogs[0].Scope.Name != "logger" {
+		t.Errorf("Unexpected scope: %v", first.ScopeLogs[0].Scope)
+	}
+	record := first.ScopeLogs[0].LogRecords[0]
+	if record.Body.GetStringValue() != "hello" || record.SeverityText != "WARN" || record.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN {
+		t.Errorf("Unexpected record: %v", record)
+	}
+	if len(record.TraceId) != 16 || len(record.SpanId) != 8 {
+		t.Errorf("Unexpected trace context: %v", record)
+	}
+	if len(record.Attributes) != 2 || record.Attributes[0].Key != "count" || record.Attributes[1].Key != "log.file.path" {
+		t.Errorf("Unexpected record attributes: %v", record.Attributes)
+	}
+
+	second := logs.ResourceLogs[1]
+	if len(second.Resource.Attributes) != 1 || second.Resource.Attributes[0].Value.GetStringValue() != "server2" {
+		t.Errorf("Unexpected resource attributes: %v", second.Resource.Attributes)
+	}
+	if second.ScopeLogs[0].LogRecords[0].SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_ERROR {
+		t.Errorf("Unexpected severity number: %v", second.ScopeLogs[0].LogRecords[0].SeverityNumber)
+	}
+}
+
+func TestSetField(t *testing.T) {
+	data := map[string]interface{}{"host": "scalar"}
+	setField(data, "host.name", "server")
+	setField(data, "a..b", "kept")
+	if host, ok := data["host"].(map[string]interface{}); !ok || host["name"] != "server" {
+		t.Errorf("Unexpected host: %v", data["host"])
+	}
+	if data["a..b"] != "kept" {
+		t.Errorf("Key with empty segment was not kept: %v", data)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/webhook"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// logsPath is the path that OTLP/HTTP log exports are sent to
+	logsPath = "/v1/logs"
+
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// receiverOTLP implements a receiver that accepts OTLP/HTTP log exports, with
+// each request treated as a separate connection that receives a response
+// only once all of its events are acknowledged
+type receiverOTLP struct {
+	*webhook.ReceiverBase
+
+	config *ReceiverOTLPFactory
+}
+
+// Factory returns the associated factory
+func (t *receiverOTLP) Factory() transports.ReceiverFactory {
+	return t.config
+}
+
+// ServeHTTP handles a log export request, passing its events through the
+// pipeline and responding once they are acknowledged
+func (t *receiverOTLP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if contentType != contentTypeJSON {
+		contentType = contentTypeProtobuf
+	}
+
+	if req.URL.Path != logsPath {
+		t.respondError(w, req, contentType, http.StatusNotFound, fmt.Errorf("path %s not found", req.URL.Path))
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		t.respondError(w, req, contentType, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	if !webhook.IsBearerAuthorised(req, t.config.BearerToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		t.respondError(w, req, contentType, http.StatusUnauthorized, errors.New("unauthorised"))
+		return
+	}
+
+	logs, size, status, err := t.readLogs(req)
+	if err != nil {
+		t.respondError(w, req, contentType, status, err)
+		return
+	}
+
+	events := logsToEvents(logs)
+	if len(events) != 0 {
+		if status, err := t.ProcessEvents(req, events, size); err != nil {
+			t.respondError(w, req, contentType, status, err)
+			return
+		}
+	}
+
+	// An empty ExportLogsServiceResponse indicates complete success
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == contentTypeJSON {
+		w.Write([]byte("{}"))
+	}
+}
+
+// respondError logs a failed request and returns the error to the client as a
+// Status message in the same encoding as the request
+func (t *receiverOTLP) respondError(w http.ResponseWriter, req *http.Request, contentType string, statusCode int, err error) {
+	log.Warningf("[R %s - %s] Request failed with status %d: %s", t.Bind(), req.RemoteAddr, statusCode, err)
+
+	message := &status.Status{Message: err.Error()}
+	var body []byte
+	if contentType == contentTypeJSON {
+		body, _ = protojson.Marshal(message)
+	} else {
+		body, _ = proto.Marshal(message)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// readLogs reads and decodes the request body, returning the logs and the size
+// of the body, or the status code and error to respond with
+func (t *receiverOTLP) readLogs(req *http.Request) (*logspb.LogsData, int, int, error) {
+	var contentType string
+	if req.Header.Get("Content-Type") != "" {
+		contentType, _, _ = mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+			return nil, 0, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type: %s", req.Header.Get("Content-Type"))
+		}
+	}
+
+	reader, status, err := webhook.ReadRequestBody(req, t.config.MaxRequestSize)
+	if err != nil {
+		return nil, 0, status, err
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, 0, webhook.RequestErrorStatus(err), err
+	}
+
+	logs := &logspb.LogsData{}
+	if contentType == contentTypeJSON {
+		err = decodeJSON(body, logs)
+	} else {
+		err = proto.Unmarshal(body, logs)
+	}
+	if err != nil {
+		return nil, 0, http.StatusBadRequest, err
+	}
+
+	return logs, len(body), http.StatusOK, nil
+}
+
+// decodeJSON decodes the OTLP JSON encoding of logs, which differs from the
+// standard protobuf JSON mapping in that trace and span IDs are hex encoded
+// rather than base64 encoded
+func decodeJSON(body []byte, logs *logspb.LogsData) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return err
+	}
+
+	resourceLogs, _ := data["resourceLogs"].([]interface{})
+	for _, resourceEntry := range resourceLogs {
+		resourceMap, _ := resourceEntry.(map[string]interface{})
+		scopeLogs, _ := resourceMap["scopeLogs"].([]interface{})
+		for _, scopeEntry := range scopeLogs {
+			scopeMap, _ := scopeEntry.(map[string]interface{})
+			records, _ := scopeMap["logRecords"].([]interface{})
+			for _, recordEntry := range records {
+				recordMap, ok := recordEntry.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				for _, key := range []string{"traceId", "spanId"} {
+					if id, ok := recordMap[key].(string); ok {
+						decoded, err := hex.DecodeString(id)
+						if err != nil {
+							return fmt.Errorf("invalid %s: %s", key, err)
+						}
+						recordMap[key] = base64.StdEncoding.EncodeToString(decoded)
+					}
+				}
+			}
+		}
+	}
+
+	converted, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(converted, logs)
+}
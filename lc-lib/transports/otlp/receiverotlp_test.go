@@ -0,0 +1,143 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/webhook"
+	"google.golang.org/protobuf/proto"
+)
+
+func createTestReceiver(t *testing.T, acknowledge bool) (*receiverOTLP, <-chan map[string]interface{}) {
+	factory := &ReceiverOTLPFactory{transport: TransportOTLP, ServerTlsConfiguration: &transports.ServerTlsConfiguration{TlsConfiguration: &transports.TlsConfiguration{}}}
+	factory.Defaults()
+	if err := factory.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	ctx := context.WithValue(context.Background(), transports.ContextReceiverConfig, &transports.ReceiverConfigEntry{MaxPendingPayloads: 10})
+	receiver := &receiverOTLP{config: factory}
+	receiver.ReceiverBase = webhook.NewReceiverBase(ctx, receiver, factory.ServerTlsConfiguration, false, "test", eventChan)
+
+	// Act as the receiver pool, acknowledging all events and then closing the
+	// connection once the end is received
+	receivedChan := make(chan map[string]interface{}, 10)
+	go func() {
+		for evnt := range eventChan {
+			switch eventImpl := evnt.(type) {
+			case transports.EventsEvent:
+				for _, data := range eventImpl.Events() {
+					receivedChan <- data
+				}
+				if acknowledge {
+					receiver.Acknowledge(eventImpl.Context(), eventImpl.Nonce(), eventImpl.Count())
+				} else {
+					receiver.ShutdownConnectionRead(eventImpl.Context(), errors.New("max queue size exceeded"))
+				}
+			case *transports.EndEvent:
+				receiver.ShutdownConnection(eventImpl.Context())
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(eventChan)
+	})
+
+	return receiver, receivedChan
+}
+
+func sendTestRequest(receiver *receiverOTLP, path string, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestReceiverOTLPProtobuf(t *testing.T) {
+	receiver, receivedChan := createTestReceiver(t, true)
+
+	body, err := proto.Marshal(createTestLogs())
+	if err != nil {
+		t.Fatalf("Failed to encode logs: %s", err)
+	}
+
+	recorder := sendTestRequest(receiver, logsPath, contentTypeProtobuf, body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusOK)
+	}
+
+	data := <-receivedChan
+	if data["message"] != "hello" {
+		t.Errorf("Unexpected event: %v", data)
+	}
+}
+
+func TestReceiverOTLPJSON(t *testing.T) {
+	receiver, receivedChan := createTestReceiver(t, true)
+
+	body := []byte(`{"resourceLogs":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"server"}}]},"scopeLogs":[{"logRecords":[{"timeUnixNano":"1704164645000000000","severityNumber":9,"body":{"stringValue":"hello"},"traceId":"000102030405060708090a0b0c0d0e0f","spanId":"0001020304050607","unknown":true}]}]}]}`)
+	recorder := sendTestRequest(receiver, logsPath, contentTypeJSON, body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status, got: %d, expected: %d (%s)", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	if recorder.Body.String() != "{}" {
+		t.Errorf("Unexpected response body: %s", recorder.Body.String())
+	}
+
+	data := <-receivedChan
+	if data["message"] != "hello" || data["trace"].(map[string]interface{})["id"] != "000102030405060708090a0b0c0d0e0f" {
+		t.Errorf("Unexpected event: %v", data)
+	}
+}
+
+func TestReceiverOTLPRejected(t *testing.T) {
+	receiver, _ := createTestReceiver(t, false)
+
+	body, _ := proto.Marshal(createTestLogs())
+	recorder := sendTestRequest(receiver, logsPath, contentTypeProtobuf, body)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReceiverOTLPInvalid(t *testing.T) {
+	receiver, _ := createTestReceiver(t, true)
+
+	recorder := sendTestRequest(receiver, logsPath, contentTypeJSON, []byte(`{"resourceLogs":`))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	recorder = sendTestRequest(receiver, logsPath, "text/plain", []byte("hello"))
+	if recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusUnsupportedMediaType)
+	}
+
+	recorder = sendTestRequest(receiver, "/v1/traces", contentTypeProtobuf, nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,432 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// errRejected occurs when the server rejects a request with a status that
+// the OTLP specification says must not be retried
+var errRejected = errors.New("request rejected")
+
+// payload contains nonce and events information
+type payload struct {
+	nonce  *string
+	events []*event.Event
+}
+
+type clientCacheItem struct {
+	client  *http.Client
+	expires time.Time
+}
+
+// transportOTLP implements a transport that sends events as OTLP/HTTP log
+// exports
+type transportOTLP struct {
+	// Constructor
+	ctx          context.Context
+	shutdownFunc context.CancelFunc
+	config       *TransportOTLPFactory
+	netConfig    *transports.Config
+	poolEntry    *addresspool.PoolEntry
+	clientCache  map[string]*clientCacheItem
+	eventChan    chan<- transports.Event
+
+	// Internal
+	// payloadMutex is so we can easily discard existing sendChan and its contents each time we reset
+	payloadChan  chan *payload
+	payloadMutex sync.Mutex
+	poolMutex    sync.Mutex
+	wait         sync.WaitGroup
+}
+
+// Factory returns the associated factory
+func (t *transportOTLP) Factory() transports.TransportFactory {
+	return t.config
+}
+
+// startController starts the controller
+func (t *transportOTLP) startController() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine is the master routine which handles submission
+func (t *transportOTLP) controllerRoutine() {
+	defer func() {
+		// Wait for all routines to close and close all connections
+		t.wait.Wait()
+		for _, cacheItem := range t.clientCache {
+			cacheItem.client.CloseIdleConnections()
+		}
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	// Setup payload chan with max write count of pending payloads
+	t.payloadMutex.Lock()
+	t.payloadChan = make(chan *payload, t.netConfig.MaxPendingPayloads)
+	t.payloadMutex.Unlock()
+
+	t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Started, nil)
+
+	// Start secondary http routines
+	for i := 1; i < t.config.Routines; i++ {
+		t.wait.Add(1)
+		go t.httpRoutine(i)
+	}
+
+	// Become the main http routine
+	t.wait.Add(1)
+	t.httpRoutine(0)
+
+	// Ensure all resources for the cancel are cleaned up
+	t.shutdownFunc()
+}
+
+// httpRoutine performs requests to the OTLP server
+func (t *transportOTLP) httpRoutine(id int) {
+	defer func() {
+		t.wait.Done()
+	}()
+
+	backoffName := fmt.Sprintf("%s:%d Retry", t.poolEntry.Server, id)
+	backoff := core.NewExpBackoff(backoffName, t.config.Retry, t.config.RetryMax)
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case payload := <-t.payloadChan:
+			if payload == nil {
+				// Graceful shutdown
+				log.Infof("[T %s]{%d} OTLP routine stopped gracefully", t.poolEntry.Server, id)
+				return
+			}
+
+			body, err := t.encode(payload.events)
+			if err != nil {
+				// Should never happen as we are encoding to a buffer
+				panic(fmt.Sprintf("failed to encode request body: %s", err))
+			}
+
+			for {
+				// Pool Next() is not race-safe
+				t.poolMutex.Lock()
+				addr, err := t.poolEntry.Next()
+				t.poolMutex.Unlock()
+				if err == nil {
+					err = t.performRequest(addr, id, len(payload.events), body)
+				}
+				if err == nil {
+					break
+				}
+				if errors.Is(err, errRejected) {
+					log.Errorf("[T %s]{%d} DATA LOST: Discarding %d events: %s", t.poolEntry.Server, id, len(payload.events), err)
+					break
+				}
+
+				log.Errorf("[T %s]{%d} OTLP request failed: %s", t.poolEntry.Server, id, err)
+
+				if t.retryWait(backoff) {
+					return
+				}
+			}
+
+			select {
+			case <-t.ctx.Done():
+				// Forced failure
+				return
+			case t.eventChan <- transports.NewAckEvent(t.ctx, payload.nonce, uint32(len(payload.events))):
+			}
+		}
+	}
+}
+
+// encode returns the request body for the events, optionally gzip compressed
+func (t *transportOTLP) encode(events []*event.Event) ([]byte, error) {
+	body, err := proto.Marshal(eventsToLogs(events))
+	if err != nil || !t.config.Gzip {
+		return body, err
+	}
+
+	bodyBuffer := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(bodyBuffer)
+	if _, err := gzipWriter.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return bodyBuffer.Bytes(), nil
+}
+
+// performRequest sends a request to the OTLP server
+// Failures are wrapped with errRejected when they should not be retried
+func (t *transportOTLP) performRequest(addr *addresspool.Address, id int, count int, body []byte) error {
+	log.Debugf("[T %s]{%d} Performing OTLP request of %d events", addr.Desc(), id, count)
+
+	httpRequest, err := t.createRequest(t.ctx, addr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpResponse, err := t.getClient(addr).Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	responseBody, _ := io.ReadAll(httpResponse.Body)
+	httpResponse.Body.Close()
+
+	switch httpResponse.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("unexpected status: %s [Message: %s]", httpResponse.Status, statusMessage(responseBody))
+	default:
+		return fmt.Errorf("%w with status: %s [Message: %s]", errRejected, httpResponse.Status, statusMessage(responseBody))
+	}
+
+	if rejected, message := partialSuccess(responseBody); rejected != 0 || message != "" {
+		log.Warningf("[T %s]{%d} DATA LOST: Server rejected %d events: %s", addr.Desc(), id, rejected, message)
+	}
+
+	log.Debugf("[T %s]{%d} OTLP request complete (status: %s)", addr.Desc(), id, httpResponse.Status)
+	return nil
+}
+
+// statusMessage returns the message from a Status response body, or the body
+// itself if it does not contain one
+func statusMessage(body []byte) string {
+	message := &status.Status{}
+	if err := proto.Unmarshal(body, message); err == nil && message.GetMessage() != "" {
+		return message.GetMessage()
+	}
+	return string(body)
+}
+
+// partialSuccess returns the rejected count and error message from the
+// partial_success field of an ExportLogsServiceResponse
+func partialSuccess(body []byte) (int64, string) {
+	var (
+		rejected int64
+		message  string
+	)
+	for len(body) > 0 {
+		number, wireType, length := protowire.ConsumeTag(body)
+		if length < 0 {
+			break
+		}
+		body = body[length:]
+		if number != 1 || wireType != protowire.BytesType {
+			if length = protowire.ConsumeFieldValue(number, wireType, body); length < 0 {
+				break
+			}
+			body = body[length:]
+			continue
+		}
+
+		partial, length := protowire.ConsumeBytes(body)
+		if length < 0 {
+			break
+		}
+		body = body[length:]
+
+		for len(partial) > 0 {
+			number, wireType, length := protowire.ConsumeTag(partial)
+			if length < 0 {
+				break
+			}
+			partial = partial[length:]
+			switch {
+			case number == 1 && wireType == protowire.VarintType:
+				value, valueLength := protowire.ConsumeVarint(partial)
+				rejected, length = int64(value), valueLength
+			case number == 2 && wireType == protowire.BytesType:
+				value, valueLength := protowire.ConsumeString(partial)
+				message, length = value, valueLength
+			default:
+				length = protowire.ConsumeFieldValue(number, wireType, partial)
+			}
+			if length < 0 {
+				break
+			}
+			partial = partial[length:]
+		}
+	}
+	return rejected, message
+}
+
+// retryWait waits the backoff timeout before attempting to retry
+// It also monitors for shutdown whilst waiting
+func (t *transportOTLP) retryWait(backoff *core.ExpBackoff) bool {
+	now := time.Now()
+	reconnectDue := now.Add(backoff.Trigger())
+
+	select {
+	case <-t.ctx.Done():
+		// Shutdown request
+		return true
+	case <-time.After(reconnectDue.Sub(now)):
+	}
+
+	return false
+}
+
+// SendEvents sends events to the transport - only valid after Started transport event received
+func (t *transportOTLP) SendEvents(nonce string, events []*event.Event) error {
+	// Are we ready?
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		return transports.ErrInvalidState
+	}
+	t.payloadChan <- &payload{nonce: &nonce, events: events}
+	return nil
+}
+
+// Ping the remote server - not implemented for OTLP since we close connections after each send
+// Immediately respond with a pong
+func (t *transportOTLP) Ping() error {
+	go func() {
+		log.Debugf("[T %s] Responding with pong", t.poolEntry.Server)
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case t.eventChan <- transports.NewPongEvent(t.ctx):
+		}
+	}()
+	return nil
+}
+
+// Fail the transport
+func (t *transportOTLP) Fail() {
+	t.shutdownFunc()
+}
+
+// Shutdown the transport - only valid after Started transport event received
+func (t *transportOTLP) Shutdown() {
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		// No connection active so just fail
+		t.shutdownFunc()
+	} else {
+		// Trigger graceful shutdown
+		close(t.payloadChan)
+	}
+}
+
+// createRequest creates a new http.Request and adds the configured headers
+func (t *transportOTLP) createRequest(ctx context.Context, addr *addresspool.Address, body *bytes.Reader) (*http.Request, error) {
+	var scheme string
+	if t.config.transport == TransportOTLPHTTPS {
+		scheme = "https"
+	} else {
+		scheme = "http"
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s://%s%s", scheme, addr.Addr().String(), t.config.Path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the host name for virtual hosting rather than the resolved address
+	request.Host = net.JoinHostPort(addr.Host(), strconv.Itoa(addr.Addr().Port))
+
+	for key, value := range t.config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	request.Header.Set("Content-Type", contentTypeProtobuf)
+	if t.config.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if t.config.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	}
+
+	return request, nil
+}
+
+// getClient returns a http.Client for the given server
+func (t *transportOTLP) getClient(addr *addresspool.Address) *http.Client {
+	t.poolMutex.Lock()
+	defer t.poolMutex.Unlock()
+
+	now := time.Now()
+	expires := time.Now().Add(time.Second * 300)
+	cacheItem, ok := t.clientCache[addr.Host()]
+	if ok {
+		cacheItem.expires = expires
+		return cacheItem.client
+	}
+
+	for key, cacheItem := range t.clientCache {
+		if cacheItem.expires.Before(now) {
+			cacheItem.client.CloseIdleConnections()
+			delete(t.clientCache, key)
+		}
+	}
+
+	certPool := x509.NewCertPool()
+	for _, cert := range t.config.CaList {
+		certPool.AddCert(cert)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    certPool,
+		ServerName: addr.Host(),
+		MinVersion: t.config.MinTLSVersion,
+		MaxVersion: t.config.MaxTLSVersion,
+	}
+	if t.config.Certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*t.config.Certificate}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSHandshakeTimeout: t.netConfig.Timeout,
+			TLSClientConfig:     tlsConfig,
+		},
+		Timeout: t.netConfig.Timeout,
+	}
+
+	t.clientCache[addr.Host()] = &clientCacheItem{client, expires}
+	return client
+}
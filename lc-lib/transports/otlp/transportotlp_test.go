@@ -0,0 +1,178 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func createTestTransport(t *testing.T, server *httptest.Server) (transports.Transport, chan transports.Event) {
+	f := &TransportOTLPFactory{
+		transport: TransportOTLP,
+		ClientTlsConfiguration: &transports.ClientTlsConfiguration{
+			TlsConfiguration: &transports.TlsConfiguration{},
+		},
+	}
+	f.Defaults()
+	f.Routines = 1
+	f.BearerToken = "token"
+	if err := f.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+
+	pool, err := addresspool.GeneratePool([]string{strings.TrimPrefix(server.URL, "http://")}, false, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate pool: %s", err)
+	}
+
+	netConfig := &transports.Config{}
+	netConfig.Defaults()
+	ctx := context.WithValue(context.Background(), transports.ContextConfig, netConfig)
+
+	eventChan := make(chan transports.Event, 10)
+	return f.NewTransport(ctx, pool[0], eventChan), eventChan
+}
+
+func receiveEvent(t *testing.T, eventChan <-chan transports.Event) transports.Event {
+	select {
+	case evnt := <-eventChan:
+		return evnt
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for transport event")
+	}
+	return nil
+}
+
+func expectAck(t *testing.T, eventChan <-chan transports.Event, expected uint32) {
+	evnt := receiveEvent(t, eventChan)
+	ack, ok := evnt.(transports.AckEvent)
+	if !ok {
+		t.Fatalf("Unexpected transport event: %T", evnt)
+	}
+	if ack.Sequence() != expected {
+		t.Fatalf("Unexpected sequence, got: %d, expected: %d", ack.Sequence(), expected)
+	}
+}
+
+func createTestEvents(messages ...string) []*event.Event {
+	events := make([]*event.Event, len(messages))
+	for idx, message := range messages {
+		events[idx] = event.NewEvent(context.Background(), nil, map[string]interface{}{"message": message})
+	}
+	return events
+}
+
+func TestTransportOTLPSends(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		received []*logspb.LogsData
+		failures = 1
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.URL.Path != logsPath || req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Content-Type") != contentTypeProtobuf {
+			t.Errorf("Unexpected request: %s %v", req.URL.Path, req.Header)
+		}
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("Failed to decompress request: %s", err)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+		logs := &logspb.LogsData{}
+		if err := proto.Unmarshal(body, logs); err != nil {
+			t.Errorf("Failed to decode request: %s", err)
+		}
+		received = append(received, logs)
+	}))
+	defer server.Close()
+
+	transport, eventChan := createTestTransport(t, server)
+	if _, ok := receiveEvent(t, eventChan).(*transports.StatusEvent); !ok {
+		t.Fatal("Transport did not start")
+	}
+
+	if err := transport.SendEvents("nonce", createTestEvents("one", "two")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectAck(t, eventChan, 2)
+	transport.Shutdown()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 1 || len(received[0].ResourceLogs[0].ScopeLogs[0].LogRecords) != 2 {
+		t.Errorf("Unexpected requests received: %v", received)
+	}
+}
+
+func TestTransportOTLPDiscardsRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	transport, eventChan := createTestTransport(t, server)
+	receiveEvent(t, eventChan)
+
+	if err := transport.SendEvents("nonce", createTestEvents("one")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectAck(t, eventChan, 1)
+	transport.Shutdown()
+}
+
+func TestPartialSuccess(t *testing.T) {
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, 3)
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, "invalid")
+
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.BytesType)
+	body = protowire.AppendBytes(body, partial)
+
+	rejected, message := partialSuccess(body)
+	if rejected != 3 || message != "invalid" {
+		t.Errorf("Unexpected partial success: %d %s", rejected, message)
+	}
+
+	if rejected, message := partialSuccess(nil); rejected != 0 || message != "" {
+		t.Errorf("Unexpected partial success for empty response: %d %s", rejected, message)
+	}
+}
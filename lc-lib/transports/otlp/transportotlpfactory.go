@@ -0,0 +1,155 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	defaultGzip     bool          = true
+	defaultPath     string        = logsPath
+	defaultRoutines int           = 4
+	defaultRetry    time.Duration = 0 * time.Second
+	defaultRetryMax time.Duration = 300 * time.Second
+)
+
+var (
+	// TransportOTLP is the transport name for OTLP/HTTP
+	TransportOTLP = "otlp"
+	// TransportOTLPHTTPS is the transport name for OTLP/HTTP over HTTPS
+	TransportOTLPHTTPS = "otlp-https"
+)
+
+// TransportOTLPFactory holds the configuration from the configuration file
+// It allows creation of TransportOTLP instances that use this configuration
+type TransportOTLPFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	BearerToken string            `config:"bearer token"`
+	Gzip        bool              `config:"gzip"`
+	Headers     map[string]string `config:"headers"`
+	Path        string            `config:"path"`
+	Retry       time.Duration     `config:"retry backoff"`
+	RetryMax    time.Duration     `config:"retry backoff max"`
+	Routines    int               `config:"routines"`
+
+	*transports.ClientTlsConfiguration `config:",embed"`
+}
+
+// NewTransportOTLPFactory create a new TransportOTLPFactory from the provided
+// configuration data, reporting back any configuration errors it discovers
+func NewTransportOTLPFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.TransportFactory, error) {
+	ret := &TransportOTLPFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *TransportOTLPFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.Routines < 1 {
+		return fmt.Errorf("%sroutines cannot be less than 1", configPath)
+	}
+	if f.Routines > 32 {
+		return fmt.Errorf("%sroutines cannot be more than 32", configPath)
+	}
+
+	if !strings.HasPrefix(f.Path, "/") {
+		return fmt.Errorf("%spath must begin with a \"/\"", configPath)
+	}
+
+	return f.ClientTlsConfiguration.TlsValidate(f.transport == TransportOTLPHTTPS, p, configPath)
+}
+
+// Defaults sets the default configuration values
+func (f *TransportOTLPFactory) Defaults() {
+	f.Gzip = defaultGzip
+	f.Path = defaultPath
+	f.Routines = defaultRoutines
+	f.Retry = defaultRetry
+	f.RetryMax = defaultRetryMax
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportOTLPFactory.
+func (f *TransportOTLPFactory) NewTransport(ctx context.Context, poolEntry *addresspool.PoolEntry, eventChan chan<- transports.Event) transports.Transport {
+	ctx, shutdownFunc := context.WithCancel(ctx)
+
+	ret := &transportOTLP{
+		ctx:          ctx,
+		shutdownFunc: shutdownFunc,
+		config:       f,
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
+		poolEntry:    poolEntry,
+		eventChan:    eventChan,
+		clientCache:  make(map[string]*clientCacheItem),
+	}
+
+	ret.startController()
+	return ret
+}
+
+// ShouldRestart returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (f *TransportOTLPFactory) ShouldRestart(newConfig transports.TransportFactory) bool {
+	newConfigImpl := newConfig.(*TransportOTLPFactory)
+	if newConfigImpl.BearerToken != f.BearerToken {
+		return true
+	}
+	if newConfigImpl.Gzip != f.Gzip {
+		return true
+	}
+	if !reflect.DeepEqual(newConfigImpl.Headers, f.Headers) {
+		return true
+	}
+	if newConfigImpl.Path != f.Path {
+		return true
+	}
+	if newConfigImpl.Retry != f.Retry {
+		return true
+	}
+	if newConfigImpl.RetryMax != f.RetryMax {
+		return true
+	}
+	if newConfigImpl.Routines != f.Routines {
+		return true
+	}
+
+	return f.ClientTlsConfiguration.HasChanged(newConfigImpl.ClientTlsConfiguration)
+}
+
+// Register the transports
+func init() {
+	transports.RegisterTransport(TransportOTLP, NewTransportOTLPFactory)
+	transports.RegisterTransport(TransportOTLPHTTPS, NewTransportOTLPFactory)
+}
@@ -0,0 +1,354 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lumberjack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+const (
+	protocolVersion byte = '2'
+
+	frameWindow     byte = 'W'
+	frameCompressed byte = 'C'
+	frameJSON       byte = 'J'
+	frameData       byte = 'D'
+	frameAck        byte = 'A'
+
+	// defaultMaxPayloadSize is the default for the largest window of events
+	// that will be accepted
+	defaultMaxPayloadSize = 10485760
+)
+
+var (
+	// errUnexpectedCompressedEnd occurs when a compressed frame ends part way
+	// through a frame contained within it
+	errUnexpectedCompressedEnd = errors.New("protocol error: Unexpected end of compressed frame")
+)
+
+// protocol implements the Lumberjack v2 protocol used by Beats, receiving
+// each window of events as a single payload
+type protocol struct {
+	conn tcp.Connection
+
+	// maxPayloadSize is the largest size in bytes that a window of events, or
+	// the decompressed contents of a compressed frame, can reach
+	maxPayloadSize int64
+
+	// windowSize, events, sequences and size hold the window currently being
+	// received
+	windowSize uint32
+	events     []map[string]interface{}
+	sequences  []uint32
+	size       int
+
+	// windowCount is used to generate a unique nonce for each window
+	windowCount uint64
+
+	// pending holds the sequence numbers for each window awaiting
+	// acknowledgement, keyed by nonce
+	pending      map[string][]uint32
+	pendingMutex sync.Mutex
+}
+
+// Negotiation does not happen for Lumberjack
+func (p *protocol) Negotiation() (transports.Event, error) {
+	return nil, nil
+}
+
+// SendEvents is not implemented as this is not a transport
+func (p *protocol) SendEvents(nonce string, events []*event.Event) error {
+	panic("Not implemented")
+}
+
+// Acknowledge sends an acknowledgement for the event at the given position
+// within the window, using the sequence number the client assigned to it
+// A sequence of 0 is sent as-is and is treated by clients as a keepalive
+func (p *protocol) Acknowledge(nonce *string, sequence uint32) error {
+	p.pendingMutex.Lock()
+	sequences, ok := p.pending[*nonce]
+	if !ok {
+		p.pendingMutex.Unlock()
+		return fmt.Errorf("acknowledgement for unknown window %x", *nonce)
+	}
+	var ackSequence uint32
+	if sequence != 0 {
+		ackSequence = sequences[sequence-1]
+	}
+	if sequence == uint32(len(sequences)) {
+		delete(p.pending, *nonce)
+	}
+	p.pendingMutex.Unlock()
+
+	log.Debugf("[R %s > %s] Sending acknowledgement for window %x with sequence %d", p.conn.LocalAddr().String(), p.conn.RemoteAddr().String(), *nonce, ackSequence)
+	return p.conn.SendMessage(&protocolACK{sequence: ackSequence})
+}
+
+// Ping is not implemented as this is not a transport
+func (p *protocol) Ping() error {
+	panic("Not implemented")
+}
+
+// Pong is not implemented as we will never receive a Ping
+func (p *protocol) Pong() error {
+	panic("Not implemented")
+}
+
+// Read reads frames from the connection until a full window of events has
+// been received, and returns them as a single payload
+func (p *protocol) Read() (transports.Event, error) {
+	for {
+		if err := p.readFrame(p.conn, false); err != nil {
+			return nil, err
+		}
+
+		if p.windowSize != 0 && len(p.events) == int(p.windowSize) {
+			return p.completeWindow(), nil
+		}
+	}
+}
+
+// completeWindow generates the payload for the window that has just been
+// received and registers its sequence numbers for acknowledgement
+func (p *protocol) completeWindow() transports.EventsEvent {
+	p.windowCount++
+	nonce := string(binary.BigEndian.AppendUint64(nil, p.windowCount))
+
+	p.pendingMutex.Lock()
+	p.pending[nonce] = p.sequences
+	p.pendingMutex.Unlock()
+
+	log.Debugf("[R %s < %s] Received window %x with %d events", p.conn.LocalAddr().String(), p.conn.RemoteAddr().String(), nonce, len(p.events))
+
+	eventsEvent := transports.NewEventsEvent(p.conn.Context(), &nonce, p.events, p.size)
+	p.windowSize = 0
+	p.events = nil
+	p.sequences = nil
+	p.size = 0
+	return eventsEvent
+}
+
+// readFrame reads a single frame from the reader
+// Frames within a compressed frame cannot themselves be window or compressed
+// frames
+func (p *protocol) readFrame(reader io.Reader, compressed bool) (err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return
+	}
+
+	if header[0] != protocolVersion {
+		return fmt.Errorf("protocol error: Unsupported protocol version %q", header[0])
+	}
+
+	switch {
+	case header[1] == frameWindow && !compressed:
+		err = p.readWindow(reader)
+	case header[1] == frameCompressed && !compressed:
+		err = p.readCompressed(reader)
+	case header[1] == frameJSON:
+		err = p.readJSON(reader)
+	case header[1] == frameData:
+		err = p.readData(reader)
+	default:
+		return fmt.Errorf("protocol error: Unexpected frame type %q", header[1])
+	}
+
+	// Having read the header the frame must be complete, so any EOF is
+	// unexpected
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// readWindow reads a window size frame which begins a new window
+func (p *protocol) readWindow(reader io.Reader) error {
+	var windowSize uint32
+	if err := binary.Read(reader, binary.BigEndian, &windowSize); err != nil {
+		return err
+	}
+
+	if len(p.events) != 0 {
+		return fmt.Errorf("protocol error: New window received with %d events of the previous window outstanding", int(p.windowSize)-len(p.events))
+	}
+	if windowSize == 0 {
+		return errors.New("protocol error: Window size cannot be 0")
+	}
+	// Every event occupies at least one byte so a larger window could never be
+	// received within the payload size limit
+	if int64(windowSize) > p.maxPayloadSize {
+		return fmt.Errorf("protocol error: Window size too large (%d > %d)", windowSize, p.maxPayloadSize)
+	}
+
+	p.windowSize = windowSize
+	return nil
+}
+
+// readCompressed reads a compressed frame and each of the frames contained
+// within it
+func (p *protocol) readCompressed(reader io.Reader) error {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return err
+	}
+
+	if int64(length) > p.maxPayloadSize {
+		return fmt.Errorf("protocol error: Compressed frame too large (%d > %d)", length, p.maxPayloadSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	decompressor, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// Limit the decompressed size so a small compressed frame cannot expand
+	// into an unbounded amount of data, allowing one byte more than the limit
+	// so we can tell when it was exceeded
+	limited := &io.LimitedReader{R: decompressor, N: p.maxPayloadSize + 1}
+	for {
+		if err := p.readFrame(limited, true); err != nil {
+			if limited.N == 0 {
+				return fmt.Errorf("protocol error: Decompressed frame too large (> %d)", p.maxPayloadSize)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return errUnexpectedCompressedEnd
+			}
+			return err
+		}
+	}
+}
+
+// readJSON reads a JSON frame containing a single event
+func (p *protocol) readJSON(reader io.Reader) error {
+	var header [8]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return err
+	}
+
+	sequence := binary.BigEndian.Uint32(header[0:4])
+	length := binary.BigEndian.Uint32(header[4:8])
+	if int64(length) > p.maxPayloadSize {
+		return tcp.ErrEventTooLarge
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		decoded = make(map[string]interface{})
+		decoded["message"] = err.Error()
+		decoded["tags"] = "_unmarshal_failure"
+	}
+
+	return p.addEvent(sequence, decoded, len(data))
+}
+
+// readData reads a data frame containing a single event made up of key value
+// pairs
+func (p *protocol) readData(reader io.Reader) error {
+	var header [8]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return err
+	}
+
+	sequence := binary.BigEndian.Uint32(header[0:4])
+	pairs := binary.BigEndian.Uint32(header[4:8])
+
+	decoded := make(map[string]interface{})
+	size := 0
+	for i := uint32(0); i < pairs; i++ {
+		key, err := p.readString(reader)
+		if err != nil {
+			return err
+		}
+		value, err := p.readString(reader)
+		if err != nil {
+			return err
+		}
+		decoded[key] = value
+		size += len(key) + len(value)
+		if int64(size) > p.maxPayloadSize {
+			return tcp.ErrEventTooLarge
+		}
+	}
+
+	return p.addEvent(sequence, decoded, size)
+}
+
+// readString reads a length prefixed string from a data frame
+func (p *protocol) readString(reader io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	if int64(length) > p.maxPayloadSize {
+		return "", tcp.ErrEventTooLarge
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// addEvent adds a received event to the current window
+func (p *protocol) addEvent(sequence uint32, decoded map[string]interface{}, size int) error {
+	if p.windowSize == 0 {
+		return errors.New("protocol error: Event received before window size")
+	}
+	if len(p.events) == int(p.windowSize) {
+		return fmt.Errorf("protocol error: Window exceeded its size of %d", p.windowSize)
+	}
+	if int64(p.size+size) > p.maxPayloadSize {
+		return fmt.Errorf("protocol error: Window exceeded the maximum payload size of %d", p.maxPayloadSize)
+	}
+
+	p.events = append(p.events, decoded)
+	p.sequences = append(p.sequences, sequence)
+	p.size += size
+	return nil
+}
+
+// NonBlocking returns false because Lumberjack blocks until full frames are received
+func (p *protocol) NonBlocking() bool {
+	return false
+}
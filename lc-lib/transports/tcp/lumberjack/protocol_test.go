@@ -0,0 +1,257 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lumberjack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type testConnection struct {
+	reader  *bytes.Reader
+	written bytes.Buffer
+}
+
+func newTestConnection(data []byte) *testConnection {
+	return &testConnection{reader: bytes.NewReader(data)}
+}
+
+func (c *testConnection) Context() context.Context {
+	return context.Background()
+}
+
+func (c *testConnection) Write(data []byte) (int, error) {
+	return c.written.Write(data)
+}
+
+func (c *testConnection) Flush() error {
+	return nil
+}
+
+func (c *testConnection) Read(data []byte) (int, error) {
+	return io.ReadFull(c.reader, data)
+}
+
+func (c *testConnection) SendMessage(message tcp.ProtocolMessage) error {
+	return message.Write(c)
+}
+
+func (c *testConnection) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *testConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func newTestProtocol(conn tcp.Connection) tcp.Protocol {
+	return (&protocolFactory{maxPayloadSize: defaultMaxPayloadSize}).NewProtocol(conn)
+}
+
+func windowFrame(size uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{'2', 'W'}, size)
+}
+
+func jsonFrame(sequence uint32, data string) []byte {
+	frame := binary.BigEndian.AppendUint32([]byte{'2', 'J'}, sequence)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	return append(frame, data...)
+}
+
+func dataFrame(sequence uint32, pairs ...string) []byte {
+	frame := binary.BigEndian.AppendUint32([]byte{'2', 'D'}, sequence)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(pairs)/2))
+	for _, value := range pairs {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(value)))
+		frame = append(frame, value...)
+	}
+	return frame
+}
+
+func compressedFrame(t *testing.T, frames ...[]byte) []byte {
+	var buffer bytes.Buffer
+	compressor := zlib.NewWriter(&buffer)
+	for _, frame := range frames {
+		if _, err := compressor.Write(frame); err != nil {
+			t.Fatalf("Failed to compress: %s", err)
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatalf("Failed to compress: %s", err)
+	}
+	frame := binary.BigEndian.AppendUint32([]byte{'2', 'C'}, uint32(buffer.Len()))
+	return append(frame, buffer.Bytes()...)
+}
+
+func readWindow(t *testing.T, p tcp.Protocol) transports.EventsEvent {
+	evnt, err := p.Read()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	eventsEvent, ok := evnt.(transports.EventsEvent)
+	if !ok {
+		t.Fatalf("Unexpected event: %T", evnt)
+	}
+	return eventsEvent
+}
+
+func expectAcks(t *testing.T, conn *testConnection, expected ...uint32) {
+	written := conn.written.Bytes()
+	if len(written) != len(expected)*6 {
+		t.Fatalf("Unexpected acknowledgement data: %v", written)
+	}
+	for idx, sequence := range expected {
+		ack := written[idx*6 : idx*6+6]
+		if ack[0] != '2' || ack[1] != 'A' || binary.BigEndian.Uint32(ack[2:]) != sequence {
+			t.Errorf("Unexpected acknowledgement %d, got: %v, expected sequence: %d", idx, ack, sequence)
+		}
+	}
+}
+
+func TestReadJSONWindow(t *testing.T) {
+	var data []byte
+	data = append(data, windowFrame(2)...)
+	data = append(data, jsonFrame(1, `{"message":"one"}`)...)
+	data = append(data, jsonFrame(2, `{"message":"two"}`)...)
+	conn := newTestConnection(data)
+	p := newTestProtocol(conn)
+
+	eventsEvent := readWindow(t, p)
+	if eventsEvent.Count() != 2 {
+		t.Fatalf("Unexpected event count, got: %d, expected: 2", eventsEvent.Count())
+	}
+	if message := eventsEvent.Events()[1]["message"]; message != "two" {
+		t.Errorf("Unexpected message, got: %v, expected: two", message)
+	}
+
+	if _, err := p.Read(); err != io.EOF {
+		t.Errorf("Unexpected error, got: %v, expected: EOF", err)
+	}
+}
+
+func TestReadCompressedDataWindow(t *testing.T) {
+	var data []byte
+	data = append(data, windowFrame(2)...)
+	data = append(data, compressedFrame(t,
+		dataFrame(1, "message", "one", "host", "a"),
+		jsonFrame(2, `{"message":"two"}`),
+	)...)
+	conn := newTestConnection(data)
+	p := newTestProtocol(conn)
+
+	eventsEvent := readWindow(t, p)
+	if eventsEvent.Count() != 2 {
+		t.Fatalf("Unexpected event count, got: %d, expected: 2", eventsEvent.Count())
+	}
+	first := eventsEvent.Events()[0]
+	if first["message"] != "one" || first["host"] != "a" {
+		t.Errorf("Unexpected data frame event: %v", first)
+	}
+}
+
+func TestAcknowledgeWindow(t *testing.T) {
+	var data []byte
+	data = append(data, windowFrame(3)...)
+	data = append(data, jsonFrame(5, `{}`)...)
+	data = append(data, jsonFrame(6, `{}`)...)
+	data = append(data, jsonFrame(7, `{}`)...)
+	conn := newTestConnection(data)
+	p := newTestProtocol(conn)
+
+	eventsEvent := readWindow(t, p)
+	if err := p.Acknowledge(eventsEvent.Nonce(), 0); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := p.Acknowledge(eventsEvent.Nonce(), 2); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := p.Acknowledge(eventsEvent.Nonce(), 3); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectAcks(t, conn, 0, 6, 7)
+
+	if err := p.Acknowledge(eventsEvent.Nonce(), 3); err == nil {
+		t.Errorf("Acknowledgement of completed window did not fail")
+	}
+}
+
+func TestReadWindowExceeded(t *testing.T) {
+	var data []byte
+	data = append(data, windowFrame(1)...)
+	data = append(data, compressedFrame(t, jsonFrame(1, `{}`), jsonFrame(2, `{}`))...)
+	p := newTestProtocol(newTestConnection(data))
+
+	if _, err := p.Read(); err == nil {
+		t.Errorf("Window exceeding its size did not fail")
+	}
+}
+
+func TestReadTruncatedCompressed(t *testing.T) {
+	frame := jsonFrame(1, `{"message":"one"}`)
+	var data []byte
+	data = append(data, windowFrame(1)...)
+	data = append(data, compressedFrame(t, frame[:len(frame)-2])...)
+	p := newTestProtocol(newTestConnection(data))
+
+	if _, err := p.Read(); err != errUnexpectedCompressedEnd {
+		t.Errorf("Unexpected error, got: %v, expected: %v", err, errUnexpectedCompressedEnd)
+	}
+}
+
+func TestReadWindowTooLarge(t *testing.T) {
+	p := (&protocolFactory{maxPayloadSize: 10}).NewProtocol(newTestConnection(windowFrame(11)))
+
+	if _, err := p.Read(); err == nil {
+		t.Errorf("Window size above the maximum payload size did not fail")
+	}
+}
+
+func TestReadWindowExceedsPayloadSize(t *testing.T) {
+	var data []byte
+	data = append(data, windowFrame(2)...)
+	data = append(data, jsonFrame(1, `{"message":"one"}`)...)
+	data = append(data, jsonFrame(2, `{"message":"two"}`)...)
+	p := (&protocolFactory{maxPayloadSize: 20}).NewProtocol(newTestConnection(data))
+
+	if _, err := p.Read(); err == nil {
+		t.Errorf("Window exceeding the maximum payload size did not fail")
+	}
+}
+
+func TestReadCompressedTooLarge(t *testing.T) {
+	// Empty pairs count nothing towards the event size but each takes 8 bytes
+	// to encode, so this compresses to far less than the limit but decompresses
+	// to more than it
+	pairs := make([]string, 200)
+	var data []byte
+	data = append(data, windowFrame(1)...)
+	data = append(data, compressedFrame(t, dataFrame(1, pairs...))...)
+	p := (&protocolFactory{maxPayloadSize: 500}).NewProtocol(newTestConnection(data))
+
+	if _, err := p.Read(); err == nil || !strings.Contains(err.Error(), "Decompressed frame too large") {
+		t.Errorf("Unexpected error for decompressed frame exceeding the maximum payload size: %v", err)
+	}
+}
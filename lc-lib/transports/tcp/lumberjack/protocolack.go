@@ -0,0 +1,40 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lumberjack
+
+import (
+	"encoding/binary"
+
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type protocolACK struct {
+	sequence uint32
+}
+
+// Write writes the acknowledgement to the connection
+func (p *protocolACK) Write(conn tcp.Connection) error {
+	// 1-byte protocol version (2)
+	// 1-byte frame type (A)
+	// 4-byte uint32 sequence
+	message := [6]byte{protocolVersion, frameAck}
+	binary.BigEndian.PutUint32(message[2:], p.sequence)
+	if _, err := conn.Write(message[:]); err != nil {
+		return err
+	}
+	return conn.Flush()
+}
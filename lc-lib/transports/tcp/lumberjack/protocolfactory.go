@@ -0,0 +1,37 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lumberjack
+
+import (
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type protocolFactory struct {
+	maxPayloadSize int64
+}
+
+func (p *protocolFactory) NewProtocol(conn tcp.Connection) tcp.Protocol {
+	return &protocol{
+		conn:           conn,
+		maxPayloadSize: p.maxPayloadSize,
+		pending:        make(map[string][]uint32),
+	}
+}
+
+func (p *protocolFactory) SupportsAck() bool {
+	return true
+}
@@ -0,0 +1,30 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+const (
+	// TransportSyslog is the transport name for plain syslog
+	TransportSyslog = "syslog"
+	// TransportSyslogTLS is the transport name for encrypted syslog
+	TransportSyslogTLS = "syslogtls"
+
+	// udpPrefix is the prefix of listen addresses that receive over UDP
+	udpPrefix = "udp://"
+
+	// maxMessageSize is the largest message that will be accepted
+	maxMessageSize = 1048576
+)
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// nilValue is used in RFC 5424 for fields that have no value
+	nilValue = "-"
+
+	// rfc3164TimestampLayout is the timestamp format used by RFC 3164, which
+	// has no year or timezone
+	rfc3164TimestampLayout = "Jan _2 15:04:05"
+)
+
+var (
+	errInvalidPriority       = errors.New("invalid priority")
+	errInvalidStructuredData = errors.New("invalid structured data")
+)
+
+// parseMessage parses a syslog message in RFC 5424 or RFC 3164 format,
+// returning the event data
+// If the message cannot be parsed the entire message is returned as the
+// message field and the event is tagged with _syslog_parse_failure
+func parseMessage(data []byte, now time.Time) map[string]interface{} {
+	// Trailing new lines and NULs are not part of the message
+	data = bytes.TrimRight(data, "\r\n\x00")
+
+	event := map[string]interface{}{}
+	priority, rest, err := parsePriority(data)
+	if err != nil {
+		event["message"] = string(data)
+		event["tags"] = "_syslog_parse_failure"
+		event["syslog_parse_error"] = err.Error()
+		return event
+	}
+
+	event["priority"] = priority
+	event["facility"] = priority / 8
+	event["severity"] = priority % 8
+
+	if len(rest) > 2 && rest[0] == '1' && rest[1] == ' ' {
+		err = parseRFC5424(event, rest[2:])
+	} else {
+		parseRFC3164(event, rest, now)
+	}
+	if err != nil {
+		event["message"] = string(rest)
+		event["tags"] = "_syslog_parse_failure"
+		event["syslog_parse_error"] = err.Error()
+	}
+
+	return event
+}
+
+// parsePriority parses the priority at the start of a message
+func parsePriority(data []byte) (int, []byte, error) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, nil, errInvalidPriority
+	}
+
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return 0, nil, errInvalidPriority
+	}
+
+	priority, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || priority > 191 {
+		return 0, nil, errInvalidPriority
+	}
+
+	return priority, data[end+1:], nil
+}
+
+// parseRFC5424 parses the remainder of an RFC 5424 message following the
+// version
+func parseRFC5424(event map[string]interface{}, data []byte) error {
+	var field []byte
+
+	field, data = nextField(data)
+	if string(field) != nilValue {
+		timestamp, err := time.Parse(time.RFC3339Nano, string(field))
+		if err != nil {
+			return err
+		}
+		event["@timestamp"] = timestamp
+	}
+
+	for _, name := range []string{"hostname", "appname", "procid", "msgid"} {
+		field, data = nextField(data)
+		if len(field) == 0 {
+			return errors.New("missing " + name)
+		}
+		if string(field) != nilValue {
+			event[name] = string(field)
+		}
+	}
+
+	if len(data) == 0 {
+		return errInvalidStructuredData
+	}
+	if data[0] == '-' {
+		data = data[1:]
+	} else {
+		structuredData, rest, err := parseStructuredData(data)
+		if err != nil {
+			return err
+		}
+		event["structured_data"] = structuredData
+		data = rest
+	}
+
+	if len(data) != 0 {
+		if data[0] != ' ' {
+			return errInvalidStructuredData
+		}
+		data = bytes.TrimPrefix(data[1:], []byte("\xef\xbb\xbf"))
+	}
+	event["message"] = string(data)
+	return nil
+}
+
+// parseStructuredData parses one or more structured data elements, returning
+// them as a map of SD-ID to a map of parameters, and the remaining data
+func parseStructuredData(data []byte) (map[string]interface{}, []byte, error) {
+	structuredData := map[string]interface{}{}
+	for len(data) != 0 && data[0] == '[' {
+		end := bytes.IndexAny(data, " ]")
+		if end < 2 {
+			return nil, nil, errInvalidStructuredData
+		}
+
+		params := map[string]interface{}{}
+		structuredData[string(data[1:end])] = params
+		data = data[end:]
+
+		for {
+			if len(data) == 0 {
+				return nil, nil, errInvalidStructuredData
+			}
+			if data[0] == ']' {
+				data = data[1:]
+				break
+			}
+
+			// Each parameter is a space followed by name="value"
+			equals := bytes.IndexByte(data, '=')
+			if data[0] != ' ' || equals < 2 || len(data) < equals+2 || data[equals+1] != '"' {
+				return nil, nil, errInvalidStructuredData
+			}
+			name := string(data[1:equals])
+
+			value, rest, err := parseParamValue(data[equals+2:])
+			if err != nil {
+				return nil, nil, err
+			}
+			params[name] = value
+			data = rest
+		}
+	}
+
+	return structuredData, data, nil
+}
+
+// parseParamValue parses a structured data parameter value up to and
+// including its closing quote, unescaping it
+func parseParamValue(data []byte) (string, []byte, error) {
+	var value []byte
+	for idx := 0; idx < len(data); idx++ {
+		switch data[idx] {
+		case '\\':
+			// Only ", \ and ] are escaped, otherwise the backslash is kept
+			if idx+1 < len(data) && (data[idx+1] == '"' || data[idx+1] == '\\' || data[idx+1] == ']') {
+				idx++
+			}
+		case '"':
+			return string(value), data[idx+1:], nil
+		}
+		value = append(value, data[idx])
+	}
+	return "", nil, errInvalidStructuredData
+}
+
+// parseRFC3164 parses the remainder of an RFC 3164 message following the
+// priority
+// RFC 3164 only describes common practice so the parsing is lenient, and
+// anything that cannot be understood is left in the message
+func parseRFC3164(event map[string]interface{}, data []byte, now time.Time) {
+	if len(data) >= len(rfc3164TimestampLayout) {
+		timestamp, err := time.ParseInLocation(rfc3164TimestampLayout, string(data[:len(rfc3164TimestampLayout)]), now.Location())
+		if err == nil {
+			// Assume the current year, unless that places the timestamp more than
+			// a day in the future, such as when receiving December's messages
+			// in January
+			timestamp = timestamp.AddDate(now.Year(), 0, 0)
+			if timestamp.After(now.Add(24 * time.Hour)) {
+				timestamp = timestamp.AddDate(-1, 0, 0)
+			}
+			event["@timestamp"] = timestamp
+			data = bytes.TrimPrefix(data[len(rfc3164TimestampLayout):], []byte(" "))
+
+			// The hostname follows the timestamp, unless it looks like the tag
+			if field, rest := nextField(data); len(field) != 0 && len(rest) != 0 && !bytes.ContainsAny(field, "[:") {
+				event["hostname"] = string(field)
+				data = rest
+			}
+		}
+	}
+
+	// The tag is the application name with an optional process ID, followed by
+	// a colon
+	if colon := bytes.Index(data, []byte(": ")); colon > 0 && !bytes.ContainsAny(data[:colon], " ") {
+		tag := data[:colon]
+		if open := bytes.IndexByte(tag, '['); open > 0 && tag[len(tag)-1] == ']' {
+			event["procid"] = string(tag[open+1 : len(tag)-1])
+			tag = tag[:open]
+		}
+		event["appname"] = string(tag)
+		data = data[colon+2:]
+	}
+
+	event["message"] = string(data)
+}
+
+// nextField returns the next space delimited field and the data following the
+// space
+func nextField(data []byte) ([]byte, []byte) {
+	if idx := bytes.IndexByte(data, ' '); idx >= 0 {
+		return data[:idx], data[idx+1:]
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRFC5424(t *testing.T) {
+	now := time.Now()
+	event := parseMessage([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application \"x\"" eventID="1011"][examplePriority@32473 class="high"] `+"\xef\xbb\xbf"+`An application event log entry...`), now)
+
+	expected := map[string]interface{}{
+		"@timestamp": time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		"priority":   165,
+		"facility":   20,
+		"severity":   5,
+		"hostname":   "mymachine.example.com",
+		"appname":    "evntslog",
+		"msgid":      "ID47",
+		"structured_data": map[string]interface{}{
+			"exampleSDID@32473": map[string]interface{}{
+				"iut":         "3",
+				"eventSource": `Application "x"`,
+				"eventID":     "1011",
+			},
+			"examplePriority@32473": map[string]interface{}{
+				"class": "high",
+			},
+		},
+		"message": "An application event log entry...",
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event:\n got: %v\n expected: %v", event, expected)
+	}
+}
+
+func TestParseRFC5424NilValues(t *testing.T) {
+	event := parseMessage([]byte("<34>1 - - - - - -\n"), time.Now())
+
+	expected := map[string]interface{}{
+		"priority": 34,
+		"facility": 4,
+		"severity": 2,
+		"message":  "",
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event:\n got: %v\n expected: %v", event, expected)
+	}
+}
+
+func TestParseRFC5424InvalidStructuredData(t *testing.T) {
+	event := parseMessage([]byte(`<34>1 - host app - - [id key="value] message`), time.Now())
+	if event["tags"] != "_syslog_parse_failure" {
+		t.Errorf("Invalid structured data was not tagged: %v", event)
+	}
+}
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC)
+	event := parseMessage([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8"), now)
+
+	expected := map[string]interface{}{
+		"@timestamp": time.Date(2020, 10, 11, 22, 14, 15, 0, time.UTC),
+		"priority":   34,
+		"facility":   4,
+		"severity":   2,
+		"hostname":   "mymachine",
+		"appname":    "su",
+		"procid":     "123",
+		"message":    "'su root' failed for lonvick on /dev/pts/8",
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event:\n got: %v\n expected: %v", event, expected)
+	}
+}
+
+func TestParseRFC3164NoHostname(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := parseMessage([]byte("<13>Dec 31 23:59:59 cron: job complete"), now)
+
+	expected := map[string]interface{}{
+		"@timestamp": time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC),
+		"priority":   13,
+		"facility":   1,
+		"severity":   5,
+		"appname":    "cron",
+		"message":    "job complete",
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event:\n got: %v\n expected: %v", event, expected)
+	}
+}
+
+func TestParseInvalidPriority(t *testing.T) {
+	event := parseMessage([]byte("no priority here"), time.Now())
+	if event["message"] != "no priority here" || event["tags"] != "_syslog_parse_failure" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type protocol struct {
+	conn       tcp.Connection
+	connReader *connectionReader
+	reader     *bufio.Reader
+}
+
+// connectionReader adapts a non-blocking connection into an io.Reader by
+// retrying reads that would block without having received any data, unless
+// wakeOnIdle is set, in which case tcp.ErrIOWouldBlock is returned so the
+// protocol can act on the connection being idle
+type connectionReader struct {
+	conn       tcp.Connection
+	wakeOnIdle bool
+}
+
+// Read implements io.Reader
+func (r *connectionReader) Read(data []byte) (int, error) {
+	for {
+		n, err := r.conn.Read(data)
+		if err == tcp.ErrIOWouldBlock {
+			if n == 0 {
+				if r.wakeOnIdle {
+					return 0, err
+				}
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Negotiation does not happen for syslog
+func (p *protocol) Negotiation() (transports.Event, error) {
+	return nil, nil
+}
+
+// SendEvents is not implemented as this is not a transport
+func (p *protocol) SendEvents(nonce string, events []*event.Event) error {
+	panic("Not implemented")
+}
+
+// Acknowledge is not used by syslog
+func (p *protocol) Acknowledge(nonce *string, sequence uint32) error {
+	return nil
+}
+
+// Ping is not implemented as this is not a transport
+func (p *protocol) Ping() error {
+	panic("Not implemented")
+}
+
+// Pong is not implemented as we will never receive a Ping
+func (p *protocol) Pong() error {
+	panic("Not implemented")
+}
+
+// Read reads a message from the connection and parses it into an event
+func (p *protocol) Read() (transports.Event, error) {
+	data, err := p.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	evnt := parseMessage(data, time.Now())
+	log.Debugf("Received event: %v", evnt)
+
+	return transports.NewEventsEvent(p.conn.Context(), &transports.NilNonce, []map[string]interface{}{evnt}, len(data)), nil
+}
+
+// readFrame reads a single message, which may use octet counting as
+// described by RFC 6587 where it begins with its length, or may be terminated
+// by a new line
+func (p *protocol) readFrame() ([]byte, error) {
+	first, err := p.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		return p.readOctetCounted()
+	}
+	return p.readLine()
+}
+
+// readOctetCounted reads a message prefixed by its length and a space
+func (p *protocol) readOctetCounted() ([]byte, error) {
+	lengthField, err := p.reader.ReadSlice(' ')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("protocol error: Invalid message length")
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(string(lengthField[:len(lengthField)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("protocol error: Invalid message length: %q", lengthField[:len(lengthField)-1])
+	}
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("protocol error: Message too large (%d > %d)", length, maxMessageSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readLine reads a message terminated by a new line
+// A final message that is not terminated is returned when the connection
+// closes, or when no more data is received for it within the socket interval,
+// as some senders do not terminate the last message they send
+func (p *protocol) readLine() ([]byte, error) {
+	p.connReader.wakeOnIdle = true
+	defer func() {
+		p.connReader.wakeOnIdle = false
+	}()
+
+	var line []byte
+	for {
+		segment, err := p.reader.ReadSlice('\n')
+		line = append(line, segment...)
+		switch {
+		case err == nil:
+			return line, nil
+		case err == bufio.ErrBufferFull:
+			if len(line) > maxMessageSize {
+				return nil, fmt.Errorf("protocol error: Message too large (> %d)", maxMessageSize)
+			}
+		case err == io.EOF && len(line) != 0:
+			return line, nil
+		case err == tcp.ErrIOWouldBlock:
+			if len(line) != 0 {
+				log.Debugf("[R %s < %s] Received message without a new line before the connection went idle", p.conn.LocalAddr().String(), p.conn.RemoteAddr().String())
+				return line, nil
+			}
+		default:
+			return nil, err
+		}
+	}
+}
+
+// NonBlocking returns true because new line framed messages do not have a
+// known length, so we check periodically for a message that was not
+// terminated before the connection went idle
+func (p *protocol) NonBlocking() bool {
+	return true
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type testConnection struct {
+	reader *bytes.Reader
+	// idle causes reads to block instead of returning EOF once all data is read
+	idle bool
+}
+
+func (c *testConnection) Context() context.Context {
+	return context.Background()
+}
+
+func (c *testConnection) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (c *testConnection) Flush() error {
+	return nil
+}
+
+func (c *testConnection) Read(data []byte) (int, error) {
+	// Return a single byte at a time to ensure partial reads are handled
+	n, err := c.reader.Read(data[:1])
+	if err == nil || (err == io.EOF && c.idle) {
+		err = tcp.ErrIOWouldBlock
+	}
+	return n, err
+}
+
+func (c *testConnection) SendMessage(message tcp.ProtocolMessage) error {
+	return nil
+}
+
+func (c *testConnection) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *testConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestProtocolFraming(t *testing.T) {
+	data := "<13>1 - - - - - - one\n21 <13>1 - - - - - - two<13>1 - - - - - - three\r\n<13>1 - - - - - - four"
+	p := (&protocolFactory{}).NewProtocol(&testConnection{reader: bytes.NewReader([]byte(data))})
+
+	for _, expected := range []string{"one", "two", "three", "four"} {
+		evnt, err := p.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		message := evnt.(transports.EventsEvent).Events()[0]["message"]
+		if message != expected {
+			t.Errorf("Unexpected message, got: %v, expected: %s", message, expected)
+		}
+	}
+
+	if _, err := p.Read(); err != io.EOF {
+		t.Errorf("Unexpected error, got: %v, expected: EOF", err)
+	}
+}
+
+func TestProtocolIdleUnterminated(t *testing.T) {
+	data := "<13>1 - - - - - - one\n<13>1 - - - - - - two"
+	p := (&protocolFactory{}).NewProtocol(&testConnection{reader: bytes.NewReader([]byte(data)), idle: true})
+
+	// The final message is returned once the connection goes idle without
+	// waiting for it to close
+	for _, expected := range []string{"one", "two"} {
+		evnt, err := p.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		message := evnt.(transports.EventsEvent).Events()[0]["message"]
+		if message != expected {
+			t.Errorf("Unexpected message, got: %v, expected: %s", message, expected)
+		}
+	}
+}
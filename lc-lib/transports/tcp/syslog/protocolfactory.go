@@ -0,0 +1,38 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"bufio"
+
+	"github.com/driskell/log-courier/lc-lib/transports/tcp"
+)
+
+type protocolFactory struct{}
+
+func (p *protocolFactory) NewProtocol(conn tcp.Connection) tcp.Protocol {
+	connReader := &connectionReader{conn: conn}
+	return &protocol{
+		conn:       conn,
+		connReader: connReader,
+		reader:     bufio.NewReader(connReader),
+	}
+}
+
+func (p *protocolFactory) SupportsAck() bool {
+	return false
+}
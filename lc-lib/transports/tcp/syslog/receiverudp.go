@@ -0,0 +1,262 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+// udpConnection represents a single remote address sending datagrams, so
+// that each is tracked by the receiver pool as a separate connection
+type udpConnection struct {
+	ctx    context.Context
+	remote string
+}
+
+type receiverUDP struct {
+	// Constructor
+	ctx          context.Context
+	shutdownFunc context.CancelFunc
+	factory      transports.ReceiverFactory
+	bind         string
+	eventChan    chan<- transports.Event
+	backoff      *core.ExpBackoff
+
+	// Internal
+	connections  map[string]*udpConnection
+	closed       []*udpConnection
+	connMutex    sync.Mutex
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+}
+
+// newReceiverUDP creates a new receiver listening for datagrams
+func newReceiverUDP(ctx context.Context, factory transports.ReceiverFactory, bind string, eventChan chan<- transports.Event) *receiverUDP {
+	backoffName := fmt.Sprintf("[R %s] Receiver Reset", bind)
+	ret := &receiverUDP{
+		factory:      factory,
+		bind:         bind,
+		eventChan:    eventChan,
+		backoff:      core.NewExpBackoff(backoffName, 0, 300*time.Second),
+		connections:  make(map[string]*udpConnection),
+		shutdownChan: make(chan struct{}),
+	}
+
+	ret.ctx, ret.shutdownFunc = context.WithCancel(context.WithValue(ctx, transports.ContextReceiver, ret))
+
+	ret.startController()
+	return ret
+}
+
+// Factory returns the associated factory
+func (t *receiverUDP) Factory() transports.ReceiverFactory {
+	return t.factory
+}
+
+// SupportsAck returns false as datagrams cannot be acknowledged
+func (t *receiverUDP) SupportsAck() bool {
+	return false
+}
+
+// startController starts the controller
+func (t *receiverUDP) startController() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine manages restarting listening as things fail
+func (t *receiverUDP) controllerRoutine() {
+	defer func() {
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	for {
+		err := t.listen()
+		if err == nil {
+			// Shutdown
+			break
+		}
+
+		log.Errorf("[R %s] Receiver error, resetting: %s", t.bind, err)
+
+		if t.retryWait() {
+			break
+		}
+	}
+
+	// Disconnect all remaining remotes
+	t.connMutex.Lock()
+	for remote, conn := range t.connections {
+		t.closed = append(t.closed, conn)
+		delete(t.connections, remote)
+	}
+	t.connMutex.Unlock()
+	t.sendDisconnects()
+
+	// Ensure resources are cleaned up for the context
+	t.shutdownFunc()
+
+	log.Infof("[R %s] Receiver exiting", t.bind)
+}
+
+// retryWait waits the backoff timeout before attempting to listen again
+// It also monitors for shutdown whilst waiting
+func (t *receiverUDP) retryWait() bool {
+	now := time.Now()
+	setupDue := now.Add(t.backoff.Trigger())
+
+	select {
+	case <-t.shutdownChan:
+		// Shutdown request
+		return true
+	case <-time.After(setupDue.Sub(now)):
+	}
+
+	return false
+}
+
+// listen receives datagrams until shutdown is requested
+func (t *receiverUDP) listen() error {
+	addr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(t.bind, udpPrefix))
+	if err != nil {
+		return fmt.Errorf("failed to select next address: %s", err)
+	}
+
+	log.Infof("[R %s] Attempting to listen", t.bind)
+
+	socket, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", t.bind, err)
+	}
+	defer socket.Close()
+
+	log.Noticef("[R %s] Listening", t.bind)
+
+	buffer := make([]byte, 65536)
+	for {
+		socket.SetReadDeadline(time.Now().Add(time.Second))
+		length, remoteAddr, err := socket.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				return fmt.Errorf("failed to receive on %s: %s", t.bind, err)
+			}
+		} else {
+			t.receive(remoteAddr.String(), buffer[:length])
+		}
+
+		t.sendDisconnects()
+
+		// Check for shutdown request
+		select {
+		case <-t.shutdownChan:
+			return nil
+		default:
+		}
+	}
+}
+
+// receive parses a datagram and passes the event to the pool, registering a
+// new connection for the remote if it is not already known
+func (t *receiverUDP) receive(remote string, data []byte) {
+	t.connMutex.Lock()
+	conn, ok := t.connections[remote]
+	if !ok {
+		conn = &udpConnection{remote: remote}
+		conn.ctx = context.WithValue(t.ctx, transports.ContextConnection, conn)
+		t.connections[remote] = conn
+	}
+	t.connMutex.Unlock()
+
+	if !ok {
+		log.Debugf("[R %s - %s] New remote", t.bind, remote)
+		t.eventChan <- transports.NewConnectEvent(conn.ctx, remote, "-")
+	}
+
+	evnt := parseMessage(data, time.Now())
+	log.Debugf("Received event: %v", evnt)
+
+	t.eventChan <- transports.NewEventsEvent(conn.ctx, &transports.NilNonce, []map[string]interface{}{evnt}, len(data))
+}
+
+// sendDisconnects notifies the pool of remotes that have been closed
+// This cannot happen when they are closed as that happens from within the
+// pool which would deadlock
+func (t *receiverUDP) sendDisconnects() {
+	t.connMutex.Lock()
+	closed := t.closed
+	t.closed = nil
+	t.connMutex.Unlock()
+
+	for _, conn := range closed {
+		t.eventChan <- transports.NewDisconnectEvent(conn.ctx, conn.remote, "-")
+	}
+}
+
+// closeConnection forgets a remote so that it is disconnected and any further
+// datagrams from it begin a new connection
+func (t *receiverUDP) closeConnection(ctx context.Context) {
+	conn := ctx.Value(transports.ContextConnection).(*udpConnection)
+	t.connMutex.Lock()
+	defer t.connMutex.Unlock()
+	if t.connections[conn.remote] == conn {
+		delete(t.connections, conn.remote)
+		t.closed = append(t.closed, conn)
+	}
+}
+
+// Acknowledge is not used as datagrams cannot be acknowledged
+func (t *receiverUDP) Acknowledge(ctx context.Context, nonce *string, sequence uint32) error {
+	return nil
+}
+
+// Pong is not implemented as we will never receive a Ping
+func (t *receiverUDP) Pong(ctx context.Context) error {
+	panic("Not implemented")
+}
+
+// FailConnection forgets a remote, such as when it has been idle
+func (t *receiverUDP) FailConnection(ctx context.Context, err error) {
+	conn := ctx.Value(transports.ContextConnection).(*udpConnection)
+	log.Debugf("[R %s - %s] Forgetting remote: %s", t.bind, conn.remote, err)
+	t.closeConnection(ctx)
+}
+
+// ShutdownConnection forgets a remote
+func (t *receiverUDP) ShutdownConnection(ctx context.Context) {
+	t.closeConnection(ctx)
+}
+
+// ShutdownConnectionRead is called when an event could not be queued, and as
+// datagrams cannot be refused the event is simply discarded
+func (t *receiverUDP) ShutdownConnectionRead(ctx context.Context, err error) {
+	conn := ctx.Value(transports.ContextConnection).(*udpConnection)
+	log.Warningf("[R %s - %s] Discarding event: %s", t.bind, conn.remote, err)
+}
+
+// Shutdown stops receiving datagrams
+func (t *receiverUDP) Shutdown() {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdownChan)
+	})
+}
@@ -76,7 +76,7 @@ func (f *TransportFactory) NewTransportWithProtocol(ctx context.Context, factory
 		shutdownFunc:    shutdownFunc,
 		config:          f,
 		factory:         factory,
-		netConfig:       transports.FetchConfig(f.config),
+		netConfig:       transports.ConfigFromContext(ctx, f.config),
 		poolEntry:       poolEntry,
 		eventChan:       eventChan,
 		backoff:         core.NewExpBackoff(backoffName, f.Reconnect, f.ReconnectMax),
@@ -38,6 +38,13 @@ type Transport interface {
 	Factory() TransportFactory
 }
 
+// DropCounter is implemented by transports that discard events they are unable
+// to send, such as when the destination for an event cannot be determined, so
+// that the number of events discarded can be reported
+type DropCounter interface {
+	DroppedEvents() uint64
+}
+
 // TransportFactory is the interface that all transport factories implement. The
 // transport factory should store the transport's configuration and, when
 // NewTransport is called, return an instance of the transport that obeys that
@@ -47,6 +54,15 @@ type TransportFactory interface {
 	ShouldRestart(TransportFactory) bool
 }
 
+// LocalTransportFactory is implemented by transport factories whose transports
+// do not connect to any server, such as the file transport. The servers
+// configuration is optional for these transports, and when it is not given a
+// single endpoint is created using the server name returned by LocalServer
+type LocalTransportFactory interface {
+	TransportFactory
+	LocalServer() string
+}
+
 // TransportRegistrarFunc is a callback that validates the configuration for
 // a transport that was registered via RegisterTransport
 type TransportRegistrarFunc func(*config.Parser, string, map[string]interface{}, string) (TransportFactory, error)
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+var newLine = []byte("\n")
+
+// batch holds the events from a payload that are to be sent to a single URL
+type batch struct {
+	url     string
+	events  []*event.Event
+	indexes []int
+}
+
+// newBatches splits the events into batches, one per distinct URL generated
+// by the pattern, retaining the order in which each URL was first seen
+// The indexes of any events for which the pattern fails are returned
+// separately
+func newBatches(urlPattern event.Pattern, events []*event.Event) ([]*batch, []int) {
+	if urlPattern.IsStatic() {
+		indexes := make([]int, len(events))
+		for index := range events {
+			indexes[index] = index
+		}
+		return []*batch{{url: urlPattern.String(), events: events, indexes: indexes}}, nil
+	}
+
+	var (
+		batches []*batch
+		failed  []int
+	)
+	batchesByURL := make(map[string]*batch)
+	for index, evnt := range events {
+		url, err := urlPattern.Format(evnt)
+		if err != nil {
+			log.Warningf("Failed to determine URL for event: %s", err)
+			failed = append(failed, index)
+			continue
+		}
+
+		urlBatch, ok := batchesByURL[url]
+		if !ok {
+			urlBatch = &batch{url: url}
+			batchesByURL[url] = urlBatch
+			batches = append(batches, urlBatch)
+		}
+		urlBatch.events = append(urlBatch.events, evnt)
+		urlBatch.indexes = append(urlBatch.indexes, index)
+	}
+
+	return batches, failed
+}
+
+// Encode returns the body of the request for the batch, consisting of one
+// JSON encoded event per line, optionally gzip compressed
+func (b *batch) Encode(compress bool) ([]byte, error) {
+	bodyBuffer := new(bytes.Buffer)
+	if !compress {
+		if err := b.writeTo(bodyBuffer); err != nil {
+			return nil, err
+		}
+		return bodyBuffer.Bytes(), nil
+	}
+
+	gzipWriter := gzip.NewWriter(bodyBuffer)
+	if err := b.writeTo(gzipWriter); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return bodyBuffer.Bytes(), nil
+}
+
+// writeTo writes each event followed by a new line
+func (b *batch) writeTo(writer io.Writer) error {
+	for _, evnt := range b.events {
+		if _, err := writer.Write(evnt.Bytes()); err != nil {
+			return err
+		}
+		if _, err := writer.Write(newLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/event"
+)
+
+func createTestEvents(types ...string) []*event.Event {
+	events := make([]*event.Event, len(types))
+	for idx, eventType := range types {
+		data := map[string]interface{}{"message": "test"}
+		if eventType != "" {
+			data["type"] = eventType
+		}
+		events[idx] = event.NewEvent(context.Background(), nil, data)
+	}
+	return events
+}
+
+func TestBatchStatic(t *testing.T) {
+	batches, failed := newBatches(event.NewPatternFromString("/ingest"), createTestEvents("a", "b"))
+	if len(failed) != 0 {
+		t.Fatalf("Unexpected failed events: %v", failed)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("Unexpected batch count, got: %d, expected: 1", len(batches))
+	}
+	if batches[0].url != "/ingest" {
+		t.Errorf("Unexpected URL, got: %s, expected: /ingest", batches[0].url)
+	}
+	if len(batches[0].events) != 2 {
+		t.Errorf("Unexpected event count, got: %d, expected: 2", len(batches[0].events))
+	}
+}
+
+func TestBatchSplitsByURL(t *testing.T) {
+	batches, failed := newBatches(event.NewPatternFromString("/ingest/%{type}"), createTestEvents("a", "b", "", "a"))
+	if len(failed) != 0 {
+		t.Errorf("Unexpected failed events: %v", failed)
+	}
+	if len(batches) != 3 {
+		t.Fatalf("Unexpected batch count, got: %d, expected: 3", len(batches))
+	}
+	if batches[0].url != "/ingest/a" || len(batches[0].indexes) != 2 || batches[0].indexes[1] != 3 {
+		t.Errorf("Unexpected first batch: %s %v", batches[0].url, batches[0].indexes)
+	}
+	if batches[1].url != "/ingest/b" || len(batches[1].indexes) != 1 || batches[1].indexes[0] != 1 {
+		t.Errorf("Unexpected second batch: %s %v", batches[1].url, batches[1].indexes)
+	}
+	if batches[2].url != "/ingest/" || len(batches[2].indexes) != 1 || batches[2].indexes[0] != 2 {
+		t.Errorf("Unexpected third batch: %s %v", batches[2].url, batches[2].indexes)
+	}
+}
+
+func TestBatchEncode(t *testing.T) {
+	batches, _ := newBatches(event.NewPatternFromString("/"), createTestEvents("a", "b"))
+	expected := string(batches[0].events[0].Bytes()) + "\n" + string(batches[0].events[1].Bytes()) + "\n"
+
+	body, err := batches[0].Encode(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(body) != expected {
+		t.Errorf("Unexpected body, got: %s, expected: %s", body, expected)
+	}
+
+	body, err = batches[0].Encode(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(decompressed) != expected {
+		t.Errorf("Unexpected body, got: %s, expected: %s", decompressed, expected)
+	}
+}
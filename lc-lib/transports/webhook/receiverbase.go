@@ -0,0 +1,419 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+var (
+	// ErrRequestTooLarge occurs when a request body, or the result of
+	// decompressing it, exceeds the max request size
+	ErrRequestTooLarge = errors.New("request body too large")
+
+	// ErrMaxPendingPayloads occurs when a request is received whilst the max
+	// pending payloads are already awaiting acknowledgement
+	ErrMaxPendingPayloads = errors.New("max pending payloads reached")
+
+	// errNotAcknowledged occurs when a request's connection is closed before all
+	// of its events were acknowledged
+	errNotAcknowledged = errors.New("events were not acknowledged")
+)
+
+// HTTPReceiver is a receiver that embeds ReceiverBase and handles the requests
+// it receives
+type HTTPReceiver interface {
+	transports.Receiver
+	http.Handler
+}
+
+// ReceiverBase implements the parts of a receiver that are common to all
+// receivers accepting events over HTTP, with each request treated as a
+// separate connection that receives a response only once all of its events
+// are acknowledged. Receivers embed it and implement ServeHTTP to decode
+// requests, passing the events to ProcessEvents
+type ReceiverBase struct {
+	// Constructor
+	ctx                context.Context
+	shutdownFunc       context.CancelFunc
+	handler            http.Handler
+	tlsConfig          *transports.ServerTlsConfiguration
+	useTLS             bool
+	bind               string
+	eventChan          chan<- transports.Event
+	maxPendingPayloads int64
+	shutdownChan       chan struct{}
+
+	// Internal
+	pendingPayloads int64
+	pendingMutex    sync.Mutex
+	shutdownOnce    sync.Once
+}
+
+// NewReceiverBase returns a new ReceiverBase for the given receiver, which
+// should embed it and then call Start once it is ready to receive requests
+func NewReceiverBase(ctx context.Context, receiver HTTPReceiver, tlsConfig *transports.ServerTlsConfiguration, useTLS bool, bind string, eventChan chan<- transports.Event) *ReceiverBase {
+	ret := &ReceiverBase{
+		handler:            receiver,
+		tlsConfig:          tlsConfig,
+		useTLS:             useTLS,
+		bind:               bind,
+		eventChan:          eventChan,
+		maxPendingPayloads: transports.ReceiverConfigFromContext(ctx).MaxPendingPayloads,
+		shutdownChan:       make(chan struct{}),
+	}
+
+	ret.ctx, ret.shutdownFunc = context.WithCancel(context.WithValue(ctx, transports.ContextReceiver, receiver))
+
+	return ret
+}
+
+// requestConnection holds the state of a single request
+type requestConnection struct {
+	count    uint32
+	acked    uint32
+	status   int
+	err      error
+	doneChan chan struct{}
+	doneOnce sync.Once
+}
+
+// finish completes the request with the given status
+func (c *requestConnection) finish(status int, err error) {
+	c.doneOnce.Do(func() {
+		c.status = status
+		c.err = err
+		close(c.doneChan)
+	})
+}
+
+// Bind returns the address the receiver listens on
+func (t *ReceiverBase) Bind() string {
+	return t.bind
+}
+
+// SupportsAck returns true as requests are only responded to once their events
+// are acknowledged
+func (t *ReceiverBase) SupportsAck() bool {
+	return true
+}
+
+// Start starts the controller
+func (t *ReceiverBase) Start() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine manages restarting listening as things fail
+func (t *ReceiverBase) controllerRoutine() {
+	defer func() {
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	backoffName := fmt.Sprintf("[R %s] Receiver Reset", t.bind)
+	backoff := core.NewExpBackoff(backoffName, 0, 300*time.Second)
+
+	for {
+		err := t.listen()
+		if err == nil {
+			// Shutdown
+			break
+		}
+
+		log.Errorf("[R %s] Receiver error, resetting: %s", t.bind, err)
+
+		if t.retryWait(backoff) {
+			break
+		}
+	}
+
+	// Ensure resources are cleaned up for the context
+	t.shutdownFunc()
+
+	log.Infof("[R %s] Receiver exiting", t.bind)
+}
+
+// retryWait waits the backoff timeout before attempting to listen again
+// It also monitors for shutdown whilst waiting
+func (t *ReceiverBase) retryWait(backoff *core.ExpBackoff) bool {
+	now := time.Now()
+	setupDue := now.Add(backoff.Trigger())
+
+	select {
+	case <-t.shutdownChan:
+		// Shutdown request
+		return true
+	case <-time.After(setupDue.Sub(now)):
+	}
+
+	return false
+}
+
+// listen starts the server and runs it until shutdown is requested, at which
+// point it waits for all outstanding requests to complete
+func (t *ReceiverBase) listen() error {
+	log.Infof("[R %s] Attempting to listen", t.bind)
+
+	listener, err := net.Listen("tcp", t.bind)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", t.bind, err)
+	}
+
+	if t.useTLS {
+		listener = tls.NewListener(listener, t.getTLSConfig())
+	}
+
+	log.Noticef("[R %s] Listening", t.bind)
+
+	server := &http.Server{Handler: t.handler}
+	serveChan := make(chan error, 1)
+	go func() {
+		serveChan <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveChan:
+		return fmt.Errorf("failed to serve on %s: %s", t.bind, err)
+	case <-t.shutdownChan:
+	}
+
+	// Stop accepting requests and wait for outstanding requests to receive their
+	// acknowledgements
+	log.Infof("[R %s] Receiver shutting down and waiting for final acknowledgements to be sent", t.bind)
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.Warningf("[R %s] Failed to shutdown cleanly: %s", t.bind, err)
+	}
+	<-serveChan
+
+	return nil
+}
+
+// getTLSConfig returns TLS configuration for the listener
+func (t *ReceiverBase) getTLSConfig() (tlsConfig *tls.Config) {
+	tlsConfig = new(tls.Config)
+
+	tlsConfig.MinVersion = t.tlsConfig.MinTLSVersion
+	tlsConfig.MaxVersion = t.tlsConfig.MaxTLSVersion
+
+	// Set the certificate if we set one
+	if t.tlsConfig.Certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*t.tlsConfig.Certificate}
+	}
+
+	// Set CA for client verification
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	for _, cert := range t.tlsConfig.CaList {
+		tlsConfig.ClientCAs.AddCert(cert)
+	}
+
+	if len(t.tlsConfig.CaList) != 0 && t.tlsConfig.SSLVerifyPeers {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return
+}
+
+// ProcessEvents passes the events decoded from a request through the pipeline
+// and waits for them to be acknowledged, returning the status code and error
+// to respond with
+func (t *ReceiverBase) ProcessEvents(req *http.Request, events []map[string]interface{}, size int) (int, error) {
+	if !t.reservePayload() {
+		return http.StatusTooManyRequests, ErrMaxPendingPayloads
+	}
+	defer t.releasePayload()
+
+	conn := &requestConnection{count: uint32(len(events)), doneChan: make(chan struct{})}
+	ctx := context.WithValue(t.ctx, transports.ContextConnection, conn)
+	desc := connectionDesc(req)
+
+	log.Debugf("[R %s - %s] Received %d events", t.bind, req.RemoteAddr, len(events))
+
+	t.eventChan <- transports.NewConnectEvent(ctx, req.RemoteAddr, desc)
+	t.eventChan <- transports.NewEventsEvent(ctx, &transports.NilNonce, events, size)
+	t.eventChan <- transports.NewEndEvent(ctx)
+
+	select {
+	case <-conn.doneChan:
+	case <-req.Context().Done():
+		conn.finish(http.StatusServiceUnavailable, errors.New("client disconnected before events were acknowledged"))
+	}
+
+	t.eventChan <- transports.NewDisconnectEvent(ctx, req.RemoteAddr, desc)
+
+	return conn.status, conn.err
+}
+
+// reservePayload reserves a pending payload, returning false if the max
+// pending payloads has been reached
+func (t *ReceiverBase) reservePayload() bool {
+	t.pendingMutex.Lock()
+	defer t.pendingMutex.Unlock()
+	if t.pendingPayloads >= t.maxPendingPayloads {
+		return false
+	}
+	t.pendingPayloads++
+	return true
+}
+
+// releasePayload releases a pending payload
+func (t *ReceiverBase) releasePayload() {
+	t.pendingMutex.Lock()
+	defer t.pendingMutex.Unlock()
+	t.pendingPayloads--
+}
+
+// Acknowledge records the acknowledgement of a request's events
+func (t *ReceiverBase) Acknowledge(ctx context.Context, nonce *string, sequence uint32) error {
+	conn := ctx.Value(transports.ContextConnection).(*requestConnection)
+	conn.acked = sequence
+	return nil
+}
+
+// Pong is not implemented as we will never receive a Ping
+func (t *ReceiverBase) Pong(ctx context.Context) error {
+	panic("Not implemented")
+}
+
+// FailConnection fails the request
+func (t *ReceiverBase) FailConnection(ctx context.Context, err error) {
+	conn := ctx.Value(transports.ContextConnection).(*requestConnection)
+	conn.finish(http.StatusServiceUnavailable, err)
+}
+
+// ShutdownConnection completes the request, responding successfully if all
+// events were acknowledged
+func (t *ReceiverBase) ShutdownConnection(ctx context.Context) {
+	conn := ctx.Value(transports.ContextConnection).(*requestConnection)
+	if conn.acked != conn.count {
+		conn.finish(http.StatusServiceUnavailable, errNotAcknowledged)
+		return
+	}
+	conn.finish(http.StatusOK, nil)
+}
+
+// ShutdownConnectionRead rejects the request as its events could not be
+// accepted, such as when the queue is full
+func (t *ReceiverBase) ShutdownConnectionRead(ctx context.Context, err error) {
+	conn := ctx.Value(transports.ContextConnection).(*requestConnection)
+	conn.finish(http.StatusTooManyRequests, err)
+}
+
+// Shutdown stops accepting requests and shuts down once all outstanding
+// requests are complete
+func (t *ReceiverBase) Shutdown() {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdownChan)
+	})
+}
+
+// connectionDesc returns the client certificate common name for a TLS request
+func connectionDesc(req *http.Request) string {
+	if req.TLS == nil {
+		return "-"
+	}
+	if len(req.TLS.VerifiedChains) > 0 {
+		return req.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return "No client certificate"
+}
+
+// ReadRequestBody returns a reader for the request body, decompressing it if
+// necessary, that fails with ErrRequestTooLarge if more than maxSize bytes are
+// read either before or after decompression. If the body cannot be read the
+// status code to respond with is returned along with the error
+func ReadRequestBody(req *http.Request, maxSize int64) (*SizeLimitReader, int, error) {
+	if req.ContentLength > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, ErrRequestTooLarge
+	}
+
+	reader := &SizeLimitReader{reader: req.Body, remaining: maxSize}
+
+	switch strings.ToLower(req.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, RequestErrorStatus(err), err
+		}
+		reader = &SizeLimitReader{reader: gzipReader, remaining: maxSize}
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding: %s", req.Header.Get("Content-Encoding"))
+	}
+
+	return reader, http.StatusOK, nil
+}
+
+// IsBearerAuthorised returns true if the request carries the given bearer
+// token, or if the given token is empty so that no authorisation is required
+func IsBearerAuthorised(req *http.Request, bearerToken string) bool {
+	if bearerToken == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(bearerToken)) == 1
+}
+
+// RequestErrorStatus returns the status code to respond with when reading or
+// decoding a request body fails with the given error
+func RequestErrorStatus(err error) int {
+	if errors.Is(err, ErrRequestTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// SizeLimitReader counts the bytes read and fails with ErrRequestTooLarge if
+// more than the remaining limit is read
+type SizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+	read      int
+}
+
+// Read implements io.Reader
+func (r *SizeLimitReader) Read(data []byte) (int, error) {
+	if int64(len(data)) > r.remaining+1 {
+		data = data[:r.remaining+1]
+	}
+	n, err := r.reader.Read(data)
+	r.read += n
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrRequestTooLarge
+	}
+	return n, err
+}
+
+// BytesRead returns the number of bytes that have been read
+func (r *SizeLimitReader) BytesRead() int {
+	return r.read
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+// receiverHTTP implements a receiver that accepts events POSTed to it, with
+// each request treated as a separate connection that receives a response
+// only once all of its events are acknowledged
+type receiverHTTP struct {
+	*ReceiverBase
+
+	config *ReceiverHTTPFactory
+}
+
+// Factory returns the associated factory
+func (t *receiverHTTP) Factory() transports.ReceiverFactory {
+	return t.config
+}
+
+// ServeHTTP handles a request, passing its events through the pipeline and
+// responding once they are acknowledged
+func (t *receiverHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		t.respondError(w, req, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	if !IsBearerAuthorised(req, t.config.BearerToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		t.respondError(w, req, http.StatusUnauthorized, errors.New("unauthorised"))
+		return
+	}
+
+	events, size, status, err := t.readEvents(req)
+	if err != nil {
+		t.respondError(w, req, status, err)
+		return
+	}
+
+	if len(events) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if status, err := t.ProcessEvents(req, events, size); err != nil {
+		t.respondError(w, req, status, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// respondError logs a failed request and returns the error to the client
+func (t *receiverHTTP) respondError(w http.ResponseWriter, req *http.Request, status int, err error) {
+	log.Warningf("[R %s - %s] Request failed with status %d: %s", t.Bind(), req.RemoteAddr, status, err)
+	http.Error(w, err.Error(), status)
+}
+
+// readEvents reads and decodes the request body, returning the events and
+// their size, or the status code and error to respond with
+func (t *receiverHTTP) readEvents(req *http.Request) ([]map[string]interface{}, int, int, error) {
+	reader, status, err := ReadRequestBody(req, t.config.MaxRequestSize)
+	if err != nil {
+		return nil, 0, status, err
+	}
+
+	events, err := decodeEvents(reader)
+	if err != nil {
+		return nil, 0, RequestErrorStatus(err), err
+	}
+
+	return events, reader.BytesRead(), http.StatusOK, nil
+}
+
+// decodeEvents decodes a JSON array of events, or a stream of events such as
+// newline delimited JSON
+func decodeEvents(reader io.Reader) ([]map[string]interface{}, error) {
+	bufReader := bufio.NewReader(reader)
+	isArray, err := startsWithArray(bufReader)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bufReader)
+	if isArray {
+		var events []map[string]interface{}
+		if err := decoder.Decode(&events); err != nil {
+			return nil, err
+		}
+		for idx, evnt := range events {
+			if evnt == nil {
+				return nil, fmt.Errorf("event at index %d is not an object", idx)
+			}
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, errors.New("unexpected data after array")
+		}
+		return events, nil
+	}
+
+	var events []map[string]interface{}
+	for {
+		var evnt map[string]interface{}
+		if err := decoder.Decode(&evnt); err != nil {
+			if err == io.EOF {
+				return events, nil
+			}
+			return nil, err
+		}
+		if evnt == nil {
+			return nil, fmt.Errorf("event at index %d is not an object", len(events))
+		}
+		events = append(events, evnt)
+	}
+}
+
+// startsWithArray returns true if the first non-whitespace character is the
+// beginning of an array
+func startsWithArray(reader *bufio.Reader) (bool, error) {
+	for {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch char {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return char == '[', reader.UnreadByte()
+	}
+}
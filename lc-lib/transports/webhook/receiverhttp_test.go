@@ -0,0 +1,162 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createTestReceiver(t *testing.T, maxPendingPayloads int64, acknowledge bool) *receiverHTTP {
+	factory := &ReceiverHTTPFactory{transport: TransportHTTP, ServerTlsConfiguration: &transports.ServerTlsConfiguration{TlsConfiguration: &transports.TlsConfiguration{}}}
+	factory.Defaults()
+	if err := factory.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	ctx := context.WithValue(context.Background(), transports.ContextReceiverConfig, &transports.ReceiverConfigEntry{MaxPendingPayloads: maxPendingPayloads})
+	receiver := &receiverHTTP{config: factory}
+	receiver.ReceiverBase = NewReceiverBase(ctx, receiver, factory.ServerTlsConfiguration, false, "test", eventChan)
+
+	// Act as the receiver pool, acknowledging all events and then closing the
+	// connection once the end is received
+	go func() {
+		for evnt := range eventChan {
+			switch eventImpl := evnt.(type) {
+			case transports.EventsEvent:
+				if acknowledge {
+					receiver.Acknowledge(eventImpl.Context(), eventImpl.Nonce(), eventImpl.Count())
+				} else {
+					receiver.ShutdownConnectionRead(eventImpl.Context(), errors.New("max queue size exceeded"))
+				}
+			case *transports.EndEvent:
+				receiver.ShutdownConnection(eventImpl.Context())
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(eventChan)
+	})
+
+	return receiver
+}
+
+func sendTestRequest(receiver *receiverHTTP, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestReceiverHTTPAcknowledged(t *testing.T) {
+	receiver := createTestReceiver(t, 10, true)
+
+	recorder := sendTestRequest(receiver, []byte("{\"message\":\"one\"}\n{\"message\":\"two\"}\n"), nil)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestReceiverHTTPGzipArray(t *testing.T) {
+	receiver := createTestReceiver(t, 10, true)
+
+	var body bytes.Buffer
+	gzipWriter := gzip.NewWriter(&body)
+	gzipWriter.Write([]byte(`[{"message":"one"},{"message":"two"}]`))
+	gzipWriter.Close()
+
+	recorder := sendTestRequest(receiver, body.Bytes(), map[string]string{"Content-Encoding": "gzip"})
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestReceiverHTTPRejected(t *testing.T) {
+	receiver := createTestReceiver(t, 10, false)
+
+	recorder := sendTestRequest(receiver, []byte(`{"message":"one"}`), nil)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReceiverHTTPMaxPendingPayloads(t *testing.T) {
+	receiver := createTestReceiver(t, 1, true)
+	receiver.reservePayload()
+
+	recorder := sendTestRequest(receiver, []byte(`{"message":"one"}`), nil)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReceiverHTTPBearerToken(t *testing.T) {
+	receiver := createTestReceiver(t, 10, true)
+	receiver.config.BearerToken = "secret"
+
+	recorder := sendTestRequest(receiver, []byte(`{"message":"one"}`), map[string]string{"Authorization": "Bearer wrong"})
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	recorder = sendTestRequest(receiver, []byte(`{"message":"one"}`), map[string]string{"Authorization": "Bearer secret"})
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestReceiverHTTPMaxRequestSize(t *testing.T) {
+	receiver := createTestReceiver(t, 10, true)
+	receiver.config.MaxRequestSize = 10
+
+	recorder := sendTestRequest(receiver, []byte(`{"message":"too long"}`), nil)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Unexpected status, got: %d, expected: %d", recorder.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	events, err := decodeEvents(strings.NewReader("{\"a\":1}\n\n{\"b\":2}"))
+	if err != nil || len(events) != 2 {
+		t.Errorf("Unexpected NDJSON result: %v (%v)", events, err)
+	}
+
+	events, err = decodeEvents(strings.NewReader(" [{\"a\":1},{\"b\":2}]\n"))
+	if err != nil || len(events) != 2 {
+		t.Errorf("Unexpected array result: %v (%v)", events, err)
+	}
+
+	if _, err = decodeEvents(strings.NewReader(`[{"a":1},null]`)); err == nil {
+		t.Errorf("Array containing null did not fail")
+	}
+
+	if _, err = decodeEvents(strings.NewReader(`{"a":1} 2`)); err == nil {
+		t.Errorf("Stream containing a number did not fail")
+	}
+}
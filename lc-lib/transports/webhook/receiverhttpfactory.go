@@ -0,0 +1,99 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	defaultMaxRequestSize int64 = 10485760
+)
+
+// ReceiverHTTPFactory holds the configuration from the configuration file
+// It allows creation of ReceiverHTTP instances that use this configuration
+type ReceiverHTTPFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	BearerToken    string `config:"bearer token"`
+	MaxRequestSize int64  `config:"max request size"`
+
+	*transports.ServerTlsConfiguration `config:",embed"`
+}
+
+// NewReceiverHTTPFactory create a new ReceiverHTTPFactory from the provided
+// configuration data, reporting back any configuration errors it discovers
+func NewReceiverHTTPFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.ReceiverFactory, error) {
+	ret := &ReceiverHTTPFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *ReceiverHTTPFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.MaxRequestSize < 1 {
+		return fmt.Errorf("%smax request size must be greater than 0", configPath)
+	}
+
+	return f.ServerTlsConfiguration.TlsValidate(f.transport == TransportHTTPS, p, configPath)
+}
+
+// Defaults sets the default configuration values
+func (f *ReceiverHTTPFactory) Defaults() {
+	f.MaxRequestSize = defaultMaxRequestSize
+}
+
+// NewReceiver returns a new Receiver interface using the settings from the
+// ReceiverHTTPFactory
+func (f *ReceiverHTTPFactory) NewReceiver(ctx context.Context, bind string, eventChan chan<- transports.Event) transports.Receiver {
+	ret := &receiverHTTP{config: f}
+	ret.ReceiverBase = NewReceiverBase(ctx, ret, f.ServerTlsConfiguration, f.transport == TransportHTTPS, bind, eventChan)
+	ret.Start()
+	return ret
+}
+
+// ShouldRestart returns true if the receiver needs to be restarted in order
+// for the new configuration to apply
+func (f *ReceiverHTTPFactory) ShouldRestart(newFactory transports.ReceiverFactory) bool {
+	newFactoryImpl := newFactory.(*ReceiverHTTPFactory)
+	if newFactoryImpl.BearerToken != f.BearerToken {
+		return true
+	}
+	if newFactoryImpl.MaxRequestSize != f.MaxRequestSize {
+		return true
+	}
+
+	return f.ServerTlsConfiguration.HasChanged(newFactoryImpl.ServerTlsConfiguration)
+}
+
+// Register the receivers
+func init() {
+	transports.RegisterReceiver(TransportHTTP, NewReceiverHTTPFactory)
+	transports.RegisterReceiver(TransportHTTPS, NewReceiverHTTPFactory)
+}
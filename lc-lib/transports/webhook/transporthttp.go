@@ -0,0 +1,388 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+// payload contains nonce and events information, and tracks which of the
+// events have been successfully sent
+type payload struct {
+	nonce    *string
+	events   []*event.Event
+	acked    []bool
+	sequence uint32
+}
+
+// markAcked marks the events at the given indexes as sent and moves the
+// sequence forward past all contiguously sent events, returning true if it
+// changed
+func (p *payload) markAcked(indexes []int) bool {
+	for _, index := range indexes {
+		p.acked[index] = true
+	}
+
+	sequence := p.sequence
+	for int(p.sequence) < len(p.acked) && p.acked[p.sequence] {
+		p.sequence++
+	}
+	return p.sequence != sequence
+}
+
+type clientCacheItem struct {
+	client  *http.Client
+	expires time.Time
+}
+
+// transportHTTP implements a transport that sends NDJSON over HTTP
+type transportHTTP struct {
+	// Constructor
+	ctx          context.Context
+	shutdownFunc context.CancelFunc
+	config       *TransportHTTPFactory
+	netConfig    *transports.Config
+	poolEntry    *addresspool.PoolEntry
+	clientCache  map[string]*clientCacheItem
+	eventChan    chan<- transports.Event
+
+	// Internal
+	// payloadMutex is so we can easily discard existing sendChan and its contents each time we reset
+	payloadChan  chan *payload
+	payloadMutex sync.Mutex
+	poolMutex    sync.Mutex
+	wait         sync.WaitGroup
+	dropped      uint64
+}
+
+// Factory returns the associated factory
+func (t *transportHTTP) Factory() transports.TransportFactory {
+	return t.config
+}
+
+// DroppedEvents returns the number of events that were discarded because the
+// URL to send them to could not be determined
+func (t *transportHTTP) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// startController starts the controller
+func (t *transportHTTP) startController() {
+	go t.controllerRoutine()
+}
+
+// controllerRoutine is the master routine which handles submission
+func (t *transportHTTP) controllerRoutine() {
+	defer func() {
+		// Wait for all routines to close and close all connections
+		t.wait.Wait()
+		for _, cacheItem := range t.clientCache {
+			cacheItem.client.CloseIdleConnections()
+		}
+		t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Finished, nil)
+	}()
+
+	// Setup payload chan with max write count of pending payloads
+	t.payloadMutex.Lock()
+	t.payloadChan = make(chan *payload, t.netConfig.MaxPendingPayloads)
+	t.payloadMutex.Unlock()
+
+	t.eventChan <- transports.NewStatusEvent(t.ctx, transports.Started, nil)
+
+	// Start secondary http routines
+	for i := 1; i < t.config.Routines; i++ {
+		t.wait.Add(1)
+		go t.httpRoutine(i)
+	}
+
+	// Become the main http routine
+	t.wait.Add(1)
+	t.httpRoutine(0)
+
+	// Ensure all resources for the cancel are cleaned up
+	t.shutdownFunc()
+}
+
+// httpRoutine performs requests to the HTTP server
+func (t *transportHTTP) httpRoutine(id int) {
+	defer func() {
+		t.wait.Done()
+	}()
+
+	backoffName := fmt.Sprintf("%s:%d Retry", t.poolEntry.Server, id)
+	backoff := core.NewExpBackoff(backoffName, t.config.Retry, t.config.RetryMax)
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case payload := <-t.payloadChan:
+			if payload == nil {
+				// Graceful shutdown
+				log.Infof("[T %s]{%d} HTTP routine stopped gracefully", t.poolEntry.Server, id)
+				return
+			}
+
+			payload.acked = make([]bool, len(payload.events))
+			batches, failed := newBatches(t.config.urlPattern, payload.events)
+			if len(failed) != 0 {
+				atomic.AddUint64(&t.dropped, uint64(len(failed)))
+				log.Warningf("[T %s]{%d} Discarded %d events for which the URL could not be determined", t.poolEntry.Server, id, len(failed))
+			}
+			if payload.markAcked(failed) && !t.sendAck(payload) {
+				return
+			}
+
+			for _, batch := range batches {
+				body, err := batch.Encode(t.config.Gzip)
+				if err != nil {
+					// Should never happen as we are encoding to a buffer
+					panic(fmt.Sprintf("failed to encode request body: %s", err))
+				}
+
+				for {
+					// Pool Next() is not race-safe
+					t.poolMutex.Lock()
+					addr, err := t.poolEntry.Next()
+					t.poolMutex.Unlock()
+					if err == nil {
+						err = t.performRequest(addr, id, batch, body)
+					}
+					if err == nil {
+						break
+					}
+
+					log.Errorf("[T %s]{%d} HTTP request failed: %s", t.poolEntry.Server, id, err)
+
+					if t.retryWait(backoff) {
+						return
+					}
+				}
+
+				if payload.markAcked(batch.indexes) && !t.sendAck(payload) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendAck sends an acknowledgement for the payload's current sequence,
+// returning false if the transport was failed whilst sending
+func (t *transportHTTP) sendAck(payload *payload) bool {
+	select {
+	case <-t.ctx.Done():
+		// Forced failure
+		return false
+	case t.eventChan <- transports.NewAckEvent(t.ctx, payload.nonce, payload.sequence):
+	}
+	return true
+}
+
+// performRequest sends a batch to the HTTP server
+func (t *transportHTTP) performRequest(addr *addresspool.Address, id int, batch *batch, body []byte) error {
+	log.Debugf("[T %s]{%d} Performing HTTP request of %d events to %s", addr.Desc(), id, len(batch.events), batch.url)
+
+	httpRequest, err := t.createRequest(t.ctx, addr, batch.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpResponse, err := t.getClient(addr).Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	responseBody, _ := io.ReadAll(httpResponse.Body)
+	httpResponse.Body.Close()
+	if !t.isSuccess(httpResponse.StatusCode) {
+		return fmt.Errorf("unexpected status: %s [Body: %s]", httpResponse.Status, responseBody)
+	}
+
+	log.Debugf("[T %s]{%d} HTTP request complete (status: %s)", addr.Desc(), id, httpResponse.Status)
+	return nil
+}
+
+// isSuccess returns true if the status code is one of the configured success
+// status codes
+func (t *transportHTTP) isSuccess(statusCode int) bool {
+	for _, successCode := range t.config.SuccessStatusCodes {
+		if statusCode == successCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryWait waits the backoff timeout before attempting to retry
+// It also monitors for shutdown whilst waiting
+func (t *transportHTTP) retryWait(backoff *core.ExpBackoff) bool {
+	now := time.Now()
+	reconnectDue := now.Add(backoff.Trigger())
+
+	select {
+	case <-t.ctx.Done():
+		// Shutdown request
+		return true
+	case <-time.After(reconnectDue.Sub(now)):
+	}
+
+	return false
+}
+
+// SendEvents sends events to the transport - only valid after Started transport event received
+func (t *transportHTTP) SendEvents(nonce string, events []*event.Event) error {
+	// Are we ready?
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		return transports.ErrInvalidState
+	}
+	t.payloadChan <- &payload{nonce: &nonce, events: events}
+	return nil
+}
+
+// Ping the remote server - not implemented for HTTP since we close connections after each send
+// Immediately respond with a pong
+func (t *transportHTTP) Ping() error {
+	go func() {
+		log.Debugf("[T %s] Responding with pong", t.poolEntry.Server)
+		select {
+		case <-t.ctx.Done():
+			// Forced failure
+			return
+		case t.eventChan <- transports.NewPongEvent(t.ctx):
+		}
+	}()
+	return nil
+}
+
+// Fail the transport
+func (t *transportHTTP) Fail() {
+	t.shutdownFunc()
+}
+
+// Shutdown the transport - only valid after Started transport event received
+func (t *transportHTTP) Shutdown() {
+	t.payloadMutex.Lock()
+	defer t.payloadMutex.Unlock()
+	if t.payloadChan == nil {
+		// No connection active so just fail
+		t.shutdownFunc()
+	} else {
+		// Trigger graceful shutdown
+		close(t.payloadChan)
+	}
+}
+
+// createRequest creates a new http.Request and adds the configured headers
+func (t *transportHTTP) createRequest(ctx context.Context, addr *addresspool.Address, url string, body *bytes.Reader) (*http.Request, error) {
+	var scheme string
+	if t.config.transport == TransportHTTPS {
+		scheme = "https"
+	} else {
+		scheme = "http"
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s://%s%s", scheme, addr.Addr().String(), url), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the host name for virtual hosting rather than the resolved address
+	request.Host = net.JoinHostPort(addr.Host(), strconv.Itoa(addr.Addr().Port))
+
+	for key, value := range t.config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if t.config.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if t.config.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	} else if t.config.Username != "" && t.config.Password != "" {
+		request.SetBasicAuth(t.config.Username, t.config.Password)
+	}
+
+	return request, nil
+}
+
+// getClient returns a http.Client for the given server
+func (t *transportHTTP) getClient(addr *addresspool.Address) *http.Client {
+	t.poolMutex.Lock()
+	defer t.poolMutex.Unlock()
+
+	now := time.Now()
+	expires := time.Now().Add(time.Second * 300)
+	cacheItem, ok := t.clientCache[addr.Host()]
+	if ok {
+		cacheItem.expires = expires
+		return cacheItem.client
+	}
+
+	for key, cacheItem := range t.clientCache {
+		if cacheItem.expires.Before(now) {
+			cacheItem.client.CloseIdleConnections()
+			delete(t.clientCache, key)
+		}
+	}
+
+	certPool := x509.NewCertPool()
+	for _, cert := range t.config.CaList {
+		certPool.AddCert(cert)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    certPool,
+		ServerName: addr.Host(),
+		MinVersion: t.config.MinTLSVersion,
+		MaxVersion: t.config.MaxTLSVersion,
+	}
+	if t.config.Certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*t.config.Certificate}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSHandshakeTimeout: t.netConfig.Timeout,
+			TLSClientConfig:     tlsConfig,
+		},
+		Timeout: t.netConfig.Timeout,
+	}
+
+	t.clientCache[addr.Host()] = &clientCacheItem{client, expires}
+	return client
+}
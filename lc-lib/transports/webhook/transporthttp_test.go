@@ -0,0 +1,187 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createTestFactory(t *testing.T) *TransportHTTPFactory {
+	f := &TransportHTTPFactory{
+		transport: TransportHTTP,
+		ClientTlsConfiguration: &transports.ClientTlsConfiguration{
+			TlsConfiguration: &transports.TlsConfiguration{},
+		},
+	}
+	f.Defaults()
+	f.Gzip = false
+	f.Routines = 1
+	f.URLPattern = "/ingest/%{type}"
+	f.Headers = map[string]string{"X-Test": "value"}
+	f.BearerToken = "token"
+	if err := f.Validate(nil, "/"); err != nil {
+		t.Fatalf("Failed to validate configuration: %s", err)
+	}
+	return f
+}
+
+func createTestTransport(t *testing.T, f *TransportHTTPFactory, server *httptest.Server) (transports.Transport, chan transports.Event) {
+	pool, err := addresspool.GeneratePool([]string{strings.TrimPrefix(server.URL, "http://")}, false, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate pool: %s", err)
+	}
+
+	netConfig := &transports.Config{}
+	netConfig.Defaults()
+	ctx := context.WithValue(context.Background(), transports.ContextConfig, netConfig)
+
+	eventChan := make(chan transports.Event, 10)
+	return f.NewTransport(ctx, pool[0], eventChan), eventChan
+}
+
+func receiveEvent(t *testing.T, eventChan <-chan transports.Event) transports.Event {
+	select {
+	case evnt := <-eventChan:
+		return evnt
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for transport event")
+	}
+	return nil
+}
+
+func TestTransportHTTPSendsBatches(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		requests = make(map[string]string)
+		failures = 1
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Test") != "value" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// Fail the first request to check it is retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		requests[r.URL.Path] += string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	transport, eventChan := createTestTransport(t, createTestFactory(t), server)
+	defer transport.Fail()
+
+	if status, ok := receiveEvent(t, eventChan).(*transports.StatusEvent); !ok || status.StatusChange() != transports.Started {
+		t.Fatalf("Transport did not start")
+	}
+
+	if err := transport.SendEvents("nonce", createTestEvents("a", "b", "a")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The first batch completes the first event only, as the second is in the
+	// second batch
+	expectedSequences := []uint32{1, 3}
+	for _, expected := range expectedSequences {
+		ack, ok := receiveEvent(t, eventChan).(transports.AckEvent)
+		if !ok {
+			t.Fatalf("Expected acknowledgement")
+		}
+		if ack.Sequence() != expected {
+			t.Errorf("Unexpected sequence, got: %d, expected: %d", ack.Sequence(), expected)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if lines := strings.Count(requests["/ingest/a"], "\n"); lines != 2 {
+		t.Errorf("Unexpected events for /ingest/a, got: %d, expected: 2", lines)
+	}
+	if lines := strings.Count(requests["/ingest/b"], "\n"); lines != 1 {
+		t.Errorf("Unexpected events for /ingest/b, got: %d, expected: 1", lines)
+	}
+}
+
+func TestTransportHTTPCountsDroppedEvents(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		requests = make(map[string]string)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		requests[r.URL.Path] += string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	transport, eventChan := createTestTransport(t, createTestFactory(t), server)
+	defer transport.Fail()
+
+	if status, ok := receiveEvent(t, eventChan).(*transports.StatusEvent); !ok || status.StatusChange() != transports.Started {
+		t.Fatalf("Transport did not start")
+	}
+
+	// Events whose URL cannot be rendered cannot be sent so are acknowledged
+	// and counted as dropped
+	events := createTestEvents("a", "a")
+	events[0] = event.NewEvent(context.Background(), nil, map[string]interface{}{"message": "invalid url", "type": make(chan int)})
+	if err := transport.SendEvents("nonce", events); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for {
+		ack, ok := receiveEvent(t, eventChan).(transports.AckEvent)
+		if !ok {
+			t.Fatalf("Expected acknowledgement")
+		}
+		if ack.Sequence() == 2 {
+			break
+		}
+	}
+
+	if dropped := transport.(transports.DropCounter).DroppedEvents(); dropped != 1 {
+		t.Errorf("Unexpected dropped events, got: %d, expected: 1", dropped)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if lines := strings.Count(requests["/ingest/a"], "\n"); lines != 1 {
+		t.Errorf("Unexpected events for /ingest/a, got: %d, expected: 1", lines)
+	}
+}
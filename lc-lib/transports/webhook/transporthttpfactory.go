@@ -0,0 +1,187 @@
+/*
+ * Copyright 2012-2020 Jason Woods and contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/event"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	defaultGzip       bool          = true
+	defaultRoutines   int           = 4
+	defaultRetry      time.Duration = 0 * time.Second
+	defaultRetryMax   time.Duration = 300 * time.Second
+	defaultURLPattern string        = "/"
+)
+
+var (
+	// TransportHTTP is the transport name for HTTP
+	TransportHTTP = "http"
+	// TransportHTTPS is the transport name for HTTPS
+	TransportHTTPS = "https"
+
+	defaultSuccessStatusCodes = []int{200, 201, 202, 204}
+)
+
+// TransportHTTPFactory holds the configuration from the configuration file
+// It allows creation of TransportHTTP instances that use this configuration
+type TransportHTTPFactory struct {
+	// Constructor
+	config    *config.Config
+	transport string
+
+	// Configuration
+	BearerToken        string            `config:"bearer token"`
+	Gzip               bool              `config:"gzip"`
+	Headers            map[string]string `config:"headers"`
+	Password           string            `config:"password"`
+	Retry              time.Duration     `config:"retry backoff"`
+	RetryMax           time.Duration     `config:"retry backoff max"`
+	Routines           int               `config:"routines"`
+	SuccessStatusCodes []int             `config:"success status codes"`
+	URLPattern         string            `config:"url pattern"`
+	Username           string            `config:"username"`
+
+	// Internal
+	urlPattern event.Pattern
+
+	*transports.ClientTlsConfiguration `config:",embed"`
+}
+
+// NewTransportHTTPFactory create a new TransportHTTPFactory from the provided
+// configuration data, reporting back any configuration errors it discovers
+func NewTransportHTTPFactory(p *config.Parser, configPath string, unUsed map[string]interface{}, name string) (transports.TransportFactory, error) {
+	ret := &TransportHTTPFactory{
+		config:    p.Config(),
+		transport: name,
+	}
+	if err := p.Populate(ret, unUsed, configPath, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Validate the configuration
+func (f *TransportHTTPFactory) Validate(p *config.Parser, configPath string) (err error) {
+	if f.Routines < 1 {
+		return fmt.Errorf("%sroutines cannot be less than 1", configPath)
+	}
+	if f.Routines > 32 {
+		return fmt.Errorf("%sroutines cannot be more than 32", configPath)
+	}
+
+	if !strings.HasPrefix(f.URLPattern, "/") {
+		return fmt.Errorf("%surl pattern must begin with a \"/\"", configPath)
+	}
+	f.urlPattern = event.NewPatternFromString(f.URLPattern)
+
+	if f.BearerToken != "" && (f.Username != "" || f.Password != "") {
+		return fmt.Errorf("%[1]sbearer token cannot be used with %[1]susername or %[1]spassword", configPath)
+	}
+
+	if len(f.SuccessStatusCodes) == 0 {
+		f.SuccessStatusCodes = defaultSuccessStatusCodes
+	}
+	for _, statusCode := range f.SuccessStatusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return fmt.Errorf("%ssuccess status codes contains an invalid status code: %d", configPath, statusCode)
+		}
+	}
+
+	return f.ClientTlsConfiguration.TlsValidate(f.transport == TransportHTTPS, p, configPath)
+}
+
+// Defaults sets the default configuration values
+func (f *TransportHTTPFactory) Defaults() {
+	f.Gzip = defaultGzip
+	f.Routines = defaultRoutines
+	f.Retry = defaultRetry
+	f.RetryMax = defaultRetryMax
+	f.URLPattern = defaultURLPattern
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportHTTPFactory.
+func (f *TransportHTTPFactory) NewTransport(ctx context.Context, poolEntry *addresspool.PoolEntry, eventChan chan<- transports.Event) transports.Transport {
+	ctx, shutdownFunc := context.WithCancel(ctx)
+
+	ret := &transportHTTP{
+		ctx:          ctx,
+		shutdownFunc: shutdownFunc,
+		config:       f,
+		netConfig:    transports.ConfigFromContext(ctx, f.config),
+		poolEntry:    poolEntry,
+		eventChan:    eventChan,
+		clientCache:  make(map[string]*clientCacheItem),
+	}
+
+	ret.startController()
+	return ret
+}
+
+// ShouldRestart returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (f *TransportHTTPFactory) ShouldRestart(newConfig transports.TransportFactory) bool {
+	newConfigImpl := newConfig.(*TransportHTTPFactory)
+	if newConfigImpl.BearerToken != f.BearerToken {
+		return true
+	}
+	if newConfigImpl.Gzip != f.Gzip {
+		return true
+	}
+	if !reflect.DeepEqual(newConfigImpl.Headers, f.Headers) {
+		return true
+	}
+	if newConfigImpl.Password != f.Password {
+		return true
+	}
+	if newConfigImpl.Retry != f.Retry {
+		return true
+	}
+	if newConfigImpl.RetryMax != f.RetryMax {
+		return true
+	}
+	if newConfigImpl.Routines != f.Routines {
+		return true
+	}
+	if !reflect.DeepEqual(newConfigImpl.SuccessStatusCodes, f.SuccessStatusCodes) {
+		return true
+	}
+	if newConfigImpl.URLPattern != f.URLPattern {
+		return true
+	}
+	if newConfigImpl.Username != f.Username {
+		return true
+	}
+
+	return f.ClientTlsConfiguration.HasChanged(newConfigImpl.ClientTlsConfiguration)
+}
+
+// Register the transports
+func init() {
+	transports.RegisterTransport(TransportHTTP, NewTransportHTTPFactory)
+	transports.RegisterTransport(TransportHTTPS, NewTransportHTTPFactory)
+}
@@ -24,18 +24,27 @@ import (
 	"github.com/driskell/log-courier/lc-lib/geoipupdate"
 	"github.com/driskell/log-courier/lc-lib/processor"
 	"github.com/driskell/log-courier/lc-lib/publisher"
+	"github.com/driskell/log-courier/lc-lib/queue"
 	"github.com/driskell/log-courier/lc-lib/receiver"
 	"github.com/driskell/log-courier/lc-lib/spooler"
 
+	_ "github.com/driskell/log-courier/lc-lib/codecs/dedupe"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/filter"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/multiline"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/plain"
+	_ "github.com/driskell/log-courier/lc-lib/codecs/sample"
 
 	_ "github.com/driskell/log-courier/lc-lib/transports/doris"
 	_ "github.com/driskell/log-courier/lc-lib/transports/es"
+	_ "github.com/driskell/log-courier/lc-lib/transports/file"
+	_ "github.com/driskell/log-courier/lc-lib/transports/kafka"
+	_ "github.com/driskell/log-courier/lc-lib/transports/otlp"
 	"github.com/driskell/log-courier/lc-lib/transports/tcp/courier"
+	_ "github.com/driskell/log-courier/lc-lib/transports/tcp/lumberjack"
 	_ "github.com/driskell/log-courier/lc-lib/transports/tcp/stream"
+	_ "github.com/driskell/log-courier/lc-lib/transports/tcp/syslog"
 	_ "github.com/driskell/log-courier/lc-lib/transports/test"
+	_ "github.com/driskell/log-courier/lc-lib/transports/webhook"
 )
 
 // Generate platform-specific default configuration values
@@ -70,6 +79,11 @@ func main() {
 	// Add processors
 	app.Pipeline().AddProcessor(processor.NewPool(app))
 
+	// Persist processed events to disk before publishing if enabled
+	if queue.FetchConfig(app.Config()).Enabled {
+		app.Pipeline().AddProcessor(queue.NewQueue(app))
+	}
+
 	// Create sink
 	app.Pipeline().SetSink(publisher.NewPublisher())
 
@@ -18,30 +18,36 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processor"
 	"github.com/driskell/log-courier/lc-lib/prospector"
 	"github.com/driskell/log-courier/lc-lib/publisher"
 	"github.com/driskell/log-courier/lc-lib/spooler"
 	"github.com/driskell/log-courier/lc-lib/stdinharvester"
 
+	_ "github.com/driskell/log-courier/lc-lib/codecs/dedupe"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/filter"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/multiline"
 	_ "github.com/driskell/log-courier/lc-lib/codecs/plain"
+	_ "github.com/driskell/log-courier/lc-lib/codecs/sample"
 
 	"github.com/driskell/log-courier/lc-lib/transports/tcp/courier"
 	_ "github.com/driskell/log-courier/lc-lib/transports/test"
 )
 
 // Generate platform-specific default configuration values
-//go:generate go run lc-lib/config/generate/platform.go platform main config.DefaultConfigurationFile config.DefaultGeneralPersistDir admin.DefaultAdminBind
+//go:generate go run lc-lib/config/generate/platform.go platform main config.DefaultConfigurationFile config.DefaultGeneralPersistDir admin.DefaultAdminBind processor.DefaultGeoIPActionDatabase
 
 var (
 	app *core.App
 
 	stdin         bool
 	fromBeginning bool
+	once          bool
 )
 
 func main() {
@@ -50,6 +56,7 @@ func main() {
 	app = core.NewApp("Log Courier", core.LogCourierVersion)
 	flag.BoolVar(&stdin, "stdin", false, "Read from stdin instead of files listed in the config file")
 	flag.BoolVar(&fromBeginning, "from-beginning", false, "On first run, read new files from the beginning instead of the end")
+	flag.BoolVar(&once, "once", false, "Read all files to the end and exit once all events are acknowledged, instead of waiting for more data")
 	app.StartUp()
 
 	// Skip admin if reading from stdin
@@ -57,19 +64,36 @@ func main() {
 		app.Pipeline().AddService(admin.NewServer(app))
 	}
 
+	var prospectorImpl *prospector.Prospector
 	if stdin {
 		// If reading from stdin, don't start prospector, directly start a harvester
 		app.Pipeline().AddSource(stdinharvester.New(app))
 	} else {
 		// Prospector will handle new files, start harvesters, and own the registrar
-		app.Pipeline().AddSource(prospector.NewProspector(app, fromBeginning))
+		prospectorImpl = prospector.NewProspector(app, fromBeginning, once)
+		app.Pipeline().AddSource(prospectorImpl)
 	}
 
 	// Add spooler as first processor, it combines into larger chunks as needed
 	app.Pipeline().AddProcessor(spooler.NewSpooler(app))
 
+	// Add processors
+	app.Pipeline().AddProcessor(processor.NewPool(app))
+
 	// Create sink
 	app.Pipeline().SetSink(publisher.NewPublisher())
 	// Go!
 	app.Run()
+
+	if once && prospectorImpl != nil {
+		summary := prospectorImpl.BatchSummary()
+		fmt.Printf("Harvested %d files (%d skipped, %d failed): %d lines, %d bytes\n", summary.Files, summary.Skipped, summary.Failed, summary.Lines, summary.Bytes)
+		if !summary.Completed {
+			fmt.Printf("Harvesting was interrupted before all files were complete\n")
+			os.Exit(1)
+		}
+		if summary.Failed != 0 {
+			os.Exit(1)
+		}
+	}
 }